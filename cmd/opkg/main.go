@@ -1,16 +1,31 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/oe-mirrors/opkg_go/internal/config"
 	"github.com/oe-mirrors/opkg_go/internal/format"
 	"github.com/oe-mirrors/opkg_go/internal/logging"
+	"github.com/oe-mirrors/opkg_go/internal/pkgdb"
 	"github.com/oe-mirrors/opkg_go/internal/pkgmgr"
+	"github.com/oe-mirrors/opkg_go/internal/pkgmgr/jsonout"
+	"github.com/oe-mirrors/opkg_go/internal/repo"
 	"github.com/oe-mirrors/opkg_go/internal/version"
 )
 
@@ -19,9 +34,33 @@ var (
 	buildTime    = ""
 )
 
+// stdout receives the output of commands. It is replaced by the file given
+// with --output; errors and prompts always go to os.Stderr.
+var stdout io.Writer = os.Stdout
+
+// outputFile is the file opened for --output, if any.
+var outputFile *os.File
+
+// managerOptions holds the options derived from global flags for every
+// manager created by mustManager.
+var managerOptions []pkgmgr.Option
+
 func main() {
+	version.Version = buildVersion
+	if err := logging.ConfigureFromEnv(); err != nil {
+		fatal(err)
+	}
 	var conf string
+	var output string
+	var noNetwork, noRecommends bool
+	var jobs int
 	flag.StringVar(&conf, "conf", defaultConfig(), "Path to opkg.conf")
+	flag.StringVar(&output, "output", "", "Write command output to `file` instead of stdout")
+	flag.StringVar(&output, "o", "", "Shorthand for --output")
+	flag.BoolVar(&noNetwork, "no-network", false, "Never access the network; use cached indexes and packages only")
+	flag.BoolVar(&noRecommends, "no-install-recommends", false, "Do not install packages that are only recommended")
+	flag.IntVar(&jobs, "jobs", 4, "Number of concurrent downloads; 1 is sequential, 0 uses every CPU")
+	flag.IntVar(&jobs, "j", 4, "Shorthand for --jobs")
 	flag.Usage = usage
 	flag.Parse()
 
@@ -30,6 +69,22 @@ func main() {
 		usage()
 		os.Exit(1)
 	}
+	managerOptions = append(managerOptions, pkgmgr.WithWorkers(jobs))
+	if noNetwork {
+		managerOptions = append(managerOptions, pkgmgr.WithNoNetwork())
+	}
+	if noRecommends {
+		managerOptions = append(managerOptions, pkgmgr.WithNoInstallRecommends(true))
+	}
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			fatal(err)
+		}
+		outputFile = file
+		stdout = file
+		defer closeOutput()
+	}
 
 	logging.Debugf("main: command %s invoked with %d args", args[0], len(args)-1)
 
@@ -41,41 +96,74 @@ func main() {
 	case "version", "--version", "-V":
 		printVersion()
 		return
+	case "init":
+		runInit(rest)
 	case "update":
-		manager := mustManager(conf)
-		if err := manager.Update(ctx); err != nil {
-			fatal(err)
-		}
-		fmt.Println("Package lists updated.")
+		runUpdate(ctx, conf, rest)
 	case "clean":
-		manager := mustManager(conf)
-		if err := manager.Clean(); err != nil {
-			fatal(err)
-		}
+		runClean(conf, rest)
+	case "cache-info":
+		runCacheInfo(conf, rest)
 	case "install":
 		runInstall(ctx, conf, rest)
 	case "download":
 		runDownload(ctx, conf, rest)
+	case "fetch":
+		runFetch(ctx, conf, rest)
+	case "download-source":
+		runDownloadSource(ctx, conf, rest)
 	case "upgrade":
 		runUpgrade(ctx, conf, rest)
+	case "mirror":
+		runMirror(ctx, conf, rest)
 	case "list":
 		runList(ctx, conf, rest, false)
 	case "list-installed":
 		runList(ctx, conf, rest, true)
 	case "list-upgradable":
 		runListUpgradable(ctx, conf, rest)
+	case "list-orphaned":
+		runListOrphaned(ctx, conf, rest)
 	case "info":
 		runInfo(ctx, conf, rest)
 	case "status":
 		runStatus(conf, rest)
 	case "find":
 		runFind(ctx, conf, rest)
+	case "search":
+		runSearch(ctx, conf, rest)
 	case "compare-versions":
 		runCompareVersions(rest)
+	case "list-feeds":
+		runListFeeds(conf, rest)
+	case "source":
+		runSource(ctx, conf, rest)
+	case "changelog":
+		runChangelog(ctx, conf, rest)
+	case "policy":
+		runPolicy(ctx, conf, rest)
+	case "check":
+		runCheck(conf, rest)
+	case "repair-db":
+		runRepairDB(conf, rest)
+	case "check-feeds":
+		runCheckFeeds(ctx, conf, rest)
+	case "enable-feed", "disable-feed":
+		runSetFeed(conf, rest, cmd == "disable-feed")
 	case "print-architecture":
-		runPrintArchitecture(conf)
+		runPrintArchitecture(conf, rest)
 	case "depends":
 		runDepends(ctx, conf, rest)
+	case "common-deps":
+		runCommonDeps(ctx, conf, rest)
+	case "unique-deps":
+		runUniqueDeps(ctx, conf, rest)
+	case "explain":
+		runExplain(ctx, conf, rest)
+	case "dep-graph":
+		runDepGraph(ctx, conf, rest)
+	case "dep-path":
+		runDepPath(ctx, conf, rest)
 	case "whatdepends":
 		runReverse(ctx, conf, rest, "whatdepends", pkgmgr.ReverseDependencyQuery{Field: "Depends"})
 	case "whatdependsrec":
@@ -86,275 +174,1363 @@ func main() {
 		runReverse(ctx, conf, rest, "whatsuggests", pkgmgr.ReverseDependencyQuery{Field: "Suggests"})
 	case "whatprovides":
 		runReverse(ctx, conf, rest, "whatprovides", pkgmgr.ReverseDependencyQuery{Field: "Provides"})
+	case "provides":
+		runProvides(ctx, conf, rest)
 	case "whatconflicts":
 		runReverse(ctx, conf, rest, "whatconflicts", pkgmgr.ReverseDependencyQuery{Field: "Conflicts"})
 	case "whatreplaces":
 		runReverse(ctx, conf, rest, "whatreplaces", pkgmgr.ReverseDependencyQuery{Field: "Replaces"})
 	default:
 		usage()
+		closeOutput()
 		os.Exit(1)
 	}
 }
 
-func runInstall(ctx context.Context, conf string, args []string) {
-	if len(args) == 0 {
-		fatal(fmt.Errorf("install command expects at least one package name"))
+func runInit(args []string) {
+	fs := newFlagSet("init")
+	dest := fs.String("dest", "/", "Root destination of the installation")
+	arch := fs.String("arch", defaultArch(), "Native package architecture")
+	feedURI := fs.String("feed-uri", "", "URI of the package feed")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	if fs.NArg() > 1 {
+		fatal(fmt.Errorf("init expects at most one output file"))
+	}
+	destGiven := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "dest" {
+			destGiven = true
+		}
+	})
+
+	if isTerminal(os.Stdin) {
+		in := bufio.NewReader(os.Stdin)
+		*dest = prompt(in, "Root destination", *dest)
+		*arch = prompt(in, "Architecture", *arch)
+		*feedURI = prompt(in, "Feed URI", *feedURI)
+		destGiven = true
+	}
+	if *feedURI == "" {
+		fatal(fmt.Errorf("init requires --feed-uri"))
+	}
+
+	cfg := &config.Config{
+		Options: map[string]string{},
+		// Lower priorities are preferred, so native packages win over
+		// architecture independent builds of the same package.
+		Architectures: []config.Architecture{
+			{Name: *arch, Priority: 1},
+			{Name: "all", Priority: 10},
+		},
+		Destinations: []config.Destination{{Name: "root", Path: *dest}},
+		Feeds:        []config.Feed{{Name: "base", URI: *feedURI, Type: "src/gz"}},
+	}
+	if destGiven {
+		cfg.Options["status_file"] = filepath.Join(*dest, "usr/lib/opkg/status")
+	}
+
+	if fs.NArg() == 0 {
+		if _, err := cfg.WriteTo(stdout); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	file, err := os.OpenFile(fs.Arg(0), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		fatal(err)
+	}
+	if _, err := cfg.WriteTo(file); err != nil {
+		file.Close()
+		fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		fatal(err)
+	}
+}
+
+// prompt asks for a value on stderr and returns the answer, or def when the
+// answer is empty.
+func prompt(in *bufio.Reader, label, def string) string {
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", label, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", label)
+	}
+	answer, _ := in.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// defaultArch maps the architecture of the running binary to the name opkg
+// feeds commonly use for it.
+func defaultArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "i686"
+	case "arm64":
+		return "aarch64"
+	case "arm":
+		return "armv7a"
+	case "mipsle":
+		return "mipsel"
+	}
+	return runtime.GOARCH
+}
+
+func runUpdate(ctx context.Context, conf string, args []string) {
+	fs := newFlagSet("update")
+	verbose := fs.Bool("verbose", false, "Print the progress of each feed")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	manager := mustManager(conf)
+	if !*verbose {
+		if err := manager.Update(ctx); err != nil {
+			fatal(err)
+		}
+		fmt.Fprintln(stdout, "Package lists updated.")
+		return
+	}
+	events, err := manager.UpdateWithEvents(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	var firstErr error
+	for ev := range events {
+		switch ev.Status {
+		case pkgmgr.FeedFetching:
+			fmt.Fprintf(stdout, "Fetching %s (%s)\n", ev.Feed.Name, ev.Feed.URI)
+		case pkgmgr.FeedDone:
+			fmt.Fprintf(stdout, "Updated %s\n", ev.Feed.Name)
+		case pkgmgr.FeedError:
+			if ev.Feed.Name == "" {
+				fmt.Fprintf(stdout, "Update failed: %v\n", ev.Err)
+			} else {
+				fmt.Fprintf(stdout, "Failed %s: %v\n", ev.Feed.Name, ev.Err)
+			}
+			if firstErr == nil {
+				firstErr = ev.Err
+			}
+		}
+	}
+	if firstErr != nil {
+		fatal(firstErr)
+	}
+}
+
+func runClean(conf string, args []string) {
+	fs := newFlagSet("clean")
+	stale := fs.String("stale", "", "Only remove cache files older than `age`, e.g. 7d or 12h")
+	orphaned := fs.Bool("orphaned", false, "Only remove archives of packages no longer in any feed")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	if fs.NArg() > 0 {
+		fatal(fmt.Errorf("clean takes no arguments"))
+	}
+	manager := mustManager(conf)
+	var err error
+	switch {
+	case *stale != "" && *orphaned:
+		fatal(errors.New("--stale and --orphaned are mutually exclusive"))
+	case *stale != "":
+		maxAge, perr := parseAge(*stale)
+		if perr != nil {
+			fatal(perr)
+		}
+		err = manager.CleanStale(maxAge)
+	case *orphaned:
+		err = manager.CleanOrphaned()
+	default:
+		err = manager.Clean()
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func runCacheInfo(conf string, args []string) {
+	if len(args) > 0 {
+		fatal(fmt.Errorf("cache-info takes no arguments"))
+	}
+	manager := mustManager(conf)
+	sizes, err := manager.CacheSizeByType()
+	if err != nil {
+		fatal(err)
+	}
+	var total int64
+	for _, ext := range slices.Sorted(maps.Keys(sizes)) {
+		label := ext
+		if label == "" {
+			label = "(other)"
+		}
+		fmt.Fprintf(stdout, "%-12s %s\n", label, humanSize(sizes[ext]))
+		total += sizes[ext]
+	}
+	fmt.Fprintf(stdout, "%-12s %s\n", "total", humanSize(total))
+}
+
+// humanSize formats n bytes with a binary unit, e.g. "512 B" or "1.5 MiB".
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n) / unit
+	suffix := "KiB"
+	for _, next := range []string{"MiB", "GiB", "TiB"} {
+		if value < unit {
+			break
+		}
+		value /= unit
+		suffix = next
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
+
+// parseAge parses a duration as accepted by time.ParseDuration, adding a
+// "d" suffix for whole days.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
+
+// refreshIndexes updates the indexes before packages are installed or
+// upgraded. Simulations only read the indexes cached by the last update, so
+// they change nothing and work offline.
+func refreshIndexes(ctx context.Context, manager *pkgmgr.Manager, simulate bool) error {
+	if simulate {
+		return manager.LoadCached()
+	}
+	return manager.Update(ctx)
+}
+
+func runInstall(ctx context.Context, conf string, args []string) {
+	fs := newFlagSet("install")
+	dest := fs.String("dest", "", "Install into the named destination")
+	partial := fs.Bool("allow-partial", false, "Keep the packages that were downloaded when others fail")
+	simulate := fs.Bool("simulate", false, "Show what would be installed without downloading anything")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		fatal(fmt.Errorf("install command expects at least one package name"))
+	}
+	manager := mustScopedManager(conf, *dest)
+	if err := refreshIndexes(ctx, manager, *simulate); err != nil {
+		fatal(err)
+	}
+	if *simulate {
+		res, err := manager.Simulate(ctx, pkgmgr.SimulateOptions{Install: args})
+		if err != nil {
+			fatal(err)
+		}
+		printSimulation(res)
+		fmt.Fprintf(stdout, "%d packages will be installed\n", len(res.ToInstall))
+		if len(res.ToUpgrade) > 0 {
+			fmt.Fprintf(stdout, "%d packages will be upgraded\n", len(res.ToUpgrade))
+		}
+		return
+	}
+	conflicts, err := manager.ListConflicting(args)
+	if err != nil {
+		fatal(err)
+	}
+	if len(conflicts) > 0 {
+		fmt.Fprintln(os.Stderr, "The following conflicts prevent installation:")
+		for _, c := range conflicts {
+			fmt.Fprintf(os.Stderr, "  %s conflicts with installed package %s (%s)\n", c.Package, c.ConflictingWith, c.ConflictType)
+		}
+		fatal(&pkgmgr.ConflictError{Conflicts: conflicts})
+	}
+	results, err := manager.InstallMultipleWith(ctx, args, pkgmgr.InstallOptions{AllowPartial: *partial})
+	for _, res := range results {
+		if res.Err == nil && res.Warning == nil && (err == nil || *partial) {
+			fmt.Fprintf(stdout, "%s -> %s\n", res.Name, res.Dest)
+		}
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func runDownload(ctx context.Context, conf string, args []string) {
+	if len(args) == 0 {
+		fatal(fmt.Errorf("download command expects a package name"))
+	}
+	manager := mustManager(conf)
+	if err := manager.Update(ctx); err != nil {
+		fatal(err)
+	}
+	for _, name := range args {
+		dest, err := manager.Download(ctx, name)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(stdout, "%s -> %s\n", name, dest)
+	}
+}
+
+// runFetch downloads packages like runDownload but uses the indexes cached
+// by the last update instead of refreshing them.
+func runFetch(ctx context.Context, conf string, args []string) {
+	if len(args) == 0 {
+		fatal(fmt.Errorf("fetch command expects a package name"))
+	}
+	manager := mustManager(conf)
+	if err := manager.LoadCached(); err != nil {
+		fatal(err)
+	}
+	for _, name := range args {
+		dest, err := manager.Download(ctx, name)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(stdout, "%s -> %s\n", name, dest)
+	}
+}
+
+func runDownloadSource(ctx context.Context, conf string, args []string) {
+	if len(args) == 0 {
+		fatal(fmt.Errorf("download-source command expects a package name"))
+	}
+	manager := mustManager(conf)
+	if err := manager.Update(ctx); err != nil {
+		fatal(err)
+	}
+	for _, name := range args {
+		dest, err := manager.DownloadSource(ctx, name)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(stdout, "%s -> %s\n", name, dest)
+	}
+}
+
+func runChangelog(ctx context.Context, conf string, args []string) {
+	fs := newFlagSet("changelog")
+	format := formatFlag(fs, "raw")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	if fs.NArg() != 1 {
+		fatal(errors.New("changelog expects exactly one package name"))
+	}
+	name, outFormat := fs.Arg(0), format()
+	manager := mustManager(conf)
+	if err := manager.Update(ctx); err != nil {
+		fatal(err)
+	}
+	u, err := manager.ChangelogURL(name)
+	if err != nil {
+		fatal(err)
+	}
+	content, err := manager.Changelog(ctx, name)
+	if err != nil {
+		fatal(err)
+	}
+	if outFormat == "json" {
+		writeJSON(jsonout.ChangelogJSON{Package: name, URL: u, Content: content})
+		return
+	}
+	fmt.Fprint(stdout, content)
+}
+
+func runUpgrade(ctx context.Context, conf string, args []string) {
+	fs := newFlagSet("upgrade")
+	dest := fs.String("dest", "", "Upgrade packages in the named destination")
+	simulate := fs.Bool("simulate", false, "Show what would be upgraded without downloading anything")
+	keepGoing := fs.Bool("continue-on-error", false, "Upgrade the remaining packages when one fails instead of undoing the upgrade")
+	safe := fs.Bool("safe", false, "Keep back packages whose new version needs packages that are not installed")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	manager := mustScopedManager(conf, *dest)
+	if err := refreshIndexes(ctx, manager, *simulate); err != nil {
+		fatal(err)
+	}
+	if *simulate {
+		res, err := manager.Simulate(ctx, pkgmgr.SimulateOptions{Upgrade: true, Patterns: fs.Args()})
+		if err != nil {
+			fatal(err)
+		}
+		printSimulation(res)
+		fmt.Fprintf(stdout, "%d packages will be upgraded\n", len(res.ToUpgrade))
+		if len(res.ToInstall) > 0 {
+			fmt.Fprintf(stdout, "%d packages will be installed\n", len(res.ToInstall))
+		}
+		return
+	}
+	results, err := manager.UpgradeWith(ctx, fs.Args(), pkgmgr.UpgradeOptions{ContinueOnError: *keepGoing, SafeUpgrade: *safe})
+	if len(results) == 0 && err == nil {
+		fmt.Fprintln(stdout, "No packages to upgrade.")
+		return
+	}
+	for _, res := range results {
+		switch {
+		case len(res.KeptBack) > 0:
+			fmt.Fprintf(stdout, "%s: kept back, %s needs %s\n", res.Upgrade.Name, res.Upgrade.Available, strings.Join(res.KeptBack, ", "))
+		case res.Err == nil:
+			fmt.Fprintf(stdout, "%s: %s -> %s (%s)\n", res.Upgrade.Name, res.Upgrade.Installed, res.Upgrade.Available, res.Destination)
+		}
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// printSimulation lists a planned operation in the style of apt-get -s:
+// removals first, then installs and upgrades, with the installed version in
+// brackets and the new version and feed in parentheses.
+func printSimulation(res pkgmgr.SimulationResult) {
+	for _, pkg := range res.ToRemove {
+		fmt.Fprintf(stdout, "Remv %s [%s]\n", pkg.Name, pkg.Version)
+	}
+	installs := slices.Concat(res.ToInstall, res.ToUpgrade)
+	sort.Slice(installs, func(i, j int) bool { return installs[i].Name < installs[j].Name })
+	for _, pkg := range installs {
+		if pkg.Installed != "" {
+			fmt.Fprintf(stdout, "Inst %s [%s] (%s %s)\n", pkg.Name, pkg.Installed, pkg.Version, pkg.Feed)
+		} else {
+			fmt.Fprintf(stdout, "Inst %s (%s %s)\n", pkg.Name, pkg.Version, pkg.Feed)
+		}
+	}
+	for _, c := range res.Conflicts {
+		fmt.Fprintf(stdout, "Conf %s conflicts with installed package %s (%s)\n", c.Package, c.ConflictingWith, c.ConflictType)
+	}
+	for _, name := range res.Missing {
+		fmt.Fprintf(stdout, "Missing pre-dependency %s\n", name)
+	}
+}
+
+func runMirror(ctx context.Context, conf string, args []string) {
+	fs := newFlagSet("mirror")
+	arches := fs.String("arch", "", "Comma separated list of architectures to mirror")
+	dryRun := fs.Bool("dry-run", false, "Show what would be mirrored without downloading")
+	workers := fs.Int("workers", 0, "Number of concurrent downloads; defaults to --jobs")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	if fs.NArg() != 1 {
+		fatal(fmt.Errorf("mirror expects a destination directory"))
+	}
+	manager := mustManager(conf)
+	if err := manager.Update(ctx); err != nil {
+		fatal(err)
+	}
+	var mirrored, failed int
+	var bytes int64
+	err := manager.Mirror(ctx, fs.Arg(0), pkgmgr.MirrorOptions{
+		Architectures: splitFields(*arches),
+		DryRun:        *dryRun,
+		Workers:       *workers,
+		Progress: func(done, total int, pkg repo.Package, err error) {
+			if err != nil {
+				failed++
+				fmt.Fprintf(stdout, "[%d/%d] %s/%s: %v\n", done, total, pkg.Feed.Name, pkg.Name, err)
+				return
+			}
+			mirrored++
+			if size, err := pkg.DownloadSize(); err == nil {
+				bytes += size
+			}
+			fmt.Fprintf(stdout, "[%d/%d] %s/%s\n", done, total, pkg.Feed.Name, pkg.Name)
+		},
+	})
+	verb := "Mirrored"
+	if *dryRun {
+		verb = "Would mirror"
+	}
+	fmt.Fprintf(stdout, "%s %d packages (%d bytes), %d failed\n", verb, mirrored, bytes, failed)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func runList(ctx context.Context, conf string, args []string, installedOnly bool) {
+	manager := mustManager(conf)
+	fs := newFlagSet("list")
+	short := fs.Bool("short-description", false, "Display only the first line of the description")
+	size := fs.Bool("size", false, "Show package size")
+	sections := fs.String("section", "", "Comma separated list of sections to list packages from")
+	maintainer := fs.String("maintainer", "", "Only list packages whose maintainer matches `glob`")
+	provides := fs.String("provides", "", "Only list packages providing a capability matching `glob`")
+	var outFormat func() string
+	withVersion, byDate, byDateDesc, bySection := new(bool), new(bool), new(bool), new(bool)
+	if installedOnly {
+		withVersion = fs.Bool("with-version", false, "Print name==version pairs; same as --format=requirements")
+		byDate = fs.Bool("sort-by-install-date", false, "Sort packages by install date, oldest first")
+		byDateDesc = fs.Bool("sort-by-install-date-desc", false, "Sort packages by install date, newest first")
+		outFormat = formatFlag(fs, "requirements")
+	} else {
+		bySection = fs.Bool("by-section", false, "Group packages under a header for each section")
+		outFormat = formatFlag(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	patterns := fs.Args()
+	if *byDate && *byDateDesc {
+		fatal(errors.New("--sort-by-install-date and --sort-by-install-date-desc are mutually exclusive"))
+	}
+	if _, err := path.Match(*maintainer, ""); err != nil {
+		fatal(fmt.Errorf("invalid --maintainer pattern %q: %w", *maintainer, err))
+	}
+	if _, err := path.Match(*provides, ""); err != nil {
+		fatal(fmt.Errorf("invalid --provides pattern %q: %w", *provides, err))
+	}
+	opts := pkgmgr.ListOptions{
+		InstalledOnly:         installedOnly,
+		Patterns:              patterns,
+		ShortDescription:      *short,
+		IncludeSize:           *size,
+		Sections:              splitFields(*sections),
+		MaintainerPattern:     *maintainer,
+		ProvidesFilter:        *provides,
+		SortByInstallDate:     *byDate || *byDateDesc,
+		InstallDateDescending: *byDateDesc,
+	}
+	if *withVersion || outFormat() == "requirements" {
+		versions, err := manager.ListInstalledVersionsWith(opts)
+		if err != nil {
+			fatal(err)
+		}
+		for _, line := range requirementLines(versions) {
+			fmt.Fprintln(stdout, line)
+		}
+		return
+	}
+	if !installedOnly {
+		if err := manager.Update(ctx); err != nil {
+			fatal(err)
+		}
+	}
+	if *bySection && outFormat() == "json" {
+		fatal(errors.New("--by-section and --format=json are mutually exclusive"))
+	}
+	if *bySection {
+		groups, err := manager.ListBySection(opts)
+		if err != nil {
+			fatal(err)
+		}
+		for i, section := range slices.Sorted(maps.Keys(groups)) {
+			if i > 0 {
+				fmt.Fprintln(stdout)
+			}
+			fmt.Fprintf(stdout, "%s:\n", section)
+			for _, pkg := range groups[section] {
+				fmt.Fprintf(stdout, "  %s - %s\n", pkg.Name, pkg.Version)
+			}
+		}
+		return
+	}
+	if outFormat() == "json" {
+		pkgs, err := manager.ListPackagesJSON(opts)
+		if err != nil {
+			fatal(err)
+		}
+		writeJSON(pkgs)
+		return
+	}
+	lines, errc := manager.StreamPackages(ctx, opts)
+	for line := range lines {
+		fmt.Fprintln(stdout, line)
+	}
+	if err := <-errc; err != nil {
+		fatal(err)
+	}
+}
+
+// requirementLines formats versions as name==version lines sorted by name.
+func requirementLines(versions map[string]string) []string {
+	names := slices.Sorted(maps.Keys(versions))
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, name+"=="+versions[name])
+	}
+	return lines
+}
+
+func runListOrphaned(ctx context.Context, conf string, args []string) {
+	if len(args) > 0 {
+		fatal(fmt.Errorf("list-orphaned takes no arguments"))
+	}
+	manager := mustManager(conf)
+	if err := manager.Update(ctx); err != nil {
+		fatal(err)
+	}
+	orphaned, err := manager.ListOrphaned()
+	if err != nil {
+		fatal(err)
+	}
+	for _, pkg := range orphaned {
+		fmt.Fprintf(stdout, "%s - %s\n", pkg.Name, pkg.Version)
+	}
+}
+
+func runListUpgradable(ctx context.Context, conf string, args []string) {
+	manager := mustManager(conf)
+	fs := newFlagSet("list-upgradable")
+	outFormat := formatFlag(fs)
+	minorOnly := fs.Bool("minor-only", false, "Skip major and epoch upgrades")
+	majorOnly := fs.Bool("major-only", false, "Only show major and epoch upgrades")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	if *minorOnly && *majorOnly {
+		fatal(errors.New("--minor-only and --major-only are mutually exclusive"))
+	}
+	if err := manager.Update(ctx); err != nil {
+		fatal(err)
+	}
+	candidates, err := manager.ListUpgradable(fs.Args())
+	if err != nil {
+		fatal(err)
+	}
+	if *minorOnly || *majorOnly {
+		candidates = slices.DeleteFunc(candidates, func(c pkgmgr.UpgradeCandidate) bool {
+			return isMajorUpgrade(c.ChangeType) != *majorOnly
+		})
+	}
+	if outFormat() == "json" {
+		writeJSON(pkgmgr.UpgradeCandidatesJSON(candidates))
+		return
+	}
+	for _, c := range candidates {
+		fmt.Fprintf(stdout, "%s - %s -> %s [%s] %s\n", c.Name, c.Installed, c.Available, c.ChangeType, c.Description)
+	}
+}
+
+// isMajorUpgrade reports whether change is filtered out by --minor-only and
+// kept by --major-only. Epoch changes count as major.
+func isMajorUpgrade(change version.VersionChange) bool {
+	return change == version.MajorChange || change == version.EpochChange
+}
+
+func runInfo(ctx context.Context, conf string, args []string) {
+	manager := mustManager(conf)
+	fs := newFlagSet("info")
+	fieldsFlag := fs.String("fields", "", "Comma separated list of fields to display")
+	short := fs.Bool("short-description", false, "Display only the first line of the description")
+	raw := fs.Bool("raw", false, "Print the paragraphs exactly as they appear in the index")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	if *raw && *fieldsFlag != "" {
+		fatal(errors.New("--raw and --fields are mutually exclusive"))
+	}
+	if err := manager.Update(ctx); err != nil {
+		fatal(err)
+	}
+	patterns := fs.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"*"}
+	}
+	info := manager.InfoParagraphs
+	if *raw {
+		info = manager.RawInfoParagraphs
+	}
+	paragraphs, err := info(patterns)
+	if err != nil {
+		fatal(err)
+	}
+	fields := splitFields(*fieldsFlag)
+	for i, p := range paragraphs {
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		if *raw {
+			stdout.Write(p.RawBytes())
+			continue
+		}
+		fmt.Fprintln(stdout, formatParagraph(p, fields, *short))
+	}
+}
+
+func runStatus(conf string, args []string) {
+	manager := mustManager(conf)
+	fs := newFlagSet("status")
+	fieldsFlag := fs.String("fields", "", "Comma separated list of fields to display")
+	short := fs.Bool("short-description", false, "Display only the first line of the description")
+	notInstalled := fs.Bool("not-installed", false, "Only show entries that are not fully installed")
+	halfInstalled := fs.Bool("half-installed", false, "Only show half-installed entries")
+	configFiles := fs.Bool("config-files-only", false, "Only show removed entries that kept their configuration files")
+	outFormat := formatFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	patterns := fs.Args()
+	keep := statusSelector(*notInstalled, *halfInstalled, *configFiles)
+	if outFormat() == "json" {
+		entries := []jsonout.StatusEntryJSON{}
+		for _, entry := range manager.StatusJSON(patterns) {
+			if keep(entry.Status) {
+				entries = append(entries, entry)
+			}
+		}
+		writeJSON(entries)
+		return
+	}
+	fields := splitFields(*fieldsFlag)
+	printed := false
+	for _, entry := range manager.GlobStatusEntries(patterns) {
+		if !keep(entry.Status) {
+			continue
+		}
+		if printed {
+			fmt.Fprintln(stdout)
+		}
+		fmt.Fprintln(stdout, formatParagraph(entry.Raw, fields, *short))
+		printed = true
+	}
+}
+
+// statusSelector returns a predicate over Status fields for the status
+// command's filter flags. Entries matching any selected filter are kept;
+// without filters every entry is.
+func statusSelector(notInstalled, halfInstalled, configFiles bool) func(string) bool {
+	if !notInstalled && !halfInstalled && !configFiles {
+		return func(string) bool { return true }
+	}
+	return func(status string) bool {
+		es := pkgdb.ParseEntryStatus(status)
+		switch {
+		case notInstalled && !(pkgdb.Entry{Status: status}).IsFullyInstalled():
+			return true
+		case halfInstalled && es.Status == "half-installed":
+			return true
+		case configFiles && es.Status == "config-files":
+			return true
+		}
+		return false
+	}
+}
+
+func runFind(ctx context.Context, conf string, args []string) {
+	if len(args) == 0 {
+		fatal(fmt.Errorf("find command expects a pattern"))
+	}
+	manager := mustManager(conf)
+	if err := manager.Update(ctx); err != nil {
+		fatal(err)
+	}
+	matches, err := manager.FindPackages(ctx, strings.Join(args, " "))
+	if err != nil {
+		fatal(err)
+	}
+	for _, pkg := range matches {
+		desc := pkg.Description
+		if idx := strings.IndexByte(desc, '\n'); idx >= 0 {
+			desc = desc[:idx]
+		}
+		fmt.Fprintf(stdout, "%s - %s\n", pkg.Name, desc)
+	}
+}
+
+func runSearch(ctx context.Context, conf string, args []string) {
+	fs := newFlagSet("search")
+	var opts pkgmgr.SearchOptions
+	fs.StringVar(&opts.NamePattern, "name-glob", "", "Only packages whose name matches `pattern`")
+	fs.StringVar(&opts.DescPattern, "desc", "", "Only packages whose description contains `text`")
+	fs.BoolVar(&opts.Regex, "regex", false, "Treat --name-glob and --desc as regular expressions")
+	fs.Func("filter", "Add a `key=value` filter: name, desc, section or maintainer; repeatable", func(v string) error {
+		return addSearchFilter(&opts, v)
+	})
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	if fs.NArg() > 0 {
+		fatal(fmt.Errorf("search takes no arguments; use --name-glob, --desc or --filter"))
+	}
+	manager := mustManager(conf)
+	if err := manager.Update(ctx); err != nil {
+		fatal(err)
+	}
+	matches, err := manager.Search(ctx, opts)
+	if err != nil {
+		fatal(err)
+	}
+	for _, pkg := range matches {
+		desc := pkg.Description
+		if idx := strings.IndexByte(desc, '\n'); idx >= 0 {
+			desc = desc[:idx]
+		}
+		fmt.Fprintf(stdout, "%s - %s\n", pkg.Name, desc)
+	}
+}
+
+// addSearchFilter applies a --filter key=value argument of search to opts.
+// Sections accumulate; the other keys may be given once.
+func addSearchFilter(opts *pkgmgr.SearchOptions, arg string) error {
+	key, value, ok := strings.Cut(arg, "=")
+	if !ok || value == "" {
+		return fmt.Errorf("filter %q is not of the form key=value", arg)
+	}
+	var target *string
+	switch key {
+	case "section":
+		opts.Sections = append(opts.Sections, value)
+		return nil
+	case "name":
+		target = &opts.NamePattern
+	case "desc":
+		target = &opts.DescPattern
+	case "maintainer":
+		target = &opts.Maintainer
+	default:
+		return fmt.Errorf("unknown filter %q; want name, desc, section or maintainer", key)
+	}
+	if *target != "" {
+		return fmt.Errorf("filter %s given more than once", key)
+	}
+	*target = value
+	return nil
+}
+
+func runCompareVersions(args []string) {
+	fs := newFlagSet("compare-versions")
+	sortVersions := fs.Bool("sort", false, "Print the given versions in ascending order")
+	reverse := fs.Bool("reverse", false, "Sort in descending order")
+	greatest := fs.Bool("greatest", false, "Print only the greatest of the given versions")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	args = fs.Args()
+	if *sortVersions || *reverse || *greatest {
+		if len(args) == 0 {
+			fatal(fmt.Errorf("compare-versions expects at least one version"))
+		}
+		if *greatest {
+			latest, err := version.Latest(args)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Fprintln(stdout, latest)
+			return
+		}
+		version.Sort(args)
+		if *reverse {
+			for i, j := 0, len(args)-1; i < j; i, j = i+1, j-1 {
+				args[i], args[j] = args[j], args[i]
+			}
+		}
+		for _, v := range args {
+			fmt.Fprintln(stdout, v)
+		}
+		return
+	}
+	if len(args) != 3 {
+		fatal(fmt.Errorf("compare-versions expects <v1> <op> <v2>"))
+	}
+	ok, err := version.CompareOp(args[0], args[1], args[2])
+	if err != nil {
+		fatal(err)
+	}
+	if ok {
+		fmt.Fprintln(stdout, "true")
+	} else {
+		fmt.Fprintln(stdout, "false")
+	}
+}
+
+// dependsFields lists the relationships depends prints, in order.
+var dependsFields = []string{"Depends", "Pre-Depends", "Recommends", "Suggests", "Provides", "Conflicts", "Replaces"}
+
+func runDepends(ctx context.Context, conf string, args []string) {
+	fs := newFlagSet("depends")
+	includeAll := fs.Bool("A", false, "Query all packages, not just installed ones")
+	fs.BoolVar(includeAll, "all", false, "Query all packages, not just installed ones")
+	recursive := fs.Bool("recursive", false, "Also show the relationships of every package depended on, transitively")
+	transitive := fs.Bool("transitive", false, "List the names of every package required, transitively")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	patterns := fs.Args()
+	if len(patterns) == 0 {
+		fatal(fmt.Errorf("depends expects at least one package name"))
+	}
+	if *recursive && *transitive {
+		fatal(errors.New("--recursive and --transitive are mutually exclusive"))
 	}
 	manager := mustManager(conf)
 	if err := manager.Update(ctx); err != nil {
 		fatal(err)
 	}
-	for _, name := range args {
-		dest, err := manager.Install(ctx, name)
+	if *transitive {
+		if len(patterns) != 1 {
+			fatal(errors.New("depends --transitive expects one package name"))
+		}
+		deps, err := manager.TransitiveDependencies(ctx, patterns[0], pkgmgr.TransitiveOptions{})
+		if err != nil {
+			fatal(err)
+		}
+		for _, name := range deps {
+			fmt.Fprintln(stdout, name)
+		}
+		return
+	}
+	paragraphs, err := manager.InfoParagraphs(patterns)
+	if err != nil {
+		fatal(err)
+	}
+	if len(paragraphs) == 0 {
+		return
+	}
+	printed := map[string]bool{}
+	printBlock := func(name string, value func(field string) string) {
+		if len(printed) > 0 {
+			fmt.Fprintln(stdout)
+		}
+		printed[name] = true
+		fmt.Fprintf(stdout, "Package: %s\n", name)
+		for _, field := range dependsFields {
+			if v := value(field); v != "" {
+				fmt.Fprintf(stdout, "  %s: %s\n", field, v)
+			}
+		}
+	}
+	for _, p := range paragraphs {
+		name := p.Value("Package")
+		if !*includeAll && !manager.Status().Installed(name) {
+			continue
+		}
+		if name == "" || *recursive && printed[name] {
+			continue
+		}
+		if !*recursive {
+			printBlock(name, p.Value)
+			continue
+		}
+		tree, err := manager.RecursiveDependencies(name, *includeAll)
 		if err != nil {
 			fatal(err)
 		}
-		fmt.Printf("%s -> %s\n", name, dest)
+		// Print in the order the packages were discovered.
+		queue := []string{name}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			deps, ok := tree[current]
+			if !ok || printed[current] {
+				continue
+			}
+			printBlock(current, func(field string) string { return strings.Join(deps[field], ", ") })
+			queue = append(queue, deps["Pre-Depends"]...)
+			queue = append(queue, deps["Depends"]...)
+		}
 	}
 }
 
-func runDownload(ctx context.Context, conf string, args []string) {
+func runCommonDeps(ctx context.Context, conf string, args []string) {
 	if len(args) == 0 {
-		fatal(fmt.Errorf("download command expects a package name"))
+		fatal(fmt.Errorf("common-deps expects at least one package name"))
 	}
 	manager := mustManager(conf)
 	if err := manager.Update(ctx); err != nil {
 		fatal(err)
 	}
-	for _, name := range args {
-		dest, err := manager.Download(ctx, name)
-		if err != nil {
-			fatal(err)
-		}
-		fmt.Printf("%s -> %s\n", name, dest)
+	deps, err := manager.CommonDependencies(args)
+	if err != nil {
+		fatal(err)
+	}
+	for _, name := range deps {
+		fmt.Fprintln(stdout, name)
 	}
 }
 
-func runUpgrade(ctx context.Context, conf string, args []string) {
+func runUniqueDeps(ctx context.Context, conf string, args []string) {
+	if len(args) != 1 {
+		fatal(fmt.Errorf("unique-deps expects exactly one package name"))
+	}
 	manager := mustManager(conf)
 	if err := manager.Update(ctx); err != nil {
 		fatal(err)
 	}
-	results, err := manager.Upgrade(ctx, args)
+	deps, err := manager.UniqueDependencies(args[0])
 	if err != nil {
 		fatal(err)
 	}
-	if len(results) == 0 {
-		fmt.Println("No packages to upgrade.")
-		return
-	}
-	for _, res := range results {
-		fmt.Printf("%s: %s -> %s (%s)\n", res.Upgrade.Name, res.Upgrade.Installed, res.Upgrade.Available, res.Destination)
+	for _, name := range deps {
+		fmt.Fprintln(stdout, name)
 	}
 }
 
-func runList(ctx context.Context, conf string, args []string, installedOnly bool) {
+func runExplain(ctx context.Context, conf string, args []string) {
+	if len(args) != 1 {
+		fatal(fmt.Errorf("explain expects exactly one package name"))
+	}
 	manager := mustManager(conf)
-	fs := newFlagSet("list")
-	short := fs.Bool("short-description", false, "Display only the first line of the description")
-	size := fs.Bool("size", false, "Show package size")
+	if err := manager.Update(ctx); err != nil {
+		fatal(err)
+	}
+	text, err := manager.Explain(args[0])
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Fprint(stdout, text)
+}
+
+func runDepPath(ctx context.Context, conf string, args []string) {
+	fs := newFlagSet("dep-path")
+	all := fs.Bool("all-paths", false, "Print every dependency path instead of the shortest one")
 	if err := fs.Parse(args); err != nil {
 		fatal(err)
 	}
-	patterns := fs.Args()
-	if !installedOnly {
-		if err := manager.Update(ctx); err != nil {
+	if fs.NArg() != 2 {
+		fatal(fmt.Errorf("dep-path expects two package names"))
+	}
+	from, to := fs.Arg(0), fs.Arg(1)
+	manager := mustManager(conf)
+	if err := manager.Update(ctx); err != nil {
+		fatal(err)
+	}
+	var paths [][]string
+	if *all {
+		found, err := manager.AllDependencyPaths(from, to)
+		if err != nil {
+			fatal(err)
+		}
+		paths = found
+	} else {
+		path, err := manager.ShortestDependencyPath(from, to)
+		if err != nil {
 			fatal(err)
 		}
+		if path != nil {
+			paths = append(paths, path)
+		}
 	}
-	lines, err := manager.ListPackages(pkgmgr.ListOptions{
-		InstalledOnly:    installedOnly,
-		Patterns:         patterns,
-		ShortDescription: *short,
-		IncludeSize:      *size,
-	})
-	if err != nil {
-		fatal(err)
+	if len(paths) == 0 {
+		fatal(fmt.Errorf("%s does not depend on %s", from, to))
 	}
-	for _, line := range lines {
-		fmt.Println(line)
+	for _, path := range paths {
+		fmt.Fprintln(stdout, strings.Join(path, " -> "))
 	}
 }
 
-func runListUpgradable(ctx context.Context, conf string, args []string) {
-	manager := mustManager(conf)
-	fs := newFlagSet("list-upgradable")
+func runDepGraph(ctx context.Context, conf string, args []string) {
+	fs := newFlagSet("dep-graph")
+	outFormat := formatFlag(fs)
 	if err := fs.Parse(args); err != nil {
 		fatal(err)
 	}
+	manager := mustManager(conf)
 	if err := manager.Update(ctx); err != nil {
 		fatal(err)
 	}
-	candidates, err := manager.ListUpgradable(fs.Args())
+	graph, err := manager.DependencyGraph(fs.Args())
 	if err != nil {
 		fatal(err)
 	}
-	if len(candidates) == 0 {
+	if outFormat() == "json" {
+		writeJSON(graph)
 		return
 	}
-	for _, c := range candidates {
-		fmt.Printf("%s - %s -> %s %s\n", c.Name, c.Installed, c.Available, c.Description)
+	if err := graph.WriteDOT(stdout); err != nil {
+		fatal(err)
 	}
 }
 
-func runInfo(ctx context.Context, conf string, args []string) {
-	manager := mustManager(conf)
-	fs := newFlagSet("info")
-	fieldsFlag := fs.String("fields", "", "Comma separated list of fields to display")
-	short := fs.Bool("short-description", false, "Display only the first line of the description")
+func runPrintArchitecture(conf string, args []string) {
+	fs := newFlagSet("print-architecture")
+	byPriority := fs.Bool("priority", false, "Sort architectures by priority")
+	nameOnly := fs.Bool("name-only", false, "Omit priority numbers")
 	if err := fs.Parse(args); err != nil {
 		fatal(err)
 	}
-	if err := manager.Update(ctx); err != nil {
-		fatal(err)
+	manager := mustManager(conf)
+	arches := manager.CompatibleArchitectures()
+	if *byPriority {
+		sort.SliceStable(arches, func(i, j int) bool { return arches[i].Priority < arches[j].Priority })
 	}
-	patterns := fs.Args()
-	if len(patterns) == 0 {
-		patterns = []string{"*"}
+	for _, arch := range arches {
+		if arch.Priority != 0 && !*nameOnly {
+			fmt.Fprintf(stdout, "%s %d\n", arch.Name, arch.Priority)
+			continue
+		}
+		fmt.Fprintln(stdout, arch.Name)
 	}
-	paragraphs, err := manager.InfoParagraphs(patterns)
-	if err != nil {
+}
+
+func runListFeeds(conf string, args []string) {
+	fs := newFlagSet("list-feeds")
+	outFormat := formatFlag(fs)
+	showStats := fs.Bool("stats", false, "Show package counts from the cached indexes")
+	if err := fs.Parse(args); err != nil {
 		fatal(err)
 	}
-	fields := splitFields(*fieldsFlag)
-	for i, p := range paragraphs {
-		if i > 0 {
-			fmt.Println()
+	manager := mustManager(conf)
+	if outFormat() == "json" {
+		if *showStats {
+			fatal(errors.New("--stats is not supported with --format=json"))
+		}
+		writeJSON(manager.FeedsJSON())
+		return
+	}
+	var stats repo.IndexStats
+	if *showStats {
+		var err error
+		if stats, err = manager.IndexStats(); err != nil {
+			fatal(err)
+		}
+	}
+	for _, feed := range manager.Feeds() {
+		marker := ""
+		if feed.Disabled {
+			marker = " [disabled]"
+		} else if *showStats {
+			marker = fmt.Sprintf(" (%d packages)", stats.ByFeed[feed.Name])
 		}
-		fmt.Println(formatParagraph(p, fields, *short))
+		if updated, ok := manager.FeedUpdatedAt(feed.Name); ok {
+			marker += " last updated: " + updated.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(stdout, "%s %s %s%s\n", feed.Type, feed.Name, feed.URI, marker)
+	}
+	if *showStats {
+		fmt.Fprintf(stdout, "%d feeds, %d packages (%d unique), %.1f packages per feed\n",
+			stats.FeedCount, stats.PackageCount, stats.UniquePackageCount, stats.AveragePackagesPerFeed())
 	}
 }
 
-func runStatus(conf string, args []string) {
+func runSource(ctx context.Context, conf string, args []string) {
+	if len(args) == 0 {
+		fatal(fmt.Errorf("source expects at least one package name"))
+	}
 	manager := mustManager(conf)
-	fs := newFlagSet("status")
-	fieldsFlag := fs.String("fields", "", "Comma separated list of fields to display")
-	short := fs.Bool("short-description", false, "Display only the first line of the description")
-	if err := fs.Parse(args); err != nil {
+	if err := manager.Update(ctx); err != nil {
 		fatal(err)
 	}
-	patterns := fs.Args()
-	paragraphs := manager.GlobStatus(patterns)
-	fields := splitFields(*fieldsFlag)
-	for i, entry := range paragraphs {
-		if i > 0 {
-			fmt.Println()
+	for _, name := range args {
+		feed, ok := manager.Source(name)
+		if !ok {
+			fatal(fmt.Errorf("no feed known for package %s", name))
+		}
+		if feed.URI == "" {
+			fmt.Fprintf(stdout, "%s: %s\n", name, feed.Name)
+			continue
 		}
-		fmt.Println(formatParagraph(entry, fields, *short))
+		fmt.Fprintf(stdout, "%s: %s (%s)\n", name, feed.Name, feed.URI)
 	}
 }
 
-func runFind(ctx context.Context, conf string, args []string) {
+func runPolicy(ctx context.Context, conf string, args []string) {
 	if len(args) == 0 {
-		fatal(fmt.Errorf("find command expects a pattern"))
+		fatal(fmt.Errorf("policy expects at least one package name"))
 	}
 	manager := mustManager(conf)
 	if err := manager.Update(ctx); err != nil {
 		fatal(err)
 	}
-	matches, err := manager.FindPackages(strings.Join(args, " "))
-	if err != nil {
-		fatal(err)
+	orNone := func(s string) string {
+		if s == "" {
+			return "(none)"
+		}
+		return s
 	}
-	for _, pkg := range matches {
-		desc := pkg.Description
-		if idx := strings.IndexByte(desc, '\n'); idx >= 0 {
-			desc = desc[:idx]
+	for _, name := range args {
+		policy, err := manager.PolicyFor(name)
+		if err != nil {
+			fatal(err)
 		}
-		fmt.Printf("%s - %s\n", pkg.Name, desc)
+		fmt.Fprintf(stdout, "%s:\n", name)
+		fmt.Fprintf(stdout, "  Installed: %s\n", orNone(policy.Installed))
+		fmt.Fprintf(stdout, "  Candidate: %s\n", orNone(policy.Candidate))
+		fmt.Fprintf(stdout, "  Feed: %s\n", orNone(policy.CandidateFeed.Name))
+		fmt.Fprintf(stdout, "  Priority: %d\n", policy.CandidatePriority)
+		fmt.Fprintf(stdout, "  Held: %t\n", policy.Held)
 	}
 }
 
-func runCompareVersions(args []string) {
-	if len(args) != 3 {
-		fatal(fmt.Errorf("compare-versions expects <v1> <op> <v2>"))
+func runCheck(conf string, args []string) {
+	fs := newFlagSet("check")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
 	}
-	ok, err := version.CompareOp(args[0], args[1], args[2])
+	manager := mustManager(conf)
+	problems, err := manager.CheckIntegrity()
 	if err != nil {
 		fatal(err)
 	}
-	if ok {
-		fmt.Println("true")
-	} else {
-		fmt.Println("false")
+	for _, p := range problems {
+		fmt.Fprintln(stdout, p.Error())
+	}
+	if len(problems) > 0 {
+		fatal(fmt.Errorf("%d problems found in the status database", len(problems)))
 	}
 }
 
-func runDepends(ctx context.Context, conf string, args []string) {
-	includeAll, patterns := parseIncludeAll("depends", args)
-	if len(patterns) == 0 {
-		fatal(fmt.Errorf("depends expects at least one package name"))
-	}
-	manager := mustManager(conf)
-	if err := manager.Update(ctx); err != nil {
+func runRepairDB(conf string, args []string) {
+	fs := newFlagSet("repair-db")
+	dryRun := fs.Bool("dry-run", false, "Print the repairs without writing the status database")
+	if err := fs.Parse(args); err != nil {
 		fatal(err)
 	}
-	paragraphs, err := manager.InfoParagraphs(patterns)
+	manager := mustManager(conf)
+	repairs, err := manager.RepairStatusWith(pkgmgr.RepairOptions{DryRun: *dryRun})
 	if err != nil {
 		fatal(err)
 	}
-	if len(paragraphs) == 0 {
-		return
-	}
-	printed := false
-	for _, p := range paragraphs {
-		name := p.Value("Package")
-		if !includeAll && !manager.Status().Installed(name) {
+	for _, r := range repairs {
+		if r.Package == "" {
+			fmt.Fprintln(stdout, r.Message)
 			continue
 		}
-		if name == "" {
-			continue
+		fmt.Fprintf(stdout, "%s: %s\n", r.Package, r.Message)
+	}
+}
+
+func runCheckFeeds(ctx context.Context, conf string, args []string) {
+	fs := newFlagSet("check-feeds")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	manager := mustManager(conf)
+	results := manager.CheckFeeds(ctx)
+	file, ok := stdout.(*os.File)
+	color := ok && isTerminal(file)
+	failed := 0
+	for _, res := range results {
+		state, detail := "OK", fmt.Sprintf("%d packages", res.PackageCount)
+		if res.Partial {
+			detail = "at least " + detail
 		}
-		if printed {
-			fmt.Println()
+		switch {
+		case !res.Reachable:
+			state, detail = "FAIL", fmt.Sprintf("unreachable: %v", res.FetchError)
+		case res.ParseError != nil:
+			state, detail = "FAIL", fmt.Sprintf("invalid index: %v", res.ParseError)
+		}
+		if !res.OK() {
+			failed++
 		}
-		fmt.Printf("Package: %s\n", name)
-		for _, field := range []string{"Depends", "Pre-Depends", "Recommends", "Suggests", "Provides", "Conflicts", "Replaces"} {
-			if value := p.Value(field); value != "" {
-				fmt.Printf("  %s: %s\n", field, value)
+		state = fmt.Sprintf("%-4s", state)
+		if color {
+			code := "32"
+			if !res.OK() {
+				code = "31"
 			}
+			state = "\x1b[" + code + "m" + state + "\x1b[0m"
 		}
-		printed = true
+		fmt.Fprintf(stdout, "%s %-20s %s\n", state, res.Feed.Name, detail)
+	}
+	if failed > 0 {
+		fatal(fmt.Errorf("%d of %d feeds failed", failed, len(results)))
 	}
 }
 
-func runPrintArchitecture(conf string) {
+func runSetFeed(conf string, args []string, disable bool) {
+	if len(args) != 1 {
+		fatal(fmt.Errorf("expected exactly one feed name"))
+	}
 	manager := mustManager(conf)
-	arches := manager.Architectures()
-	for _, arch := range arches {
-		if arch.Priority != 0 {
-			fmt.Printf("%s %d\n", arch.Name, arch.Priority)
-			continue
-		}
-		fmt.Println(arch.Name)
+	var err error
+	if disable {
+		err = manager.DisableFeed(args[0])
+	} else {
+		err = manager.EnableFeed(args[0])
+	}
+	if err != nil {
+		fatal(err)
 	}
 }
 
 func runReverse(ctx context.Context, conf string, args []string, name string, query pkgmgr.ReverseDependencyQuery) {
-	includeAll, patterns := parseIncludeAll(name, args)
-	query.IncludeAll = includeAll
-	query.Patterns = patterns
+	fs := newFlagSet(name)
+	fs.BoolVar(&query.IncludeAll, "A", false, "Query all packages, not just installed ones")
+	fs.BoolVar(&query.IncludeAll, "all", false, "Query all packages, not just installed ones")
+	if query.Recursive {
+		fs.IntVar(&query.Depth, "max-depth", 20, "Stop the search after `N` levels of dependants (0 for no limit)")
+	}
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	query.Patterns = fs.Args()
 	manager := mustManager(conf)
 	if err := manager.Update(ctx); err != nil {
 		fatal(err)
 	}
 	matches, err := manager.ReverseDependencies(query)
-	if err != nil {
+	if errors.Is(err, pkgmgr.ErrDepthLimitReached) {
+		fmt.Fprintln(os.Stderr, "warning: depth limit reached, results may be incomplete")
+	} else if err != nil {
 		fatal(err)
 	}
 	for _, name := range matches {
-		fmt.Println(name)
+		fmt.Fprintln(stdout, name)
 	}
 }
 
-func parseIncludeAll(name string, args []string) (bool, []string) {
-	fs := newFlagSet(name)
-	all := fs.Bool("A", false, "Query all packages, not just installed ones")
-	fs.BoolVar(all, "all", false, "Query all packages, not just installed ones")
-	if err := fs.Parse(args); err != nil {
+func runProvides(ctx context.Context, conf string, args []string) {
+	if len(args) == 0 {
+		fatal(fmt.Errorf("provides expects a capability"))
+	}
+	manager := mustManager(conf)
+	if err := manager.Update(ctx); err != nil {
+		fatal(err)
+	}
+	providers, err := manager.FindProviders(ctx, strings.Join(args, " "))
+	if err != nil {
+		fatal(err)
+	}
+	for _, pkg := range providers {
+		fmt.Fprintf(stdout, "%s - %s\n", pkg.Name, pkg.Version)
+	}
+}
+
+// isTerminal reports whether f refers to a character device such as a TTY.
+// The null device is a character device too but never a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+	return true
+}
+
+// formatFlag registers the --format flag on fs, accepting text, json and the
+// extra formats given. The returned function reports the selected format and
+// must be called after parsing; it exits on an unknown format.
+func formatFlag(fs *flag.FlagSet, extra ...string) func() string {
+	formats := append([]string{"text", "json"}, extra...)
+	f := fs.String("format", "text", "Output format: "+strings.Join(formats, ", "))
+	return func() string {
+		for _, name := range formats {
+			if *f == name {
+				return name
+			}
+		}
+		fatal(fmt.Errorf("unknown output format %q", *f))
+		return ""
+	}
+}
+
+// writeJSON encodes v as indented JSON to stdout.
+func writeJSON(v any) {
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
 		fatal(err)
 	}
-	return *all, fs.Args()
 }
 
 func newFlagSet(name string) *flag.FlagSet {
@@ -434,28 +1610,72 @@ func usage() {
 	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options...] sub-command [arguments...]\n", os.Args[0])
 	fmt.Fprintln(flag.CommandLine.Output(), "\nPackage Manipulation:")
 	fmt.Fprintln(flag.CommandLine.Output(), "  update                          Update list of available packages")
-	fmt.Fprintln(flag.CommandLine.Output(), "  upgrade [pkgs]                  Upgrade installed packages")
-	fmt.Fprintln(flag.CommandLine.Output(), "  install <pkgs>                  Install package(s)")
+	fmt.Fprintln(flag.CommandLine.Output(), "  upgrade [--dest d] [--simulate] [--continue-on-error] [--safe] [pkgs]")
+	fmt.Fprintln(flag.CommandLine.Output(), "                                  Upgrade installed packages")
+	fmt.Fprintln(flag.CommandLine.Output(), "  install [--dest d] [--allow-partial] [--simulate] <pkgs>")
+	fmt.Fprintln(flag.CommandLine.Output(), "                                  Install package(s)")
 	fmt.Fprintln(flag.CommandLine.Output(), "  download <pkgs>                 Download package(s) to the cache")
-	fmt.Fprintln(flag.CommandLine.Output(), "  clean                           Clean internal cache")
+	fmt.Fprintln(flag.CommandLine.Output(), "  fetch <pkgs>                    Download package(s) using the cached indexes")
+	fmt.Fprintln(flag.CommandLine.Output(), "  download-source <pkgs>          Download the source tarball of package(s)")
+	fmt.Fprintln(flag.CommandLine.Output(), "  mirror <dest-dir>               Download all feed packages into a local mirror")
+	fmt.Fprintln(flag.CommandLine.Output(), "  clean [--stale age|--orphaned]  Clean internal cache")
+	fmt.Fprintln(flag.CommandLine.Output(), "  cache-info                      Show the size of the cache by file type")
+	fmt.Fprintln(flag.CommandLine.Output(), "  enable-feed <feed>              Enable a disabled feed")
+	fmt.Fprintln(flag.CommandLine.Output(), "  disable-feed <feed>             Disable a feed without removing it")
 	fmt.Fprintln(flag.CommandLine.Output(), "\nInformational Commands:")
-	fmt.Fprintln(flag.CommandLine.Output(), "  list [glob]                     List available packages")
-	fmt.Fprintln(flag.CommandLine.Output(), "  list-installed [glob]           List installed packages")
-	fmt.Fprintln(flag.CommandLine.Output(), "  list-upgradable [glob]          List installed and upgradable packages")
-	fmt.Fprintln(flag.CommandLine.Output(), "  info [pkg|glob]                 Display package metadata")
-	fmt.Fprintln(flag.CommandLine.Output(), "  status [pkg|glob]               Display installed package status")
+	fmt.Fprintln(flag.CommandLine.Output(), "  list [--section s] [--maintainer m] [--provides p] [--by-section] [glob]")
+	fmt.Fprintln(flag.CommandLine.Output(), "                                  List available packages")
+	fmt.Fprintln(flag.CommandLine.Output(), "  list-installed [--with-version] [--sort-by-install-date[-desc]] [glob]")
+	fmt.Fprintln(flag.CommandLine.Output(), "                                  List installed packages")
+	fmt.Fprintln(flag.CommandLine.Output(), "  list-upgradable [--minor-only|--major-only] [glob]")
+	fmt.Fprintln(flag.CommandLine.Output(), "                                  List installed and upgradable packages")
+	fmt.Fprintln(flag.CommandLine.Output(), "  list-orphaned                   List installed packages no feed carries")
+	fmt.Fprintln(flag.CommandLine.Output(), "  info [--raw] [pkg|glob]         Display package metadata")
+	fmt.Fprintln(flag.CommandLine.Output(), "  status [--not-installed|--half-installed|--config-files-only] [pkg|glob]")
+	fmt.Fprintln(flag.CommandLine.Output(), "                                  Display installed package status")
 	fmt.Fprintln(flag.CommandLine.Output(), "  find <substring>                Search packages by name or description")
-	fmt.Fprintln(flag.CommandLine.Output(), "  depends [-A] [pkg|glob]+        Show package dependencies")
+	fmt.Fprintln(flag.CommandLine.Output(), "  search [--name-glob p] [--desc s] [--regex] [--filter k=v]...")
+	fmt.Fprintln(flag.CommandLine.Output(), "                                  Search packages matching every filter")
+	fmt.Fprintln(flag.CommandLine.Output(), "  source <pkgs>                   Show which feed a package comes from")
+	fmt.Fprintln(flag.CommandLine.Output(), "  changelog [--format f] <pkg>    Show the upstream changelog of a package")
+	fmt.Fprintln(flag.CommandLine.Output(), "  policy <pkgs>                   Show which version would be selected and why")
+	fmt.Fprintln(flag.CommandLine.Output(), "  depends [-A] [--recursive] [pkg|glob]+ | depends --transitive <pkg>")
+	fmt.Fprintln(flag.CommandLine.Output(), "                                  Show package dependencies")
+	fmt.Fprintln(flag.CommandLine.Output(), "  common-deps <pkgs>              List dependencies shared by all packages")
+	fmt.Fprintln(flag.CommandLine.Output(), "  unique-deps <pkg>               List dependencies no other package needs")
+	fmt.Fprintln(flag.CommandLine.Output(), "  dep-graph [--format f] <pkgs>   Print the dependency graph as DOT or JSON")
+	fmt.Fprintln(flag.CommandLine.Output(), "  dep-path [--all-paths] <a> <b>  Show why a depends on b")
+	fmt.Fprintln(flag.CommandLine.Output(), "  explain <pkg>                   Summarise why a package is needed")
 	fmt.Fprintln(flag.CommandLine.Output(), "  whatdepends[-A] [pkg|glob]+     List packages depending on the target")
-	fmt.Fprintln(flag.CommandLine.Output(), "  whatdependsrec[-A] [pkg|glob]+  Recursively list dependencies")
+	fmt.Fprintln(flag.CommandLine.Output(), "  whatdependsrec[-A] [--max-depth N] [pkg|glob]+")
+	fmt.Fprintln(flag.CommandLine.Output(), "                                  Recursively list dependencies")
 	fmt.Fprintln(flag.CommandLine.Output(), "  whatrecommends[-A] [pkg|glob]+  List recommending packages")
 	fmt.Fprintln(flag.CommandLine.Output(), "  whatsuggests[-A] [pkg|glob]+    List suggesting packages")
 	fmt.Fprintln(flag.CommandLine.Output(), "  whatprovides [-A] [pkg|glob]+   List packages providing the target")
+	fmt.Fprintln(flag.CommandLine.Output(), "  provides <capability>           List packages able to satisfy a capability")
 	fmt.Fprintln(flag.CommandLine.Output(), "  whatconflicts[-A] [pkg|glob]+   List conflicting packages")
 	fmt.Fprintln(flag.CommandLine.Output(), "  whatreplaces [-A] [pkg|glob]+   List packages that replace the target")
 	fmt.Fprintln(flag.CommandLine.Output(), "  compare-versions <v1> <op> <v2> Compare version strings")
-	fmt.Fprintln(flag.CommandLine.Output(), "  print-architecture              List configured architectures")
+	fmt.Fprintln(flag.CommandLine.Output(), "  compare-versions --sort [--reverse|--greatest] <versions>")
+	fmt.Fprintln(flag.CommandLine.Output(), "                                  Sort version strings")
+	fmt.Fprintln(flag.CommandLine.Output(), "  init [--feed-uri uri] [file]    Generate a skeleton opkg.conf")
+	fmt.Fprintln(flag.CommandLine.Output(), "  list-feeds [--stats]            List configured feeds")
+	fmt.Fprintln(flag.CommandLine.Output(), "  check                           Validate the status database")
+	fmt.Fprintln(flag.CommandLine.Output(), "  repair-db [--dry-run]           Fix common status database corruption")
+	fmt.Fprintln(flag.CommandLine.Output(), "  check-feeds                     Check that every feed is reachable")
+	fmt.Fprintln(flag.CommandLine.Output(), "  print-architecture              List compatible architectures")
 	fmt.Fprintln(flag.CommandLine.Output(), "  version                         Print version information")
+	fmt.Fprintln(flag.CommandLine.Output(), "\nExit Status:")
+	fmt.Fprintln(flag.CommandLine.Output(), "  0  success")
+	fmt.Fprintln(flag.CommandLine.Output(), "  1  general failure")
+	fmt.Fprintln(flag.CommandLine.Output(), "  2  package not found")
+	fmt.Fprintln(flag.CommandLine.Output(), "  3  network error")
+	fmt.Fprintln(flag.CommandLine.Output(), "  4  checksum mismatch")
+	fmt.Fprintln(flag.CommandLine.Output(), "  5  conflicts detected")
+	fmt.Fprintln(flag.CommandLine.Output(), "\nEnvironment:")
+	fmt.Fprintln(flag.CommandLine.Output(), "  OPKG_CONF       configuration path")
+	fmt.Fprintln(flag.CommandLine.Output(), "  OPKG_LOG_LEVEL  debug, info, warn or error")
+	fmt.Fprintln(flag.CommandLine.Output(), "  OPKG_LOG_FILE   append log output to this file")
 	fmt.Fprintln(flag.CommandLine.Output(), "\nOptions:")
 	flag.PrintDefaults()
 }
@@ -468,23 +1688,79 @@ func defaultConfig() string {
 }
 
 func mustManager(conf string) *pkgmgr.Manager {
-	manager, err := pkgmgr.New(conf)
+	manager, err := pkgmgr.New(conf, managerOptions...)
 	if err != nil {
 		fatal(err)
 	}
 	return manager
 }
 
+// mustScopedManager is like mustManager but scopes the manager to the named
+// destination when dest is not empty.
+func mustScopedManager(conf, dest string) *pkgmgr.Manager {
+	manager := mustManager(conf)
+	if dest == "" {
+		return manager
+	}
+	scoped, err := manager.WithDest(dest)
+	if err != nil {
+		fatal(err)
+	}
+	return scoped
+}
+
 func printVersion() {
 	ts := buildTime
 	if ts == "" {
 		ts = time.Now().UTC().Format(time.RFC3339)
 	}
 	logging.Debugf("main: printing version %s built at %s", buildVersion, ts)
-	fmt.Printf("opkg-go %s (%s)\n", buildVersion, ts)
+	fmt.Fprintf(stdout, "opkg-go %s (%s)\n", buildVersion, ts)
 }
 
+// Exit codes distinguishing failure modes for scripts.
+const (
+	exitFailure  = 1
+	exitNotFound = 2
+	exitNetwork  = 3
+	exitChecksum = 4
+	exitConflict = 5
+)
+
 func fatal(err error) {
 	fmt.Fprintln(os.Stderr, err)
-	os.Exit(1)
+	closeOutput()
+	os.Exit(exitCode(err))
+}
+
+// closeOutput closes the --output file. It is safe to call more than once.
+func closeOutput() {
+	if outputFile == nil {
+		return
+	}
+	if err := outputFile.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	outputFile = nil
+	stdout = os.Stdout
+}
+
+func exitCode(err error) int {
+	var (
+		notFound *pkgmgr.PackageNotFoundError
+		network  *pkgmgr.NetworkError
+		checksum *pkgmgr.ChecksumError
+		conflict *pkgmgr.ConflictError
+	)
+	switch {
+	case errors.As(err, &notFound):
+		return exitNotFound
+	case errors.As(err, &network):
+		return exitNetwork
+	case errors.As(err, &checksum):
+		return exitChecksum
+	case errors.As(err, &conflict):
+		return exitConflict
+	}
+	return exitFailure
 }
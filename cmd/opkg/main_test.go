@@ -0,0 +1,555 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMain lets tests run the command by re-executing the test binary with
+// OPKG_TEST_MAIN set.
+func TestMain(m *testing.M) {
+	if os.Getenv("OPKG_TEST_MAIN") == "1" {
+		os.Args = append([]string{"opkg"}, os.Args[1:]...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runOpkg runs the command against a configuration using feedURL and
+// returns its combined output and exit code.
+func runOpkg(t *testing.T, feedURL string, args ...string) (string, int) {
+	t.Helper()
+	return runOpkgWithStatus(t, feedURL, "", args...)
+}
+
+// runOpkgWithStatus is like runOpkg but starts from a status database with
+// the given contents, when not empty.
+func runOpkgWithStatus(t *testing.T, feedURL, status string, args ...string) (string, int) {
+	t.Helper()
+	dir := t.TempDir()
+	if status != "" {
+		if err := os.WriteFile(filepath.Join(dir, "status"), []byte(status), 0o644); err != nil {
+			t.Fatalf("write status: %v", err)
+		}
+	}
+	return runOpkgInDir(t, dir, feedURL, args...)
+}
+
+// runOpkgInDir runs the command with a configuration in dir that keeps the
+// status database and the cache directory below dir. Unless the test sets
+// OPKG_LOG_LEVEL, the command logs at info level so that debug builds print
+// the same output.
+func runOpkgInDir(t *testing.T, dir, feedURL string, args ...string) (string, int) {
+	t.Helper()
+	conf := filepath.Join(dir, "opkg.conf")
+	data := fmt.Sprintf("option status_file %s\noption cache_dir %s\nsrc base %s\n",
+		filepath.Join(dir, "status"), filepath.Join(dir, "cache"), feedURL)
+	if err := os.WriteFile(conf, []byte(data), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cmd := exec.Command(os.Args[0], append([]string{"-conf", conf}, args...)...)
+	cmd.Env = append(os.Environ(), "OPKG_TEST_MAIN=1")
+	if os.Getenv("OPKG_LOG_LEVEL") == "" {
+		cmd.Env = append(cmd.Env, "OPKG_LOG_LEVEL=info")
+	}
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(out), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("run opkg: %v", err)
+	}
+	return string(out), 0
+}
+
+func newFeed(t *testing.T, packages string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/base/Packages" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, packages)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/base"
+}
+
+func TestExitCodes(t *testing.T) {
+	feed := newFeed(t, "Package: present\nVersion: 1.0\nFilename: present.ipk\n")
+
+	if out, code := runOpkg(t, feed, "install", "missing"); code != exitNotFound {
+		t.Fatalf("expected exit code %d for missing package, got %d: %s", exitNotFound, code, out)
+	}
+	if out, code := runOpkg(t, feed, "install", "present"); code != exitNetwork {
+		t.Fatalf("expected exit code %d for failed download, got %d: %s", exitNetwork, code, out)
+	}
+}
+
+func TestOutputFlagWritesToFile(t *testing.T) {
+	feed := newFeed(t, "Package: present\nVersion: 1.0\nDescription: test package\n")
+	path := filepath.Join(t.TempDir(), "out.txt")
+
+	out, code := runOpkg(t, feed, "--output", path, "list")
+	if code != 0 {
+		t.Fatalf("list failed with exit code %d: %s", code, out)
+	}
+	if out != "" {
+		t.Fatalf("expected nothing on the terminal, got %q", out)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !strings.Contains(string(data), "present") {
+		t.Fatalf("unexpected output file contents %q", data)
+	}
+}
+
+func TestRequirementLines(t *testing.T) {
+	versions := map[string]string{"zlib": "1.3-r0", "busybox": "1:1.36.1-r0", "busybox-syslog": "1.36.1-r0"}
+	lines := requirementLines(versions)
+	want := []string{"busybox==1:1.36.1-r0", "busybox-syslog==1.36.1-r0", "zlib==1.3-r0"}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("requirementLines()=%q want %q", lines, want)
+	}
+	pattern := regexp.MustCompile(`^[^=\s]+==[^=\s]+$`)
+	for _, line := range lines {
+		if !pattern.MatchString(line) {
+			t.Fatalf("line %q does not match name==version", line)
+		}
+		parts := strings.SplitN(line, "==", 2)
+		if len(parts) != 2 || versions[parts[0]] != parts[1] {
+			t.Fatalf("could not recover name and version from %q", line)
+		}
+	}
+}
+
+func TestInfoRaw(t *testing.T) {
+	paragraph := "Package: present\nVersion: 1.0\nDescription: test package\n  with an indented second line\n"
+	feed := newFeed(t, paragraph+"\nPackage: other\nVersion: 2.0\n")
+
+	out, code := runOpkg(t, feed, "info", "--raw", "present")
+	if code != 0 {
+		t.Fatalf("info --raw failed with exit code %d: %s", code, out)
+	}
+	if out != paragraph {
+		t.Fatalf("info --raw printed %q want %q", out, paragraph)
+	}
+	// Installed packages print the index paragraph without status fields.
+	out, code = runOpkgWithStatus(t, feed, "Package: present\nVersion: 1.0\nStatus: install ok installed\n", "info", "--raw", "present")
+	if code != 0 || out != paragraph {
+		t.Fatalf("info --raw of an installed package printed %q (exit code %d), want %q", out, code, paragraph)
+	}
+	if out, code := runOpkg(t, feed, "info", "--raw", "--fields", "Version", "present"); code != exitFailure {
+		t.Fatalf("expected --raw with --fields to fail, got exit code %d: %s", code, out)
+	}
+}
+
+func TestStatusStateFilters(t *testing.T) {
+	status := "Package: busybox\nVersion: 1.36.1\nStatus: install ok installed\n\n" +
+		"Package: dropbear\nVersion: 2022.83\nStatus: deinstall ok config-files\n\n" +
+		"Package: openssl\nVersion: 3.1.4\nStatus: install ok half-installed\n\n" +
+		"Package: zlib\nVersion: 1.3\nStatus: install ok unpacked\n"
+	feed := newFeed(t, "")
+
+	for _, tc := range []struct {
+		flag string
+		want []string
+	}{
+		{"--not-installed", []string{"dropbear", "openssl", "zlib"}},
+		{"--half-installed", []string{"openssl"}},
+		{"--config-files-only", []string{"dropbear"}},
+	} {
+		out, code := runOpkgWithStatus(t, feed, status, "status", "--fields", "Package", tc.flag)
+		if code != 0 {
+			t.Fatalf("status %s failed with exit code %d: %s", tc.flag, code, out)
+		}
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			if name, ok := strings.CutPrefix(line, "Package: "); ok {
+				got = append(got, name)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("status %s listed %v want %v", tc.flag, got, tc.want)
+		}
+	}
+}
+
+func TestLogLevelFromEnv(t *testing.T) {
+	feed := newFeed(t, "Package: present\nVersion: 1.0\nFilename: present.ipk\n")
+
+	t.Setenv("OPKG_LOG_LEVEL", "error")
+	out, code := runOpkg(t, feed, "update")
+	if code != 0 {
+		t.Fatalf("update failed with %d: %s", code, out)
+	}
+	if strings.Contains(out, "[DEBUG]") {
+		t.Fatalf("debug lines written at error level:\n%s", out)
+	}
+
+	t.Setenv("OPKG_LOG_LEVEL", "debug")
+	out, code = runOpkg(t, feed, "update")
+	if code != 0 {
+		t.Fatalf("update failed with %d: %s", code, out)
+	}
+	if !strings.Contains(out, "[DEBUG] repo: fetching feed feed=base") {
+		t.Fatalf("expected debug lines on stderr, got:\n%s", out)
+	}
+
+	t.Setenv("OPKG_LOG_LEVEL", "chatty")
+	if out, code := runOpkg(t, feed, "update"); code != exitFailure || !strings.Contains(out, "invalid log level") {
+		t.Fatalf("expected failure for an invalid level, got %d: %s", code, out)
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	for in, want := range map[string]time.Duration{"7d": 7 * 24 * time.Hour, "0d": 0, "12h": 12 * time.Hour, "90m": 90 * time.Minute} {
+		if got, err := parseAge(in); err != nil || got != want {
+			t.Errorf("parseAge(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "d", "-1d", "1.5d", "week"} {
+		if _, err := parseAge(in); err == nil {
+			t.Errorf("parseAge(%q) succeeded", in)
+		}
+	}
+}
+
+func TestListUpgradableChangeFilters(t *testing.T) {
+	feed := newFeed(t, "Package: big\nVersion: 2.0\nDescription: big jump\n\nPackage: small\nVersion: 1.1\nDescription: small step\n")
+	status := "Package: big\nVersion: 1.0\nStatus: install ok installed\n\nPackage: small\nVersion: 1.0\nStatus: install ok installed\n"
+
+	out, code := runOpkgWithStatus(t, feed, status, "list-upgradable")
+	if code != 0 || out != "big - 1.0 -> 2.0 [major] big jump\nsmall - 1.0 -> 1.1 [minor] small step\n" {
+		t.Fatalf("list-upgradable printed %q (exit %d)", out, code)
+	}
+	if out, code := runOpkgWithStatus(t, feed, status, "list-upgradable", "--minor-only"); code != 0 || out != "small - 1.0 -> 1.1 [minor] small step\n" {
+		t.Fatalf("--minor-only printed %q (exit %d)", out, code)
+	}
+	if out, code := runOpkgWithStatus(t, feed, status, "list-upgradable", "--major-only"); code != 0 || out != "big - 1.0 -> 2.0 [major] big jump\n" {
+		t.Fatalf("--major-only printed %q (exit %d)", out, code)
+	}
+	if _, code := runOpkgWithStatus(t, feed, status, "list-upgradable", "--major-only", "--minor-only"); code != exitFailure {
+		t.Fatalf("combining both filters should fail, got exit %d", code)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	feed := newFeed(t, "Package: curl\nVersion: 8.0\nSection: net\nDescription: URL transfer tool\n\n"+
+		"Package: libcurl4\nVersion: 8.0\nSection: libs\nDescription: URL transfer library\n\n"+
+		"Package: zlib\nVersion: 1.3\nSection: libs\nDescription: Compression library\n")
+
+	out, code := runOpkg(t, feed, "search", "--filter", "section=libs", "--filter", "desc=url")
+	if code != 0 || out != "libcurl4 - URL transfer library\n" {
+		t.Fatalf("search printed %q (exit %d)", out, code)
+	}
+	out, code = runOpkg(t, feed, "search", "--regex", "--name-glob", "^(curl|zlib)$")
+	if code != 0 || out != "curl - URL transfer tool\nzlib - Compression library\n" {
+		t.Fatalf("regex search printed %q (exit %d)", out, code)
+	}
+	if out, code := runOpkg(t, feed, "search", "--filter", "colour=red"); code != exitFailure || !strings.Contains(out, "unknown filter") {
+		t.Fatalf("unknown filter accepted: %q (exit %d)", out, code)
+	}
+}
+
+func TestWhatDependsRecMaxDepth(t *testing.T) {
+	feed := newFeed(t, "Package: a\nVersion: 1\n\n"+
+		"Package: b\nVersion: 1\nDepends: a\n\n"+
+		"Package: c\nVersion: 1\nDepends: b\n")
+
+	out, code := runOpkg(t, feed, "whatdependsrec", "-A", "--max-depth", "1", "a")
+	if want := "warning: depth limit reached, results may be incomplete\nb\n"; code != 0 || out != want {
+		t.Fatalf("whatdependsrec --max-depth 1 printed %q (exit %d)", out, code)
+	}
+	out, code = runOpkg(t, feed, "whatdependsrec", "-A", "a")
+	if code != 0 || out != "b\nc\n" {
+		t.Fatalf("whatdependsrec printed %q (exit %d)", out, code)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	feed := newFeed(t, "")
+	status := "Package: busybox\nVersion: 1.36\nStatus: install ok installed\n\n" +
+		"Package: curl\nVersion: 8.0\nStatus: install ok installed\nAuto-Installed: maybe\n"
+
+	out, code := runOpkgWithStatus(t, feed, status, "check")
+	want := "curl: Auto-Installed: invalid value \"maybe\", want yes\n1 problems found in the status database\n"
+	if code != exitFailure || out != want {
+		t.Fatalf("check printed %q (exit %d)", out, code)
+	}
+	if out, code := runOpkgWithStatus(t, feed, "Package: busybox\nVersion: 1.36\nStatus: install ok installed\n", "check"); code != 0 || out != "" {
+		t.Fatalf("check of a clean database printed %q (exit %d)", out, code)
+	}
+}
+
+func TestRepairDB(t *testing.T) {
+	feed := newFeed(t, "")
+	dir := t.TempDir()
+	status := "Package: busybox\nVersion: 1.35\n\nPackage: busybox\nVersion: 1.36\nStatus: install ok installed\n"
+	path := filepath.Join(dir, "status")
+	if err := os.WriteFile(path, []byte(status), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := "busybox: set Status to \"install ok installed\"\nbusybox: dropped duplicate entry for version 1.35, kept 1.36\n"
+
+	out, code := runOpkgInDir(t, dir, feed, "repair-db", "--dry-run")
+	if code != 0 || out != want {
+		t.Fatalf("repair-db --dry-run printed %q (exit %d)", out, code)
+	}
+	if data, _ := os.ReadFile(path); string(data) != status {
+		t.Fatalf("dry run modified the database:\n%s", data)
+	}
+	out, code = runOpkgInDir(t, dir, feed, "repair-db")
+	if code != 0 || out != want {
+		t.Fatalf("repair-db printed %q (exit %d)", out, code)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "Package: busybox\nVersion: 1.36\nStatus: install ok installed\n" {
+		t.Fatalf("repaired database:\n%s", data)
+	}
+}
+
+func TestListBySection(t *testing.T) {
+	feed := newFeed(t, "Package: wget\nVersion: 1.21\nSection: net\n\n"+
+		"Package: curl\nVersion: 8.0\nSection: net\n\n"+
+		"Package: mystery\nVersion: 1.0\n")
+
+	out, code := runOpkg(t, feed, "list", "--by-section")
+	if want := "net:\n  curl - 8.0\n  wget - 1.21\n\nunknown:\n  mystery - 1.0\n"; code != 0 || out != want {
+		t.Fatalf("list --by-section printed %q (exit %d)", out, code)
+	}
+}
+
+func TestDepGraph(t *testing.T) {
+	feed := newFeed(t, "Package: app\nVersion: 2.0\nDepends: libc\nConflicts: oldapp\n\n"+
+		"Package: libc\nVersion: 2.39\n")
+
+	out, code := runOpkg(t, feed, "dep-graph", "app")
+	want := "digraph dependencies {\n" +
+		"  \"app\" [label=\"app\\n2.0\"];\n" +
+		"  \"libc\" [label=\"libc\\n2.39\"];\n" +
+		"  \"oldapp\" [label=\"oldapp\"];\n" +
+		"  \"app\" -> \"libc\";\n" +
+		"  \"app\" -> \"oldapp\" [label=\"Conflicts\"];\n" +
+		"}\n"
+	if code != 0 || out != want {
+		t.Fatalf("dep-graph printed %q (exit %d)", out, code)
+	}
+	out, code = runOpkg(t, feed, "dep-graph", "--format=json", "app")
+	var doc struct {
+		Nodes []map[string]any `json:"nodes"`
+		Edges []map[string]any `json:"edges"`
+	}
+	if err := json.Unmarshal([]byte(out), &doc); code != 0 || err != nil || len(doc.Nodes) != 3 || len(doc.Edges) != 2 {
+		t.Fatalf("dep-graph --format=json printed %q (exit %d, %v)", out, code, err)
+	}
+}
+
+func TestListProvides(t *testing.T) {
+	feed := newFeed(t, "Package: openssl\nVersion: 3.0\nProvides: libssl\nDescription: OpenSSL\n\n"+
+		"Package: libressl\nVersion: 3.8\nProvides: libssl\nDescription: LibreSSL\n\n"+
+		"Package: curl\nVersion: 8.0\nDescription: URL tool\n")
+
+	out, code := runOpkg(t, feed, "list", "--provides", "libssl")
+	if want := "libressl - LibreSSL\nopenssl - OpenSSL\n"; code != 0 || out != want {
+		t.Fatalf("list --provides printed %q (exit %d)", out, code)
+	}
+}
+
+func TestDependsRecursive(t *testing.T) {
+	feed := newFeed(t, "Package: app\nVersion: 1\nDepends: libfoo\n\n"+
+		"Package: libfoo\nVersion: 1\nDepends: libc (>= 2.0)\n\n"+
+		"Package: libc\nVersion: 2.39\nProvides: libc6\n")
+
+	out, code := runOpkg(t, feed, "depends", "-A", "--recursive", "app")
+	want := "Package: app\n  Depends: libfoo\n\nPackage: libfoo\n  Depends: libc\n\nPackage: libc\n  Provides: libc6\n"
+	if code != 0 || out != want {
+		t.Fatalf("depends --recursive printed %q (exit %d)", out, code)
+	}
+	out, code = runOpkg(t, feed, "depends", "--transitive", "app")
+	if code != 0 || out != "libc\nlibfoo\n" {
+		t.Fatalf("depends --transitive printed %q (exit %d)", out, code)
+	}
+}
+
+func TestUpgradeSafe(t *testing.T) {
+	feed := newFeed(t, "Package: app\nVersion: 2.0\nFilename: app.ipk\nDepends: libnew\n\n"+
+		"Package: libnew\nVersion: 1.0\nFilename: libnew.ipk\n")
+	status := "Package: app\nVersion: 1.0\nStatus: install ok installed\n"
+
+	out, code := runOpkgWithStatus(t, feed, status, "upgrade", "--safe")
+	if want := "app: kept back, 2.0 needs libnew\n"; code != 0 || out != want {
+		t.Fatalf("upgrade --safe printed %q (exit %d), want %q", out, code, want)
+	}
+}
+
+func TestChangelog(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app/CHANGELOG" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "2.0: new release\n")
+	}))
+	t.Cleanup(upstream.Close)
+	feed := newFeed(t, "Package: app\nVersion: 2.0\nHomepage: "+upstream.URL+"/app\n")
+
+	out, code := runOpkg(t, feed, "changelog", "--format=raw", "app")
+	if code != 0 || out != "2.0: new release\n" {
+		t.Fatalf("changelog printed %q (exit %d)", out, code)
+	}
+	out, code = runOpkg(t, feed, "changelog", "--format=json", "app")
+	var doc map[string]string
+	if code != 0 || json.Unmarshal([]byte(out), &doc) != nil {
+		t.Fatalf("changelog --format=json printed %q (exit %d)", out, code)
+	}
+	if doc["package"] != "app" || doc["url"] != upstream.URL+"/app/CHANGELOG" || doc["content"] != "2.0: new release\n" {
+		t.Fatalf("unexpected document %v", doc)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	feed := newFeed(t, "Package: app\nVersion: 2.0\nFilename: app.ipk\n")
+	status := "Package: app\nVersion: 1.0\nStatus: hold ok installed\n"
+
+	out, code := runOpkgWithStatus(t, feed, status, "policy", "app")
+	want := "app:\n  Installed: 1.0\n  Candidate: 1.0\n  Feed: (none)\n  Priority: 0\n  Held: true\n"
+	if code != 0 || out != want {
+		t.Fatalf("policy printed %q (exit %d), want %q", out, code, want)
+	}
+}
+
+func TestInitPrefersNativeArchitecture(t *testing.T) {
+	out, code := runOpkg(t, "", "init", "--arch", "armv7a", "--feed-uri", "http://example.invalid/feed")
+	if want := "arch armv7a 1\narch all 10\n"; code != 0 || !strings.Contains(out, want) {
+		t.Fatalf("init printed %q (exit %d), want the architectures %q", out, code, want)
+	}
+}
+
+// cachedDir returns a directory for runOpkgInDir whose cache holds index as
+// the last update of the feed "base" and whose status database holds status.
+func cachedDir(t *testing.T, index, status string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "cache"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cache", "base.Packages"), []byte(index), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if status != "" {
+		if err := os.WriteFile(filepath.Join(dir, "status"), []byte(status), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// offlineFeed is a feed URL that cannot be reached.
+const offlineFeed = "http://example.invalid/base"
+
+func TestSimulate(t *testing.T) {
+	index := "Package: busybox\nVersion: 1.36\nFilename: busybox.ipk\n\n" +
+		"Package: curl\nVersion: 8.0\nFilename: curl.ipk\nPre-Depends: libcurl\n\n" +
+		"Package: libcurl\nVersion: 8.0\nFilename: libcurl.ipk\n"
+	status := "Package: busybox\nVersion: 1.35\nStatus: install ok installed\n"
+
+	// Simulations read the cached indexes and do not contact the feed.
+	dir := cachedDir(t, index, status)
+	out, code := runOpkgInDir(t, dir, offlineFeed, "upgrade", "--simulate")
+	if want := "Inst busybox [1.35] (1.36 base)\n1 packages will be upgraded\n"; code != 0 || out != want {
+		t.Fatalf("upgrade --simulate printed %q (exit %d), want %q", out, code, want)
+	}
+	out, code = runOpkgInDir(t, dir, offlineFeed, "install", "--simulate", "curl")
+	if want := "Inst curl (8.0 base)\nInst libcurl (8.0 base)\n2 packages will be installed\n"; code != 0 || out != want {
+		t.Fatalf("install --simulate printed %q (exit %d), want %q", out, code, want)
+	}
+	if out, code := runOpkg(t, offlineFeed, "install", "--simulate", "curl"); code != exitFailure || !strings.Contains(out, "run 'opkg update' first") {
+		t.Fatalf("install --simulate without cached indexes: %q (exit %d)", out, code)
+	}
+}
+
+func TestNoInstallRecommends(t *testing.T) {
+	dir := cachedDir(t, "Package: curl\nVersion: 8.0\nFilename: curl.ipk\nRecommends: ca-certificates\n\n"+
+		"Package: ca-certificates\nVersion: 2024\nFilename: ca-certificates.ipk\n", "")
+
+	out, code := runOpkgInDir(t, dir, offlineFeed, "install", "--simulate", "curl")
+	if want := "Inst ca-certificates (2024 base)\nInst curl (8.0 base)\n2 packages will be installed\n"; code != 0 || out != want {
+		t.Fatalf("install --simulate printed %q (exit %d), want %q", out, code, want)
+	}
+	out, code = runOpkgInDir(t, dir, offlineFeed, "--no-install-recommends", "install", "--simulate", "curl")
+	if want := "Inst curl (8.0 base)\n1 packages will be installed\n"; code != 0 || out != want {
+		t.Fatalf("--no-install-recommends install --simulate printed %q (exit %d), want %q", out, code, want)
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1024:            "1.0 KiB",
+		1536:            "1.5 KiB",
+		5 << 20:         "5.0 MiB",
+		3<<30 + 512<<20: "3.5 GiB",
+		2 << 40:         "2.0 TiB",
+	} {
+		if got := humanSize(n); got != want {
+			t.Errorf("humanSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestFetchUsesCachedIndexes(t *testing.T) {
+	var indexRequests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/base/busybox.ipk":
+			fmt.Fprint(w, "archive")
+		default:
+			indexRequests.Add(1)
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	feed := srv.URL + "/base"
+
+	if out, code := runOpkg(t, feed, "fetch", "busybox"); code != exitFailure || !strings.Contains(out, "run 'opkg update' first") {
+		t.Fatalf("fetch without cached indexes: %q (exit %d)", out, code)
+	}
+
+	dir := cachedDir(t, "Package: busybox\nVersion: 1.36\nFilename: busybox.ipk\n", "")
+	out, code := runOpkgInDir(t, dir, feed, "fetch", "busybox")
+	if want := "busybox -> " + filepath.Join(dir, "cache", "busybox.ipk") + "\n"; code != 0 || out != want {
+		t.Fatalf("fetch printed %q (exit %d), want %q", out, code, want)
+	}
+	if n := indexRequests.Load(); n != 0 {
+		t.Fatalf("fetch requested %d indexes from the feed", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "status")); !os.IsNotExist(err) {
+		t.Fatalf("fetch recorded the package as installed: %v", err)
+	}
+}
+
+func TestListOrphaned(t *testing.T) {
+	feed := newFeed(t, "Package: busybox\nVersion: 1.36\n")
+	status := "Package: busybox\nVersion: 1.36\nStatus: install ok installed\n\n" +
+		"Package: legacy-tool\nVersion: 0.9\nStatus: install ok installed\n"
+	out, code := runOpkgWithStatus(t, feed, status, "list-orphaned")
+	if code != 0 || out != "legacy-tool - 0.9\n" {
+		t.Fatalf("list-orphaned printed %q (exit %d)", out, code)
+	}
+}
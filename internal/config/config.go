@@ -4,9 +4,13 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -16,9 +20,39 @@ import (
 // Feed represents a remote package feed declared in opkg.conf using the
 // "src" or "src/gz" directives.
 type Feed struct {
-	Name string
-	URI  string
-	Type string
+	Name     string
+	URI      string
+	Type     string
+	Disabled bool
+
+	// file is the configuration file declaring the feed.
+	file string
+}
+
+// disabledTag marks a commented out feed declaration that opkg-go still
+// tracks as a disabled feed, e.g. "#disabled src/gz base http://...".
+const disabledTag = "#disabled"
+
+func isFeedDirective(directive string) bool {
+	switch directive {
+	case "src", "src/gz", "src/sig":
+		return true
+	}
+	return false
+}
+
+// splitDisabled strips a leading disabled tag from a feed declaration. It
+// reports whether the tag was present; lines that are not feed declarations
+// are returned unchanged.
+func splitDisabled(line string) (string, bool) {
+	if !strings.HasPrefix(line, disabledTag) {
+		return line, false
+	}
+	rest := strings.TrimSpace(strings.TrimPrefix(line, disabledTag))
+	if tokens := fields(rest); len(tokens) > 0 && isFeedDirective(tokens[0]) {
+		return rest, true
+	}
+	return line, false
 }
 
 // Destination represents a named filesystem destination used by opkg to store
@@ -37,6 +71,11 @@ type Config struct {
 	Destinations  []Destination
 	Includes      []string
 	Architectures []Architecture
+
+	// UnknownDirectives collects directives the parser does not recognise,
+	// keyed by directive name, with the remaining tokens of their last
+	// occurrence. They are not stored in Options.
+	UnknownDirectives map[string][]string
 }
 
 // Architecture represents an architecture entry declared with the "arch"
@@ -47,19 +86,51 @@ type Architecture struct {
 	Priority int
 }
 
+// Compatibility lists, per target architecture, the additional package
+// architectures that can run on it, for example i386 packages on an x86_64
+// system with multilib support. Callers may extend the map.
+var Compatibility = map[string][]string{
+	"x86_64": {"i386", "i486", "i586", "i686"},
+	"i686":   {"i386", "i486", "i586"},
+	"i586":   {"i386", "i486"},
+	"i486":   {"i386"},
+}
+
+// IsCompatibleWith reports whether packages built for the architecture can be
+// used on a system whose native architecture is target. The "all"
+// architecture is compatible with every target.
+func (a Architecture) IsCompatibleWith(target string) bool {
+	if a.Name == "all" || a.Name == target {
+		return true
+	}
+	for _, name := range Compatibility[target] {
+		if name == a.Name {
+			return true
+		}
+	}
+	return false
+}
+
 // Load parses the provided configuration file and all includes referenced by
 // "include" directives. The parser is whitespace agnostic and ignores empty
 // lines or comments (lines starting with "#" or "//").
+//
+// Every file is read at most once, compared by its path with symbolic links
+// resolved. Include cycles therefore end at the first repeated file, and a
+// file included from several places, as when A includes B and C which both
+// include D, contributes its declarations only once.
 func Load(path string) (*Config, error) {
-	cfg := &Config{Options: map[string]string{}}
+	cfg := &Config{Options: map[string]string{}, UnknownDirectives: map[string][]string{}}
 	visited := map[string]bool{}
 
 	var load func(string) error
 	load = func(p string) error {
-		if visited[p] {
+		key := canonicalPath(p)
+		if visited[key] {
+			logging.Debugf("config: skipping %s, already loaded", p)
 			return nil
 		}
-		visited[p] = true
+		visited[key] = true
 
 		logging.Debugf("config: loading file %s", p)
 
@@ -73,7 +144,7 @@ func Load(path string) (*Config, error) {
 		lineNo := 0
 		for scanner.Scan() {
 			lineNo++
-			raw := strings.TrimSpace(scanner.Text())
+			raw, disabled := splitDisabled(strings.TrimSpace(scanner.Text()))
 			if raw == "" || strings.HasPrefix(raw, "#") || strings.HasPrefix(raw, "//") {
 				continue
 			}
@@ -100,7 +171,7 @@ func Load(path string) (*Config, error) {
 				if len(tokens) < 3 {
 					return fmt.Errorf("%s:%d: %s expects name and URI", p, lineNo, tokens[0])
 				}
-				cfg.Feeds = append(cfg.Feeds, Feed{Name: tokens[1], URI: tokens[2], Type: tokens[0]})
+				cfg.Feeds = append(cfg.Feeds, Feed{Name: tokens[1], URI: tokens[2], Type: tokens[0], Disabled: disabled, file: p})
 			case "arch":
 				if len(tokens) < 2 {
 					return fmt.Errorf("%s:%d: arch expects name and optional priority", p, lineNo)
@@ -142,19 +213,15 @@ func Load(path string) (*Config, error) {
 					}
 				}
 			default:
-				// Keep unknown directives so that higher layers can decide how to
-				// handle them. Store the remainder of the line in the options map
-				// using the directive name as the key.
-				if len(tokens) >= 2 {
-					cfg.Options[tokens[0]] = strings.Join(tokens[1:], " ")
-					continue
-				}
 				if strings.Contains(tokens[0], "=") && len(tokens) == 1 {
 					parts := strings.SplitN(tokens[0], "=", 2)
 					cfg.Options[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
 					continue
 				}
-				return fmt.Errorf("%s:%d: unsupported directive %q", p, lineNo, tokens[0])
+				// Keep unknown directives apart from the options so that
+				// higher layers can decide how to handle them.
+				logging.Debugf("config: %s:%d: unknown directive %q", p, lineNo, tokens[0])
+				cfg.UnknownDirectives[tokens[0]] = append([]string{}, tokens[1:]...)
 			}
 		}
 		if err := scanner.Err(); err != nil {
@@ -175,6 +242,80 @@ func Load(path string) (*Config, error) {
 	return cfg, nil
 }
 
+// canonicalPath returns the absolute path of p with symbolic links resolved,
+// or p itself when it cannot be resolved, for example because it does not
+// exist.
+func canonicalPath(p string) string {
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return p
+	}
+	if abs, err := filepath.Abs(resolved); err == nil {
+		return abs
+	}
+	return resolved
+}
+
+// Validate checks the configuration for problems. Unknown directives are
+// reported as warnings since they may belong to a newer opkg release; feeds
+// and destinations lacking a name or location, and feeds declared twice, are
+// errors.
+func (c *Config) Validate() ([]string, error) {
+	if c == nil {
+		return nil, errors.New("nil config")
+	}
+	var warnings []string
+	for _, name := range sortedKeys(c.UnknownDirectives) {
+		warnings = append(warnings, fmt.Sprintf("unknown directive %q", name))
+	}
+
+	var errs []error
+	seen := map[string]bool{}
+	for _, feed := range c.Feeds {
+		if feed.Name == "" || feed.URI == "" {
+			errs = append(errs, fmt.Errorf("feed %q lacks a name or URI", feed.Name))
+		}
+		if seen[feed.Name] {
+			errs = append(errs, fmt.Errorf("feed %q declared more than once", feed.Name))
+		}
+		seen[feed.Name] = true
+	}
+	for _, dest := range c.Destinations {
+		if dest.Name == "" || dest.Path == "" {
+			errs = append(errs, fmt.Errorf("destination %q lacks a name or path", dest.Name))
+		}
+	}
+	return warnings, errors.Join(errs...)
+}
+
+// SetOption sets an option after validating the configuration. The option
+// is left unchanged when the configuration is invalid.
+func (c *Config) SetOption(key, value string) error {
+	if c == nil {
+		return errors.New("nil config")
+	}
+	if key == "" || strings.ContainsAny(key, " \t=") {
+		return fmt.Errorf("invalid option name %q", key)
+	}
+	if _, err := c.Validate(); err != nil {
+		return err
+	}
+	if c.Options == nil {
+		c.Options = map[string]string{}
+	}
+	c.Options[key] = value
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // FindOption returns a configuration value using a case-sensitive key. If the
 // key is not found the provided fallback is returned.
 func (c *Config) FindOption(key, fallback string) string {
@@ -188,6 +329,7 @@ func (c *Config) FindOption(key, fallback string) string {
 }
 
 // StatusPath returns the filesystem path to the package status database.
+// Without a status option it lives below the default destination.
 func (c *Config) StatusPath() (string, error) {
 	if c == nil {
 		return "", errors.New("nil config")
@@ -201,10 +343,8 @@ func (c *Config) StatusPath() (string, error) {
 	if dir := c.FindOption("status_dir", ""); dir != "" {
 		return filepath.Join(dir, "status"), nil
 	}
-	for _, dest := range c.Destinations {
-		if dest.Name == "root" {
-			return filepath.Join(dest.Path, "usr/lib/opkg/status"), nil
-		}
+	if dest, ok := c.DefaultDest(); ok {
+		return filepath.Join(dest.Path, "usr/lib/opkg/status"), nil
 	}
 	return "", errors.New("status path not configured")
 }
@@ -223,17 +363,260 @@ func (c *Config) CacheDir() string {
 	return "/tmp"
 }
 
+// SourceURI returns the base URL of the source package repository, or an
+// empty string when the source_uri option is not set.
+func (c *Config) SourceURI() string {
+	return strings.TrimSuffix(c.FindOption("source_uri", ""), "/")
+}
+
+// NoInstallRecommends reports whether the no_install_recommends option is
+// set to a true value such as "1", so that packages listed only in
+// Recommends are not installed.
+func (c *Config) NoInstallRecommends() bool {
+	enabled, err := strconv.ParseBool(c.FindOption("no_install_recommends", "0"))
+	return err == nil && enabled
+}
+
+// CompatibleArchitectures returns the declared architectures that are
+// compatible with target, sorted by ascending priority.
+func (c *Config) CompatibleArchitectures(target string) []Architecture {
+	if c == nil {
+		return nil
+	}
+	var out []Architecture
+	for _, arch := range c.Architectures {
+		if arch.IsCompatibleWith(target) {
+			out = append(out, arch)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Priority < out[j].Priority })
+	return out
+}
+
 // ResolveDest returns the filesystem path for a destination name.
 func (c *Config) ResolveDest(name string) (string, error) {
 	if c == nil {
 		return "", errors.New("nil config")
 	}
+	if dest, ok := c.DestByName(name); ok {
+		return dest.Path, nil
+	}
+	return "", fmt.Errorf("unknown destination %q", name)
+}
+
+// DestByName returns the destination with the given name.
+func (c *Config) DestByName(name string) (Destination, bool) {
+	if c == nil {
+		return Destination{}, false
+	}
 	for _, dest := range c.Destinations {
 		if dest.Name == name {
-			return dest.Path, nil
+			return dest, true
 		}
 	}
-	return "", fmt.Errorf("unknown destination %q", name)
+	return Destination{}, false
+}
+
+// DefaultDest returns the primary installation destination: the one named
+// "root" when configured, otherwise the first declared destination.
+func (c *Config) DefaultDest() (Destination, bool) {
+	if dest, ok := c.DestByName("root"); ok {
+		return dest, true
+	}
+	if c == nil || len(c.Destinations) == 0 {
+		return Destination{}, false
+	}
+	return c.Destinations[0], true
+}
+
+// FeedByName returns the first feed with the given name.
+func (c *Config) FeedByName(name string) (Feed, bool) {
+	i := c.feedIndex(name)
+	if i < 0 {
+		return Feed{}, false
+	}
+	return c.Feeds[i], true
+}
+
+// feedIndex returns the position in Feeds of the first feed with the given
+// name, or -1 when there is none.
+func (c *Config) feedIndex(name string) int {
+	if c == nil {
+		return -1
+	}
+	for i, feed := range c.Feeds {
+		if feed.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Clone returns a copy of c that shares no maps or slices with it, so that
+// either can be modified without affecting the other.
+func (c *Config) Clone() *Config {
+	if c == nil {
+		return nil
+	}
+	clone := *c
+	clone.Options = maps.Clone(c.Options)
+	clone.UnknownDirectives = maps.Clone(c.UnknownDirectives)
+	clone.Feeds = slices.Clone(c.Feeds)
+	clone.Destinations = slices.Clone(c.Destinations)
+	clone.Includes = slices.Clone(c.Includes)
+	clone.Architectures = slices.Clone(c.Architectures)
+	return &clone
+}
+
+// Merge returns a new configuration combining c with other. Feeds,
+// destinations and architectures are merged by name and options by key; on
+// a clash the entry from other replaces the one from c in place. Neither
+// input is modified.
+func (c *Config) Merge(other *Config) *Config {
+	if c == nil {
+		c = &Config{}
+	}
+	if other == nil {
+		other = &Config{}
+	}
+	merged := &Config{Options: make(map[string]string, len(c.Options)+len(other.Options))}
+	for k, v := range c.Options {
+		merged.Options[k] = v
+	}
+	for k, v := range other.Options {
+		merged.Options[k] = v
+	}
+	if len(c.UnknownDirectives)+len(other.UnknownDirectives) > 0 {
+		merged.UnknownDirectives = map[string][]string{}
+		for k, v := range c.UnknownDirectives {
+			merged.UnknownDirectives[k] = v
+		}
+		for k, v := range other.UnknownDirectives {
+			merged.UnknownDirectives[k] = v
+		}
+	}
+	merged.Feeds = mergeByName(c.Feeds, other.Feeds, func(f Feed) string { return f.Name })
+	merged.Destinations = mergeByName(c.Destinations, other.Destinations, func(d Destination) string { return d.Name })
+	merged.Architectures = mergeByName(c.Architectures, other.Architectures, func(a Architecture) string { return a.Name })
+	merged.Includes = mergeByName(c.Includes, other.Includes, func(s string) string { return s })
+	return merged
+}
+
+func mergeByName[T any](base, override []T, name func(T) string) []T {
+	out := append([]T(nil), base...)
+	pos := make(map[string]int, len(out))
+	for i, item := range out {
+		pos[name(item)] = i
+	}
+	for _, item := range override {
+		if i, ok := pos[name(item)]; ok {
+			out[i] = item
+			continue
+		}
+		pos[name(item)] = len(out)
+		out = append(out, item)
+	}
+	return out
+}
+
+// SetFeedDisabled enables or disables the named feed by rewriting the line
+// that declares it in its configuration file. Disabled feeds are kept in the
+// file behind a "#disabled" tag.
+func (c *Config) SetFeedDisabled(name string, disabled bool) error {
+	if c == nil {
+		return errors.New("nil config")
+	}
+	idx := c.feedIndex(name)
+	if idx < 0 {
+		return fmt.Errorf("unknown feed %q", name)
+	}
+	feed := c.Feeds[idx]
+	if feed.Disabled == disabled {
+		return nil
+	}
+	if feed.file == "" {
+		return fmt.Errorf("feed %q was not loaded from a file", name)
+	}
+
+	info, err := os.Stat(feed.file)
+	if err != nil {
+		return fmt.Errorf("stat config %s: %w", feed.file, err)
+	}
+	data, err := os.ReadFile(feed.file)
+	if err != nil {
+		return fmt.Errorf("read config %s: %w", feed.file, err)
+	}
+	lines := strings.Split(string(data), "\n")
+	found := false
+	for i, line := range lines {
+		body, tagged := splitDisabled(strings.TrimSpace(line))
+		tokens := fields(body)
+		if len(tokens) < 3 || !isFeedDirective(tokens[0]) || tokens[1] != name || tagged == disabled {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if disabled {
+			lines[i] = indent + disabledTag + " " + body
+		} else {
+			lines[i] = indent + body
+		}
+		found = true
+		break
+	}
+	if !found {
+		return fmt.Errorf("feed %q not found in %s", name, feed.file)
+	}
+	if err := os.WriteFile(feed.file, []byte(strings.Join(lines, "\n")), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("write config %s: %w", feed.file, err)
+	}
+	c.Feeds[idx].Disabled = disabled
+	logging.Debugf("config: feed %s disabled=%t in %s", name, disabled, feed.file)
+	return nil
+}
+
+// WriteTo renders the configuration in opkg.conf syntax: options first,
+// followed by architectures, destinations and feeds. Disabled feeds carry the
+// "#disabled" tag and unknown directives are written back verbatim. Include
+// directives are not emitted because the included declarations are already
+// part of the configuration.
+func (c *Config) WriteTo(w io.Writer) (int64, error) {
+	if c == nil {
+		return 0, errors.New("nil config")
+	}
+	var b strings.Builder
+	for _, key := range sortedKeys(c.Options) {
+		fmt.Fprintf(&b, "option %s %s\n", key, c.Options[key])
+	}
+	for _, arch := range c.Architectures {
+		fmt.Fprintf(&b, "arch %s %d\n", arch.Name, arch.Priority)
+	}
+	for _, dest := range c.Destinations {
+		fmt.Fprintf(&b, "dest %s %s\n", dest.Name, quoteField(dest.Path))
+	}
+	for _, feed := range c.Feeds {
+		if feed.Disabled {
+			b.WriteString(disabledTag + " ")
+		}
+		typ := feed.Type
+		if typ == "" {
+			typ = "src"
+		}
+		fmt.Fprintf(&b, "%s %s %s\n", typ, feed.Name, quoteField(feed.URI))
+	}
+	for _, name := range sortedKeys(c.UnknownDirectives) {
+		b.WriteString(strings.Join(append([]string{name}, c.UnknownDirectives[name]...), " ") + "\n")
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// quoteField wraps values containing whitespace in double quotes so that
+// fields reads them back as a single token.
+func quoteField(s string) string {
+	if strings.ContainsAny(s, " \t") {
+		return `"` + s + `"`
+	}
+	return s
 }
 
 // fields is similar to strings.Fields but keeps path-like values intact by
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -84,3 +85,289 @@ func TestStatusPathOptions(t *testing.T) {
 		t.Fatalf("unexpected dest fallback status path %q", status)
 	}
 }
+
+func TestNoInstallRecommends(t *testing.T) {
+	for value, want := range map[string]bool{"1": true, "true": true, "0": false, "no": false, "": false} {
+		cfg := &Config{Options: map[string]string{}}
+		if value != "" {
+			cfg.Options["no_install_recommends"] = value
+		}
+		if got := cfg.NoInstallRecommends(); got != want {
+			t.Errorf("NoInstallRecommends() with %q = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestArchitectureCompatibility(t *testing.T) {
+	if !(Architecture{Name: "all"}).IsCompatibleWith("armv7a") {
+		t.Fatalf("expected all to be compatible with any target")
+	}
+	if !(Architecture{Name: "i686"}).IsCompatibleWith("x86_64") {
+		t.Fatalf("expected i686 to run on x86_64")
+	}
+	if (Architecture{Name: "x86_64"}).IsCompatibleWith("i686") {
+		t.Fatalf("did not expect x86_64 to run on i686")
+	}
+
+	cfg := &Config{Architectures: []Architecture{
+		{Name: "x86_64", Priority: 10},
+		{Name: "armv7a", Priority: 5},
+		{Name: "i686", Priority: 7},
+		{Name: "all", Priority: 1},
+	}}
+	got := cfg.CompatibleArchitectures("x86_64")
+	want := []string{"all", "i686", "x86_64"}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected compatible architectures %+v", got)
+	}
+	for i, name := range want {
+		if got[i].Name != name {
+			t.Fatalf("unexpected compatible architectures %+v", got)
+		}
+	}
+}
+
+func TestSetFeedDisabledRewritesConfig(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "opkg.conf")
+	contents := "src/gz base http://example.invalid/base\n" +
+		"#disabled src/gz extra http://example.invalid/extra\n" +
+		"# a regular comment\n"
+	if err := os.WriteFile(cfgPath, []byte(contents), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(cfg.Feeds) != 2 || cfg.Feeds[0].Disabled || !cfg.Feeds[1].Disabled {
+		t.Fatalf("unexpected feeds %+v", cfg.Feeds)
+	}
+
+	if err := cfg.SetFeedDisabled("base", true); err != nil {
+		t.Fatalf("disable base: %v", err)
+	}
+	if err := cfg.SetFeedDisabled("extra", false); err != nil {
+		t.Fatalf("enable extra: %v", err)
+	}
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	want := "#disabled src/gz base http://example.invalid/base\n" +
+		"src/gz extra http://example.invalid/extra\n" +
+		"# a regular comment\n"
+	if string(data) != want {
+		t.Fatalf("unexpected rewritten config:\n%s", data)
+	}
+
+	reloaded, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if !reloaded.Feeds[0].Disabled || reloaded.Feeds[1].Disabled {
+		t.Fatalf("unexpected reloaded feeds %+v", reloaded.Feeds)
+	}
+}
+
+func TestMergePrefersOther(t *testing.T) {
+	base := &Config{
+		Options:       map[string]string{"cache_dir": "/var/cache", "tmp_dir": "/tmp"},
+		Feeds:         []Feed{{Name: "base", URI: "http://old/base"}, {Name: "extra", URI: "http://old/extra"}},
+		Destinations:  []Destination{{Name: "root", Path: "/"}},
+		Architectures: []Architecture{{Name: "all", Priority: 1}},
+	}
+	user := &Config{
+		Options:       map[string]string{"cache_dir": "/data/cache"},
+		Feeds:         []Feed{{Name: "base", URI: "http://new/base"}, {Name: "local", URI: "file:///feed"}},
+		Destinations:  []Destination{{Name: "ram", Path: "/tmp/ram"}},
+		Architectures: []Architecture{{Name: "all", Priority: 2}},
+	}
+
+	merged := base.Merge(user)
+	wantFeeds := []Feed{{Name: "base", URI: "http://new/base"}, {Name: "extra", URI: "http://old/extra"}, {Name: "local", URI: "file:///feed"}}
+	if !reflect.DeepEqual(merged.Feeds, wantFeeds) {
+		t.Fatalf("unexpected feeds %+v", merged.Feeds)
+	}
+	if merged.Options["cache_dir"] != "/data/cache" || merged.Options["tmp_dir"] != "/tmp" {
+		t.Fatalf("unexpected options %+v", merged.Options)
+	}
+	if len(merged.Destinations) != 2 || len(merged.Architectures) != 1 || merged.Architectures[0].Priority != 2 {
+		t.Fatalf("unexpected destinations %+v or architectures %+v", merged.Destinations, merged.Architectures)
+	}
+	if base.Feeds[0].URI != "http://old/base" || base.Options["cache_dir"] != "/var/cache" {
+		t.Fatalf("Merge modified its receiver")
+	}
+}
+
+func TestLoadRecordsUnknownDirectives(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "opkg.conf")
+	data := "src/gz base http://example.invalid/base\nsignature_policy strict keyring\nfuture_flag\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	want := map[string][]string{"signature_policy": {"strict", "keyring"}, "future_flag": {}}
+	if !reflect.DeepEqual(cfg.UnknownDirectives, want) {
+		t.Fatalf("unexpected unknown directives %+v", cfg.UnknownDirectives)
+	}
+	if _, ok := cfg.Options["signature_policy"]; ok {
+		t.Fatalf("unknown directive leaked into the options: %+v", cfg.Options)
+	}
+
+	warnings, err := cfg.Validate()
+	if err != nil {
+		t.Fatalf("Validate returned error: %v", err)
+	}
+	if len(warnings) != 2 {
+		t.Fatalf("expected two warnings, got %v", warnings)
+	}
+
+	if err := cfg.SetOption("cache_dir", "/data/cache"); err != nil || cfg.FindOption("cache_dir", "") != "/data/cache" {
+		t.Fatalf("SetOption failed: %v", err)
+	}
+	if err := cfg.SetOption("bad key", "x"); err == nil {
+		t.Fatalf("expected SetOption to reject invalid key")
+	}
+	cfg.Feeds = append(cfg.Feeds, Feed{Name: "base", URI: "http://example.invalid/other"})
+	if err := cfg.SetOption("tmp_dir", "/tmp"); err == nil {
+		t.Fatalf("expected SetOption to report duplicate feed")
+	}
+	if _, ok := cfg.Options["tmp_dir"]; ok {
+		t.Fatalf("SetOption changed an invalid configuration")
+	}
+}
+
+func TestWriteToRoundTrip(t *testing.T) {
+	cfg := &Config{
+		Options:       map[string]string{"status_file": "/mnt/root/usr/lib/opkg/status"},
+		Architectures: []Architecture{{Name: "all", Priority: 1}, {Name: "armv7a", Priority: 10}},
+		Destinations:  []Destination{{Name: "root", Path: "/mnt/my root"}},
+		Feeds: []Feed{
+			{Name: "base", URI: "http://example.invalid/base", Type: "src/gz"},
+			{Name: "extra", URI: "http://example.invalid/extra", Type: "src", Disabled: true},
+		},
+		UnknownDirectives: map[string][]string{"future": {"a", "b"}},
+	}
+	path := filepath.Join(t.TempDir(), "opkg.conf")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create config: %v", err)
+	}
+	if _, err := cfg.WriteTo(file); err != nil {
+		t.Fatalf("WriteTo returned error: %v", err)
+	}
+	file.Close()
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	for i := range loaded.Feeds {
+		loaded.Feeds[i].file = ""
+	}
+	if !reflect.DeepEqual(loaded.Options, cfg.Options) ||
+		!reflect.DeepEqual(loaded.Architectures, cfg.Architectures) ||
+		!reflect.DeepEqual(loaded.Destinations, cfg.Destinations) ||
+		!reflect.DeepEqual(loaded.Feeds, cfg.Feeds) ||
+		!reflect.DeepEqual(loaded.UnknownDirectives, cfg.UnknownDirectives) {
+		t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", loaded, cfg)
+	}
+	if path, err := loaded.StatusPath(); err != nil || path != "/mnt/root/usr/lib/opkg/status" {
+		t.Fatalf("unexpected status path %q: %v", path, err)
+	}
+}
+
+func TestFeedLookups(t *testing.T) {
+	cfg := &Config{Feeds: []Feed{
+		{Name: "base", URI: "http://example.invalid/base", Type: "src/gz"},
+		{Name: "extra", URI: "http://example.invalid/extra", Type: "src"},
+		{Name: "base", URI: "http://example.invalid/shadowed", Type: "src"},
+	}}
+
+	feed, ok := cfg.FeedByName("base")
+	if !ok || feed.URI != "http://example.invalid/base" {
+		t.Fatalf("FeedByName(base) = %+v, %v", feed, ok)
+	}
+	if feed, ok := cfg.FeedByName("missing"); ok || feed != (Feed{}) {
+		t.Fatalf("FeedByName(missing) = %+v, %v", feed, ok)
+	}
+	var nilConfig *Config
+	if _, ok := nilConfig.FeedByName("base"); ok {
+		t.Fatal("nil config should have no feeds")
+	}
+}
+
+func TestDefaultDest(t *testing.T) {
+	cfg := &Config{Destinations: []Destination{{Name: "ram", Path: "/tmp/ram"}, {Name: "usb", Path: "/media/usb"}}}
+
+	dest, ok := cfg.DefaultDest()
+	if !ok || dest.Name != "ram" {
+		t.Fatalf("DefaultDest without root = %+v, %v; want the first destination", dest, ok)
+	}
+	if status, err := cfg.StatusPath(); err != nil || status != filepath.Join("/tmp/ram", "usr/lib/opkg/status") {
+		t.Fatalf("StatusPath = %q, %v", status, err)
+	}
+
+	cfg.Destinations = append(cfg.Destinations, Destination{Name: "root", Path: "/"})
+	if dest, ok := cfg.DefaultDest(); !ok || dest.Name != "root" {
+		t.Fatalf("DefaultDest = %+v, %v; want root", dest, ok)
+	}
+	if dest, ok := cfg.DestByName("usb"); !ok || dest.Path != "/media/usb" {
+		t.Fatalf("DestByName(usb) = %+v, %v", dest, ok)
+	}
+	if _, ok := cfg.DestByName("missing"); ok {
+		t.Fatal("DestByName found a missing destination")
+	}
+
+	if _, ok := (&Config{}).DefaultDest(); ok {
+		t.Fatal("DefaultDest without destinations should report false")
+	}
+}
+
+func TestLoadIncludesEachFileOnce(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+
+	// A symlink back to the including file is a cycle.
+	main := write("main.conf", "src/gz base http://example.invalid/base\ninclude loop.conf\n")
+	if err := os.Symlink(main, filepath.Join(dir, "loop.conf")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	if err := os.Symlink(main, filepath.Join(dir, "opkg.conf")); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{main, filepath.Join(dir, "opkg.conf")} {
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load(%s) returned error: %v", path, err)
+		}
+		if len(cfg.Feeds) != 1 {
+			t.Fatalf("Load(%s) read the symlinked file again: %+v", path, cfg.Feeds)
+		}
+	}
+
+	// Diamond: a includes b and c, which both include d.
+	write("d.conf", "src/gz extra http://example.invalid/extra\n")
+	write("b.conf", "include d.conf\n")
+	write("c.conf", "include d.conf\n")
+	a := write("a.conf", "include b.conf\ninclude c.conf\n")
+	cfg, err := Load(a)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(cfg.Feeds) != 1 || cfg.Feeds[0].Name != "extra" {
+		t.Fatalf("expected d.conf to be read once, got %+v", cfg.Feeds)
+	}
+}
@@ -2,38 +2,234 @@ package downloader
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/oe-mirrors/opkg_go/internal/logging"
+	"github.com/oe-mirrors/opkg_go/internal/version"
 )
 
 // Client wraps an http.Client to provide convenient helpers for downloading
 // repository metadata and package archives.
 type Client struct {
-	http    *http.Client
-	timeout time.Duration
+	http      *http.Client
+	timeout   time.Duration
+	userAgent string
 }
 
+// Option configures a Client created by New.
+type Option func(*Client)
+
+// WithUserAgent sets the User-Agent header sent with every request. The
+// default is "opkg-go/<version>".
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// StatusError reports a response with a status code other than 200 OK.
+type StatusError struct {
+	URL    string
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %s for %s", e.Status, e.URL)
+}
+
+// ErrChecksumMismatch is returned, possibly wrapped, when downloaded content
+// does not match its expected checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Checksum is the expected digest of a download. Algorithm is "sha512",
+// "sha256" or "md5"; Value is the hex encoded digest.
+type Checksum struct {
+	Algorithm string
+	Value     string
+}
+
+// Verify checks data against the checksum.
+func (c Checksum) Verify(data []byte) error {
+	var sum []byte
+	switch strings.ToLower(c.Algorithm) {
+	case "sha512":
+		digest := sha512.Sum512(data)
+		sum = digest[:]
+	case "sha256":
+		digest := sha256.Sum256(data)
+		sum = digest[:]
+	case "md5":
+		digest := md5.Sum(data)
+		sum = digest[:]
+	default:
+		return fmt.Errorf("unsupported checksum algorithm %q", c.Algorithm)
+	}
+	if got := hex.EncodeToString(sum); !strings.EqualFold(got, strings.TrimSpace(c.Value)) {
+		return fmt.Errorf("%w: %s is %s, expected %s", ErrChecksumMismatch, c.Algorithm, got, c.Value)
+	}
+	return nil
+}
+
+// ErrOffline is returned by clients created with NewOffline for every
+// request.
+var ErrOffline = errors.New("network access disabled")
+
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL, ErrOffline)
+}
+
+// NewOffline creates a downloader that refuses every request with
+// ErrOffline without touching the network.
+func NewOffline() *Client {
+	c := New(0)
+	c.http.Transport = offlineTransport{}
+	return c
+}
+
+// WithMaxConnsPerHost limits the number of connections, idle or active, the
+// client opens to a single host. Zero means no limit.
+func WithMaxConnsPerHost(n int) Option {
+	return func(c *Client) {
+		c.transport().MaxConnsPerHost = n
+	}
+}
+
+// WithMaxIdleConns sets how many idle connections the client keeps for
+// reuse, both in total and per host. The standard library keeps only two
+// per host, which forces concurrent downloads from one feed to reconnect.
+func WithMaxIdleConns(n int) Option {
+	return func(c *Client) {
+		t := c.transport()
+		t.MaxIdleConns = n
+		t.MaxIdleConnsPerHost = n
+	}
+}
+
+// WithDisableKeepAlives makes the client close every connection after a
+// single request, for servers that mishandle persistent connections.
+func WithDisableKeepAlives(disable bool) Option {
+	return func(c *Client) {
+		c.transport().DisableKeepAlives = disable
+	}
+}
+
+// WithProxy sends every request through the proxy at proxyURL. A nil URL
+// connects directly, ignoring the HTTP_PROXY family of environment variables
+// the client honours by default.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(c *Client) {
+		if proxyURL == nil {
+			c.transport().Proxy = nil
+			return
+		}
+		c.transport().Proxy = http.ProxyURL(proxyURL)
+	}
+}
+
+// WithTimeout bounds the duration of each request. Zero keeps the default
+// of 30 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		if d == 0 {
+			d = defaultTimeout
+		}
+		c.timeout = d
+		c.http.Timeout = d
+	}
+}
+
+// With returns a copy of c with opts applied. The copy has its own
+// http.Client and transport, so requests in flight on c are unaffected.
+func (c *Client) With(opts ...Option) *Client {
+	cp := *c
+	httpClient := *c.http
+	cp.http = &httpClient
+	if t, ok := c.http.Transport.(*http.Transport); ok {
+		cp.http.Transport = t.Clone()
+	}
+	for _, opt := range opts {
+		opt(&cp)
+	}
+	return &cp
+}
+
+// transport returns the client's own http.Transport, cloning the default
+// transport the first time so that options never modify shared state.
+// Clients created with NewOffline stay offline: their options apply to a
+// transport that is never used.
+func (c *Client) transport() *http.Transport {
+	switch t := c.http.Transport.(type) {
+	case *http.Transport:
+		return t
+	case offlineTransport:
+		return &http.Transport{}
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	c.http.Transport = t
+	return t
+}
+
+const defaultTimeout = 30 * time.Second
+
 // New creates a downloader with sane defaults.
-func New(timeout time.Duration) *Client {
+func New(timeout time.Duration, opts ...Option) *Client {
 	if timeout == 0 {
-		timeout = 30 * time.Second
+		timeout = defaultTimeout
 	}
-	return &Client{
+	c := &Client{
 		http: &http.Client{
 			Timeout: timeout,
 		},
-		timeout: timeout,
+		timeout:   timeout,
+		userAgent: "opkg-go/" + version.Version,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// newRequest creates a request carrying the client's User-Agent.
+func (c *Client) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
 	}
+	return req, nil
 }
 
 // GetBytes fetches the URL and returns the body as a byte slice.
 func (c *Client) GetBytes(ctx context.Context, url string) ([]byte, error) {
+	return c.GetBytesLimited(ctx, url, 0)
+}
+
+// ErrTooLarge is returned, wrapped, when a response body exceeds the limit
+// passed to GetBytesLimited.
+var ErrTooLarge = errors.New("response too large")
+
+// GetBytesLimited is GetBytes for responses of at most maxBytes. Longer
+// bodies fail with ErrTooLarge without being read completely. Zero means no
+// limit.
+func (c *Client) GetBytesLimited(ctx context.Context, url string, maxBytes int64) ([]byte, error) {
 	if c == nil {
 		return nil, fmt.Errorf("nil downloader client")
 	}
@@ -41,7 +237,7 @@ func (c *Client) GetBytes(ctx context.Context, url string) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req, err := c.newRequest(ctx, http.MethodGet, url)
 	if err != nil {
 		return nil, err
 	}
@@ -52,23 +248,97 @@ func (c *Client) GetBytes(ctx context.Context, url string) ([]byte, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status %s for %s", resp.Status, url)
+		return nil, &StatusError{URL: url, Code: resp.StatusCode, Status: resp.Status}
+	}
+	var r io.Reader = resp.Body
+	if maxBytes > 0 {
+		r = io.LimitReader(resp.Body, maxBytes+1)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes > 0 && int64(len(body)) > maxBytes {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrTooLarge, url, maxBytes)
+	}
+	logging.Debugf("downloader: received %d bytes from %s", len(body), url)
+	return body, nil
+}
+
+// GetPrefix fetches at most n bytes from the start of url with a Range
+// request. Of a server that ignores the range and sends the whole body only
+// the first n bytes are read.
+func (c *Client) GetPrefix(ctx context.Context, url string, n int64) ([]byte, error) {
+	if c == nil {
+		return nil, fmt.Errorf("nil downloader client")
+	}
+	logging.Debugf("downloader: fetching the first %d bytes of %s", n, url)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, http.MethodGet, url)
+	if err != nil {
+		return nil, err
 	}
-	body, err := io.ReadAll(resp.Body)
-	if err == nil {
-		logging.Debugf("downloader: received %d bytes from %s", len(body), url)
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
 	}
-	return body, err
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, &StatusError{URL: url, Code: resp.StatusCode, Status: resp.Status}
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, n))
+}
+
+// Head sends a HEAD request for url and returns the response headers.
+// Redirects are followed by the underlying http.Client.
+func (c *Client) Head(ctx context.Context, url string) (http.Header, error) {
+	if c == nil {
+		return nil, fmt.Errorf("nil downloader client")
+	}
+	logging.Debugf("downloader: probing %s", url)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, http.MethodHead, url)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{URL: url, Code: resp.StatusCode, Status: resp.Status}
+	}
+	return resp.Header, nil
 }
 
 // DownloadToFile downloads the content from url and writes it to the provided
 // path, creating parent directories as necessary.
 func (c *Client) DownloadToFile(ctx context.Context, url, path string) error {
+	return c.DownloadToFileWithChecksum(ctx, url, path, nil)
+}
+
+// DownloadToFileWithChecksum is like DownloadToFile but verifies the content
+// against sum, when non-nil, before it is written. Content that does not
+// match is discarded.
+func (c *Client) DownloadToFileWithChecksum(ctx context.Context, url, path string, sum *Checksum) error {
 	logging.Debugf("downloader: downloading %s to %s", url, path)
 	data, err := c.GetBytes(ctx, url)
 	if err != nil {
 		return err
 	}
+	if sum != nil {
+		if err := sum.Verify(data); err != nil {
+			return fmt.Errorf("verify %s: %w", url, err)
+		}
+	}
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return fmt.Errorf("prepare directory: %w", err)
 	}
@@ -82,3 +352,137 @@ func (c *Client) DownloadToFile(ctx context.Context, url, path string) error {
 	logging.Debugf("downloader: download completed for %s", path)
 	return nil
 }
+
+// ResumeDownload continues an interrupted download of url to path. The
+// partial content is taken from the temporary file DownloadToFile leaves
+// behind, path + ".tmp", or else from path itself, and only the missing
+// bytes are requested with a Range header. When the server ignores the
+// range with 200 OK, rejects it with 416 or there is nothing to resume, the
+// file is downloaded afresh. A failed transfer keeps what was received for
+// the next attempt.
+func (c *Client) ResumeDownload(ctx context.Context, url, path string) error {
+	partial := path + ".tmp"
+	info, err := os.Stat(partial)
+	if errors.Is(err, os.ErrNotExist) {
+		partial = path
+		info, err = os.Stat(path)
+	}
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.Size() == 0) {
+		return c.DownloadToFile(ctx, url, path)
+	}
+	if err != nil {
+		return fmt.Errorf("stat partial download: %w", err)
+	}
+	offset := info.Size()
+	logging.Debugf("downloader: resuming %s into %s at byte %d", url, partial, offset)
+
+	reqCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := c.newRequest(reqCtx, http.MethodGet, url)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	target, flags := partial, os.O_WRONLY|os.O_APPEND
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if want := fmt.Sprintf("bytes %d-", offset); !strings.HasPrefix(resp.Header.Get("Content-Range"), want) {
+			logging.Debugf("downloader: unexpected Content-Range %q for %s, downloading it again", resp.Header.Get("Content-Range"), url)
+			return c.DownloadToFile(ctx, url, path)
+		}
+	case http.StatusOK:
+		logging.Debugf("downloader: %s ignored the range, downloading it again", url)
+		target, flags = path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		logging.Debugf("downloader: %s rejected the range, downloading it again", url)
+		return c.DownloadToFile(ctx, url, path)
+	default:
+		return &StatusError{URL: url, Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	f, err := os.OpenFile(target, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("open partial download: %w", err)
+	}
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", target, err)
+	}
+	if target != path {
+		if err := os.Rename(target, path); err != nil {
+			return fmt.Errorf("commit download: %w", err)
+		}
+	}
+	logging.Debugf("downloader: download completed for %s", path)
+	return nil
+}
+
+// DownloadItem describes one download of a DownloadMany batch.
+type DownloadItem struct {
+	URL      string
+	Path     string
+	Checksum *Checksum
+}
+
+// DownloadResult reports the outcome of a DownloadItem.
+type DownloadResult struct {
+	Item DownloadItem
+	Err  error
+}
+
+// DownloadMany downloads items with DownloadToFileWithChecksum using a pool of
+// workers goroutines; values below one download sequentially. The results
+// are in the same order as items and a failed item does not stop the others.
+// When ctx is cancelled, running downloads are aborted and items that have
+// not started report ctx.Err().
+func (c *Client) DownloadMany(ctx context.Context, items []DownloadItem, workers int) []DownloadResult {
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]DownloadResult, len(items))
+	for i, item := range items {
+		results[i].Item = item
+	}
+	logging.Debugf("downloader: downloading %d items with %d workers", len(items), workers)
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if err := ctx.Err(); err != nil {
+					results[i].Err = err
+					continue
+				}
+				item := items[i]
+				results[i].Err = c.DownloadToFileWithChecksum(ctx, item.URL, item.Path, item.Checksum)
+			}
+		}()
+	}
+	next := 0
+dispatch:
+	for ; next < len(items); next++ {
+		select {
+		case queue <- next:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+	for ; next < len(items); next++ {
+		results[next].Err = ctx.Err()
+	}
+	return results
+}
@@ -0,0 +1,382 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oe-mirrors/opkg_go/internal/version"
+)
+
+func TestUserAgent(t *testing.T) {
+	var got atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.Header.Get("User-Agent"))
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	if _, err := New(0).GetBytes(ctx, srv.URL); err != nil {
+		t.Fatalf("GetBytes returned error: %v", err)
+	}
+	if want := "opkg-go/" + version.Version; got.Load() != want {
+		t.Fatalf("default User-Agent = %q, want %q", got.Load(), want)
+	}
+
+	client := New(0, WithUserAgent("mirror-bot/2.0"))
+	if _, err := client.GetBytes(ctx, srv.URL); err != nil {
+		t.Fatalf("GetBytes returned error: %v", err)
+	}
+	if got.Load() != "mirror-bot/2.0" {
+		t.Fatalf("GET User-Agent = %q", got.Load())
+	}
+	got.Store("")
+	if _, err := client.Head(ctx, srv.URL); err != nil {
+		t.Fatalf("Head returned error: %v", err)
+	}
+	if got.Load() != "mirror-bot/2.0" {
+		t.Fatalf("HEAD User-Agent = %q", got.Load())
+	}
+}
+
+func TestHeadReturnsHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("unexpected method %s", r.Method)
+		}
+		w.Header().Set("Content-Length", "1234")
+		w.Header().Set("ETag", `"abc"`)
+	}))
+	defer srv.Close()
+
+	header, err := New(0).Head(context.Background(), srv.URL+"/Packages.gz")
+	if err != nil {
+		t.Fatalf("Head returned error: %v", err)
+	}
+	if got := header.Get("Content-Length"); got != "1234" {
+		t.Fatalf("unexpected Content-Length %q", got)
+	}
+	if got := header.Get("ETag"); got != `"abc"` {
+		t.Fatalf("unexpected ETag %q", got)
+	}
+}
+
+func TestGetBytesLimited(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("x", 100))
+	}))
+	defer srv.Close()
+	c := New(0)
+	ctx := context.Background()
+
+	if body, err := c.GetBytesLimited(ctx, srv.URL, 100); err != nil || len(body) != 100 {
+		t.Fatalf("body at the limit: %d bytes, %v", len(body), err)
+	}
+	if _, err := c.GetBytesLimited(ctx, srv.URL, 99); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestGetPrefix(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ignore-range" {
+			fmt.Fprint(w, content)
+			return
+		}
+		http.ServeContent(w, r, "Packages", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+	c := New(0)
+	ctx := context.Background()
+
+	for _, path := range []string{"/ranged", "/ignore-range"} {
+		if body, err := c.GetPrefix(ctx, srv.URL+path, 15); err != nil || string(body) != content[:15] {
+			t.Fatalf("%s: got %q, %v", path, body, err)
+		}
+		if body, err := c.GetPrefix(ctx, srv.URL+path, 500); err != nil || string(body) != content {
+			t.Fatalf("%s: got %q, %v", path, body, err)
+		}
+	}
+}
+
+func TestResumeDownload(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	var ranges []string
+	var mu sync.Mutex
+	ignoreRange := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		ignore := ignoreRange
+		mu.Unlock()
+		if ignore {
+			fmt.Fprint(w, content)
+			return
+		}
+		http.ServeContent(w, r, "tool.ipk", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+	c := New(0)
+	ctx := context.Background()
+	dir := t.TempDir()
+	check := func(path string) {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != content {
+			t.Fatalf("%s holds %d bytes (%v), want the full content", path, len(data), err)
+		}
+		if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+			t.Fatalf("temporary file left behind: %v", err)
+		}
+	}
+
+	// A .tmp file left by DownloadToFile is resumed with a 206 response.
+	path := filepath.Join(dir, "tmp.ipk")
+	if err := os.WriteFile(path+".tmp", []byte(content[:300]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ResumeDownload(ctx, srv.URL, path); err != nil {
+		t.Fatalf("ResumeDownload returned error: %v", err)
+	}
+	check(path)
+
+	// A partial destination file is appended to.
+	path = filepath.Join(dir, "partial.ipk")
+	if err := os.WriteFile(path, []byte(content[:999]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ResumeDownload(ctx, srv.URL, path); err != nil {
+		t.Fatalf("ResumeDownload returned error: %v", err)
+	}
+	check(path)
+
+	// A complete file makes the server answer 416 and is fetched again.
+	if err := c.ResumeDownload(ctx, srv.URL, path); err != nil {
+		t.Fatalf("ResumeDownload returned error: %v", err)
+	}
+	check(path)
+
+	// A server ignoring the range replaces the partial content.
+	mu.Lock()
+	ignoreRange = true
+	mu.Unlock()
+	path = filepath.Join(dir, "ignored.ipk")
+	if err := os.WriteFile(path+".tmp", []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ResumeDownload(ctx, srv.URL, path); err != nil {
+		t.Fatalf("ResumeDownload returned error: %v", err)
+	}
+	check(path)
+
+	// Without a partial file the download starts from scratch.
+	if err := c.ResumeDownload(ctx, srv.URL, filepath.Join(dir, "new.ipk")); err != nil {
+		t.Fatalf("ResumeDownload returned error: %v", err)
+	}
+	check(filepath.Join(dir, "new.ipk"))
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"bytes=300-", "bytes=999-", "bytes=1000-", "", "bytes=7-", ""}
+	if strings.Join(ranges, ",") != strings.Join(want, ",") {
+		t.Fatalf("Range headers %q, want %q", ranges, want)
+	}
+}
+
+func TestDownloadManyKeepsOrderAndIsolatesFailures(t *testing.T) {
+	var active, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprintf(w, "content of %s", r.URL.Path)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	var items []DownloadItem
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("pkg%d.ipk", i)
+		digest := sha256.Sum256([]byte("content of /" + name))
+		sum := &Checksum{Algorithm: "sha256", Value: hex.EncodeToString(digest[:])}
+		if i == 3 {
+			sum.Value = strings.Repeat("0", 64)
+		}
+		items = append(items, DownloadItem{URL: srv.URL + "/" + name, Path: filepath.Join(dir, name), Checksum: sum})
+	}
+
+	results := New(5*time.Second).DownloadMany(context.Background(), items, 4)
+	if len(results) != len(items) {
+		t.Fatalf("expected %d results, got %d", len(items), len(results))
+	}
+	for i, res := range results {
+		if res.Item != items[i] {
+			t.Fatalf("result %d belongs to %s", i, res.Item.URL)
+		}
+		if i == 3 {
+			if !errors.Is(res.Err, ErrChecksumMismatch) {
+				t.Fatalf("expected checksum mismatch for item 3, got %v", res.Err)
+			}
+			if _, err := os.Stat(res.Item.Path); !os.IsNotExist(err) {
+				t.Fatalf("expected no file for the failed item, got %v", err)
+			}
+			continue
+		}
+		if res.Err != nil {
+			t.Fatalf("item %d failed: %v", i, res.Err)
+		}
+		data, err := os.ReadFile(res.Item.Path)
+		if err != nil || string(data) != fmt.Sprintf("content of /pkg%d.ipk", i) {
+			t.Fatalf("unexpected content for item %d: %q %v", i, data, err)
+		}
+	}
+	if got := atomic.LoadInt32(&peak); got > 4 || got < 2 {
+		t.Fatalf("expected between 2 and 4 concurrent downloads, saw %d", got)
+	}
+}
+
+func TestDownloadManyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	items := []DownloadItem{{URL: "http://example.invalid/a", Path: filepath.Join(t.TempDir(), "a")}, {URL: "http://example.invalid/b"}}
+	for _, res := range New(0).DownloadMany(ctx, items, 2) {
+		if !errors.Is(res.Err, context.Canceled) {
+			t.Fatalf("expected context.Canceled for %s, got %v", res.Item.URL, res.Err)
+		}
+	}
+}
+
+func TestTransportOptions(t *testing.T) {
+	c := New(0, WithMaxConnsPerHost(8), WithMaxIdleConns(16), WithDisableKeepAlives(true))
+	tr, ok := c.http.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected a dedicated transport, got %T", c.http.Transport)
+	}
+	if tr == http.DefaultTransport {
+		t.Fatal("options modified the shared default transport")
+	}
+	if tr.MaxConnsPerHost != 8 || tr.MaxIdleConns != 16 || tr.MaxIdleConnsPerHost != 16 || !tr.DisableKeepAlives {
+		t.Fatalf("unexpected transport settings %+v", tr)
+	}
+	if New(0).http.Transport != nil {
+		t.Fatal("a client without options should use the default transport")
+	}
+}
+
+func TestWithCopiesClient(t *testing.T) {
+	orig := New(0, WithMaxIdleConns(4))
+	proxy, _ := url.Parse("http://proxy.invalid:3128")
+	c := orig.With(WithProxy(proxy), WithTimeout(time.Second))
+	if orig.timeout != 30*time.Second || orig.http.Timeout != 30*time.Second {
+		t.Fatalf("With changed the original timeout to %v", orig.timeout)
+	}
+	if c.timeout != time.Second || c.http.Timeout != time.Second {
+		t.Fatalf("unexpected timeout %v", c.timeout)
+	}
+	tr := c.http.Transport.(*http.Transport)
+	if tr == orig.http.Transport {
+		t.Fatal("With shares the transport of the original")
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://feed.invalid/Packages", nil)
+	if got, err := tr.Proxy(req); err != nil || got.String() != proxy.String() {
+		t.Fatalf("proxy = %v, %v; want %v", got, err, proxy)
+	}
+	if tr.MaxIdleConns != 4 {
+		t.Fatal("With dropped the transport settings of the original")
+	}
+	if direct := c.With(WithProxy(nil)); direct.http.Transport.(*http.Transport).Proxy != nil {
+		t.Fatal("a nil proxy URL should connect directly")
+	}
+}
+
+func TestOfflineIgnoresTransportOptions(t *testing.T) {
+	proxy, _ := url.Parse("http://proxy.invalid:3128")
+	c := NewOffline().With(WithProxy(proxy), WithMaxConnsPerHost(2), WithTimeout(time.Second))
+	if _, err := c.GetBytes(context.Background(), "http://feed.invalid/Packages"); !errors.Is(err, ErrOffline) {
+		t.Fatalf("expected ErrOffline, got %v", err)
+	}
+}
+
+func TestChecksumVerify(t *testing.T) {
+	data := []byte("archive")
+	sha256Sum := sha256.Sum256(data)
+	sha512Sum := sha512.Sum512(data)
+	for _, sum := range []Checksum{
+		{Algorithm: "sha512", Value: hex.EncodeToString(sha512Sum[:])},
+		{Algorithm: "SHA256", Value: hex.EncodeToString(sha256Sum[:])},
+	} {
+		if err := sum.Verify(data); err != nil {
+			t.Errorf("%s: Verify returned error: %v", sum.Algorithm, err)
+		}
+		if err := sum.Verify([]byte("other")); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("%s: expected a mismatch, got %v", sum.Algorithm, err)
+		}
+	}
+	if err := (Checksum{Algorithm: "crc32", Value: "0"}).Verify(data); err == nil {
+		t.Error("expected an unsupported algorithm to fail")
+	}
+}
+
+// BenchmarkConcurrentDownloads fetches 100 files at once from a local
+// server, comparing the default transport with one that keeps enough idle
+// connections for every download.
+func BenchmarkConcurrentDownloads(b *testing.B) {
+	payload := strings.Repeat("x", 64<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, payload)
+	}))
+	defer srv.Close()
+
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"default", nil},
+		{"tuned", []Option{WithMaxIdleConns(100), WithMaxConnsPerHost(100)}},
+		{"no-keepalive", []Option{WithDisableKeepAlives(true)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			c := New(0, bc.opts...)
+			ctx := context.Background()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				errs := make(chan error, 100)
+				for j := 0; j < 100; j++ {
+					wg.Add(1)
+					go func(j int) {
+						defer wg.Done()
+						if _, err := c.GetBytes(ctx, fmt.Sprintf("%s/%d", srv.URL, j)); err != nil {
+							errs <- err
+						}
+					}(j)
+				}
+				wg.Wait()
+				close(errs)
+				if err := <-errs; err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
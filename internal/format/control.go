@@ -2,8 +2,12 @@ package format
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"sort"
 	"strings"
 
@@ -13,6 +17,13 @@ import (
 // Paragraph represents a set of key/value pairs from a Debian control file.
 type Paragraph struct {
 	Fields map[string]string
+
+	// order lists the keys in the order they were parsed. Keys added to
+	// Fields directly are not listed; see OrderedKeys.
+	order []string
+	// raw holds the parsed lines verbatim when parsed with KeepRaw; see
+	// RawBytes.
+	raw []byte
 }
 
 // Value returns the value for the provided key, performing a case-insensitive
@@ -26,15 +37,140 @@ func (p Paragraph) Value(key string) string {
 	return ""
 }
 
+// RawBytes returns the paragraph exactly as ParseControlOpts read it with
+// KeepRaw set, including the original indentation of continuation lines,
+// without the terminating blank line. Changes made to Fields after parsing
+// are not reflected. Other paragraphs, and paragraphs changed by Merge or
+// MergeOverride, are serialised with WriteTo instead. The result must not be
+// modified.
+func (p Paragraph) RawBytes() []byte {
+	if p.raw != nil {
+		return p.raw
+	}
+	var b bytes.Buffer
+	p.WriteTo(&b)
+	return b.Bytes()
+}
+
+// Clone returns a copy of the paragraph that shares no mutable state with p.
+func (p Paragraph) Clone() Paragraph {
+	c := Paragraph{order: append([]string(nil), p.order...), raw: bytes.Clone(p.raw)}
+	if p.Fields != nil {
+		c.Fields = make(map[string]string, len(p.Fields))
+		for k, v := range p.Fields {
+			c.Fields[k] = v
+		}
+	}
+	return c
+}
+
+// Merge adds the fields of other that p lacks. Keys are compared case
+// insensitively and existing values are kept.
+func (p *Paragraph) Merge(other Paragraph) {
+	p.merge(other, false)
+}
+
+// MergeOverride copies every field of other into p, replacing the values of
+// keys p already has. Replaced keys keep their spelling and position in p.
+//
+// Both methods modify the Fields map of p, which copies of p share; merge
+// into a Clone to leave the original untouched.
+func (p *Paragraph) MergeOverride(other Paragraph) {
+	p.merge(other, true)
+}
+
+func (p *Paragraph) merge(other Paragraph, override bool) {
+	for _, key := range other.OrderedKeys() {
+		value := other.Fields[key]
+		if existing, ok := p.key(key); ok {
+			if override && p.Fields[existing] != value {
+				p.Fields[existing] = value
+				// The parsed text no longer matches; RawBytes falls back
+				// to WriteTo.
+				p.raw = nil
+			}
+			continue
+		}
+		if p.Fields == nil {
+			p.Fields = map[string]string{}
+		}
+		p.Fields[key] = value
+		p.order = append(slices.Clip(p.order), key)
+		p.raw = nil
+	}
+}
+
+// key returns the spelling under which p stores key, compared case
+// insensitively.
+func (p Paragraph) key(key string) (string, bool) {
+	if _, ok := p.Fields[key]; ok {
+		return key, true
+	}
+	for k := range p.Fields {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
 // ControlFile contains one or more paragraphs extracted from a Packages file
 // or from the status database.
 type ControlFile struct {
 	Paragraphs []Paragraph
 }
 
+// Clone returns a deep copy of the control file.
+func (cf ControlFile) Clone() ControlFile {
+	var c ControlFile
+	if cf.Paragraphs != nil {
+		c.Paragraphs = make([]Paragraph, len(cf.Paragraphs))
+		for i, p := range cf.Paragraphs {
+			c.Paragraphs[i] = p.Clone()
+		}
+	}
+	return c
+}
+
 // ParseControl parses a Debian control formatted stream. The implementation is
 // compatible with both Packages indexes and status files.
 func ParseControl(r io.Reader) (*ControlFile, error) {
+	return ParseControlOpts(r, ParseControlOptions{})
+}
+
+// ParseControlOptions bounds the input ParseControlOpts accepts. Zero values
+// mean no limit.
+type ParseControlOptions struct {
+	// MaxBytes is the largest number of bytes read from the stream.
+	MaxBytes int64
+	// MaxParagraphs is the largest number of paragraphs returned.
+	MaxParagraphs int
+	// KeepRaw keeps a verbatim copy of each paragraph for RawBytes. It
+	// doubles the memory a parsed file takes.
+	KeepRaw bool
+}
+
+// ErrLimitExceeded is returned, wrapped, when control data exceeds a limit
+// set in ParseControlOptions.
+var ErrLimitExceeded = errors.New("control data exceeds limit")
+
+// ParseControlWithLimit parses at most maxBytes of r and fails when the
+// stream is longer.
+func ParseControlWithLimit(r io.Reader, maxBytes int64) (*ControlFile, error) {
+	return ParseControlOpts(r, ParseControlOptions{MaxBytes: maxBytes})
+}
+
+// ParseControlOpts is ParseControl with limits that protect against
+// oversized input. Exceeding a limit fails with ErrLimitExceeded instead of
+// returning truncated data.
+func ParseControlOpts(r io.Reader, opts ParseControlOptions) (*ControlFile, error) {
+	var limited *io.LimitedReader
+	if opts.MaxBytes > 0 {
+		// Read one byte past the limit to tell a stream of exactly
+		// MaxBytes from a longer one.
+		limited = &io.LimitedReader{R: r, N: opts.MaxBytes + 1}
+		r = limited
+	}
 	scanner := bufio.NewScanner(r)
 	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
 
@@ -43,24 +179,40 @@ func ParseControl(r io.Reader) (*ControlFile, error) {
 
 	logging.Debugf("format: begin parsing control data")
 
-	flush := func() {
+	flush := func() error {
 		if len(current.Fields) == 0 {
-			return
+			return nil
+		}
+		if opts.MaxParagraphs > 0 && len(file.Paragraphs) == opts.MaxParagraphs {
+			return fmt.Errorf("%w: more than %d paragraphs", ErrLimitExceeded, opts.MaxParagraphs)
 		}
 		file.Paragraphs = append(file.Paragraphs, current)
 		current = Paragraph{Fields: map[string]string{}}
+		return nil
 	}
 
 	current = Paragraph{Fields: map[string]string{}}
 	var lastKey string
 
+	exceeded := func() bool { return limited != nil && limited.N == 0 }
+	tooLarge := fmt.Errorf("%w: more than %d bytes", ErrLimitExceeded, opts.MaxBytes)
 	for scanner.Scan() {
+		// The stream is only exhausted early when it is too long; stop
+		// before parsing a line the limit may have cut short.
+		if exceeded() {
+			return nil, tooLarge
+		}
 		line := scanner.Text()
 		if line == "" {
-			flush()
+			if err := flush(); err != nil {
+				return nil, err
+			}
 			lastKey = ""
 			continue
 		}
+		if opts.KeepRaw {
+			current.raw = append(append(current.raw, line...), '\n')
+		}
 		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
 			if lastKey == "" {
 				return nil, fmt.Errorf("continuation line encountered before key: %q", line)
@@ -79,12 +231,20 @@ func ParseControl(r io.Reader) (*ControlFile, error) {
 		if current.Fields == nil {
 			current.Fields = map[string]string{}
 		}
+		if _, seen := current.Fields[key]; !seen {
+			current.order = append(current.order, key)
+		}
 		current.Fields[key] = value
 	}
+	if exceeded() {
+		return nil, tooLarge
+	}
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
-	flush()
+	if err := flush(); err != nil {
+		return nil, err
+	}
 	logging.Debugf("format: parsed %d paragraphs", len(file.Paragraphs))
 	return &file, nil
 }
@@ -98,3 +258,148 @@ func (p Paragraph) Keys() []string {
 	sort.Strings(keys)
 	return keys
 }
+
+// OrderedKeys returns the keys of the paragraph in the order they were
+// parsed. Keys without a recorded position, for example in paragraphs built
+// in code, follow in sorted order with the Package field first.
+func (p Paragraph) OrderedKeys() []string {
+	keys := make([]string, 0, len(p.Fields))
+	listed := make(map[string]bool, len(p.order))
+	for _, key := range p.order {
+		if _, ok := p.Fields[key]; ok && !listed[key] {
+			listed[key] = true
+			keys = append(keys, key)
+		}
+	}
+	var rest []string
+	for _, key := range p.Keys() {
+		if !listed[key] {
+			rest = append(rest, key)
+		}
+	}
+	for i, key := range rest {
+		if strings.EqualFold(key, "Package") && i > 0 {
+			copy(rest[1:i+1], rest[:i])
+			rest[0] = key
+			break
+		}
+	}
+	return append(keys, rest...)
+}
+
+// WriteTo serialises the paragraph in control file syntax. Fields are written
+// in the order given by OrderedKeys. Multi-line values are written as
+// continuation lines.
+func (p Paragraph) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	for _, key := range p.OrderedKeys() {
+		lines := strings.Split(p.Fields[key], "\n")
+		if lines[0] == "" {
+			b.WriteString(key + ":\n")
+		} else {
+			b.WriteString(key + ": " + lines[0] + "\n")
+		}
+		for _, line := range lines[1:] {
+			b.WriteString(" " + line + "\n")
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// WriteTo serialises all paragraphs separated by blank lines.
+func (cf ControlFile) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for i, p := range cf.Paragraphs {
+		if i > 0 {
+			n, err := io.WriteString(w, "\n")
+			total += int64(n)
+			if err != nil {
+				return total, err
+			}
+		}
+		n, err := p.WriteTo(w)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// MarshalJSON encodes the paragraph as a JSON object whose keys appear in
+// the order given by OrderedKeys, with their original case.
+func (p Paragraph) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range p.OrderedKeys() {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(p.Fields[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes a JSON object of string values, recording the order
+// of its keys.
+func (p *Paragraph) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("paragraph must be a JSON object, got %v", tok)
+	}
+	fields := map[string]string{}
+	var order []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		var value string
+		if err := dec.Decode(&value); err != nil {
+			return fmt.Errorf("field %s: %w", key, err)
+		}
+		if _, seen := fields[key]; !seen {
+			order = append(order, key)
+		}
+		fields[key] = value
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	p.Fields = fields
+	p.order = order
+	return nil
+}
+
+// MarshalJSON encodes the control file as a JSON array of paragraphs.
+func (cf ControlFile) MarshalJSON() ([]byte, error) {
+	if cf.Paragraphs == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(cf.Paragraphs)
+}
+
+// UnmarshalJSON decodes a JSON array of paragraph objects.
+func (cf *ControlFile) UnmarshalJSON(data []byte) error {
+	var paragraphs []Paragraph
+	if err := json.Unmarshal(data, &paragraphs); err != nil {
+		return err
+	}
+	cf.Paragraphs = paragraphs
+	return nil
+}
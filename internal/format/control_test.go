@@ -0,0 +1,193 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const packagesExcerpt = `Package: busybox
+Version: 1.36.1-r0
+Depends: libc6 (>= 2.35), update-alternatives-opkg
+Provides: /bin/sh
+Section: base
+Architecture: armv7a
+Maintainer: OE-Core Developers <openembedded-core@lists.openembedded.org>
+MD5Sum: 6d2a9bd4a5f8c2e0d4fa0b4c4c0f1e7a
+Size: 212340
+Filename: busybox_1.36.1-r0_armv7a.ipk
+Source: busybox_1.36.1.bb
+Description: Tiny versions of many common UNIX utilities in a single small executable.
+ BusyBox combines tiny versions of many common UNIX utilities into a single
+ small executable.
+
+Package: libc6
+Version: 2.35-r0
+Architecture: armv7a
+Filename: libc6_2.35-r0_armv7a.ipk
+Description: GNU C Library
+`
+
+func TestControlFileJSONRoundTrip(t *testing.T) {
+	cf, err := ParseControl(strings.NewReader(packagesExcerpt))
+	if err != nil {
+		t.Fatalf("ParseControl returned error: %v", err)
+	}
+	first, err := json.Marshal(cf)
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+	if !strings.HasPrefix(string(first), `[{"Package":"busybox","Version":"1.36.1-r0","Depends":`) {
+		t.Fatalf("fields not encoded in parse order: %s", first)
+	}
+
+	var decoded ControlFile
+	if err := json.Unmarshal(first, &decoded); err != nil {
+		t.Fatalf("Unmarshal returned error: %v", err)
+	}
+	second, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Fatalf("round trip changed JSON:\n%s\n%s", first, second)
+	}
+
+	var buf bytes.Buffer
+	if _, err := decoded.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo returned error: %v", err)
+	}
+	if buf.String() != packagesExcerpt {
+		t.Fatalf("control output differs from input:\n%s", buf.String())
+	}
+}
+
+func TestWriteToPutsPackageFirstWithoutParseOrder(t *testing.T) {
+	p := Paragraph{Fields: map[string]string{"Version": "1.0", "Package": "tool", "Architecture": "all"}}
+	var buf bytes.Buffer
+	if _, err := p.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo returned error: %v", err)
+	}
+	if want := "Package: tool\nArchitecture: all\nVersion: 1.0\n"; buf.String() != want {
+		t.Fatalf("WriteTo=%q want %q", buf.String(), want)
+	}
+}
+
+func TestCloneIsIndependent(t *testing.T) {
+	cf, err := ParseControl(strings.NewReader(packagesExcerpt))
+	if err != nil {
+		t.Fatalf("ParseControl returned error: %v", err)
+	}
+	clone := cf.Clone()
+	clone.Paragraphs[0].Fields["Version"] = "9.9"
+	clone.Paragraphs[0].order[0] = "Version"
+	clone.Paragraphs[1].Fields["Extra"] = "yes"
+
+	if got := cf.Paragraphs[0].Value("Version"); got != "1.36.1-r0" {
+		t.Fatalf("clone modified original Version: %q", got)
+	}
+	if cf.Paragraphs[0].OrderedKeys()[0] != "Package" {
+		t.Fatalf("clone modified original field order")
+	}
+	if _, ok := cf.Paragraphs[1].Fields["Extra"]; ok {
+		t.Fatalf("clone modified original fields")
+	}
+}
+
+func TestRawBytesPreservesParsedText(t *testing.T) {
+	first := "Package: busybox\nVersion: 1.36.1-r0\nDescription: Tiny utilities\n    indented by four\n\tand a tab\n"
+	second := "Package: zlib\nVersion: 1.3\n"
+	cf, err := ParseControlOpts(strings.NewReader(first+"\n"+second), ParseControlOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatalf("ParseControlOpts returned error: %v", err)
+	}
+	if got := string(cf.Paragraphs[0].RawBytes()); got != first {
+		t.Fatalf("RawBytes()=%q want %q", got, first)
+	}
+	if got := string(cf.Paragraphs[1].RawBytes()); got != second {
+		t.Fatalf("RawBytes()=%q want %q", got, second)
+	}
+	cf, err = ParseControl(strings.NewReader(first))
+	if err != nil {
+		t.Fatalf("ParseControl returned error: %v", err)
+	}
+	if got := string(cf.Paragraphs[0].RawBytes()); got == first {
+		t.Fatalf("ParseControl kept the raw text without KeepRaw")
+	}
+	built := Paragraph{Fields: map[string]string{"Package": "tool", "Version": "1.0"}}
+	if got := string(built.RawBytes()); got != "Package: tool\nVersion: 1.0\n" {
+		t.Fatalf("unexpected RawBytes for built paragraph %q", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	parse := func(text string) Paragraph {
+		t.Helper()
+		cf, err := ParseControl(strings.NewReader(text))
+		if err != nil {
+			t.Fatalf("ParseControl returned error: %v", err)
+		}
+		return cf.Paragraphs[0]
+	}
+	index := "Package: busybox\nVersion: 1.36.1\nDescription: Tiny utilities\n"
+	status := parse("package: busybox\nVersion: 1.35.0\nStatus: install ok installed\n")
+
+	merged := parse(index)
+	merged.Merge(status)
+	if merged.Value("Version") != "1.36.1" || merged.Value("Status") != "install ok installed" {
+		t.Fatalf("Merge did not keep existing keys and add new ones: %v", merged.Fields)
+	}
+	if _, dup := merged.Fields["package"]; dup {
+		t.Fatal("Merge added a key that differs only in case")
+	}
+	if got, want := string(merged.RawBytes()), index+"Status: install ok installed\n"; got != want {
+		t.Fatalf("RawBytes after Merge = %q, want %q", got, want)
+	}
+
+	overridden := parse(index)
+	overridden.MergeOverride(status)
+	if overridden.Value("Version") != "1.35.0" || overridden.Value("Status") != "install ok installed" {
+		t.Fatalf("MergeOverride did not replace existing keys: %v", overridden.Fields)
+	}
+	if got, want := overridden.OrderedKeys(), []string{"Package", "Version", "Description", "Status"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("OrderedKeys after MergeOverride = %v, want %v", got, want)
+	}
+	if got := string(overridden.RawBytes()); !strings.Contains(got, "Version: 1.35.0\n") {
+		t.Fatalf("RawBytes after MergeOverride still shows the old text: %q", got)
+	}
+
+	var empty Paragraph
+	empty.Merge(status)
+	if empty.Value("Status") != "install ok installed" {
+		t.Fatalf("Merge into an empty paragraph = %v", empty.Fields)
+	}
+}
+
+func TestParseControlLimits(t *testing.T) {
+	data := "Package: a\nVersion: 1\n\nPackage: b\nVersion: 2\n"
+	size := int64(len(data))
+
+	cf, err := ParseControlWithLimit(strings.NewReader(data), size)
+	if err != nil {
+		t.Fatalf("input of exactly the limit rejected: %v", err)
+	}
+	if len(cf.Paragraphs) != 2 {
+		t.Fatalf("expected 2 paragraphs, got %d", len(cf.Paragraphs))
+	}
+	if _, err := ParseControlWithLimit(strings.NewReader(data), size-1); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected ErrLimitExceeded one byte over the limit, got %v", err)
+	}
+	if _, err := ParseControlWithLimit(strings.NewReader(data), 5); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected ErrLimitExceeded for a limit inside the first line, got %v", err)
+	}
+
+	if _, err := ParseControlOpts(strings.NewReader(data), ParseControlOptions{MaxParagraphs: 2}); err != nil {
+		t.Fatalf("paragraph count at the limit rejected: %v", err)
+	}
+	if _, err := ParseControlOpts(strings.NewReader(data), ParseControlOptions{MaxParagraphs: 1}); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected ErrLimitExceeded for too many paragraphs, got %v", err)
+	}
+}
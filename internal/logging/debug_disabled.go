@@ -2,5 +2,8 @@
 
 package logging
 
-// Debugf is a no-op when the debug build tag is not enabled.
-func Debugf(string, ...interface{}) {}
+import "log/slog"
+
+// defaultLevel hides debug messages unless the debug build tag is enabled
+// or the level is raised at runtime.
+const defaultLevel = slog.LevelInfo
@@ -2,22 +2,7 @@
 
 package logging
 
-import (
-	"fmt"
-	"os"
-	"sync"
-	"time"
-)
+import "log/slog"
 
-var (
-	mu sync.Mutex
-)
-
-// Debugf prints formatted debug output when the debug build tag is enabled.
-func Debugf(format string, args ...interface{}) {
-	mu.Lock()
-	defer mu.Unlock()
-	timestamp := time.Now().Format(time.RFC3339)
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintf(os.Stderr, "[%s][DEBUG] %s\n", timestamp, msg)
-}
+// defaultLevel shows debug messages when the debug build tag is enabled.
+const defaultLevel = slog.LevelDebug
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// debugf is the sink of the loggers returned by With; tests replace it.
+var debugf = Debugf
+
+// With returns a Debugf style logger that appends fields to every message
+// as key=value pairs, sorted by key. Values containing spaces are quoted.
+// The fields are formatted once, when the first message is written, so a
+// logger that never writes at the current level costs no formatting.
+func With(fields map[string]any) func(format string, args ...any) {
+	var (
+		once   sync.Once
+		suffix string
+	)
+	return func(format string, args ...any) {
+		if !Enabled(slog.LevelDebug) {
+			return
+		}
+		once.Do(func() { suffix = formatFields(fields) })
+		debugf(format+"%s", append(args[:len(args):len(args)], suffix)...)
+	}
+}
+
+// WithFeed returns a logger that tags every message with feed=name.
+func WithFeed(name string) func(format string, args ...any) {
+	return With(map[string]any{"feed": name})
+}
+
+// WithPackage returns a logger that tags every message with package=name.
+func WithPackage(name string) func(format string, args ...any) {
+	return With(map[string]any{"package": name})
+}
+
+func formatFields(fields map[string]any) string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		value := fmt.Sprint(fields[key])
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %s=%s", key, value)
+	}
+	return b.String()
+}
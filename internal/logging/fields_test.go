@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"testing"
+)
+
+func captureDebug(t *testing.T) *[]string {
+	t.Helper()
+	var lines []string
+	prev := debugf
+	debugf = func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	prevLevel := Level()
+	SetLevel(slog.LevelDebug)
+	t.Cleanup(func() {
+		debugf = prev
+		SetLevel(prevLevel)
+	})
+	return &lines
+}
+
+// countingValue counts how often it is formatted.
+type countingValue struct{ calls *int }
+
+func (v countingValue) String() string {
+	*v.calls++
+	return "value"
+}
+
+func TestWithAppendsFieldsToEveryMessage(t *testing.T) {
+	lines := captureDebug(t)
+
+	log := With(map[string]any{"feed": "base", "attempt": 2, "url": "http://example.invalid/a b"})
+	log("fetching %s", "Packages.gz")
+	log("done")
+	WithFeed("extra")("parsed %d packages", 12)
+	WithPackage("busybox")("downloaded")
+
+	want := []string{
+		`fetching Packages.gz attempt=2 feed=base url="http://example.invalid/a b"`,
+		`done attempt=2 feed=base url="http://example.invalid/a b"`,
+		"parsed 12 packages feed=extra",
+		"downloaded package=busybox",
+	}
+	if len(*lines) != len(want) {
+		t.Fatalf("got %d messages: %q", len(*lines), *lines)
+	}
+	for i, line := range *lines {
+		if line != want[i] {
+			t.Errorf("message %d = %q want %q", i, line, want[i])
+		}
+	}
+}
+
+func TestWithFormatsFieldsOnlyWhenEnabled(t *testing.T) {
+	lines := captureDebug(t)
+	var calls int
+	log := With(map[string]any{"key": countingValue{&calls}})
+
+	SetLevel(slog.LevelInfo)
+	log("hidden")
+	if calls != 0 || len(*lines) != 0 {
+		t.Fatalf("disabled logger formatted its fields %d times and wrote %q", calls, *lines)
+	}
+
+	SetLevel(slog.LevelDebug)
+	log("first")
+	log("second")
+	if calls != 1 || len(*lines) != 2 || (*lines)[1] != "second key=value" {
+		t.Fatalf("fields formatted %d times, messages %q", calls, *lines)
+	}
+}
@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	mu     sync.Mutex
+	level            = new(slog.LevelVar)
+	output io.Writer = os.Stderr
+)
+
+func init() {
+	level.Set(defaultLevel)
+}
+
+// SetLevel sets the lowest level that is written. Messages below it are
+// discarded.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// Level returns the current log level.
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SetOutput directs log output to w. Error messages are also written to
+// stderr when w is not stderr, so they are never hidden in a log file.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	output = w
+}
+
+// Enabled reports whether messages at level l are written.
+func Enabled(l slog.Level) bool {
+	return l >= level.Level()
+}
+
+// Debugf writes a formatted debug message.
+func Debugf(format string, args ...interface{}) {
+	logf(slog.LevelDebug, format, args...)
+}
+
+// Infof writes a formatted informational message.
+func Infof(format string, args ...interface{}) {
+	logf(slog.LevelInfo, format, args...)
+}
+
+// Warnf writes a formatted warning.
+func Warnf(format string, args ...interface{}) {
+	logf(slog.LevelWarn, format, args...)
+}
+
+// Errorf writes a formatted error message.
+func Errorf(format string, args ...interface{}) {
+	logf(slog.LevelError, format, args...)
+}
+
+func logf(l slog.Level, format string, args ...interface{}) {
+	if !Enabled(l) {
+		return
+	}
+	line := fmt.Sprintf("[%s][%s] %s\n", time.Now().Format(time.RFC3339), l, fmt.Sprintf(format, args...))
+	mu.Lock()
+	defer mu.Unlock()
+	io.WriteString(output, line)
+	if l >= slog.LevelError && output != io.Writer(os.Stderr) {
+		io.WriteString(os.Stderr, line)
+	}
+}
+
+// ConfigureFromEnv applies OPKG_LOG_LEVEL (debug, info, warn or error) and
+// OPKG_LOG_FILE, which names a file that log output is appended to.
+func ConfigureFromEnv() error {
+	if value := os.Getenv("OPKG_LOG_LEVEL"); value != "" {
+		l, err := ParseLevel(value)
+		if err != nil {
+			return err
+		}
+		SetLevel(l)
+	}
+	if path := os.Getenv("OPKG_LOG_FILE"); path != "" {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		SetOutput(file)
+	}
+	return nil
+}
+
+// ParseLevel parses a level name as accepted by OPKG_LOG_LEVEL.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q: want debug, info, warn or error", s)
+}
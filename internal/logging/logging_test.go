@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func captureOutput(t *testing.T, l slog.Level) *bytes.Buffer {
+	t.Helper()
+	prevLevel := Level()
+	var buf bytes.Buffer
+	SetLevel(l)
+	SetOutput(&buf)
+	t.Cleanup(func() {
+		SetLevel(prevLevel)
+		SetOutput(os.Stderr)
+	})
+	return &buf
+}
+
+func TestSetLevelFiltersMessages(t *testing.T) {
+	buf := captureOutput(t, slog.LevelWarn)
+
+	Debugf("hidden %d", 1)
+	Infof("hidden %d", 2)
+	Warnf("shown %d", 3)
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Fatalf("messages below the level were written: %q", out)
+	}
+	if !strings.Contains(out, "[WARN] shown 3") {
+		t.Fatalf("warning missing: %q", out)
+	}
+
+	SetLevel(slog.LevelDebug)
+	Debugf("now shown")
+	if !strings.Contains(buf.String(), "[DEBUG] now shown") {
+		t.Fatalf("debug message missing after SetLevel: %q", buf.String())
+	}
+}
+
+func TestConfigureFromEnv(t *testing.T) {
+	captureOutput(t, slog.LevelInfo)
+	path := filepath.Join(t.TempDir(), "opkg.log")
+	if err := os.WriteFile(path, []byte("previous\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OPKG_LOG_LEVEL", "DEBUG")
+	t.Setenv("OPKG_LOG_FILE", path)
+
+	if err := ConfigureFromEnv(); err != nil {
+		t.Fatalf("ConfigureFromEnv: %v", err)
+	}
+	if Level() != slog.LevelDebug {
+		t.Fatalf("level = %v, want debug", Level())
+	}
+	Debugf("to file")
+	mu.Lock()
+	if c, ok := output.(interface{ Close() error }); ok {
+		c.Close()
+	}
+	mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "previous\n") || !strings.Contains(string(data), "[DEBUG] to file") {
+		t.Fatalf("log file not appended to: %q", data)
+	}
+
+	t.Setenv("OPKG_LOG_LEVEL", "loud")
+	if err := ConfigureFromEnv(); err == nil {
+		t.Fatal("expected an error for an unknown level")
+	}
+}
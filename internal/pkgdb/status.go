@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -22,6 +24,55 @@ type Entry struct {
 	Raw          format.Paragraph
 }
 
+// EntryStatus is the parsed form of a Status field such as
+// "install ok installed": the desired action, the error flag and the current
+// package state.
+type EntryStatus struct {
+	Want   string
+	Flag   string
+	Status string
+}
+
+// ParseEntryStatus splits a Status field into its three space separated
+// parts. Missing parts are left empty and extra parts are ignored.
+func ParseEntryStatus(s string) EntryStatus {
+	parts := strings.Fields(s)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return EntryStatus{Want: parts[0], Flag: parts[1], Status: parts[2]}
+}
+
+// Valid reports whether every part of es is a state opkg and dpkg know.
+func (es EntryStatus) Valid() bool {
+	return slices.Contains(wantStates, es.Want) &&
+		slices.Contains(flagStates, es.Flag) &&
+		slices.Contains(packageStates, es.Status)
+}
+
+var (
+	wantStates    = []string{"unknown", "install", "hold", "deinstall", "purge"}
+	flagStates    = []string{"ok", "reinstreq", "hold", "hold-reinstreq"}
+	packageStates = []string{"not-installed", "unpacked", "half-configured", "installed",
+		"half-installed", "config-files", "post-inst-failed", "removal-failed",
+		"triggers-awaited", "triggers-pending"}
+)
+
+// IsFullyInstalled reports whether the entry is wanted and in the installed
+// state. Entries such as "deinstall ok config-files" or
+// "install ok half-installed" are not.
+func (e Entry) IsFullyInstalled() bool {
+	es := ParseEntryStatus(e.Status)
+	return es.Want == "install" && es.Status == "installed"
+}
+
+// IsHeld reports whether the entry is on hold, either through the hold
+// want state set by "opkg flag hold" or a hold error flag.
+func (e Entry) IsHeld() bool {
+	es := ParseEntryStatus(e.Status)
+	return es.Want == "hold" || es.Flag == "hold" || es.Flag == "hold-reinstreq"
+}
+
 // Status wraps the parsed status database. The structure is safe for
 // concurrent readers.
 type Status struct {
@@ -37,26 +88,60 @@ func Load(path string) (*Status, error) {
 	if err != nil {
 		return nil, fmt.Errorf("read status: %w", err)
 	}
+	status := &Status{path: path}
+	if err := status.parse(data); err != nil {
+		return nil, err
+	}
+	logging.Debugf("pkgdb: loaded %d entries", len(status.byName))
+	return status, nil
+}
+
+// LoadMultiple reads several status databases and merges them into one.
+// When a package appears in more than one file, the entry of the later file
+// wins. The merged database has no backing file, so Save fails; modify the
+// individual databases instead.
+func LoadMultiple(paths []string) (*Status, error) {
+	merged := Empty()
+	for _, path := range paths {
+		status, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		for name, entry := range status.byName {
+			merged.byName[name] = entry
+		}
+	}
+	logging.Debugf("pkgdb: merged %d status files into %d entries", len(paths), len(merged.byName))
+	return merged, nil
+}
+
+// parse replaces the in-memory entries with the ones found in data. Callers
+// must hold the write lock or own the Status exclusively.
+func (s *Status) parse(data []byte) error {
 	cf, err := format.ParseControl(bytes.NewReader(data))
 	if err != nil {
-		return nil, fmt.Errorf("parse status: %w", err)
+		return fmt.Errorf("parse status: %w", err)
 	}
-	status := &Status{path: path, byName: map[string]Entry{}}
+	byName := map[string]Entry{}
 	for _, paragraph := range cf.Paragraphs {
 		name := paragraph.Value("Package")
 		if name == "" {
 			continue
 		}
-		status.byName[name] = Entry{
-			Name:         name,
-			Version:      paragraph.Value("Version"),
-			Architecture: paragraph.Value("Architecture"),
-			Status:       paragraph.Value("Status"),
-			Raw:          paragraph,
-		}
+		byName[name] = entryFrom(name, paragraph)
+	}
+	s.byName = byName
+	return nil
+}
+
+func entryFrom(name string, paragraph format.Paragraph) Entry {
+	return Entry{
+		Name:         name,
+		Version:      paragraph.Value("Version"),
+		Architecture: paragraph.Value("Architecture"),
+		Status:       paragraph.Value("Status"),
+		Raw:          paragraph,
 	}
-	logging.Debugf("pkgdb: loaded %d entries", len(status.byName))
-	return status, nil
 }
 
 // Empty returns a Status instance without backing storage. Useful for systems
@@ -65,6 +150,11 @@ func Empty() *Status {
 	return &Status{byName: map[string]Entry{}}
 }
 
+// EmptyAt returns an empty Status backed by path. Save creates the file.
+func EmptyAt(path string) *Status {
+	return &Status{path: path, byName: map[string]Entry{}}
+}
+
 // Installed reports whether the given package is installed according to the
 // status database.
 func (s *Status) Installed(name string) bool {
@@ -76,7 +166,7 @@ func (s *Status) Installed(name string) bool {
 		return false
 	}
 	logging.Debugf("pkgdb: package %s has status %s", name, entry.Status)
-	return strings.Contains(entry.Status, "installed")
+	return entry.IsFullyInstalled()
 }
 
 // Entries returns a copy of all entries stored in the database.
@@ -113,3 +203,85 @@ func (s *Status) Lookup(name string) (Entry, error) {
 	logging.Debugf("pkgdb: lookup miss for %s", name)
 	return Entry{}, ErrNotFound
 }
+
+// Remove deletes a package from the in-memory database. Call Save to persist
+// the change.
+func (s *Status) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byName[name]; !ok {
+		return ErrNotFound
+	}
+	delete(s.byName, name)
+	logging.Debugf("pkgdb: removed %s", name)
+	return nil
+}
+
+// Set adds the package described by paragraph to the in-memory database,
+// replacing any entry of the same name. The paragraph must have a Package
+// field. Call Save to persist the change.
+func (s *Status) Set(paragraph format.Paragraph) error {
+	name := paragraph.Value("Package")
+	if name == "" {
+		return errors.New("status entry has no Package field")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byName[name] = entryFrom(name, paragraph)
+	logging.Debugf("pkgdb: set %s to %s", name, paragraph.Value("Status"))
+	return nil
+}
+
+// Bytes serialises the database in status file syntax, ordered by package
+// name.
+func (s *Status) Bytes() ([]byte, error) {
+	var cf format.ControlFile
+	for _, entry := range s.Entries() {
+		cf.Paragraphs = append(cf.Paragraphs, entry.Raw.Clone())
+	}
+	var buf bytes.Buffer
+	if _, err := cf.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Save writes the database back to its status file. The file is replaced
+// atomically so readers never observe a partially written database.
+func (s *Status) Save() error {
+	data, err := s.Bytes()
+	if err != nil {
+		return err
+	}
+	return s.writeFile(data)
+}
+
+// Restore replaces both the in-memory database and the status file with the
+// serialised database in data, typically obtained earlier from Bytes.
+func (s *Status) Restore(data []byte) error {
+	s.mu.Lock()
+	err := s.parse(data)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.writeFile(data)
+}
+
+func (s *Status) writeFile(data []byte) error {
+	if s.Path() == "" {
+		return errors.New("status database has no backing file")
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("prepare status directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write status: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("commit status: %w", err)
+	}
+	logging.Debugf("pkgdb: wrote status file %s", s.path)
+	return nil
+}
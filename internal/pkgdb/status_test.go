@@ -0,0 +1,111 @@
+package pkgdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseEntryStatus(t *testing.T) {
+	for _, tc := range []struct {
+		status    string
+		want      EntryStatus
+		installed bool
+		valid     bool
+	}{
+		{"install ok installed", EntryStatus{"install", "ok", "installed"}, true, true},
+		{"deinstall ok config-files", EntryStatus{"deinstall", "ok", "config-files"}, false, true},
+		{"install ok half-installed", EntryStatus{"install", "ok", "half-installed"}, false, true},
+		{"deinstall ok installed", EntryStatus{"deinstall", "ok", "installed"}, false, true},
+		{"  install  ok   installed ", EntryStatus{"install", "ok", "installed"}, true, true},
+		{"install ok broken", EntryStatus{"install", "ok", "broken"}, false, false},
+		{"installed", EntryStatus{Want: "installed"}, false, false},
+		{"", EntryStatus{}, false, false},
+	} {
+		if got := ParseEntryStatus(tc.status); got != tc.want {
+			t.Errorf("ParseEntryStatus(%q)=%+v want %+v", tc.status, got, tc.want)
+		}
+		if got := (Entry{Status: tc.status}).IsFullyInstalled(); got != tc.installed {
+			t.Errorf("IsFullyInstalled() for %q = %v want %v", tc.status, got, tc.installed)
+		}
+		if got := tc.want.Valid(); got != tc.valid {
+			t.Errorf("Valid() for %q = %v want %v", tc.status, got, tc.valid)
+		}
+	}
+}
+
+func TestEntryIsHeld(t *testing.T) {
+	for status, want := range map[string]bool{
+		"hold ok installed":               true,
+		"install hold installed":          true,
+		"install hold-reinstreq unpacked": true,
+		"install ok installed":            false,
+		"install reinstreq unpacked":      false,
+	} {
+		if got := (Entry{Status: status}).IsHeld(); got != want {
+			t.Errorf("IsHeld() for %q = %v want %v", status, got, want)
+		}
+	}
+}
+
+func TestInstalledIgnoresConfigFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status")
+	data := "Package: busybox\nVersion: 1.36.1\nStatus: install ok installed\n\n" +
+		"Package: dropbear\nVersion: 2022.83\nStatus: deinstall ok config-files\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write status: %v", err)
+	}
+	status, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if !status.Installed("busybox") {
+		t.Fatalf("expected busybox to be installed")
+	}
+	if status.Installed("dropbear") {
+		t.Fatalf("did not expect a package with only config files to be installed")
+	}
+}
+
+func TestLoadMultipleLaterFilesWin(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root-status")
+	usb := filepath.Join(dir, "usb-status")
+	if err := os.WriteFile(root, []byte("Package: busybox\nVersion: 1.35.0\nStatus: install ok installed\n\n"+
+		"Package: zlib\nVersion: 1.3\nStatus: install ok installed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(usb, []byte("Package: busybox\nVersion: 1.36.1\nStatus: install ok installed\n\n"+
+		"Package: curl\nVersion: 8.0\nStatus: install ok installed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := LoadMultiple([]string{root, usb})
+	if err != nil {
+		t.Fatalf("LoadMultiple returned error: %v", err)
+	}
+	want := map[string]string{"busybox": "1.36.1", "curl": "8.0", "zlib": "1.3"}
+	entries := status.Entries()
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for _, entry := range entries {
+		if want[entry.Name] != entry.Version {
+			t.Errorf("%s has version %s, want %s", entry.Name, entry.Version, want[entry.Name])
+		}
+	}
+
+	reversed, err := LoadMultiple([]string{usb, root})
+	if err != nil {
+		t.Fatalf("LoadMultiple returned error: %v", err)
+	}
+	if entry, _ := reversed.Lookup("busybox"); entry.Version != "1.35.0" {
+		t.Fatalf("busybox from the last file should win, got %s", entry.Version)
+	}
+	if err := reversed.Save(); err == nil {
+		t.Fatal("a merged database must not be saved")
+	}
+	if _, err := LoadMultiple([]string{root, filepath.Join(dir, "missing")}); err == nil {
+		t.Fatal("expected an error for a missing status file")
+	}
+}
@@ -0,0 +1,79 @@
+package pkgdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/oe-mirrors/opkg_go/internal/logging"
+)
+
+// pollInterval is how often Watch checks the status file when no native
+// notification mechanism is available.
+const pollInterval = time.Second
+
+// Watch reports changes to the status file on disk. A value is sent on the
+// returned channel after the file is written, replaced or removed; changes
+// that happen before the previous notification was received are coalesced.
+// The channel is closed once ctx is done. Watch uses inotify on Linux and
+// falls back to polling the file's modification time elsewhere.
+func (s *Status) Watch(ctx context.Context) (<-chan struct{}, error) {
+	path := s.Path()
+	if path == "" {
+		return nil, errors.New("status database has no backing file")
+	}
+	ch := make(chan struct{}, 1)
+	err := watchNative(ctx, path, ch)
+	if err == nil {
+		return ch, nil
+	}
+	logging.Debugf("pkgdb: native watch of %s unavailable, polling: %v", path, err)
+	go pollFile(ctx, path, pollInterval, ch)
+	return ch, nil
+}
+
+// notify sends on ch without blocking when a notification is already
+// pending.
+func notify(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// fileState identifies a version of a file for polling purposes.
+type fileState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{exists: true, modTime: info.ModTime(), size: info.Size()}
+}
+
+// pollFile notifies ch whenever the state of path differs from the previous
+// check. It closes ch when ctx is done.
+func pollFile(ctx context.Context, path string, interval time.Duration, ch chan<- struct{}) {
+	defer close(ch)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := statFile(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if current := statFile(path); current != last {
+			logging.Debugf("pkgdb: status file %s changed", path)
+			last = current
+			notify(ch)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+//go:build linux
+
+package pkgdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"github.com/oe-mirrors/opkg_go/internal/logging"
+)
+
+// watchNative watches the directory containing path with inotify. The
+// directory is watched rather than the file itself because Save replaces the
+// file by renaming a temporary file over it.
+func watchNative(ctx context.Context, path string, ch chan<- struct{}) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("inotify init: %w", err)
+	}
+	dir, base := filepath.Split(path)
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_CREATE | syscall.IN_DELETE
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Clean(dir), mask); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("inotify watch %s: %w", dir, err)
+	}
+	// The descriptor is non-blocking, so reads go through the runtime poller
+	// and closing the file interrupts a pending read.
+	file := os.NewFile(uintptr(fd), "inotify")
+
+	go func() {
+		<-ctx.Done()
+		file.Close()
+	}()
+	go func() {
+		defer close(ch)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					logging.Debugf("pkgdb: inotify read failed: %v", err)
+				}
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				start := off + syscall.SizeofInotifyEvent
+				end := start + int(event.Len)
+				name := string(bytes.TrimRight(buf[start:end], "\x00"))
+				if name == base {
+					logging.Debugf("pkgdb: status file %s changed", path)
+					notify(ch)
+				}
+				off = end
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package pkgdb
+
+import (
+	"context"
+	"errors"
+)
+
+// watchNative is unavailable on this platform; Watch falls back to polling.
+func watchNative(context.Context, string, chan<- struct{}) error {
+	return errors.New("no native file notifications on this platform")
+}
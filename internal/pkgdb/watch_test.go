@@ -0,0 +1,70 @@
+package pkgdb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchReportsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status")
+	if err := os.WriteFile(path, []byte("Package: a\nVersion: 1.0\n"), 0o644); err != nil {
+		t.Fatalf("write status: %v", err)
+	}
+	status, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := status.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch returned error: %v", err)
+	}
+
+	if err := status.Remove("a"); err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	if err := status.Save(); err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no change notification within 2s")
+	}
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestPollFileReportsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status")
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan struct{}, 1)
+	go pollFile(ctx, path, 10*time.Millisecond, ch)
+
+	// The poller may take its first snapshot after any given write, so keep
+	// growing the file until a change is noticed.
+	deadline := time.After(2 * time.Second)
+	content := []byte("Package: a\n")
+	for notified := false; !notified; {
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatalf("write status: %v", err)
+		}
+		content = append(content, '\n')
+		select {
+		case <-ch:
+			notified = true
+		case <-time.After(20 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("no change notification within 2s")
+		}
+	}
+	cancel()
+	for range ch {
+	}
+}
@@ -0,0 +1,106 @@
+package pkgmgr
+
+import (
+	"sort"
+
+	"github.com/oe-mirrors/opkg_go/internal/format"
+	"github.com/oe-mirrors/opkg_go/internal/version"
+)
+
+// ConflictSet describes a conflict between a package that is about to be
+// installed and a package that is already installed. ConflictType is either
+// "Conflicts" or "Breaks" depending on the field declaring the relationship.
+type ConflictSet struct {
+	Package         string
+	ConflictingWith string
+	ConflictType    string
+}
+
+// ListConflicting reports all conflicts that installing the named packages
+// would introduce. Both directions are checked: the Conflicts and Breaks
+// fields of the new packages against installed packages, and the fields of
+// installed packages against the new packages. Version constraints such as
+// "libssl (< 1.1)" are honoured.
+func (m *Manager) ListConflicting(names []string) ([]ConflictSet, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	status := m.Status()
+	var installed []installedPackage
+	for _, entry := range status.Entries() {
+		if status.Installed(entry.Name) {
+			installed = append(installed, installedPackage{name: entry.Name, version: entry.Version, raw: entry.Raw})
+		}
+	}
+
+	seen := map[ConflictSet]bool{}
+	var conflicts []ConflictSet
+	add := func(c ConflictSet) {
+		if !seen[c] {
+			seen[c] = true
+			conflicts = append(conflicts, c)
+		}
+	}
+	for _, name := range names {
+		pkg, ok := m.findPackage(name)
+		if !ok {
+			return nil, &PackageNotFoundError{Name: name}
+		}
+		for _, other := range installed {
+			if other.name == pkg.Name {
+				// Upgrading or reinstalling the same package never conflicts.
+				continue
+			}
+			for _, field := range []string{"Conflicts", "Breaks"} {
+				if relationsHit(pkg.Raw.Value(field), other.name, other.version, other.raw) {
+					add(ConflictSet{Package: pkg.Name, ConflictingWith: other.name, ConflictType: field})
+				}
+				if relationsHit(other.raw.Value(field), pkg.Name, pkg.Version, pkg.Raw) {
+					add(ConflictSet{Package: pkg.Name, ConflictingWith: other.name, ConflictType: field})
+				}
+			}
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Package != conflicts[j].Package {
+			return conflicts[i].Package < conflicts[j].Package
+		}
+		if conflicts[i].ConflictingWith != conflicts[j].ConflictingWith {
+			return conflicts[i].ConflictingWith < conflicts[j].ConflictingWith
+		}
+		return conflicts[i].ConflictType < conflicts[j].ConflictType
+	})
+	return conflicts, nil
+}
+
+type installedPackage struct {
+	name    string
+	version string
+	raw     format.Paragraph
+}
+
+// relationsHit reports whether any relation in field refers to the package
+// described by name, ver and raw. Unversioned relations also match virtual
+// names listed in the package's Provides field.
+func relationsHit(field, name, ver string, raw format.Paragraph) bool {
+	if field == "" {
+		return false
+	}
+	provides := tokensFromRelations(raw.Value("Provides"))
+	for _, group := range version.ParseRelations(field) {
+		for _, rel := range group {
+			if rel.SatisfiedBy(name, ver) {
+				return true
+			}
+			if len(rel.Constraints) > 0 {
+				continue
+			}
+			for _, p := range provides {
+				if p == rel.Name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,364 @@
+package pkgmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/oe-mirrors/opkg_go/internal/format"
+	"github.com/oe-mirrors/opkg_go/internal/logging"
+	"github.com/oe-mirrors/opkg_go/internal/version"
+)
+
+// CommonDependencies returns the packages that every named package depends
+// on, directly or transitively, through Depends and Pre-Depends.
+func (m *Manager) CommonDependencies(names []string) ([]string, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, errors.New("at least one package name is required")
+	}
+	var common map[string]bool
+	for _, name := range names {
+		deps, err := m.dependencyClosure(name)
+		if err != nil {
+			return nil, err
+		}
+		if common == nil {
+			common = deps
+			continue
+		}
+		for dep := range common {
+			if !deps[dep] {
+				delete(common, dep)
+			}
+		}
+	}
+	return sortedKeys(common), nil
+}
+
+// UniqueDependencies returns the transitive dependencies of name that no
+// other installed package needs. These are the packages that become
+// removable together with name. Installed packages that are themselves only
+// pulled in by name do not keep their dependencies alive.
+func (m *Manager) UniqueDependencies(name string) ([]string, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	deps, err := m.dependencyClosure(name)
+	if err != nil {
+		return nil, err
+	}
+	status := m.Status()
+	for _, entry := range status.Entries() {
+		if entry.Name == name || deps[entry.Name] || !status.Installed(entry.Name) {
+			continue
+		}
+		needed, err := m.dependencyClosure(entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		for dep := range needed {
+			delete(deps, dep)
+		}
+	}
+	return sortedKeys(deps), nil
+}
+
+// maxDependencyPathDepth bounds the length of the paths AllDependencyPaths
+// explores.
+const maxDependencyPathDepth = 16
+
+// ShortestDependencyPath returns the shortest chain of Depends and
+// Pre-Depends relationships leading from one package to another, including
+// both ends. It returns nil when to is not reachable from from.
+func (m *Manager) ShortestDependencyPath(from, to string) ([]string, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	if _, ok := m.lookupParagraph(from); !ok {
+		return nil, &PackageNotFoundError{Name: from}
+	}
+	parent := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == to {
+			var path []string
+			for name := to; name != ""; name = parent[name] {
+				path = append([]string{name}, path...)
+			}
+			return path, nil
+		}
+		for _, dep := range m.dependencyEdges(current) {
+			if _, seen := parent[dep]; !seen {
+				parent[dep] = current
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// AllDependencyPaths returns every cycle free dependency chain from one
+// package to another. Chains longer than maxDependencyPathDepth packages are
+// not explored.
+func (m *Manager) AllDependencyPaths(from, to string) ([][]string, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	if _, ok := m.lookupParagraph(from); !ok {
+		return nil, &PackageNotFoundError{Name: from}
+	}
+	var paths [][]string
+	onPath := map[string]bool{}
+	var walk func(path []string)
+	walk = func(path []string) {
+		current := path[len(path)-1]
+		if current == to {
+			paths = append(paths, append([]string(nil), path...))
+			return
+		}
+		if len(path) >= maxDependencyPathDepth {
+			return
+		}
+		onPath[current] = true
+		for _, dep := range m.dependencyEdges(current) {
+			if !onPath[dep] {
+				walk(append(path, dep))
+			}
+		}
+		onPath[current] = false
+	}
+	walk([]string{from})
+	sort.SliceStable(paths, func(i, j int) bool { return len(paths[i]) < len(paths[j]) })
+	return paths, nil
+}
+
+// TransitiveOptions selects the optional relationships TransitiveDependencies
+// follows in addition to Depends and Pre-Depends.
+type TransitiveOptions struct {
+	IncludeRecommends bool
+	IncludeSuggests   bool
+}
+
+// TransitiveDependencies returns the sorted names of every package name
+// requires, directly or transitively, excluding name itself. For each group
+// of alternatives the first known package is chosen, as for dependency
+// paths. It fails with a PackageNotFoundError when name is unknown.
+func (m *Manager) TransitiveDependencies(ctx context.Context, name string, opts TransitiveOptions) ([]string, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	fields := []string{"Pre-Depends", "Depends"}
+	if opts.IncludeRecommends {
+		fields = append(fields, "Recommends")
+	}
+	if opts.IncludeSuggests {
+		fields = append(fields, "Suggests")
+	}
+	deps, err := m.closure(ctx, name, fields)
+	if err != nil {
+		return nil, err
+	}
+	return sortedKeys(deps), nil
+}
+
+// dependencyClosure returns the transitive dependencies of name, excluding
+// name itself.
+func (m *Manager) dependencyClosure(name string) (map[string]bool, error) {
+	return m.closure(context.Background(), name, []string{"Pre-Depends", "Depends"})
+}
+
+// closure returns the packages reachable from name through fields,
+// excluding name itself.
+func (m *Manager) closure(ctx context.Context, name string, fields []string) (map[string]bool, error) {
+	if _, ok := m.lookupParagraph(name); !ok {
+		return nil, &PackageNotFoundError{Name: name}
+	}
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		current := queue[0]
+		queue = queue[1:]
+		p, ok := m.lookupParagraph(current)
+		if !ok {
+			continue
+		}
+		for _, field := range fields {
+			for _, dep := range m.relationTargets(p, field) {
+				if !seen[dep] {
+					seen[dep] = true
+					queue = append(queue, dep)
+				}
+			}
+		}
+	}
+	delete(seen, name)
+	return seen, nil
+}
+
+// DependencyOrderForRemoval returns names ordered so that every package is
+// removed before the packages it depends on: packages no other member of the
+// set depends on come first. It fails when a package is not installed or
+// when removing the set would leave an installed package outside it with an
+// unsatisfied Depends or Pre-Depends. Members of a dependency cycle are
+// appended in name order.
+func (m *Manager) DependencyOrderForRemoval(names []string) ([]string, error) {
+	status := m.Status()
+	removing := map[string]bool{}
+	for _, name := range names {
+		if !status.Installed(name) {
+			return nil, fmt.Errorf("package %s is not installed", name)
+		}
+		removing[name] = true
+	}
+
+	// providers maps every installed package and virtual name to the
+	// installed packages satisfying it.
+	providers := map[string][]string{}
+	for _, entry := range status.Entries() {
+		if !entry.IsFullyInstalled() {
+			continue
+		}
+		providers[entry.Name] = append(providers[entry.Name], entry.Name)
+		for _, group := range version.ParseRelations(entry.Raw.Value("Provides")) {
+			for _, rel := range group {
+				providers[rel.Name] = append(providers[rel.Name], entry.Name)
+			}
+		}
+	}
+
+	var broken []string
+	dependents := map[string]int{}
+	dependsOn := map[string][]string{}
+	for _, entry := range status.Entries() {
+		if !entry.IsFullyInstalled() {
+			continue
+		}
+		for _, field := range []string{"Pre-Depends", "Depends"} {
+			for _, group := range version.ParseRelations(entry.Raw.Value(field)) {
+				var satisfiers []string
+				for _, rel := range group {
+					satisfiers = append(satisfiers, providers[rel.Name]...)
+				}
+				if len(satisfiers) == 0 {
+					continue
+				}
+				kept := false
+				for _, pkg := range satisfiers {
+					if !removing[pkg] {
+						kept = true
+					} else if removing[entry.Name] && pkg != entry.Name {
+						dependsOn[entry.Name] = append(dependsOn[entry.Name], pkg)
+						dependents[pkg]++
+					}
+				}
+				if !kept && !removing[entry.Name] {
+					broken = append(broken, fmt.Sprintf("%s (%s: %s)", entry.Name, field, relationGroupString(group)))
+				}
+			}
+		}
+	}
+	if len(broken) > 0 {
+		sort.Strings(broken)
+		return nil, fmt.Errorf("removal would break installed packages: %s", strings.Join(broken, ", "))
+	}
+
+	var order []string
+	ready := []string{}
+	for name := range removing {
+		if dependents[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		delete(removing, name)
+		for _, dep := range dependsOn[name] {
+			dependents[dep]--
+			if dependents[dep] == 0 && removing[dep] {
+				ready = append(ready, dep)
+			}
+		}
+	}
+	if len(removing) > 0 {
+		cycle := sortedKeys(removing)
+		logging.Debugf("pkgmgr: dependency cycle among %v; removing in name order", cycle)
+		order = append(order, cycle...)
+	}
+	return order, nil
+}
+
+// relationGroupString formats a group of alternatives back into control
+// file syntax.
+func relationGroupString(group []version.Relation) string {
+	alts := make([]string, 0, len(group))
+	for _, rel := range group {
+		s := rel.Name
+		for _, c := range rel.Constraints {
+			s += " (" + c.Op + " " + c.Version + ")"
+		}
+		alts = append(alts, s)
+	}
+	return strings.Join(alts, " | ")
+}
+
+// dependencyEdges returns the packages name depends on through Depends and
+// Pre-Depends. For each group of alternatives the first one that refers to a
+// known package is chosen, falling back to the first alternative.
+func (m *Manager) dependencyEdges(name string) []string {
+	p, ok := m.lookupParagraph(name)
+	if !ok {
+		return nil
+	}
+	return append(m.relationTargets(p, "Pre-Depends"), m.relationTargets(p, "Depends")...)
+}
+
+// relationTargets returns the package chosen from each group of
+// alternatives of field in p, as described for dependencyEdges.
+func (m *Manager) relationTargets(p format.Paragraph, field string) []string {
+	var targets []string
+	for _, group := range version.ParseRelations(p.Value(field)) {
+		chosen := group[0].Name
+		for _, alt := range group {
+			if _, ok := m.lookupParagraph(alt.Name); ok {
+				chosen = alt.Name
+				break
+			}
+		}
+		targets = append(targets, chosen)
+	}
+	return targets
+}
+
+// lookupParagraph returns the metadata of name from the indexes, falling
+// back to the status database for packages no feed carries.
+func (m *Manager) lookupParagraph(name string) (format.Paragraph, bool) {
+	if pkg, ok := m.findPackage(name); ok {
+		return pkg.Raw, true
+	}
+	if entry, err := m.Status().Lookup(name); err == nil {
+		return entry.Raw, true
+	}
+	return format.Paragraph{}, false
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,93 @@
+package pkgmgr
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/oe-mirrors/opkg_go/internal/downloader"
+)
+
+// PackageNotFoundError is returned when a package is neither available in
+// the loaded indexes nor recorded in the status database.
+type PackageNotFoundError struct {
+	Name string
+}
+
+func (e *PackageNotFoundError) Error() string {
+	return fmt.Sprintf("package %s not found", e.Name)
+}
+
+// NetworkError wraps failures to reach a feed or download an archive.
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string { return e.Err.Error() }
+
+func (e *NetworkError) Unwrap() error { return e.Err }
+
+// ChecksumError is returned when a downloaded archive does not match the
+// checksum declared in its feed index.
+type ChecksumError struct {
+	Package string
+	Err     error
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("package %s: %v", e.Package, e.Err)
+}
+
+func (e *ChecksumError) Unwrap() error { return e.Err }
+
+// VirtualPackageError is returned when installing a package that has no
+// archive of its own. Providers lists the packages that provide it.
+type VirtualPackageError struct {
+	Name      string
+	Providers []string
+}
+
+func (e *VirtualPackageError) Error() string {
+	msg := fmt.Sprintf("package %s is virtual; try: opkg whatprovides %s", e.Name, e.Name)
+	if len(e.Providers) > 0 {
+		msg += fmt.Sprintf(" (provided by: %s)", strings.Join(e.Providers, ", "))
+	}
+	return msg
+}
+
+// ConflictError reports conflicts that prevent an installation.
+type ConflictError struct {
+	Conflicts []ConflictSet
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%d conflict(s) detected, nothing installed", len(e.Conflicts))
+}
+
+// classifyDownloadError wraps err in the typed error matching its cause.
+// Errors of other kinds are returned unchanged.
+func classifyDownloadError(pkg string, err error) error {
+	var statusErr *downloader.StatusError
+	var urlErr *url.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, downloader.ErrChecksumMismatch):
+		return &ChecksumError{Package: pkg, Err: err}
+	case errors.As(err, &statusErr), errors.As(err, &urlErr):
+		return &NetworkError{Err: err}
+	}
+	return err
+}
+
+// PreDependsError is returned when a Pre-Depends clause of Package is met
+// neither by an installed package nor by one the feeds can install.
+type PreDependsError struct {
+	Package     string
+	Requirement string
+}
+
+func (e *PreDependsError) Error() string {
+	return fmt.Sprintf("package %s pre-depends on %s, which is not installed and cannot be installed", e.Package, e.Requirement)
+}
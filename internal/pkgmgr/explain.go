@@ -0,0 +1,76 @@
+package pkgmgr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Explain describes name for humans: what it depends on, which installed
+// packages need it directly and transitively, what it provides and
+// conflicts with, and its size.
+func (m *Manager) Explain(name string) (string, error) {
+	deps, err := m.Dependencies(name)
+	if err != nil {
+		return "", err
+	}
+	p, _ := m.lookupParagraph(name)
+	direct, err := m.ReverseDependencies(ReverseDependencyQuery{Field: "Depends", Patterns: []string{name}})
+	if err != nil {
+		return "", err
+	}
+	transitive, err := m.ReverseDependencies(ReverseDependencyQuery{Field: "Depends", Recursive: true, Patterns: []string{name}})
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", name, p.Value("Version"))
+	if m.Status().Installed(name) {
+		b.WriteString("  Installed: yes\n")
+	} else {
+		b.WriteString("  Installed: no\n")
+	}
+	fmt.Fprintf(&b, "  Depends on: %s\n", listOrNone(append(deps["Pre-Depends"], deps["Depends"]...)))
+	fmt.Fprintf(&b, "  Required by %d installed %s: %s\n", len(direct), plural(len(direct), "package", "packages"), listOrNone(direct))
+	if indirect := len(transitive) - len(direct); indirect > 0 {
+		fmt.Fprintf(&b, "    and indirectly by %d more: %s\n", indirect, strings.Join(without(transitive, direct), ", "))
+	}
+	fmt.Fprintf(&b, "  Provides: %s\n", listOrNone(deps["Provides"]))
+	fmt.Fprintf(&b, "  Conflicts with: %s\n", listOrNone(append(deps["Conflicts"], deps["Breaks"]...)))
+	if size, err := strconv.ParseInt(p.Value("Size"), 10, 64); err == nil {
+		fmt.Fprintf(&b, "  Estimated size: %d kB\n", (size+1023)/1024)
+	} else {
+		b.WriteString("  Estimated size: unknown\n")
+	}
+	return b.String(), nil
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// without returns the items of all that are not in exclude, keeping order.
+func without(all, exclude []string) []string {
+	skip := map[string]bool{}
+	for _, item := range exclude {
+		skip[item] = true
+	}
+	var out []string
+	for _, item := range all {
+		if !skip[item] {
+			out = append(out, item)
+		}
+	}
+	return out
+}
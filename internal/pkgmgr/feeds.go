@@ -0,0 +1,83 @@
+package pkgmgr
+
+import (
+	"context"
+	"sync"
+
+	"github.com/oe-mirrors/opkg_go/internal/config"
+	"github.com/oe-mirrors/opkg_go/internal/logging"
+	"github.com/oe-mirrors/opkg_go/internal/repo"
+)
+
+// FeedCheckResult reports the health of a single feed as determined by
+// CheckFeeds.
+type FeedCheckResult struct {
+	Feed         config.Feed
+	Reachable    bool
+	FetchError   error
+	ParseError   error
+	PackageCount int
+	// Partial reports that the index is larger than CheckFeeds reads, so
+	// PackageCount only counts the packages at its start.
+	Partial bool
+}
+
+// feedCheckBytes bounds the uncompressed part of each index CheckFeeds
+// downloads and parses.
+const feedCheckBytes = 1 << 20
+
+// OK reports whether the feed was reachable and its index parsed cleanly.
+func (r FeedCheckResult) OK() bool {
+	return r.Reachable && r.ParseError == nil
+}
+
+// CheckFeeds probes every enabled feed with a HEAD request, then downloads
+// and parses the start of the index of the feeds that answered, without
+// touching the cache or the loaded indexes. At most the manager's download
+// workers check feeds at once. Results are returned in configuration order.
+func (m *Manager) CheckFeeds(ctx context.Context) []FeedCheckResult {
+	var feeds []config.Feed
+	for _, feed := range m.Feeds() {
+		if !feed.Disabled {
+			feeds = append(feeds, feed)
+		}
+	}
+	results := make([]FeedCheckResult, len(feeds))
+	slots := make(chan struct{}, m.downloadWorkers(0))
+	var wg sync.WaitGroup
+	for i, feed := range feeds {
+		wg.Add(1)
+		go func(i int, feed config.Feed) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			res := FeedCheckResult{Feed: feed}
+			client := m.downloader()
+			url, err := repo.Probe(ctx, feed, client)
+			var (
+				data     []byte
+				complete bool
+			)
+			if err == nil {
+				data, complete, err = repo.FetchPrefix(ctx, feed, client, url, feedCheckBytes)
+			}
+			if err != nil {
+				logging.Debugf("pkgmgr: feed %s unreachable: %v", feed.Name, err)
+				res.FetchError = err
+				results[i] = res
+				return
+			}
+			res.Reachable = true
+			res.Partial = !complete
+			idx, err := repo.ParseIndex(feed, data)
+			if err != nil {
+				res.ParseError = err
+			} else {
+				res.PackageCount = len(idx.Packages)
+			}
+			results[i] = res
+		}(i, feed)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,165 @@
+package pkgmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// GraphNode is a package of a DependencyGraph. Version is empty for
+// packages that neither a feed nor the status database knows.
+type GraphNode struct {
+	ID        string `json:"id"`
+	Version   string `json:"version"`
+	Installed bool   `json:"installed"`
+}
+
+// GraphEdge is a relationship from Source to Target. Type is the control
+// field declaring it, such as "Depends", "Recommends" or "Conflicts".
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Type   string `json:"type"`
+}
+
+// DependencyGraph holds the packages reachable from a set of roots and the
+// relationships between them. Nodes are sorted by ID and edges by source,
+// target and type.
+type DependencyGraph struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// graphFields lists the relationships a DependencyGraph records. Only the
+// fields marked as followed pull their targets' own relationships into the
+// graph.
+var graphFields = []struct {
+	name   string
+	follow bool
+}{
+	{"Pre-Depends", true},
+	{"Depends", true},
+	{"Recommends", false},
+	{"Conflicts", false},
+}
+
+// DependencyGraph returns the graph of names and their transitive
+// dependencies through Depends and Pre-Depends. The Recommends and Conflicts
+// of every package in the graph are recorded as edges too, but their
+// targets' relationships are not explored.
+func (m *Manager) DependencyGraph(names []string) (*DependencyGraph, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, errors.New("at least one package name is required")
+	}
+	status := m.Status()
+	nodes := map[string]GraphNode{}
+	addNode := func(name string) {
+		if _, ok := nodes[name]; ok {
+			return
+		}
+		p, _ := m.lookupParagraph(name)
+		nodes[name] = GraphNode{ID: name, Version: p.Value("Version"), Installed: status.Installed(name)}
+	}
+	var queue []string
+	for _, name := range names {
+		if _, ok := m.lookupParagraph(name); !ok {
+			return nil, &PackageNotFoundError{Name: name}
+		}
+		queue = append(queue, name)
+	}
+	expanded := map[string]bool{}
+	edges := map[GraphEdge]bool{}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		addNode(name)
+		if expanded[name] {
+			continue
+		}
+		expanded[name] = true
+		p, ok := m.lookupParagraph(name)
+		if !ok {
+			continue
+		}
+		for _, field := range graphFields {
+			for _, target := range m.relationTargets(p, field.name) {
+				addNode(target)
+				edges[GraphEdge{Source: name, Target: target, Type: field.name}] = true
+				if field.follow {
+					queue = append(queue, target)
+				}
+			}
+		}
+	}
+
+	g := &DependencyGraph{}
+	for _, node := range nodes {
+		g.Nodes = append(g.Nodes, node)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	for edge := range edges {
+		g.Edges = append(g.Edges, edge)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		return a.Type < b.Type
+	})
+	return g, nil
+}
+
+// MarshalJSON encodes the graph as an object with "nodes" and "edges"
+// arrays, the layout web visualisers such as D3.js consume. Empty graphs
+// encode empty arrays rather than null.
+func (g *DependencyGraph) MarshalJSON() ([]byte, error) {
+	doc := struct {
+		Nodes []GraphNode `json:"nodes"`
+		Edges []GraphEdge `json:"edges"`
+	}{Nodes: g.Nodes, Edges: g.Edges}
+	if doc.Nodes == nil {
+		doc.Nodes = []GraphNode{}
+	}
+	if doc.Edges == nil {
+		doc.Edges = []GraphEdge{}
+	}
+	return json.Marshal(doc)
+}
+
+// WriteDOT writes the graph in Graphviz DOT syntax. Installed packages are
+// drawn with a bold outline; edges other than Depends are labelled with
+// their type.
+func (g *DependencyGraph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph dependencies {\n")
+	for _, node := range g.Nodes {
+		attrs := fmt.Sprintf(`label="%s"`, node.ID)
+		if node.Version != "" {
+			attrs = fmt.Sprintf(`label="%s\n%s"`, node.ID, node.Version)
+		}
+		if node.Installed {
+			attrs += ", style=bold"
+		}
+		fmt.Fprintf(&b, "  %q [%s];\n", node.ID, attrs)
+	}
+	for _, edge := range g.Edges {
+		if edge.Type == "Depends" {
+			fmt.Fprintf(&b, "  %q -> %q;\n", edge.Source, edge.Target)
+			continue
+		}
+		fmt.Fprintf(&b, "  %q -> %q [label=%q];\n", edge.Source, edge.Target, edge.Type)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,366 @@
+package pkgmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/oe-mirrors/opkg_go/internal/downloader"
+	"github.com/oe-mirrors/opkg_go/internal/logging"
+	"github.com/oe-mirrors/opkg_go/internal/repo"
+	"github.com/oe-mirrors/opkg_go/internal/version"
+)
+
+// InstallResult reports the outcome of installing one package with
+// InstallMultiple. Dest is the archive path when the download succeeded; the
+// archive is removed again when another package of a batch without
+// AllowPartial fails. A recommended package that cannot be installed reports
+// Warning instead of Err and does not fail the batch.
+type InstallResult struct {
+	Name    string
+	Dest    string
+	Err     error
+	Warning error
+}
+
+// InstallOptions controls the behaviour of InstallMultipleWith.
+type InstallOptions struct {
+	// Workers is the number of concurrent downloads. Values below one use
+	// the manager's WithWorkers setting, or four when there is none.
+	Workers int
+	// AllowPartial keeps the archives of the packages that were downloaded
+	// when others fail. Without it a failed batch deletes the archives it
+	// downloaded, so nothing is left half installed.
+	AllowPartial bool
+}
+
+// downloadWorkers returns requested when positive and otherwise the manager's
+// worker setting, defaulting to four.
+func (m *Manager) downloadWorkers(requested int) int {
+	switch {
+	case requested > 0:
+		return requested
+	case m.workers > 0:
+		return m.workers
+	}
+	return 4
+}
+
+// InstallMultiple is InstallMultipleWith with default options.
+func (m *Manager) InstallMultiple(ctx context.Context, names []string) ([]InstallResult, error) {
+	return m.InstallMultipleWith(ctx, names, InstallOptions{})
+}
+
+// InstallMultipleWith plans every named package first, as PlanInstall does,
+// and then downloads the archives concurrently. Once the downloads are done
+// the packages are recorded in the status database in plan order: all of
+// them when every download succeeded, or with AllowPartial the successful
+// ones whose pre-dependencies succeeded too. The results list the named
+// packages in order, with repeated names reported once, followed by the
+// pre-dependencies and recommended packages the plans add. They report each
+// package individually; the returned error joins the failures.
+func (m *Manager) InstallMultipleWith(ctx context.Context, names []string, opts InstallOptions) ([]InstallResult, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	status := m.Status()
+	var prev []byte
+	if status.Path() != "" {
+		var err error
+		if prev, err = status.Bytes(); err != nil {
+			return nil, err
+		}
+	}
+	workers := m.downloadWorkers(opts.Workers)
+
+	var (
+		results []InstallResult
+		pkgs    []repo.Package
+		// requires maps a result to the results it cannot be recorded
+		// without: the pre-dependencies and, for recommended packages, the
+		// package recommending them.
+		requires = map[int][]int{}
+		// soft marks the results that are only recommended.
+		soft = map[int]bool{}
+		// order is the order in which the results are recorded.
+		order []int
+	)
+	index := map[string]int{}
+	add := func(name string) int {
+		if i, ok := index[name]; ok {
+			return i
+		}
+		index[name] = len(results)
+		results = append(results, InstallResult{Name: name})
+		pkgs = append(pkgs, repo.Package{})
+		return len(results) - 1
+	}
+	for _, name := range names {
+		add(name)
+	}
+	var plans []InstallPlan
+	for _, name := range names {
+		i := index[name]
+		if results[i].Err != nil || pkgs[i].Name != "" {
+			continue
+		}
+		plan, err := m.PlanInstall(name)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		pkgs[i] = plan.Package
+		plans = append(plans, plan)
+	}
+	for _, plan := range plans {
+		var pre []int
+		for _, name := range plan.PreDepends {
+			j := add(name)
+			if soft[j] {
+				delete(soft, j)
+				delete(requires, j)
+			}
+			pre = append(pre, j)
+			order = append(order, j)
+		}
+		main := index[plan.Package.Name]
+		requires[main] = pre
+		order = append(order, main)
+		for _, name := range plan.Recommends {
+			if _, known := index[name]; known {
+				continue
+			}
+			j := add(name)
+			soft[j] = true
+			requires[j] = append(append([]int(nil), pre...), main)
+			order = append(order, j)
+		}
+	}
+
+	var (
+		items   []downloader.DownloadItem
+		pending []int
+	)
+	for i := range results {
+		if results[i].Err != nil {
+			continue
+		}
+		if pkgs[i].Name == "" {
+			pkg, ok := m.findPackage(results[i].Name)
+			if !ok {
+				results[i].Err = &PackageNotFoundError{Name: results[i].Name}
+				continue
+			}
+			pkgs[i] = pkg
+		}
+		dest, cached, err := m.resolvePackage(ctx, pkgs[i])
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Dest = dest
+		if cached {
+			continue
+		}
+		items = append(items, downloader.DownloadItem{URL: pkgs[i].FullURL(), Path: dest, Checksum: packageChecksum(pkgs[i])})
+		pending = append(pending, i)
+	}
+
+	logging.Debugf("pkgmgr: downloading %d of %d packages with %d workers", len(items), len(results), workers)
+	downloaded := map[int]bool{}
+	for j, res := range m.downloader().DownloadMany(ctx, items, workers) {
+		i := pending[j]
+		if res.Err != nil {
+			results[i].Dest = ""
+			results[i].Err = classifyDownloadError(results[i].Name, res.Err)
+			continue
+		}
+		downloaded[i] = true
+	}
+
+	var errs []error
+	// fail reports err for result i: as an error, or as a warning for
+	// recommended packages.
+	fail := func(i int, err error) {
+		if soft[i] {
+			logging.Warnf("pkgmgr: not installing recommended package %s: %v", results[i].Name, err)
+			results[i].Warning = err
+			return
+		}
+		results[i].Err = err
+		errs = append(errs, fmt.Errorf("install %s: %w", results[i].Name, err))
+	}
+	for i := range results {
+		if err := results[i].Err; err != nil {
+			results[i].Err = nil
+			fail(i, err)
+		}
+	}
+	if len(errs) == 0 || opts.AllowPartial {
+		recorded := map[int]bool{}
+		for _, i := range order {
+			if recorded[i] || results[i].Err != nil || results[i].Warning != nil {
+				continue
+			}
+			for _, j := range requires[i] {
+				if results[j].Err != nil {
+					if soft[i] {
+						fail(i, fmt.Errorf("%s failed", results[j].Name))
+					} else {
+						fail(i, fmt.Errorf("pre-dependency %s failed", results[j].Name))
+					}
+					break
+				}
+			}
+			if results[i].Err != nil || results[i].Warning != nil {
+				continue
+			}
+			recorded[i] = true
+			if err := m.recordPackage(pkgs[i]); err != nil {
+				fail(i, err)
+			}
+		}
+	}
+	if len(errs) > 0 && !opts.AllowPartial {
+		for i := range downloaded {
+			logging.Debugf("pkgmgr: removing %s after failed batch", results[i].Dest)
+			if err := os.Remove(results[i].Dest); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("clean up %s: %w", results[i].Dest, err))
+			}
+		}
+		if prev != nil {
+			logging.Debugf("pkgmgr: restoring status database after failed batch")
+			if err := status.Restore(prev); err != nil {
+				errs = append(errs, fmt.Errorf("restore status database: %w", err))
+			}
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// InstallPlan describes what Install does for one package.
+type InstallPlan struct {
+	// Package is the package that is installed.
+	Package repo.Package
+	// PreDepends lists the packages that are installed and recorded, in
+	// order, before Package. Pre-dependencies that are already installed
+	// are not listed.
+	PreDepends []string
+	// Recommends lists the packages Package recommends that are installed
+	// after it, unless the manager was created with
+	// WithNoInstallRecommends. Only packages the feeds carry, that are not
+	// installed yet and whose own Pre-Depends are already met are listed.
+	Recommends []string
+}
+
+// PlanInstall returns the plan Install follows for name. Each Pre-Depends
+// clause must be met by an installed package, including through Provides,
+// or by a package from the feeds whose version fits the clause; the
+// pre-dependencies of such a package are planned before it. It fails with a
+// PreDependsError naming the first clause that cannot be satisfied.
+func (m *Manager) PlanInstall(name string) (InstallPlan, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return InstallPlan{}, err
+	}
+	pkg, ok := m.findPackage(name)
+	if !ok {
+		return InstallPlan{}, &PackageNotFoundError{Name: name}
+	}
+	plan, err := m.planPackage(pkg)
+	if err != nil || m.noInstallRecommends {
+		return plan, err
+	}
+	planned := map[string]bool{pkg.Name: true}
+	for _, pre := range plan.PreDepends {
+		planned[pre] = true
+	}
+	status := m.Status()
+recommends:
+	for _, rec := range m.relationTargets(pkg.Raw, "Recommends") {
+		if planned[rec] || status.Installed(rec) {
+			continue
+		}
+		recPkg, ok := m.findPackage(rec)
+		if !ok || recPkg.IsVirtual() {
+			logging.Debugf("pkgmgr: %s recommends unavailable package %s", pkg.Name, rec)
+			continue
+		}
+		for _, group := range version.ParseRelations(recPkg.Raw.Value("Pre-Depends")) {
+			if !m.preDependsSatisfied(group, planned) {
+				logging.Debugf("pkgmgr: skipping %s recommended by %s, its Pre-Depends are not met", rec, pkg.Name)
+				continue recommends
+			}
+		}
+		planned[rec] = true
+		plan.Recommends = append(plan.Recommends, rec)
+	}
+	return plan, nil
+}
+
+// planPackage returns the plan for installing exactly pkg, without the
+// packages it recommends. Upgrades use it directly, so they never pull in
+// new recommendations.
+func (m *Manager) planPackage(pkg repo.Package) (InstallPlan, error) {
+	plan := InstallPlan{Package: pkg}
+	if err := m.planPreDepends(pkg, map[string]bool{pkg.Name: true}, &plan.PreDepends); err != nil {
+		return InstallPlan{}, err
+	}
+	return plan, nil
+}
+
+// planPreDepends appends the packages pkg pre-depends on to order, each after
+// its own pre-dependencies. planned holds the packages already scheduled,
+// which also breaks cycles.
+func (m *Manager) planPreDepends(pkg repo.Package, planned map[string]bool, order *[]string) error {
+	for _, group := range version.ParseRelations(pkg.Raw.Value("Pre-Depends")) {
+		if m.preDependsSatisfied(group, planned) {
+			continue
+		}
+		dep, ok := m.preDependsCandidate(group)
+		if !ok {
+			return &PreDependsError{Package: pkg.Name, Requirement: relationGroupString(group)}
+		}
+		planned[dep.Name] = true
+		if err := m.planPreDepends(dep, planned, order); err != nil {
+			return err
+		}
+		*order = append(*order, dep.Name)
+	}
+	return nil
+}
+
+// preDependsSatisfied reports whether an alternative of group is already
+// planned or installed.
+func (m *Manager) preDependsSatisfied(group []version.Relation, planned map[string]bool) bool {
+	status := m.Status()
+	for _, rel := range group {
+		if planned[rel.Name] {
+			return true
+		}
+		if entry, err := status.Lookup(rel.Name); err == nil && entry.IsFullyInstalled() && rel.SatisfiedBy(entry.Name, entry.Version) {
+			return true
+		}
+		if len(rel.Constraints) > 0 {
+			continue
+		}
+		for _, entry := range status.Entries() {
+			if entry.IsFullyInstalled() && relationMatches(entry.Raw.Value("Provides"), rel.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// preDependsCandidate returns the package from the feeds installed for the
+// first alternative of group it can satisfy.
+func (m *Manager) preDependsCandidate(group []version.Relation) (repo.Package, bool) {
+	for _, rel := range group {
+		pkg, ok := m.findPackage(rel.Name)
+		if ok && !pkg.IsVirtual() && rel.SatisfiedBy(pkg.Name, pkg.Version) {
+			return pkg, true
+		}
+	}
+	return repo.Package{}, false
+}
@@ -0,0 +1,235 @@
+package pkgmgr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/oe-mirrors/opkg_go/internal/format"
+	"github.com/oe-mirrors/opkg_go/internal/logging"
+	"github.com/oe-mirrors/opkg_go/internal/pkgdb"
+	"github.com/oe-mirrors/opkg_go/internal/version"
+)
+
+// IntegrityError describes one problem CheckIntegrity found in a status
+// database. Package is empty for entries without a Package field.
+type IntegrityError struct {
+	Package string
+	Field   string
+	Message string
+}
+
+func (e IntegrityError) Error() string {
+	if e.Package == "" {
+		return fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Package, e.Field, e.Message)
+}
+
+// CheckIntegrity validates the status database on disk. Every entry must
+// carry Package, Version and Status fields, Status must be a known
+// three-part state, Auto-Installed must be "yes" when present, and no
+// package may be recorded twice. With WithAllDestinations every destination
+// database is checked. The returned error reports databases that cannot be
+// read at all; a missing database has nothing to check.
+func (m *Manager) CheckIntegrity() ([]IntegrityError, error) {
+	var problems []IntegrityError
+	for _, path := range m.statusFiles() {
+		paragraphs, err := readStatusParagraphs(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found := checkParagraphs(paragraphs)
+		logging.Debugf("pkgmgr: integrity: %d problems in %s", len(found), path)
+		problems = append(problems, found...)
+	}
+	return problems, nil
+}
+
+// statusFiles returns the status databases CheckIntegrity and RepairStatus
+// work on: the manager's own database, or every destination database with
+// WithAllDestinations.
+func (m *Manager) statusFiles() []string {
+	if m.allDestinations {
+		return statusPaths(m.conf())
+	}
+	if path := m.Status().Path(); path != "" {
+		return []string{path}
+	}
+	return nil
+}
+
+// readStatusParagraphs parses the status file at path without the
+// normalisation pkgdb applies, keeping nameless and duplicate entries.
+func readStatusParagraphs(path string) ([]format.Paragraph, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read status: %w", err)
+	}
+	cf, err := format.ParseControl(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse status %s: %w", path, err)
+	}
+	return cf.Paragraphs, nil
+}
+
+func checkParagraphs(paragraphs []format.Paragraph) []IntegrityError {
+	var problems []IntegrityError
+	seen := map[string]bool{}
+	for _, p := range paragraphs {
+		name := p.Value("Package")
+		report := func(field, message string) {
+			problems = append(problems, IntegrityError{Package: name, Field: field, Message: message})
+		}
+		for _, field := range []string{"Package", "Version", "Status"} {
+			if p.Value(field) == "" {
+				report(field, "missing or empty")
+			}
+		}
+		if status := p.Value("Status"); status != "" {
+			if parts := len(strings.Fields(status)); parts != 3 || !pkgdb.ParseEntryStatus(status).Valid() {
+				report("Status", fmt.Sprintf("invalid status %q", status))
+			}
+		}
+		if auto := p.Value("Auto-Installed"); auto != "" && auto != "yes" {
+			report("Auto-Installed", fmt.Sprintf("invalid value %q, want yes", auto))
+		}
+		if name != "" {
+			if seen[name] {
+				report("Package", "duplicate entry")
+			}
+			seen[name] = true
+		}
+	}
+	return problems
+}
+
+// StatusRepair describes one change RepairStatus made, or would make in a
+// dry run, to the entry of Package.
+type StatusRepair struct {
+	Package string
+	Message string
+}
+
+// RepairOptions tunes RepairStatusWith.
+type RepairOptions struct {
+	// DryRun reports the repairs without writing the database.
+	DryRun bool
+}
+
+// RepairStatus fixes the problems of the status database that can be fixed
+// safely. See RepairStatusWith.
+func (m *Manager) RepairStatus() error {
+	_, err := m.RepairStatusWith(RepairOptions{})
+	return err
+}
+
+// RepairStatusWith normalises the status database and returns the repairs
+// it made. Entries without a Status field are marked "install ok installed".
+// When a package is recorded more than once, only the entry with the
+// highest version is kept, the later one on a tie. Entries with a malformed
+// version are logged and left unchanged, as are entries without a name;
+// apart from duplicates no entry is ever dropped. Repaired databases are
+// replaced atomically, and databases needing no repair are not rewritten.
+func (m *Manager) RepairStatusWith(opts RepairOptions) ([]StatusRepair, error) {
+	var repairs []StatusRepair
+	changed := false
+	for _, path := range m.statusFiles() {
+		paragraphs, err := readStatusParagraphs(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		repaired, found := repairParagraphs(paragraphs)
+		repairs = append(repairs, found...)
+		if repaired == nil || opts.DryRun {
+			continue
+		}
+		var buf bytes.Buffer
+		if _, err := (format.ControlFile{Paragraphs: repaired}).WriteTo(&buf); err != nil {
+			return nil, err
+		}
+		status := m.Status()
+		if status.Path() != path {
+			status = pkgdb.EmptyAt(path)
+		}
+		if err := status.Restore(buf.Bytes()); err != nil {
+			return nil, fmt.Errorf("repair %s: %w", path, err)
+		}
+		changed = true
+		logging.Debugf("pkgmgr: repair: rewrote %s", path)
+	}
+	if changed && m.allDestinations {
+		merged, err := m.MergedStatus()
+		if err != nil {
+			return repairs, err
+		}
+		m.mu.Lock()
+		m.status = merged
+		m.mu.Unlock()
+	}
+	return repairs, nil
+}
+
+// repairParagraphs returns the repaired paragraphs and the repairs made. The
+// paragraphs are nil when nothing had to be rewritten, which happens when
+// the only findings are malformed versions.
+func repairParagraphs(paragraphs []format.Paragraph) ([]format.Paragraph, []StatusRepair) {
+	var repairs []StatusRepair
+	rewrite := false
+	// keep maps each name to the index of the entry kept for it.
+	keep := map[string]int{}
+	dropped := make([]bool, len(paragraphs))
+	out := make([]format.Paragraph, len(paragraphs))
+	for i, p := range paragraphs {
+		out[i] = p
+		name := p.Value("Package")
+		if v := p.Value("Version"); v != "" {
+			if err := version.Validate(v); err != nil {
+				logging.Debugf("pkgmgr: repair: leaving %s alone: %v", name, err)
+				repairs = append(repairs, StatusRepair{Package: name, Message: fmt.Sprintf("malformed version %q left unchanged", v)})
+			}
+		}
+		if p.Value("Status") == "" {
+			repaired := p.Clone()
+			repaired.MergeOverride(format.Paragraph{Fields: map[string]string{"Status": "install ok installed"}})
+			out[i] = repaired
+			rewrite = true
+			repairs = append(repairs, StatusRepair{Package: name, Message: `set Status to "install ok installed"`})
+		}
+		if name == "" {
+			continue
+		}
+		prev, ok := keep[name]
+		if !ok {
+			keep[name] = i
+			continue
+		}
+		older, newer := prev, i
+		if version.Compare(p.Value("Version"), paragraphs[prev].Value("Version")) < 0 {
+			older, newer = i, prev
+		}
+		dropped[older] = true
+		keep[name] = newer
+		rewrite = true
+		repairs = append(repairs, StatusRepair{Package: name, Message: fmt.Sprintf("dropped duplicate entry for version %s, kept %s",
+			paragraphs[older].Value("Version"), paragraphs[newer].Value("Version"))})
+	}
+	if !rewrite {
+		return nil, repairs
+	}
+	var result []format.Paragraph
+	for i, p := range out {
+		if !dropped[i] {
+			result = append(result, p)
+		}
+	}
+	return result, repairs
+}
@@ -0,0 +1,118 @@
+package pkgmgr
+
+import (
+	"strings"
+	"time"
+
+	"github.com/oe-mirrors/opkg_go/internal/pkgmgr/jsonout"
+)
+
+// ListPackagesJSON returns the packages ListPackages would list as typed
+// JSON documents. IncludeSize is ignored; sizes are always included when
+// known.
+func (m *Manager) ListPackagesJSON(opts ListOptions) ([]jsonout.PackageJSON, error) {
+	describe := func(desc string) string {
+		if opts.ShortDescription {
+			return firstLine(desc)
+		}
+		return desc
+	}
+	status := m.Status()
+	out := []jsonout.PackageJSON{}
+	if opts.InstalledOnly {
+		entries := m.StatusParagraphs(opts.Patterns)
+		m.sortInstalled(entries, opts)
+		for _, entry := range entries {
+			if !opts.selects(entry.Raw) {
+				continue
+			}
+			out = append(out, jsonout.PackageJSON{
+				Name:         entry.Name,
+				Version:      entry.Version,
+				Architecture: entry.Architecture,
+				Description:  describe(entry.Raw.Value("Description")),
+				Size:         entry.Raw.Value("Installed-Size"),
+				Feed:         entry.Raw.Value("Feed"),
+				Installed:    status.Installed(entry.Name),
+			})
+		}
+		return out, nil
+	}
+	pkgs, err := m.listAvailable(opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range pkgs {
+		out = append(out, jsonout.PackageJSON{
+			Name:         pkg.Name,
+			Version:      pkg.Version,
+			Architecture: pkg.Architecture,
+			Description:  describe(pkg.Description),
+			Size:         pkg.Size,
+			Feed:         pkg.Feed.Name,
+			Installed:    status.Installed(pkg.Name),
+		})
+	}
+	return out, nil
+}
+
+// ListUpgradableJSON returns the result of ListUpgradable as typed JSON
+// documents annotated with the kind of version change, the feed URL and the
+// size of each upgrade.
+func (m *Manager) ListUpgradableJSON(patterns []string) ([]jsonout.UpgradeCandidateJSON, error) {
+	candidates, err := m.ListUpgradable(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return UpgradeCandidatesJSON(candidates), nil
+}
+
+// UpgradeCandidatesJSON converts upgrade candidates, for example a filtered
+// result of ListUpgradable, to typed JSON documents.
+func UpgradeCandidatesJSON(candidates []UpgradeCandidate) []jsonout.UpgradeCandidateJSON {
+	out := make([]jsonout.UpgradeCandidateJSON, 0, len(candidates))
+	for _, c := range candidates {
+		out = append(out, jsonout.UpgradeCandidateJSON{
+			Name:               c.Name,
+			Installed:          c.Installed,
+			Available:          c.Available,
+			Change:             c.ChangeType.String(),
+			Description:        strings.TrimSpace(c.Description),
+			Feed:               c.Feed.Name,
+			FeedURL:            c.Feed.URI,
+			DownloadSize:       c.DownloadSize,
+			InstalledSizeDelta: c.InstalledSizeDelta,
+		})
+	}
+	return out
+}
+
+// StatusJSON returns the status entries matching patterns as typed JSON
+// documents.
+func (m *Manager) StatusJSON(patterns []string) []jsonout.StatusEntryJSON {
+	entries := m.StatusParagraphs(patterns)
+	out := make([]jsonout.StatusEntryJSON, 0, len(entries))
+	for _, entry := range entries {
+		out = append(out, jsonout.StatusEntryJSON{
+			Name:         entry.Name,
+			Version:      entry.Version,
+			Architecture: entry.Architecture,
+			Status:       entry.Status,
+		})
+	}
+	return out
+}
+
+// FeedsJSON returns the configured feeds as typed JSON documents.
+func (m *Manager) FeedsJSON() []jsonout.FeedJSON {
+	feeds := m.Feeds()
+	out := make([]jsonout.FeedJSON, 0, len(feeds))
+	for _, feed := range feeds {
+		doc := jsonout.FeedJSON{Name: feed.Name, URI: feed.URI, Type: feed.Type, Disabled: feed.Disabled}
+		if updated, ok := m.FeedUpdatedAt(feed.Name); ok {
+			doc.UpdatedAt = updated.Format(time.RFC3339)
+		}
+		out = append(out, doc)
+	}
+	return out
+}
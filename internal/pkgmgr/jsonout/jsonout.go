@@ -0,0 +1,61 @@
+// Package jsonout defines the JSON documents produced by the opkg CLI with
+// --format=json. Library users can decode the CLI output with these types
+// or obtain them directly from the corresponding Manager methods.
+package jsonout
+
+// PackageJSON describes a package from a feed index or the status database.
+type PackageJSON struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture,omitempty"`
+	Description  string `json:"description,omitempty"`
+	Size         string `json:"size,omitempty"`
+	Feed         string `json:"feed,omitempty"`
+	Installed    bool   `json:"installed"`
+}
+
+// UpgradeCandidateJSON describes an installed package with a newer version
+// available.
+type UpgradeCandidateJSON struct {
+	Name        string `json:"name"`
+	Installed   string `json:"installed"`
+	Available   string `json:"available"`
+	Change      string `json:"change"`
+	Description string `json:"description,omitempty"`
+	Feed        string `json:"feed,omitempty"`
+	FeedURL     string `json:"feed_url,omitempty"`
+	// DownloadSize is the size in bytes of the new archive, or zero when
+	// the index does not declare it.
+	DownloadSize int64 `json:"download_size"`
+	// InstalledSizeDelta is how many bytes the upgrade adds to the
+	// installed size; negative when the new version is smaller. It is zero
+	// unless both versions declare an Installed-Size.
+	InstalledSizeDelta int64 `json:"installed_size_delta"`
+}
+
+// StatusEntryJSON describes an entry of the status database.
+type StatusEntryJSON struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture,omitempty"`
+	Status       string `json:"status"`
+}
+
+// FeedJSON describes a configured feed.
+type FeedJSON struct {
+	Name     string `json:"name"`
+	URI      string `json:"uri"`
+	Type     string `json:"type"`
+	Disabled bool   `json:"disabled"`
+	// UpdatedAt is when the feed was last fetched, in RFC 3339 format, or
+	// empty when it was never fetched into the cache.
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// ChangelogJSON holds the upstream changelog of a package and the URL it was
+// downloaded from.
+type ChangelogJSON struct {
+	Package string `json:"package"`
+	URL     string `json:"url"`
+	Content string `json:"content"`
+}
@@ -4,9 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/oe-mirrors/opkg_go/internal/config"
 	"github.com/oe-mirrors/opkg_go/internal/downloader"
@@ -17,23 +23,148 @@ import (
 )
 
 // Manager coordinates package operations by wiring configuration, repository
-// metadata and the status database together.
+// metadata and the status database together. A Manager is safe for
+// concurrent use: the configuration, status database and indexes are
+// replaced under mu, and readers work on snapshots taken under it.
 type Manager struct {
-	cfg           *config.Config
-	client        *downloader.Client
-	status        *pkgdb.Status
+	cfgPath string
+	cfg     *config.Config
+	client  *downloader.Client
+	status  *pkgdb.Status
+	cache   string
+
+	// noNetwork makes Update read cached indexes and Install use cached
+	// archives only.
+	noNetwork bool
+	// dest is the root of the destination the manager is scoped to by
+	// WithDest, or empty for the configured default.
+	dest string
+	// archOverride restricts package lookups to packages compatible with
+	// this architecture when set by WithArch.
+	archOverride string
+	// workers bounds concurrent downloads when set by WithWorkers; zero
+	// keeps the defaults of the individual operations.
+	workers int
+	// updateTimeout bounds the total duration of an update when set by
+	// WithUpdateTimeout.
+	updateTimeout time.Duration
+	// allDestinations makes New load the merged status of every
+	// destination; see WithAllDestinations.
+	allDestinations bool
+	// noInstallRecommends keeps packages listed only in Recommends out of
+	// install plans; see WithNoInstallRecommends.
+	noInstallRecommends bool
+
+	mu            sync.RWMutex
 	indexes       repo.IndexSet
-	cache         string
 	indexesLoaded bool
+	updated       time.Time
+	ready         chan struct{}
+	// paragraphs caches InfoParagraphs lookups for the current indexes. It
+	// is created lazily and dropped whenever the indexes change.
+	paragraphs *paragraphCache
+}
+
+// Option configures optional behaviour of a Manager created with New.
+type Option func(*Manager)
+
+// WithNoNetwork prevents the manager from making any network request.
+// Update loads the indexes cached by a previous update instead, and Install
+// and Download only succeed for archives already present in the cache.
+func WithNoNetwork() Option {
+	return func(m *Manager) {
+		m.noNetwork = true
+		m.client = downloader.NewOffline()
+	}
+}
+
+// WithWorkers limits the number of concurrent downloads of Update,
+// InstallMultiple and Mirror to n. One makes every operation sequential and
+// zero or less uses runtime.NumCPU().
+func WithWorkers(n int) Option {
+	return func(m *Manager) {
+		if n <= 0 {
+			n = runtime.NumCPU()
+		}
+		m.workers = n
+	}
+}
+
+// WithUpdateTimeout limits how long Update and UpdateWithEvents may take in
+// total, across every feed. The per-request timeout of the downloader still
+// applies to each individual download.
+func WithUpdateTimeout(d time.Duration) Option {
+	return func(m *Manager) {
+		m.updateTimeout = d
+	}
+}
+
+// WithNoInstallRecommends controls whether install plans leave out the
+// packages that are only recommended, overriding the no_install_recommends
+// option of the configuration.
+func WithNoInstallRecommends(enabled bool) Option {
+	return func(m *Manager) {
+		m.noInstallRecommends = enabled
+	}
+}
+
+// WithAllDestinations makes New load the status databases of every
+// configured destination, merged by MergedStatus, instead of the default
+// status file alone. The merged database is read-only.
+func WithAllDestinations(enabled bool) Option {
+	return func(m *Manager) {
+		m.allDestinations = enabled
+	}
 }
 
 // New creates a package manager using the provided configuration file.
-func New(cfgPath string) (*Manager, error) {
+func New(cfgPath string, opts ...Option) (*Manager, error) {
 	cfg, err := config.Load(cfgPath)
 	if err != nil {
 		return nil, err
 	}
 	logging.Debugf("pkgmgr: configuration loaded from %s", cfgPath)
+	return newManager(cfgPath, cfg, opts)
+}
+
+// NewFromConfig creates a package manager using an already parsed
+// configuration, which the manager takes ownership of. Reset fails on such a
+// manager since there is no file to reload.
+func NewFromConfig(cfg *config.Config, opts ...Option) (*Manager, error) {
+	if cfg == nil {
+		return nil, errors.New("nil config")
+	}
+	return newManager("", cfg, opts)
+}
+
+// NewFromReader creates a package manager from configuration text, for
+// example an opkg.conf embedded in an application. The text is parsed from a
+// temporary file that is removed before NewFromReader returns, so include
+// directives should use absolute paths. As with NewFromConfig, Reset fails
+// on the manager.
+func NewFromReader(r io.Reader, opts ...Option) (*Manager, error) {
+	f, err := os.CreateTemp("", "opkg-*.conf")
+	if err != nil {
+		return nil, fmt.Errorf("create config file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write config file: %w", err)
+	}
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	return NewFromConfig(cfg, opts...)
+}
+
+// newManager sets up a manager for cfg, which was loaded from cfgPath or,
+// when cfgPath is empty, obtained some other way.
+func newManager(cfgPath string, cfg *config.Config, opts []Option) (*Manager, error) {
 	cache, err := config.EnsureCacheDir(cfg)
 	if err != nil {
 		return nil, err
@@ -44,56 +175,428 @@ func New(cfgPath string) (*Manager, error) {
 	if err != nil {
 		logging.Debugf("pkgmgr: status path unavailable, using empty database: %v", err)
 		status = pkgdb.Empty()
-	} else {
-		status, err = pkgdb.Load(statusPath)
-		if err != nil {
-			// When the status file is missing we continue with an empty DB.
-			if errors.Is(err, os.ErrNotExist) {
-				logging.Debugf("pkgmgr: status file %s missing, using empty database", statusPath)
-				status = pkgdb.Empty()
-			} else {
-				return nil, err
-			}
+	} else if status, err = loadStatus(statusPath); err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		cfgPath: cfgPath,
+		cfg:     cfg,
+		client:  downloader.New(0),
+		status:  status,
+		cache:   cache,
+
+		noInstallRecommends: cfg.NoInstallRecommends(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.allDestinations {
+		if m.status, err = m.MergedStatus(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// MergedStatus loads the status database of the configured status path and
+// of every destination, in that order, and merges them with
+// pkgdb.LoadMultiple: a package recorded in several databases takes the
+// entry of the last destination. Missing files are skipped.
+func (m *Manager) MergedStatus() (*pkgdb.Status, error) {
+	return pkgdb.LoadMultiple(statusPaths(m.conf()))
+}
+
+// statusPaths returns the existing status databases of cfg that
+// MergedStatus merges.
+func statusPaths(cfg *config.Config) []string {
+	var candidates []string
+	if path, err := cfg.StatusPath(); err == nil {
+		candidates = append(candidates, path)
+	}
+	if cfg != nil {
+		for _, dest := range cfg.Destinations {
+			candidates = append(candidates, filepath.Join(dest.Path, "usr/lib/opkg/status"))
+		}
+	}
+	var paths []string
+	for _, path := range candidates {
+		path = filepath.Clean(path)
+		if slices.Contains(paths, path) {
+			continue
+		}
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			logging.Debugf("pkgmgr: status file %s missing, skipping", path)
+			continue
 		}
+		paths = append(paths, path)
 	}
+	return paths
+}
 
-	return &Manager{
-		cfg:    cfg,
-		client: downloader.New(0),
-		status: status,
-		cache:  cache,
-	}, nil
+// loadStatus loads the status database at path. A missing file yields an
+// empty database that Save creates at path.
+func loadStatus(path string) (*pkgdb.Status, error) {
+	status, err := pkgdb.Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		logging.Debugf("pkgmgr: status file %s missing, using empty database", path)
+		return pkgdb.EmptyAt(path), nil
+	}
+	return status, err
 }
 
-// Update refreshes the remote package metadata.
+// clone returns a copy of m sharing its configuration, client, status
+// database and loaded indexes. The copy has its own lock.
+func (m *Manager) clone() *Manager {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c := &Manager{
+		cfgPath:             m.cfgPath,
+		cfg:                 m.cfg,
+		client:              m.client,
+		status:              m.status,
+		cache:               m.cache,
+		noNetwork:           m.noNetwork,
+		dest:                m.dest,
+		archOverride:        m.archOverride,
+		workers:             m.workers,
+		updateTimeout:       m.updateTimeout,
+		allDestinations:     m.allDestinations,
+		noInstallRecommends: m.noInstallRecommends,
+		indexes:             m.indexes,
+		indexesLoaded:       m.indexesLoaded,
+		updated:             m.updated,
+		// paragraphs is not shared: copies may select packages differently.
+	}
+	if m.indexesLoaded {
+		c.ready = make(chan struct{})
+		close(c.ready)
+	}
+	return c
+}
+
+// WithDest returns a copy of the manager scoped to the named destination.
+// The copy keeps its package cache below the destination root, at the same
+// relative path as the default cache, and uses the status database at
+// <dest>/usr/lib/opkg/status. Loaded indexes are shared with m.
+func (m *Manager) WithDest(name string) (*Manager, error) {
+	root, err := m.conf().ResolveDest(name)
+	if err != nil {
+		return nil, err
+	}
+	status, err := loadStatus(filepath.Join(root, "usr/lib/opkg/status"))
+	if err != nil {
+		return nil, err
+	}
+	c := m.clone()
+	c.dest = root
+	c.cache = filepath.Join(root, m.cache)
+	c.status = status
+	logging.Debugf("pkgmgr: scoped to destination %s at %s", name, root)
+	return c, nil
+}
+
+// WithArch returns a copy of the manager whose package lookups and listings
+// only consider packages compatible with arch. The configured architectures,
+// as reported by Architectures, are unaffected.
+func (m *Manager) WithArch(arch string) *Manager {
+	c := m.clone()
+	c.archOverride = arch
+	return c
+}
+
+// archAllowed reports whether packages built for arch pass the WithArch
+// filter. Packages that do not declare an architecture always pass.
+func (m *Manager) archAllowed(arch string) bool {
+	if m.archOverride == "" || arch == "" {
+		return true
+	}
+	return config.Architecture{Name: arch}.IsCompatibleWith(m.archOverride)
+}
+
+// Reset discards the loaded indexes and reloads the configuration file and
+// the status database, so that changes made to them since the manager was
+// created take effect. A manager created with WithAllDestinations merges the
+// status databases of the reloaded destinations again. Queries load the
+// cached indexes again, as they do after New, until the next Update.
+func (m *Manager) Reset() error {
+	if m.cfgPath == "" {
+		return errors.New("manager was not created from a configuration file")
+	}
+	cfg, err := config.Load(m.cfgPath)
+	if err != nil {
+		return err
+	}
+	status := pkgdb.Empty()
+	if m.allDestinations {
+		if status, err = pkgdb.LoadMultiple(statusPaths(cfg)); err != nil {
+			return err
+		}
+	} else if path := m.Status().Path(); path != "" {
+		if status, err = loadStatus(path); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg = cfg
+	m.status = status
+	m.indexes = repo.IndexSet{}
+	m.indexesLoaded = false
+	m.updated = time.Time{}
+	m.paragraphs = nil
+	select {
+	case <-m.ready:
+		// Let WaitForIndexes block again until the next update.
+		m.ready = nil
+	default:
+	}
+	logging.Debugf("pkgmgr: reset state from %s", m.cfgPath)
+	return nil
+}
+
+// FeedEvent reports the progress of a single feed during UpdateWithEvents.
+type FeedEvent = repo.FeedEvent
+
+// Statuses reported in FeedEvent.Status.
+const (
+	FeedFetching = repo.FeedFetching
+	FeedDone     = repo.FeedDone
+	FeedError    = repo.FeedError
+)
+
+// Update refreshes the remote package metadata. It is a convenience wrapper
+// around UpdateWithEvents that returns the first error it reports.
 func (m *Manager) Update(ctx context.Context) error {
+	events, err := m.UpdateWithEvents(ctx)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for ev := range events {
+		if ev.Err != nil && firstErr == nil {
+			firstErr = classifyDownloadError("", ev.Err)
+		}
+	}
+	return firstErr
+}
+
+// UpdateWithEvents refreshes the remote package metadata in the background and
+// reports per-feed progress on the returned channel. The channel is closed
+// once all feeds have finished; the indexes are only replaced when every feed
+// succeeded. A failure no feed event reported, such as a cancelled ctx, is
+// sent as a final FeedError event with an empty Feed. Cancelling ctx aborts
+// the downloads still in progress.
+func (m *Manager) UpdateWithEvents(ctx context.Context) (<-chan FeedEvent, error) {
+	cfg := m.conf()
+	if cfg == nil {
+		return nil, errors.New("configuration required")
+	}
 	logging.Debugf("pkgmgr: updating package metadata")
-	indexes, err := repo.Update(ctx, m.cfg, m.cache, m.client)
+	events := make(chan FeedEvent, 2*len(cfg.Feeds)+1)
+	cancel := context.CancelFunc(func() {})
+	if m.updateTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, m.updateTimeout)
+	}
+	client := m.downloader()
+	go func() {
+		defer close(events)
+		defer cancel()
+		update := repo.UpdateWith
+		if m.noNetwork {
+			update = loadCachedIndexes
+		}
+		// Forward the feed events, noting whether one of them already
+		// reported the failure.
+		feedEvents := make(chan FeedEvent)
+		forwarded := make(chan bool)
+		go func() {
+			reported := false
+			for ev := range feedEvents {
+				reported = reported || ev.Status == FeedError
+				events <- ev
+			}
+			forwarded <- reported
+		}()
+		indexes, err := update(ctx, cfg, m.cache, client, repo.UpdateOptions{Events: feedEvents, Downloads: m.workers})
+		close(feedEvents)
+		reported := <-forwarded
+		if err != nil {
+			logging.Debugf("pkgmgr: update failed: %v", err)
+			if !reported {
+				events <- FeedEvent{Status: FeedError, Err: err}
+			}
+			return
+		}
+		m.setIndexes(indexes)
+		logging.Debugf("pkgmgr: index set contains %d feeds", len(indexes))
+	}()
+	return events, nil
+}
+
+// loadCachedIndexes has the signature of repo.UpdateWith but reads every
+// enabled feed from the cache instead of the network.
+func loadCachedIndexes(ctx context.Context, cfg *config.Config, cacheDir string, _ *downloader.Client, opts repo.UpdateOptions) ([]repo.Index, error) {
+	return readCachedIndexes(ctx, cfg, cacheDir, opts.Events, false)
+}
+
+// readCachedIndexes reads the cached index of every enabled feed, reporting
+// progress on events when it is not nil. A feed that was never cached is an
+// error unless skipMissing is set.
+func readCachedIndexes(ctx context.Context, cfg *config.Config, cacheDir string, events chan<- FeedEvent, skipMissing bool) ([]repo.Index, error) {
+	emit := func(feed config.Feed, status string, err error) {
+		if events != nil {
+			events <- FeedEvent{Feed: feed, Status: status, Err: err}
+		}
+	}
+	var indexes []repo.Index
+	for _, feed := range cfg.Feeds {
+		if feed.Disabled {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		emit(feed, FeedFetching, nil)
+		idx, err := repo.LoadCachedIndex(feed, cacheDir)
+		if errors.Is(err, os.ErrNotExist) {
+			if skipMissing {
+				logging.Debugf("pkgmgr: no cached index for feed %s", feed.Name)
+				continue
+			}
+			err = fmt.Errorf("no cached index for feed %s in %s; network access is disabled, so indexes must be pre-populated by an update with network access", feed.Name, cacheDir)
+		}
+		if err != nil {
+			emit(feed, FeedError, err)
+			return nil, err
+		}
+		emit(feed, FeedDone, nil)
+		indexes = append(indexes, *idx)
+	}
+	return indexes, nil
+}
+
+// LoadCached loads the indexes stored in the cache directory by a previous
+// update without accessing the network. Enabled feeds that were never cached
+// are skipped; when no feed is cached at all it returns an error telling the
+// caller to run an update. LastUpdated reports the oldest cached index.
+func (m *Manager) LoadCached() error {
+	cfg := m.conf()
+	if cfg == nil {
+		return errors.New("configuration required")
+	}
+	indexes, err := readCachedIndexes(context.Background(), cfg, m.cache, nil, true)
 	if err != nil {
 		return err
 	}
+	if len(indexes) == 0 {
+		return errNotLoaded
+	}
+	var oldest time.Time
+	for _, idx := range indexes {
+		if oldest.IsZero() || idx.Updated.Before(oldest) {
+			oldest = idx.Updated
+		}
+	}
+	m.setIndexes(indexes)
+	m.mu.Lock()
+	m.updated = oldest
+	m.mu.Unlock()
+	logging.Debugf("pkgmgr: loaded %d cached feeds from %s", len(indexes), m.cache)
+	return nil
+}
+
+func (m *Manager) setIndexes(indexes []repo.Index) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.indexes = repo.NewIndexSet(indexes)
 	m.indexesLoaded = true
-	logging.Debugf("pkgmgr: index set contains %d feeds", len(indexes))
+	m.paragraphs = nil
+	m.updated = time.Now()
+	if m.ready == nil {
+		m.ready = make(chan struct{})
+	}
+	select {
+	case <-m.ready:
+	default:
+		close(m.ready)
+	}
+}
+
+// Background refreshes the package indexes immediately and then every
+// interval until ctx is cancelled. It returns without waiting for the first
+// refresh; update errors are logged and do not stop the loop.
+func (m *Manager) Background(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid refresh interval %s", interval)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := m.Update(ctx); err != nil {
+				logging.Debugf("pkgmgr: background update failed: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
 	return nil
 }
 
+// IndexesLoaded reports whether package indexes are available.
+func (m *Manager) IndexesLoaded() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.indexesLoaded
+}
+
+// LastUpdated returns when the indexes were last loaded successfully, or the
+// zero time if they never were.
+func (m *Manager) LastUpdated() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.updated
+}
+
+// WaitForIndexes blocks until at least one update has completed successfully
+// or ctx is done.
+func (m *Manager) WaitForIndexes(ctx context.Context) error {
+	m.mu.Lock()
+	if m.ready == nil {
+		m.ready = make(chan struct{})
+	}
+	ready := m.ready
+	m.mu.Unlock()
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // List returns a human readable representation of packages available in the
 // repositories. When installedOnly is true only packages present in the status
 // database are returned.
 func (m *Manager) List(installedOnly bool) []string {
 	logging.Debugf("pkgmgr: listing packages installedOnly=%t", installedOnly)
+	installed := m.Status()
 	var lines []string
 	if installedOnly {
-		for _, entry := range m.status.Entries() {
+		for _, entry := range installed.Entries() {
 			lines = append(lines, fmt.Sprintf("%s - %s", entry.Name, entry.Version))
 		}
 		return lines
 	}
 
-	for _, pkg := range m.indexes.All() {
+	for _, pkg := range m.indexSet().All() {
 		status := ""
-		if m.status.Installed(pkg.Name) {
+		if installed.Installed(pkg.Name) {
 			status = " [installed]"
 		}
 		desc := strings.ReplaceAll(pkg.Description, "\n", " ")
@@ -105,39 +608,240 @@ func (m *Manager) List(installedOnly bool) []string {
 // Info returns detailed information about the provided package name.
 func (m *Manager) Info(name string) (string, error) {
 	logging.Debugf("pkgmgr: retrieving info for %s", name)
-	pkg, ok := m.indexes.Find(name)
+	pkg, ok := m.findPackage(name)
 	if !ok {
-		if entry, err := m.status.Lookup(name); err == nil {
+		if entry, err := m.Status().Lookup(name); err == nil {
 			return formatParagraph(entry.Raw), nil
 		}
-		return "", fmt.Errorf("package %s not found", name)
+		return "", &PackageNotFoundError{Name: name}
 	}
 	return formatParagraph(pkg.Raw), nil
 }
 
-// Install downloads the package archive into the cache directory. The Go
-// implementation does not attempt to unpack or execute maintainer scripts; it
-// focuses on downloading the package and leaving further processing to the
-// caller or external tooling.
+// Install downloads the package archive into the cache directory and records
+// the package as installed in the status database. The Go implementation
+// does not attempt to unpack or execute maintainer scripts; it focuses on
+// downloading the package and leaving further processing to the caller or
+// external tooling. Packages the plan lists in PreDepends are installed and
+// recorded first; nothing is downloaded when one of them cannot be satisfied.
+// The packages it lists in Recommends are installed after the package.
 func (m *Manager) Install(ctx context.Context, name string) (string, error) {
 	logging.Debugf("pkgmgr: installing %s", name)
-	if err := m.ensureIndexesLoaded(); err != nil {
+	plan, err := m.PlanInstall(name)
+	if err != nil {
 		return "", err
 	}
-	pkg, ok := m.indexes.Find(name)
+	dest, _, err := m.install(ctx, plan)
+	return dest, err
+}
+
+// install executes plan: the pre-dependencies are installed in order, then
+// the planned package itself and finally the packages it recommends. It
+// returns the archive of the planned package and, also on failure, the
+// archives it added to the cache, so callers can undo the downloads. A
+// recommended package that cannot be installed only logs a warning.
+func (m *Manager) install(ctx context.Context, plan InstallPlan) (dest string, added []string, err error) {
+	for _, pre := range plan.PreDepends {
+		logging.Debugf("pkgmgr: installing %s before %s", pre, plan.Package.Name)
+		pkg, ok := m.findPackage(pre)
+		if !ok {
+			return "", added, &PackageNotFoundError{Name: pre}
+		}
+		preDest, fresh, err := m.installOne(ctx, pkg)
+		if fresh {
+			added = append(added, preDest)
+		}
+		if err != nil {
+			return "", added, fmt.Errorf("install pre-dependency %s of %s: %w", pre, plan.Package.Name, err)
+		}
+	}
+	dest, fresh, err := m.installOne(ctx, plan.Package)
+	if fresh {
+		added = append(added, dest)
+	}
+	if err != nil {
+		return "", added, err
+	}
+	for _, rec := range plan.Recommends {
+		logging.Debugf("pkgmgr: installing %s recommended by %s", rec, plan.Package.Name)
+		pkg, ok := m.findPackage(rec)
+		if !ok {
+			logging.Warnf("pkgmgr: %s recommended by %s is not available", rec, plan.Package.Name)
+			continue
+		}
+		recDest, fresh, err := m.installOne(ctx, pkg)
+		if fresh {
+			added = append(added, recDest)
+		}
+		if err != nil {
+			logging.Warnf("pkgmgr: not installing %s recommended by %s: %v", rec, plan.Package.Name, err)
+		}
+	}
+	return dest, added, nil
+}
+
+// installOne downloads and records pkg without looking at its
+// pre-dependencies. fresh reports that the archive at dest was not in the
+// cache before, even when recording the install failed.
+func (m *Manager) installOne(ctx context.Context, pkg repo.Package) (dest string, fresh bool, err error) {
+	dest, fresh, err = m.fetchPackage(ctx, pkg)
+	if err != nil {
+		return "", false, err
+	}
+	if err := m.recordPackage(pkg); err != nil {
+		return dest, fresh, err
+	}
+	return dest, fresh, nil
+}
+
+// recordPackage records pkg as installed with RecordInstall. It fails when
+// the status database has no backing file, as the install would be lost.
+func (m *Manager) recordPackage(pkg repo.Package) error {
+	if m.Status().Path() == "" {
+		return fmt.Errorf("record install of %s: status database has no backing file", pkg.Name)
+	}
+	return m.recordInstall(pkg.Name, pkg.Version, pkg.Feed.Name, pkg.Architecture)
+}
+
+// fetch places the archive of name in the cache directory and returns the
+// package and the archive path.
+func (m *Manager) fetch(ctx context.Context, name string) (repo.Package, string, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return repo.Package{}, "", err
+	}
+	pkg, ok := m.findPackage(name)
 	if !ok {
-		return "", fmt.Errorf("package %s not available", name)
+		return pkg, "", &PackageNotFoundError{Name: name}
 	}
-	if pkg.Filename == "" {
-		return "", fmt.Errorf("package %s does not declare a Filename field", name)
+	dest, _, err := m.fetchPackage(ctx, pkg)
+	return pkg, dest, err
+}
+
+// fetchPackage places the archive of pkg in the cache directory and returns
+// its path. fresh reports that the archive was not in the cache before.
+func (m *Manager) fetchPackage(ctx context.Context, pkg repo.Package) (dest string, fresh bool, err error) {
+	dest, cached, err := m.resolvePackage(ctx, pkg)
+	if err != nil {
+		return "", false, err
 	}
-	url := strings.TrimSuffix(pkg.Feed.URI, "/") + "/" + strings.TrimPrefix(pkg.Filename, "/")
-	dest := filepath.Join(m.cache, filepath.Base(pkg.Filename))
-	if err := m.client.DownloadToFile(ctx, url, dest); err != nil {
-		return "", err
+	if cached {
+		return dest, false, nil
+	}
+	_, statErr := os.Stat(dest)
+	if err := m.downloader().DownloadToFileWithChecksum(ctx, pkg.FullURL(), dest, packageChecksum(pkg)); err != nil {
+		return "", false, classifyDownloadError(pkg.Name, err)
+	}
+	logging.Debugf("pkgmgr: package %s downloaded to %s", pkg.Name, dest)
+	return dest, statErr != nil, nil
+}
+
+// RecordInstall marks the named package as installed at version in the
+// status database and saves it, creating the entry when there is none.
+// Fields of an existing entry are kept apart from Version, Status and Feed.
+// An empty feed leaves the Feed field of an existing entry unchanged. This
+// keeps the database in sync with packages installed by other tools.
+func (m *Manager) RecordInstall(name, version, feed string) error {
+	return m.recordInstall(name, version, feed, "")
+}
+
+// recordInstall is RecordInstall that also sets Architecture unless arch is
+// empty.
+func (m *Manager) recordInstall(name, version, feed, arch string) error {
+	fields := map[string]string{
+		"Package": name,
+		"Version": version,
+		"Status":  "install ok installed",
+	}
+	if feed != "" {
+		fields["Feed"] = feed
+	}
+	if arch != "" {
+		fields["Architecture"] = arch
+	}
+	status := m.Status()
+	var p format.Paragraph
+	if entry, err := status.Lookup(name); err == nil {
+		p = entry.Raw.Clone()
+	}
+	p.MergeOverride(format.Paragraph{Fields: fields})
+	if err := status.Set(p); err != nil {
+		return fmt.Errorf("record install of %s: %w", name, err)
+	}
+	if err := status.Save(); err != nil {
+		return fmt.Errorf("record install of %s: %w", name, err)
+	}
+	return nil
+}
+
+// RecordRemove deletes the named package from the status database and saves
+// it. It fails with pkgdb.ErrNotFound when the package has no entry.
+func (m *Manager) RecordRemove(name string) error {
+	status := m.Status()
+	if err := status.Remove(name); err != nil {
+		return fmt.Errorf("record removal of %s: %w", name, err)
+	}
+	if err := status.Save(); err != nil {
+		return fmt.Errorf("record removal of %s: %w", name, err)
+	}
+	return nil
+}
+
+// resolveInstall selects the package to install for name and resolves it
+// as resolvePackage does.
+func (m *Manager) resolveInstall(ctx context.Context, name string) (pkg repo.Package, dest string, cached bool, err error) {
+	pkg, ok := m.findPackage(name)
+	if !ok {
+		return pkg, "", false, &PackageNotFoundError{Name: name}
+	}
+	dest, cached, err = m.resolvePackage(ctx, pkg)
+	return pkg, dest, cached, err
+}
+
+// resolvePackage returns the cache path of the archive of pkg. cached
+// reports that the archive must not be downloaded because network access is
+// disabled and it is already in the cache.
+func (m *Manager) resolvePackage(ctx context.Context, pkg repo.Package) (dest string, cached bool, err error) {
+	if pkg.IsVirtual() {
+		return "", false, m.virtualPackageError(ctx, pkg.Name)
+	}
+	dest = filepath.Join(m.cache, filepath.Base(pkg.Filename))
+	if m.noNetwork {
+		if _, err := os.Stat(dest); err != nil {
+			return "", false, fmt.Errorf("package %s is not in the cache and network access is disabled: %w", pkg.Name, err)
+		}
+		logging.Debugf("pkgmgr: using cached archive %s for %s", dest, pkg.Name)
+		return dest, true, nil
+	}
+	return dest, false, nil
+}
+
+func (m *Manager) virtualPackageError(ctx context.Context, name string) error {
+	verr := &VirtualPackageError{Name: name}
+	providers, err := m.FindProviders(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range providers {
+		if pkg.Name != name && !pkg.IsVirtual() {
+			verr.Providers = append(verr.Providers, pkg.Name)
+		}
+	}
+	return verr
+}
+
+// packageChecksum returns the strongest checksum the index declares for pkg,
+// or nil when it declares none.
+func packageChecksum(pkg repo.Package) *downloader.Checksum {
+	if sum := pkg.Checksum.SHA512; sum != "" {
+		return &downloader.Checksum{Algorithm: "sha512", Value: sum}
+	}
+	if sum := pkg.Checksum.SHA256; sum != "" {
+		return &downloader.Checksum{Algorithm: "sha256", Value: sum}
+	}
+	if sum := pkg.Checksum.MD5; sum != "" {
+		return &downloader.Checksum{Algorithm: "md5", Value: sum}
 	}
-	logging.Debugf("pkgmgr: package %s downloaded to %s", name, dest)
-	return dest, nil
+	return nil
 }
 
 func formatParagraph(p format.Paragraph) string {
@@ -151,5 +855,63 @@ func formatParagraph(p format.Paragraph) string {
 
 // Status returns the current status database.
 func (m *Manager) Status() *pkgdb.Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return m.status
 }
+
+// downloader returns the current download client.
+func (m *Manager) downloader() *downloader.Client {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.client
+}
+
+// SetProxy sends subsequent requests through the proxy at proxyURL, for
+// example "http://proxy.example.com:3128". An empty string connects
+// directly. Requests already in flight keep their previous settings.
+func (m *Manager) SetProxy(proxyURL string) error {
+	var u *url.URL
+	if proxyURL != "" {
+		var err error
+		u, err = url.Parse(proxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL %q: %w", proxyURL, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q: scheme and host required", proxyURL)
+		}
+	}
+	if m.noNetwork {
+		logging.Debugf("pkgmgr: network access disabled, ignoring proxy %q", proxyURL)
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.client = m.client.With(downloader.WithProxy(u))
+	logging.Debugf("pkgmgr: proxy set to %q", proxyURL)
+	return nil
+}
+
+// SetTimeout bounds the duration of each subsequent request. Zero restores
+// the default.
+func (m *Manager) SetTimeout(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.client = m.client.With(downloader.WithTimeout(d))
+}
+
+// conf returns the current configuration.
+func (m *Manager) conf() *config.Config {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cfg
+}
+
+// indexSet returns the current indexes. The set is never modified in place,
+// so the snapshot stays consistent while an update replaces it.
+func (m *Manager) indexSet() repo.IndexSet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.indexes
+}
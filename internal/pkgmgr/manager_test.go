@@ -0,0 +1,3098 @@
+package pkgmgr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oe-mirrors/opkg_go/internal/config"
+	"github.com/oe-mirrors/opkg_go/internal/downloader"
+	"github.com/oe-mirrors/opkg_go/internal/pkgdb"
+	"github.com/oe-mirrors/opkg_go/internal/pkgmgr/jsonout"
+	"github.com/oe-mirrors/opkg_go/internal/repo"
+	"github.com/oe-mirrors/opkg_go/internal/version"
+)
+
+func TestCompatibleArchitecturesAddsAll(t *testing.T) {
+	m := &Manager{cfg: &config.Config{}}
+	arches := m.CompatibleArchitectures()
+	if len(arches) != 1 || arches[0].Name != "all" {
+		t.Fatalf("expected only the implicit all architecture, got %+v", arches)
+	}
+
+	m.cfg.Architectures = []config.Architecture{{Name: "all", Priority: 1}, {Name: "armv7a", Priority: 10}}
+	arches = m.CompatibleArchitectures()
+	if len(arches) != 2 {
+		t.Fatalf("expected declared all to be kept once, got %+v", arches)
+	}
+}
+
+func BenchmarkFindPackages(b *testing.B) {
+	m := &Manager{cfg: &config.Config{}, status: pkgdb.Empty(), indexes: largeIndexSet(8, 5000), indexesLoaded: true}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.FindPackages(context.Background(), "tool 42"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInfoParagraphs(b *testing.B) {
+	m := &Manager{cfg: &config.Config{}, status: pkgdb.Empty(), indexes: largeIndexSet(1, 20000), indexesLoaded: true}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		paragraphs, err := m.InfoParagraphs([]string{"*"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(paragraphs) != 20000 {
+			b.Fatalf("got %d paragraphs", len(paragraphs))
+		}
+	}
+}
+
+// BenchmarkListPackages compares collecting the 50k lines of ListPackages
+// with consuming them one by one from StreamPackages.
+func BenchmarkListPackages(b *testing.B) {
+	m := &Manager{cfg: &config.Config{}, status: pkgdb.Empty(), indexes: largeIndexSet(1, 50000), indexesLoaded: true}
+	b.Run("collect", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			lines, err := m.ListPackages(ListOptions{})
+			if err != nil || len(lines) != 50000 {
+				b.Fatalf("got %d lines: %v", len(lines), err)
+			}
+		}
+	})
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			lines, errc := m.StreamPackages(context.Background(), ListOptions{})
+			n := 0
+			for range lines {
+				n++
+			}
+			if err := <-errc; err != nil || n != 50000 {
+				b.Fatalf("got %d lines: %v", n, err)
+			}
+		}
+	})
+}
+
+// largeIndexSet builds a synthetic index set with the given number of feeds
+// and packages per feed.
+func largeIndexSet(feeds, perFeed int) repo.IndexSet {
+	var indexes []repo.Index
+	for f := 0; f < feeds; f++ {
+		feed := config.Feed{Name: fmt.Sprintf("feed%d", f), URI: "http://example.invalid"}
+		idx := repo.Index{Feed: feed, Packages: map[string]repo.Package{}}
+		for p := 0; p < perFeed; p++ {
+			name := fmt.Sprintf("pkg-%d-%d", f, p)
+			idx.Packages[name] = repo.Package{
+				Name:        name,
+				Version:     "1.0-r0",
+				Description: fmt.Sprintf("Synthetic tool %d for benchmarks", p),
+				Feed:        feed,
+			}
+		}
+		indexes = append(indexes, idx)
+	}
+	return repo.NewIndexSet(indexes)
+}
+
+func TestBackgroundLoadsIndexes(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: busybox\nVersion: 1.36.1-r0\nFilename: busybox.ipk\n",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Background(ctx, 10*time.Millisecond); err != nil {
+		t.Fatalf("Background returned error: %v", err)
+	}
+	waitCtx, waitCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer waitCancel()
+	if err := m.WaitForIndexes(waitCtx); err != nil {
+		t.Fatalf("WaitForIndexes returned error: %v", err)
+	}
+	if !m.IndexesLoaded() {
+		t.Fatalf("expected indexes to be loaded")
+	}
+	if m.LastUpdated().IsZero() {
+		t.Fatalf("expected LastUpdated to be set")
+	}
+}
+
+// newFeedServer serves the provided files, keyed by URL path.
+func newFeedServer(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestManager creates a manager for the given feeds with an empty status
+// database and a temporary cache directory.
+func newTestManager(t *testing.T, feeds ...config.Feed) *Manager {
+	t.Helper()
+	return &Manager{
+		cfg:    &config.Config{Options: map[string]string{}, Feeds: feeds},
+		client: downloader.New(0),
+		status: pkgdb.EmptyAt(filepath.Join(t.TempDir(), "status")),
+		cache:  t.TempDir(),
+	}
+}
+
+func TestTransactionRollsBackInstalls(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: first\nVersion: 1.0\nFilename: first.ipk\n\n" +
+			"Package: second\nVersion: 1.0\nFilename: second.ipk\n",
+		"/base/first.ipk": "first",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	err := m.Transaction(ctx, func(tx *Transaction) error {
+		tx.Install("first")
+		tx.Install("second")
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected transaction to fail")
+	}
+	if _, err := os.Stat(filepath.Join(m.cache, "first.ipk")); !os.IsNotExist(err) {
+		t.Fatalf("expected first.ipk to be rolled back, stat error: %v", err)
+	}
+}
+
+func TestUpdateSkipsDisabledFeed(t *testing.T) {
+	var extraHits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/extra/") {
+			atomic.AddInt32(&extraHits, 1)
+		}
+		fmt.Fprint(w, "Package: busybox\nVersion: 1.0\n")
+	}))
+	defer srv.Close()
+
+	cfgPath := filepath.Join(t.TempDir(), "opkg.conf")
+	contents := fmt.Sprintf("src base %s/base\nsrc extra %s/extra\n", srv.URL, srv.URL)
+	if err := os.WriteFile(cfgPath, []byte(contents), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	m := newTestManager(t)
+	m.cfg = cfg
+
+	if err := m.DisableFeed("extra"); err != nil {
+		t.Fatalf("DisableFeed returned error: %v", err)
+	}
+	if feed, _ := cfg.FeedByName("extra"); feed.Disabled {
+		t.Fatal("DisableFeed modified the configuration in use")
+	}
+	if err := m.Update(context.Background()); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	if hits := atomic.LoadInt32(&extraHits); hits != 0 {
+		t.Fatalf("disabled feed was fetched %d times", hits)
+	}
+}
+
+func TestCheckFeeds(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/good/Packages":   "Package: a\nVersion: 1\n\nPackage: b\nVersion: 2\n",
+		"/broken/Packages": "this is not a control file\n",
+	})
+	m := newTestManager(t,
+		config.Feed{Name: "good", URI: srv.URL + "/good"},
+		config.Feed{Name: "missing", URI: srv.URL + "/missing"},
+		config.Feed{Name: "broken", URI: srv.URL + "/broken"},
+	)
+	results := m.CheckFeeds(context.Background())
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if !results[0].OK() || results[0].PackageCount != 2 {
+		t.Fatalf("unexpected result for good feed: %+v", results[0])
+	}
+	if results[1].Reachable || results[1].FetchError == nil {
+		t.Fatalf("expected missing feed to be unreachable: %+v", results[1])
+	}
+	if !results[2].Reachable || results[2].ParseError == nil {
+		t.Fatalf("expected broken feed to fail parsing: %+v", results[2])
+	}
+}
+
+func TestCheckFeedsReadsBoundedPrefix(t *testing.T) {
+	var large strings.Builder
+	for i := 0; large.Len() <= feedCheckBytes; i++ {
+		fmt.Fprintf(&large, "Package: pkg-%d\nVersion: 1.0\nDescription: synthetic package %d\n\n", i, i)
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(large.String()))
+	zw.Close()
+	srv := newFeedServer(t, map[string]string{
+		"/plain/Packages": large.String(),
+		"/gz/Packages.gz": compressed.String(),
+	})
+	m := newTestManager(t,
+		config.Feed{Name: "plain", URI: srv.URL + "/plain"},
+		config.Feed{Name: "gz", URI: srv.URL + "/gz"},
+	)
+	for _, res := range m.CheckFeeds(context.Background()) {
+		if !res.OK() || !res.Partial || res.PackageCount == 0 {
+			t.Fatalf("unexpected result for feed %s: %+v", res.Feed.Name, res)
+		}
+	}
+}
+
+func TestMirrorWritesPackagesAndIndex(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: tool\nVersion: 1.0\nArchitecture: armv7a\nFilename: sub/tool.ipk\n\n" +
+			"Package: other\nVersion: 1.0\nArchitecture: mips\nFilename: other.ipk\n\n" +
+			"Package: broken\nVersion: 1.0\nArchitecture: armv7a\nFilename: broken.ipk\n",
+		"/base/sub/tool.ipk": "tool-archive",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base"})
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	dest := t.TempDir()
+	calls := 0
+	err := m.Mirror(ctx, dest, MirrorOptions{
+		Architectures: []string{"armv7a"},
+		Progress:      func(done, total int, pkg repo.Package, err error) { calls++ },
+	})
+	// broken.ipk is not served, so its download fails.
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected the download of broken to fail, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected two progress calls, got %d", calls)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "base", "sub", "tool.ipk"))
+	if err != nil || string(data) != "tool-archive" {
+		t.Fatalf("unexpected mirrored archive %q: %v", data, err)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dest, "base", "Packages.gz"))
+	if err != nil {
+		t.Fatalf("read mirror index: %v", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("open mirror index: %v", err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompress mirror index: %v", err)
+	}
+	idx, err := repo.ParseIndex(config.Feed{Name: "mirror"}, plain)
+	if err != nil {
+		t.Fatalf("parse mirror index: %v", err)
+	}
+	if len(idx.Packages) != 1 || idx.Packages["tool"].Filename != "sub/tool.ipk" {
+		t.Fatalf("mirror index should list only the mirrored package, got %+v", idx.Packages)
+	}
+}
+
+func TestSourceFallsBackToStatusFeed(t *testing.T) {
+	m := newTestManager(t, config.Feed{Name: "base", URI: "http://example.invalid/base"})
+	m.status = statusFromText(t, "Package: legacy\nVersion: 1.0\nStatus: install ok installed\nFeed: base\n\n"+
+		"Package: unknown\nVersion: 1.0\nStatus: install ok installed\nFeed: gone\n")
+	m.setIndexes(nil)
+
+	feed, ok := m.Source("legacy")
+	if !ok || feed.URI != "http://example.invalid/base" {
+		t.Fatalf("unexpected source for legacy: %+v %t", feed, ok)
+	}
+	feed, ok = m.Source("unknown")
+	if !ok || feed.Name != "gone" || feed.URI != "" {
+		t.Fatalf("unexpected source for unknown: %+v %t", feed, ok)
+	}
+	if _, ok := m.Source("missing"); ok {
+		t.Fatalf("expected no source for missing package")
+	}
+}
+
+// statusFromText writes contents to a temporary status file and loads it.
+func statusFromText(t *testing.T, contents string) *pkgdb.Status {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "status")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("write status: %v", err)
+	}
+	status, err := pkgdb.Load(path)
+	if err != nil {
+		t.Fatalf("load status: %v", err)
+	}
+	return status
+}
+
+// newIndexedManager creates a test manager whose indexes are parsed from
+// packages instead of being fetched from a feed.
+func newIndexedManager(t *testing.T, packages string) *Manager {
+	t.Helper()
+	feed := config.Feed{Name: "base", URI: "http://example.invalid/base"}
+	m := newTestManager(t, feed)
+	idx, err := repo.ParseIndex(feed, []byte(packages))
+	if err != nil {
+		t.Fatalf("parse index: %v", err)
+	}
+	m.setIndexes([]repo.Index{*idx})
+	return m
+}
+
+func TestFindProvidersHonoursVersionedProvides(t *testing.T) {
+	m := newIndexedManager(t, "Package: openssl-compat\nVersion: 3.0\nProvides: libssl1.1 (= 1.1.1)\n\n"+
+		"Package: libssl-shim\nVersion: 1.0\nProvides: libssl1.1\n\n"+
+		"Package: libssl1.1\nVersion: 1.1.0\n\n"+
+		"Package: unrelated\nVersion: 1.0\n")
+	ctx := context.Background()
+
+	providers, err := m.FindProviders(ctx, "libssl1.1 (= 1.1.1)")
+	if err != nil {
+		t.Fatalf("FindProviders returned error: %v", err)
+	}
+	if len(providers) != 1 || providers[0].Name != "openssl-compat" {
+		t.Fatalf("unexpected providers for versioned capability: %+v", providers)
+	}
+
+	providers, err = m.FindProviders(ctx, "libssl1.1")
+	if err != nil {
+		t.Fatalf("FindProviders returned error: %v", err)
+	}
+	var names []string
+	for _, pkg := range providers {
+		names = append(names, pkg.Name)
+	}
+	if strings.Join(names, ",") != "libssl-shim,libssl1.1,openssl-compat" {
+		t.Fatalf("unexpected providers for bare capability: %v", names)
+	}
+}
+
+func TestCommonDependencies(t *testing.T) {
+	m := newIndexedManager(t, "Package: curl\nVersion: 1.0\nDepends: libcurl, zlib\n\n"+
+		"Package: libcurl\nVersion: 1.0\nDepends: libssl\n\n"+
+		"Package: wget\nVersion: 1.0\nDepends: libssl | gnutls, libpcre\n\n"+
+		"Package: libssl\nVersion: 1.0\n\n"+
+		"Package: zlib\nVersion: 1.0\n\n"+
+		"Package: libpcre\nVersion: 1.0\n")
+
+	deps, err := m.CommonDependencies([]string{"curl", "wget"})
+	if err != nil {
+		t.Fatalf("CommonDependencies returned error: %v", err)
+	}
+	if len(deps) != 1 || deps[0] != "libssl" {
+		t.Fatalf("expected [libssl], got %v", deps)
+	}
+	if _, err := m.CommonDependencies([]string{"curl", "missing"}); err == nil {
+		t.Fatalf("expected error for unknown package")
+	}
+}
+
+func TestUniqueDependencies(t *testing.T) {
+	m := newIndexedManager(t, "")
+	var status strings.Builder
+	for _, name := range []string{"alpha", "beta", "gamma"} {
+		fmt.Fprintf(&status, "Package: %s\nVersion: 1.0\nStatus: install ok installed\nDepends: libshared, lib%s\n\n", name, name)
+		fmt.Fprintf(&status, "Package: lib%s\nVersion: 1.0\nStatus: install ok installed\n\n", name)
+	}
+	status.WriteString("Package: libshared\nVersion: 1.0\nStatus: install ok installed\n")
+	m.status = statusFromText(t, status.String())
+
+	deps, err := m.UniqueDependencies("alpha")
+	if err != nil {
+		t.Fatalf("UniqueDependencies returned error: %v", err)
+	}
+	if len(deps) != 1 || deps[0] != "libalpha" {
+		t.Fatalf("expected [libalpha], got %v", deps)
+	}
+}
+
+func TestShortestDependencyPath(t *testing.T) {
+	m := newIndexedManager(t, "Package: A\nVersion: 1\nDepends: B, X\n\n"+
+		"Package: B\nVersion: 1\nDepends: C\n\n"+
+		"Package: C\nVersion: 1\nDepends: D, A\n\n"+
+		"Package: X\nVersion: 1\nDepends: Y\n\n"+
+		"Package: Y\nVersion: 1\nDepends: Z\n\n"+
+		"Package: Z\nVersion: 1\nDepends: D\n\n"+
+		"Package: D\nVersion: 1\n")
+
+	path, err := m.ShortestDependencyPath("A", "D")
+	if err != nil {
+		t.Fatalf("ShortestDependencyPath returned error: %v", err)
+	}
+	if strings.Join(path, ",") != "A,B,C,D" {
+		t.Fatalf("unexpected path %v", path)
+	}
+	if path, err := m.ShortestDependencyPath("D", "A"); err != nil || path != nil {
+		t.Fatalf("expected no path from D to A, got %v (%v)", path, err)
+	}
+
+	paths, err := m.AllDependencyPaths("A", "D")
+	if err != nil {
+		t.Fatalf("AllDependencyPaths returned error: %v", err)
+	}
+	if len(paths) != 2 || strings.Join(paths[1], ",") != "A,X,Y,Z,D" {
+		t.Fatalf("unexpected paths %v", paths)
+	}
+}
+
+func TestInstallReportsChecksumMismatch(t *testing.T) {
+	// The correct SHA256 is ignored in favour of the stronger SHA512.
+	sum := sha256.Sum256([]byte("tool-archive"))
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: tool\nVersion: 1.0\nFilename: tool.ipk\nSHA256sum: " + hex.EncodeToString(sum[:]) +
+			"\nSHA512sum: " + strings.Repeat("0", 128) + "\n",
+		"/base/tool.ipk": "tool-archive",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base"})
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	_, err := m.Install(ctx, "tool")
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) || checksumErr.Package != "tool" {
+		t.Fatalf("expected ChecksumError, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(m.cache, "tool.ipk")); !os.IsNotExist(err) {
+		t.Fatalf("expected mismatching archive to be discarded, stat error: %v", err)
+	}
+	var notFound *PackageNotFoundError
+	if _, err := m.Install(ctx, "missing"); !errors.As(err, &notFound) {
+		t.Fatalf("expected PackageNotFoundError, got %v", err)
+	}
+}
+
+func TestUpdateWithEvents(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: busybox\nVersion: 1.36\n",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+	events, err := m.UpdateWithEvents(context.Background())
+	if err != nil {
+		t.Fatalf("UpdateWithEvents returned error: %v", err)
+	}
+	var statuses []string
+	for ev := range events {
+		if ev.Err != nil {
+			t.Fatalf("unexpected error event %+v", ev)
+		}
+		statuses = append(statuses, ev.Feed.Name+" "+ev.Status)
+	}
+	if want := []string{"base " + FeedFetching, "base " + FeedDone}; !reflect.DeepEqual(statuses, want) {
+		t.Fatalf("got events %v, want %v", statuses, want)
+	}
+	if _, ok := m.findPackage("busybox"); !ok {
+		t.Fatalf("indexes were not replaced after the update")
+	}
+}
+
+func TestUpdateReportsErrorWithoutFeedEvent(t *testing.T) {
+	feed := config.Feed{Name: "base", URI: "http://example.invalid/base"}
+	m := newTestManager(t, feed)
+	WithNoNetwork()(m)
+	if err := os.WriteFile(repo.CachedIndexPath(m.cache, feed), []byte("Package: busybox\nVersion: 1.36\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The cancelled context stops the update before any feed is read, so
+	// no feed event carries the error.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Update(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	events, err := m.UpdateWithEvents(ctx)
+	if err != nil {
+		t.Fatalf("UpdateWithEvents returned error: %v", err)
+	}
+	var got []FeedEvent
+	for ev := range events {
+		got = append(got, ev)
+	}
+	if len(got) != 1 || got[0].Status != FeedError || got[0].Feed.Name != "" || !errors.Is(got[0].Err, context.Canceled) {
+		t.Fatalf("expected one final error event, got %+v", got)
+	}
+}
+
+func TestNoNetworkUsesCacheOnly(t *testing.T) {
+	feed := config.Feed{Name: "base", URI: "http://example.invalid/base"}
+	m := newTestManager(t, feed)
+	WithNoNetwork()(m)
+	ctx := context.Background()
+
+	err := m.Update(ctx)
+	if err == nil || !strings.Contains(err.Error(), "pre-populated") {
+		t.Fatalf("expected error about missing cached index, got %v", err)
+	}
+
+	data := "Package: tool\nVersion: 1.0\nFilename: tool.ipk\n\nPackage: other\nVersion: 1.0\nFilename: other.ipk\n"
+	if err := os.WriteFile(repo.CachedIndexPath(m.cache, feed), []byte(data), 0o644); err != nil {
+		t.Fatalf("write cached index: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(m.cache, "tool.ipk"), []byte("tool"), 0o644); err != nil {
+		t.Fatalf("write cached archive: %v", err)
+	}
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	if dest, err := m.Install(ctx, "tool"); err != nil || dest != filepath.Join(m.cache, "tool.ipk") {
+		t.Fatalf("expected cached archive, got %q: %v", dest, err)
+	}
+	if _, err := m.Install(ctx, "other"); err == nil {
+		t.Fatalf("expected Install to fail for an uncached archive")
+	}
+	if results := m.CheckFeeds(ctx); len(results) != 1 || !errors.Is(results[0].FetchError, downloader.ErrOffline) {
+		t.Fatalf("expected CheckFeeds to be refused, got %+v", results)
+	}
+}
+
+func TestWithDestInstallsIntoDestination(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: tool\nVersion: 1.0\nFilename: tool.ipk\n",
+		"/base/tool.ipk": "tool-archive",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base"})
+	usb := t.TempDir()
+	m.cfg.Destinations = []config.Destination{{Name: "usb", Path: usb}}
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	scoped, err := m.WithDest("usb")
+	if err != nil {
+		t.Fatalf("WithDest returned error: %v", err)
+	}
+	if _, err := m.WithDest("missing"); err == nil {
+		t.Fatalf("expected error for unknown destination")
+	}
+	dest, err := scoped.Install(ctx, "tool")
+	if err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	if want := filepath.Join(usb, m.cache, "tool.ipk"); dest != want {
+		t.Fatalf("scoped install wrote %s, want %s", dest, want)
+	}
+	if _, err := os.Stat(filepath.Join(m.cache, "tool.ipk")); !os.IsNotExist(err) {
+		t.Fatalf("expected default cache to stay untouched, stat error: %v", err)
+	}
+	if got, want := scoped.Status().Path(), filepath.Join(usb, "usr/lib/opkg/status"); got != want {
+		t.Fatalf("scoped status path %s, want %s", got, want)
+	}
+}
+
+func TestWithArchFiltersPackages(t *testing.T) {
+	arm := config.Feed{Name: "armv7a", URI: "http://example.invalid/armv7a"}
+	arm64 := config.Feed{Name: "aarch64", URI: "http://example.invalid/aarch64"}
+	m := newTestManager(t, arm, arm64)
+	m.cfg.Architectures = []config.Architecture{{Name: "armv7a", Priority: 10}}
+	var indexes []repo.Index
+	for feed, data := range map[config.Feed]string{
+		arm:   "Package: tool\nVersion: 1.0\nArchitecture: armv7a\n\nPackage: armonly\nVersion: 1.0\nArchitecture: armv7a\n",
+		arm64: "Package: tool\nVersion: 1.0\nArchitecture: aarch64\n\nPackage: noarch\nVersion: 1.0\nArchitecture: all\n",
+	} {
+		idx, err := repo.ParseIndex(feed, []byte(data))
+		if err != nil {
+			t.Fatalf("parse index: %v", err)
+		}
+		indexes = append(indexes, *idx)
+	}
+	m.setIndexes(indexes)
+
+	scoped := m.WithArch("aarch64")
+	if pkg, ok := scoped.findPackage("tool"); !ok || pkg.Architecture != "aarch64" {
+		t.Fatalf("expected aarch64 tool, got %+v", pkg)
+	}
+	if _, ok := scoped.findPackage("armonly"); ok {
+		t.Fatalf("did not expect armv7a package in aarch64 view")
+	}
+	lines, err := scoped.ListPackages(ListOptions{})
+	if err != nil {
+		t.Fatalf("ListPackages returned error: %v", err)
+	}
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "noarch ") || !strings.HasPrefix(lines[1], "tool ") {
+		t.Fatalf("unexpected scoped listing %v", lines)
+	}
+	paragraphs, err := scoped.InfoParagraphs([]string{"*"})
+	if err != nil {
+		t.Fatalf("InfoParagraphs returned error: %v", err)
+	}
+	for _, p := range paragraphs {
+		if p.Value("Architecture") == "armv7a" {
+			t.Fatalf("unexpected armv7a paragraph %+v", p)
+		}
+	}
+	if pkg, ok := m.findPackage("tool"); !ok || pkg.Architecture != "armv7a" {
+		t.Fatalf("expected unscoped manager to keep preferring armv7a, got %+v", pkg)
+	}
+	if got := scoped.Architectures(); len(got) != len(m.Architectures()) {
+		t.Fatalf("WithArch changed configured architectures: %+v", got)
+	}
+}
+
+func TestWithArchPrefersDeclaredCompatibleArchitectures(t *testing.T) {
+	feeds := []config.Feed{
+		{Name: "i386", URI: "http://example.invalid/i386"},
+		{Name: "i686", URI: "http://example.invalid/i686"},
+	}
+	m := newTestManager(t, feeds...)
+	m.cfg.Architectures = []config.Architecture{{Name: "i386", Priority: 20}, {Name: "i686", Priority: 10}}
+	var indexes []repo.Index
+	for _, feed := range feeds {
+		idx, err := repo.ParseIndex(feed, []byte("Package: tool\nVersion: 1.0\nArchitecture: "+feed.Name+"\n"))
+		if err != nil {
+			t.Fatalf("parse index: %v", err)
+		}
+		indexes = append(indexes, *idx)
+	}
+	m.setIndexes(indexes)
+
+	if pkg, ok := m.WithArch("x86_64").findPackage("tool"); !ok || pkg.Architecture != "i686" {
+		t.Fatalf("expected the i686 tool, got %+v", pkg)
+	}
+}
+
+func TestResetReloadsConfiguration(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages":  "Package: tool\nVersion: 1.0\n",
+		"/extra/Packages": "Package: extra-tool\nVersion: 1.0\n",
+	})
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "opkg.conf")
+	data := fmt.Sprintf("option status_file %s\noption cache_dir %s\nsrc base %s/base\n",
+		filepath.Join(dir, "status"), filepath.Join(dir, "cache"), srv.URL)
+	if err := os.WriteFile(conf, []byte(data), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	m, err := New(conf)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	data += fmt.Sprintf("src extra %s/extra\n", srv.URL)
+	if err := os.WriteFile(conf, []byte(data), 0o644); err != nil {
+		t.Fatalf("rewrite config: %v", err)
+	}
+	if err := m.Reset(); err != nil {
+		t.Fatalf("Reset returned error: %v", err)
+	}
+	if m.IndexesLoaded() {
+		t.Fatalf("expected indexes to be discarded")
+	}
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	if _, ok := m.findPackage("extra-tool"); !ok {
+		t.Fatalf("expected package from the new feed after Reset")
+	}
+}
+
+func TestInstallRequiresStatusFile(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: tool\nVersion: 1.0\nFilename: tool.ipk\n",
+		"/base/tool.ipk": "tool",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+	m.status = pkgdb.Empty()
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	if _, err := m.Install(ctx, "tool"); err == nil {
+		t.Fatal("expected Install to fail without a status file")
+	}
+}
+
+func TestInstallRejectsVirtualPackage(t *testing.T) {
+	m := newIndexedManager(t, "Package: virtual-editor\nVersion: 1.0\n\n"+
+		"Package: vim\nVersion: 9.0\nFilename: vim.ipk\nProvides: virtual-editor\n")
+
+	_, err := m.Install(context.Background(), "virtual-editor")
+	var verr *VirtualPackageError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected VirtualPackageError, got %v", err)
+	}
+	want := "package virtual-editor is virtual; try: opkg whatprovides virtual-editor (provided by: vim)"
+	if err.Error() != want {
+		t.Fatalf("unexpected error message %q", err.Error())
+	}
+}
+
+func TestJSONOutputRoundTrip(t *testing.T) {
+	m := newIndexedManager(t, "Package: busybox\nVersion: 1.36.1\nArchitecture: armv7\nSize: 512\nDescription: tiny utilities\n\n"+
+		"Package: zlib\nVersion: 1.3\nArchitecture: armv7\nDescription: compression\n")
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.35.0\nArchitecture: armv7\nStatus: install ok installed\n")
+
+	pkgs, err := m.ListPackagesJSON(ListOptions{})
+	if err != nil {
+		t.Fatalf("ListPackagesJSON returned error: %v", err)
+	}
+	data, err := json.Marshal(pkgs)
+	if err != nil {
+		t.Fatalf("marshal packages: %v", err)
+	}
+	var decoded []jsonout.PackageJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal packages: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, pkgs) {
+		t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", decoded, pkgs)
+	}
+	want := jsonout.PackageJSON{Name: "busybox", Version: "1.36.1", Architecture: "armv7", Description: "tiny utilities", Size: "512", Feed: "base", Installed: true}
+	if len(decoded) != 2 || decoded[0] != want || decoded[1].Installed {
+		t.Fatalf("unexpected packages %+v", decoded)
+	}
+
+	upgrades, err := m.ListUpgradableJSON(nil)
+	if err != nil {
+		t.Fatalf("ListUpgradableJSON returned error: %v", err)
+	}
+	data, err = json.Marshal(upgrades)
+	if err != nil {
+		t.Fatalf("marshal upgrades: %v", err)
+	}
+	if !strings.Contains(string(data), `"change":"minor"`) {
+		t.Fatalf("expected minor change in %s", data)
+	}
+	var decodedUpgrades []jsonout.UpgradeCandidateJSON
+	if err := json.Unmarshal(data, &decodedUpgrades); err != nil || !reflect.DeepEqual(decodedUpgrades, upgrades) {
+		t.Fatalf("upgrade round trip mismatch: %v %+v", err, decodedUpgrades)
+	}
+
+	data, err = json.Marshal(m.StatusJSON(nil))
+	if err != nil {
+		t.Fatalf("marshal status: %v", err)
+	}
+	if got := string(data); got != `[{"name":"busybox","version":"1.35.0","architecture":"armv7","status":"install ok installed"}]` {
+		t.Fatalf("unexpected status JSON %s", got)
+	}
+}
+
+func TestInfoParagraphsCacheFollowsIndexes(t *testing.T) {
+	m := newTestManager(t)
+	parse := func(feed, data string) repo.Index {
+		idx, err := repo.ParseIndex(config.Feed{Name: feed}, []byte(data))
+		if err != nil {
+			t.Fatalf("parse index: %v", err)
+		}
+		return *idx
+	}
+	m.setIndexes([]repo.Index{
+		parse("base", "Package: tool\nVersion: 1.0\n\nPackage: zlib\nVersion: 1.3\n"),
+		parse("extra", "Package: tool\nVersion: 2.0\n"),
+	})
+
+	for i := 0; i < 2; i++ {
+		paragraphs, err := m.InfoParagraphs([]string{"*"})
+		if err != nil {
+			t.Fatalf("InfoParagraphs returned error: %v", err)
+		}
+		want, _ := m.findPackage("tool")
+		if len(paragraphs) != 2 || paragraphs[0].Value("Package") != "tool" || paragraphs[1].Value("Package") != "zlib" {
+			t.Fatalf("unexpected paragraphs %+v", paragraphs)
+		}
+		if paragraphs[0].Value("Version") != want.Version {
+			t.Fatalf("expected the version findPackage selects (%s), got %s", want.Version, paragraphs[0].Value("Version"))
+		}
+	}
+
+	m.setIndexes([]repo.Index{parse("base", "Package: tool\nVersion: 3.0\n")})
+	paragraphs, err := m.InfoParagraphs([]string{"tool", "zlib"})
+	if err != nil {
+		t.Fatalf("InfoParagraphs returned error: %v", err)
+	}
+	if len(paragraphs) != 1 || paragraphs[0].Value("Version") != "3.0" {
+		t.Fatalf("expected cache to be dropped after index change, got %+v", paragraphs)
+	}
+}
+
+func TestListPackagesLoadsCachedIndexes(t *testing.T) {
+	base := config.Feed{Name: "base", URI: "http://example.invalid/base"}
+	m := newTestManager(t, base, config.Feed{Name: "extra", URI: "http://example.invalid/extra"})
+	if _, err := m.ListPackages(ListOptions{}); !errors.Is(err, errNotLoaded) {
+		t.Fatalf("expected errNotLoaded without a cache, got %v", err)
+	}
+
+	path := repo.CachedIndexPath(m.cache, base)
+	if err := os.WriteFile(path, []byte("Package: busybox\nVersion: 1.36.1\nDescription: tiny utilities\n"), 0o644); err != nil {
+		t.Fatalf("write cached index: %v", err)
+	}
+	stamp := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, stamp, stamp); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	lines, err := m.ListPackages(ListOptions{})
+	if err != nil {
+		t.Fatalf("ListPackages returned error: %v", err)
+	}
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "busybox - tiny utilities") {
+		t.Fatalf("unexpected listing %v", lines)
+	}
+	if !m.IndexesLoaded() || !m.LastUpdated().Equal(stamp) {
+		t.Fatalf("expected cached indexes dated %v, got loaded=%v at %v", stamp, m.IndexesLoaded(), m.LastUpdated())
+	}
+}
+
+func TestConcurrentUpdateAndQueries(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: busybox\nVersion: 1.36.1\nFilename: busybox.ipk\nDescription: tiny utilities\n",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if err := m.Update(ctx); err != nil {
+					errs <- err
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if _, err := m.ListPackages(ListOptions{}); err != nil {
+					errs <- err
+				}
+				if _, err := m.FindPackages(context.Background(), "busybox"); err != nil {
+					errs <- err
+				}
+				if _, err := m.InfoParagraphs([]string{"*"}); err != nil {
+					errs <- err
+				}
+				m.Status().Installed("busybox")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent call failed: %v", err)
+	}
+}
+
+func TestInstallMultipleReportsEachPackage(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: first\nVersion: 1.0\nFilename: first.ipk\n\n" +
+			"Package: second\nVersion: 1.0\nFilename: second.ipk\n\n" +
+			"Package: third\nVersion: 1.0\nFilename: third.ipk\n",
+		"/base/first.ipk": "first",
+		"/base/third.ipk": "third",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	names := []string{"first", "second", "third", "missing"}
+
+	results, err := m.InstallMultipleWith(ctx, names, InstallOptions{Workers: 2, AllowPartial: true})
+	if err == nil {
+		t.Fatalf("expected combined error")
+	}
+	var notFound *PackageNotFoundError
+	if !errors.As(err, &notFound) || notFound.Name != "missing" {
+		t.Fatalf("expected PackageNotFoundError in %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %+v", results)
+	}
+	for i, res := range results {
+		if res.Name != names[i] {
+			t.Fatalf("result %d is for %s", i, res.Name)
+		}
+	}
+	if results[1].Err == nil || results[3].Err == nil {
+		t.Fatalf("expected second and missing to fail: %+v", results)
+	}
+	for _, i := range []int{0, 2} {
+		if results[i].Err != nil {
+			t.Fatalf("%s failed: %v", results[i].Name, results[i].Err)
+		}
+		if _, err := os.Stat(results[i].Dest); err != nil {
+			t.Fatalf("expected %s to be kept with AllowPartial: %v", results[i].Dest, err)
+		}
+	}
+
+	results, err = m.InstallMultiple(ctx, names)
+	if err == nil {
+		t.Fatalf("expected combined error")
+	}
+	if results[0].Err != nil || results[0].Dest == "" {
+		t.Fatalf("expected first to be reported as downloaded: %+v", results[0])
+	}
+	if _, err := os.Stat(results[0].Dest); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed after the failed batch, got %v", results[0].Dest, err)
+	}
+}
+
+func TestInstallMultipleRecordsStatus(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/base/Packages":
+			fmt.Fprint(w, "Package: first\nVersion: 1.0\nFilename: first.ipk\n\n"+
+				"Package: second\nVersion: 1.0\nFilename: second.ipk\n\n"+
+				"Package: third\nVersion: 1.0\nFilename: third.ipk\n")
+		case "/base/first.ipk":
+			requests.Add(1)
+			fmt.Fprint(w, "first")
+		case "/base/third.ipk":
+			fmt.Fprint(w, "third")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+	m.status = pkgdb.EmptyAt(filepath.Join(t.TempDir(), "status"))
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	// A batch that fails records nothing.
+	if _, err := m.InstallMultiple(ctx, []string{"first", "second"}); err == nil {
+		t.Fatalf("expected the batch to fail")
+	}
+	if len(m.Status().Entries()) != 0 {
+		t.Fatalf("failed batch recorded %+v", m.Status().Entries())
+	}
+
+	// AllowPartial records the packages that were downloaded.
+	if _, err := m.InstallMultipleWith(ctx, []string{"second", "third"}, InstallOptions{AllowPartial: true}); err == nil {
+		t.Fatalf("expected second to fail")
+	}
+	if !m.Status().Installed("third") || m.Status().Installed("second") {
+		t.Fatalf("unexpected entries %+v", m.Status().Entries())
+	}
+
+	requests.Store(0)
+	results, err := m.InstallMultiple(ctx, []string{"first", "first"})
+	if err != nil || len(results) != 1 || results[0].Name != "first" {
+		t.Fatalf("expected one result for the repeated name, got %+v, %v", results, err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("first.ipk was requested %d times", n)
+	}
+	reloaded, err := pkgdb.Load(m.Status().Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := reloaded.Lookup("first"); err != nil || !entry.IsFullyInstalled() || entry.Raw.Value("Feed") != "base" {
+		t.Fatalf("first not recorded: %+v (%v)", entry, err)
+	}
+}
+
+func TestWithWorkersFetchesFeedsSequentially(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		order    []string
+		inFlight int
+		overlap  bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > 1 {
+			overlap = true
+		}
+		order = append(order, path.Dir(r.URL.Path))
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		if path.Base(r.URL.Path) != "Packages" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "Package: tool%s\nVersion: 1.0\n", path.Dir(r.URL.Path)[1:])
+	}))
+	t.Cleanup(srv.Close)
+
+	var feeds []config.Feed
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("feed%d", i)
+		feeds = append(feeds, config.Feed{Name: name, URI: srv.URL + "/" + name})
+	}
+	m := newTestManager(t, feeds...)
+	WithWorkers(1)(m)
+	if err := m.Update(context.Background()); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	if overlap {
+		t.Fatalf("expected no concurrent requests with one worker, order %v", order)
+	}
+	// Each feed requests Packages.gz and then Packages; sequential fetching
+	// keeps both requests of a feed next to each other.
+	if len(order) != 2*len(feeds) {
+		t.Fatalf("unexpected requests %v", order)
+	}
+	for i := 0; i < len(order); i += 2 {
+		if order[i] != order[i+1] {
+			t.Fatalf("requests of different feeds interleaved: %v", order)
+		}
+	}
+}
+
+func TestListInstalledVersions(t *testing.T) {
+	m := newTestManager(t)
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.36.1-r0\nStatus: install ok installed\n\n"+
+		"Package: removed\nVersion: 1.0\nStatus: deinstall ok config-files\n")
+	versions, err := m.ListInstalledVersions()
+	if err != nil {
+		t.Fatalf("ListInstalledVersions returned error: %v", err)
+	}
+	if len(versions) != 1 || versions["busybox"] != "1.36.1-r0" {
+		t.Fatalf("unexpected versions %v", versions)
+	}
+}
+
+func TestListInstalledVersionsWithFilters(t *testing.T) {
+	m := newTestManager(t)
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.36.1-r0\nSection: base\nMaintainer: Jane <jane@example.invalid>\nStatus: install ok installed\n\n"+
+		"Package: busybox-syslog\nVersion: 1.36.1-r0\nSection: admin\nStatus: install ok installed\n\n"+
+		"Package: zlib\nVersion: 1.3\nSection: libs\nStatus: install ok installed\n")
+	for _, tc := range []struct {
+		opts ListOptions
+		want map[string]string
+	}{
+		{ListOptions{Patterns: []string{"busybox*"}}, map[string]string{"busybox": "1.36.1-r0", "busybox-syslog": "1.36.1-r0"}},
+		{ListOptions{Patterns: []string{"busybox*"}, Sections: []string{"admin"}}, map[string]string{"busybox-syslog": "1.36.1-r0"}},
+		{ListOptions{MaintainerPattern: "Jane*"}, map[string]string{"busybox": "1.36.1-r0"}},
+	} {
+		versions, err := m.ListInstalledVersionsWith(tc.opts)
+		if err != nil {
+			t.Fatalf("ListInstalledVersionsWith returned error: %v", err)
+		}
+		if !reflect.DeepEqual(versions, tc.want) {
+			t.Errorf("ListInstalledVersionsWith(%+v) = %v, want %v", tc.opts, versions, tc.want)
+		}
+	}
+}
+
+func TestGlobStatusEntriesMatchParagraphs(t *testing.T) {
+	m := newTestManager(t)
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.36.1-r0\nArchitecture: armv7a\nStatus: install ok installed\n\n"+
+		"Package: busybox-syslog\nVersion: 1.36.1-r0\nStatus: install ok installed\n\n"+
+		"Package: zlib\nVersion: 1.3\nStatus: install ok installed\n")
+
+	entries := m.GlobStatusEntries([]string{"busybox*"})
+	paragraphs := m.GlobStatusParagraphs([]string{"busybox*"})
+	if len(entries) != 2 || len(paragraphs) != len(entries) {
+		t.Fatalf("unexpected results: %+v %+v", entries, paragraphs)
+	}
+	for i, entry := range entries {
+		p := paragraphs[i]
+		if entry.Name != p.Value("Package") || entry.Version != p.Value("Version") ||
+			entry.Architecture != p.Value("Architecture") || entry.Status != p.Value("Status") {
+			t.Fatalf("entry %+v does not match paragraph %+v", entry, p.Fields)
+		}
+	}
+	if entries[0].Architecture != "armv7a" || entries[0].Status != "install ok installed" {
+		t.Fatalf("unexpected typed entry %+v", entries[0])
+	}
+}
+
+func TestDependencyOrderForRemoval(t *testing.T) {
+	m := newTestManager(t)
+	m.status = statusFromText(t, "Package: app\nVersion: 1.0\nDepends: libfoo\nStatus: install ok installed\n\n"+
+		"Package: libfoo\nVersion: 1.0\nDepends: libc\nStatus: install ok installed\n\n"+
+		"Package: libc\nVersion: 1.0\nStatus: install ok installed\n\n"+
+		"Package: tool\nVersion: 1.0\nDepends: libc | musl\nStatus: install ok installed\n")
+
+	if _, err := m.DependencyOrderForRemoval([]string{"libc", "app", "libfoo"}); err == nil || !strings.Contains(err.Error(), "tool (Depends: libc | musl)") {
+		t.Fatalf("expected tool to be reported as broken, got %v", err)
+	}
+
+	order, err := m.DependencyOrderForRemoval([]string{"libfoo", "app"})
+	if err != nil {
+		t.Fatalf("DependencyOrderForRemoval returned error: %v", err)
+	}
+	if strings.Join(order, ",") != "app,libfoo" {
+		t.Fatalf("unexpected order %v", order)
+	}
+
+	m.status = statusFromText(t, "Package: app\nVersion: 1.0\nDepends: libfoo\nStatus: install ok installed\n\n"+
+		"Package: libfoo\nVersion: 1.0\nDepends: libc\nStatus: install ok installed\n\n"+
+		"Package: libc\nVersion: 1.0\nStatus: install ok installed\n")
+	order, err = m.DependencyOrderForRemoval([]string{"libc", "libfoo", "app"})
+	if err != nil {
+		t.Fatalf("DependencyOrderForRemoval returned error: %v", err)
+	}
+	if strings.Join(order, ",") != "app,libfoo,libc" {
+		t.Fatalf("expected the leaf app to be removed first, got %v", order)
+	}
+	if _, err := m.DependencyOrderForRemoval([]string{"libfoo"}); err == nil {
+		t.Fatalf("expected removing libfoo alone to break app")
+	}
+	if _, err := m.DependencyOrderForRemoval([]string{"missing"}); err == nil {
+		t.Fatalf("expected error for a package that is not installed")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	m := newIndexedManager(t, "Package: libssl1.1\nVersion: 1.1.1w\nDepends: libc6, zlib\nProvides: openssl-libs\nConflicts: libssl3\nSize: 2048000\n\n"+
+		"Package: curl\nVersion: 8.4\nDepends: libssl1.1, libc6\n\n"+
+		"Package: openssh\nVersion: 9.5\nDepends: libssl1.1\n\n"+
+		"Package: git\nVersion: 2.42\nDepends: curl\n\n"+
+		"Package: libc6\nVersion: 2.38\n\nPackage: zlib\nVersion: 1.3\n")
+	m.status = statusFromText(t, "Package: libssl1.1\nVersion: 1.1.1w\nStatus: install ok installed\nDepends: libc6, zlib\n\n"+
+		"Package: curl\nVersion: 8.4\nStatus: install ok installed\nDepends: libssl1.1, libc6\n\n"+
+		"Package: openssh\nVersion: 9.5\nStatus: install ok installed\nDepends: libssl1.1\n\n"+
+		"Package: git\nVersion: 2.42\nStatus: install ok installed\nDepends: curl\n")
+
+	out, err := m.Explain("libssl1.1")
+	if err != nil {
+		t.Fatalf("Explain returned error: %v", err)
+	}
+	for _, want := range []string{
+		"libssl1.1 1.1.1w\n",
+		"  Installed: yes\n",
+		"  Depends on: libc6, zlib\n",
+		"  Required by 2 installed packages: curl, openssh\n",
+		"    and indirectly by 1 more: git\n",
+		"  Provides: openssl-libs\n",
+		"  Conflicts with: libssl3\n",
+		"  Estimated size: 2000 kB\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Explain output lacks %q:\n%s", want, out)
+		}
+	}
+	if _, err := m.Explain("missing"); err == nil {
+		t.Fatalf("expected error for unknown package")
+	}
+}
+
+func TestListUpgradableUsesNewestFeed(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages":  "Package: busybox\nVersion: 1.36.0\nDescription: base build\n",
+		"/extra/Packages": "Package: busybox\nVersion: 1.37.0\nDescription: extra build\n",
+	})
+	base := config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"}
+	extra := config.Feed{Name: "extra", URI: srv.URL + "/extra", Type: "src"}
+	m := newTestManager(t, base, extra)
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.35.0\nStatus: install ok installed\n")
+	if err := m.Update(context.Background()); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	upgrades, err := m.ListUpgradable(nil)
+	if err != nil {
+		t.Fatalf("ListUpgradable returned error: %v", err)
+	}
+	if len(upgrades) != 1 || upgrades[0].Package.Version != "1.37.0" || upgrades[0].Package.Feed != extra {
+		t.Fatalf("ListUpgradable = %+v, want the package from extra", upgrades)
+	}
+	upgrades[0].Package = repo.Package{}
+	want := []UpgradeCandidate{{Name: "busybox", Installed: "1.35.0", Available: "1.37.0", Description: "extra build", Feed: extra, ChangeType: version.MinorChange}}
+	if !reflect.DeepEqual(upgrades, want) {
+		t.Fatalf("ListUpgradable = %+v, want %+v", upgrades, want)
+	}
+}
+
+// newStallingFeedServer serves feeds whose index never arrives; requests
+// only return once the client gives up.
+func newStallingFeedServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestUpdateCancelledMidDownload(t *testing.T) {
+	srv := newStallingFeedServer(t)
+	m := newTestManager(t,
+		config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"},
+		config.Feed{Name: "extra", URI: srv.URL + "/extra", Type: "src"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	err := m.Update(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancelled context to abort the update, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("update kept running for %v after cancellation", elapsed)
+	}
+	if m.IndexesLoaded() {
+		t.Fatal("indexes loaded by an aborted update")
+	}
+}
+
+func TestWithUpdateTimeout(t *testing.T) {
+	srv := newStallingFeedServer(t)
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+	WithUpdateTimeout(100 * time.Millisecond)(m)
+
+	start := time.Now()
+	if err := m.Update(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the update timeout to abort the update, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("update took %v despite a 100ms timeout", elapsed)
+	}
+}
+
+func TestCleanStaleAndOrphaned(t *testing.T) {
+	m := newIndexedManager(t, "Package: busybox\nVersion: 1.36.1\nFilename: busybox_1.36.1_armv7a.ipk\n")
+	old := time.Now().Add(-10 * 24 * time.Hour)
+	files := map[string]time.Time{
+		"busybox_1.36.1_armv7a.ipk": old,
+		"busybox_1.35.0_armv7a.ipk": time.Now(),
+		"zlib_1.3_armv7a.ipk":       old,
+		"base.Packages":             time.Now(),
+	}
+	for name, mtime := range files {
+		path := filepath.Join(m.cache, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cached := func() []string {
+		entries, err := os.ReadDir(m.cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+
+	if err := m.CleanStale(7 * 24 * time.Hour); err != nil {
+		t.Fatalf("CleanStale returned error: %v", err)
+	}
+	if got, want := cached(), []string{"base.Packages", "busybox_1.35.0_armv7a.ipk"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after CleanStale cache holds %v, want %v", got, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(m.cache, "busybox_1.36.1_armv7a.ipk"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The archives of a feed without an index cannot be told apart from
+	// orphaned ones.
+	m.cfg.Feeds = append(m.cfg.Feeds, config.Feed{Name: "extra", URI: "http://example.invalid/extra"})
+	if err := m.CleanOrphaned(); err == nil {
+		t.Fatal("expected CleanOrphaned to refuse an unloaded feed")
+	}
+	if got := cached(); len(got) != 3 {
+		t.Fatalf("CleanOrphaned removed files despite failing: %v", got)
+	}
+	m.cfg.Feeds = m.cfg.Feeds[:1]
+	if err := m.CleanOrphaned(); err != nil {
+		t.Fatalf("CleanOrphaned returned error: %v", err)
+	}
+	if got, want := cached(), []string{"base.Packages", "busybox_1.36.1_armv7a.ipk"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after CleanOrphaned cache holds %v, want %v", got, want)
+	}
+}
+
+func TestListSections(t *testing.T) {
+	m := newIndexedManager(t, "Package: curl\nVersion: 8.0\nSection: net\n\n"+
+		"Package: wget\nVersion: 1.21\nSection: net\n\n"+
+		"Package: zlib\nVersion: 1.3\nSection: libs\n\n"+
+		"Package: busybox\nVersion: 1.36\nSection: utils\n\n"+
+		"Package: nosection\nVersion: 1.0\n")
+	m.status = statusFromText(t, "Package: zlib\nVersion: 1.3\nSection: libs\nStatus: install ok installed\n\n"+
+		"Package: busybox\nVersion: 1.36\nSection: utils\nStatus: install ok installed\n")
+
+	sections, err := m.ListSections()
+	if err != nil {
+		t.Fatalf("ListSections returned error: %v", err)
+	}
+	if want := []string{"libs", "net", "utils"}; !reflect.DeepEqual(sections, want) {
+		t.Fatalf("ListSections = %v, want %v", sections, want)
+	}
+
+	names := func(opts ListOptions) []string {
+		t.Helper()
+		pkgs, err := m.ListPackagesJSON(opts)
+		if err != nil {
+			t.Fatalf("ListPackagesJSON returned error: %v", err)
+		}
+		var out []string
+		for _, pkg := range pkgs {
+			out = append(out, pkg.Name)
+		}
+		return out
+	}
+	if got, want := names(ListOptions{Sections: []string{"net"}}), []string{"curl", "wget"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("net section lists %v, want %v", got, want)
+	}
+	if got, want := names(ListOptions{Sections: []string{"libs", "utils"}}), []string{"busybox", "zlib"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("libs and utils sections list %v, want %v", got, want)
+	}
+	if got, want := names(ListOptions{InstalledOnly: true, Sections: []string{"utils"}}), []string{"busybox"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("installed utils section lists %v, want %v", got, want)
+	}
+	lines, err := m.ListPackages(ListOptions{Sections: []string{"libs"}})
+	if err != nil || len(lines) != 1 || !strings.HasPrefix(lines[0], "zlib - ") {
+		t.Fatalf("ListPackages for libs = %q, %v", lines, err)
+	}
+	lines, err = m.ListPackages(ListOptions{InstalledOnly: true, Sections: []string{"net"}})
+	if err != nil || len(lines) != 0 {
+		t.Fatalf("no installed package is in net, got %q, %v", lines, err)
+	}
+}
+
+func TestListMaintainers(t *testing.T) {
+	m := newIndexedManager(t, "Package: curl\nVersion: 8.0\nMaintainer: Net Team <net@example.invalid>\n\n"+
+		"Package: wget\nVersion: 1.21\nMaintainer: Net Team <net@example.invalid>\n\n"+
+		"Package: zlib\nVersion: 1.3\nMaintainer: Core Team <core@example.invalid>\n\n"+
+		"Package: orphan\nVersion: 1.0\n")
+
+	maintainers, err := m.ListMaintainers()
+	if err != nil {
+		t.Fatalf("ListMaintainers returned error: %v", err)
+	}
+	if want := []string{"Core Team <core@example.invalid>", "Net Team <net@example.invalid>"}; !reflect.DeepEqual(maintainers, want) {
+		t.Fatalf("ListMaintainers = %v, want %v", maintainers, want)
+	}
+
+	for pattern, want := range map[string][]string{
+		"Net Team*":     {"curl", "wget"},
+		"*<core@*":      {"zlib"},
+		"Nobody*":       nil,
+		"*<*example*>*": {"curl", "wget", "zlib"},
+	} {
+		pkgs, err := m.ListPackagesJSON(ListOptions{MaintainerPattern: pattern})
+		if err != nil {
+			t.Fatalf("ListPackagesJSON returned error: %v", err)
+		}
+		var names []string
+		for _, pkg := range pkgs {
+			names = append(names, pkg.Name)
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("maintainer %q lists %v, want %v", pattern, names, want)
+		}
+	}
+}
+
+func TestInfoParagraphsIncludeInstalledStatus(t *testing.T) {
+	m := newIndexedManager(t, "Package: busybox\nVersion: 1.36.1\n\nPackage: zlib\nVersion: 1.3\n")
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.35.0\nStatus: install ok installed\n")
+
+	paragraphs, err := m.InfoParagraphs([]string{"*"})
+	if err != nil {
+		t.Fatalf("InfoParagraphs returned error: %v", err)
+	}
+	if len(paragraphs) != 2 {
+		t.Fatalf("got %d paragraphs", len(paragraphs))
+	}
+	busybox, zlib := paragraphs[0], paragraphs[1]
+	if busybox.Value("Status") != "install ok installed" || busybox.Value("Version") != "1.36.1" {
+		t.Fatalf("busybox should carry index metadata and its status: %v", busybox.Fields)
+	}
+	if zlib.Value("Status") != "" {
+		t.Fatalf("zlib is not installed but reports status %q", zlib.Value("Status"))
+	}
+
+	again, err := m.InfoParagraphs([]string{"busybox"})
+	if err != nil || len(again) != 1 || again[0].Value("Status") != "install ok installed" {
+		t.Fatalf("cached lookup lost the status: %v %v", again, err)
+	}
+	m.status = pkgdb.Empty()
+	if again, _ := m.InfoParagraphs([]string{"busybox"}); again[0].Value("Status") != "" {
+		t.Fatal("the status was merged into the cached index paragraph")
+	}
+}
+
+func TestInfoParagraphsMergeStatusFields(t *testing.T) {
+	m := newIndexedManager(t, "Package: busybox\nVersion: 1.36.1\nFilename: busybox_1.36.1.ipk\nInstalled-Size: 900\n\n"+
+		"Package: zlib\nVersion: 1.3\nFilename: zlib_1.3.ipk\n")
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.36.1\nStatus: install ok installed\n"+
+		"Installed-Size: 1024\nConffiles:\n /etc/busybox.conf 0123abcd\n\n"+
+		"Package: zlib\nVersion: 1.3\nStatus: install user installed\nAuto-Installed: yes\nInstalled-Size: 96\n")
+
+	paragraphs, err := m.InfoParagraphs([]string{"busybox", "zlib"})
+	if err != nil {
+		t.Fatalf("InfoParagraphs returned error: %v", err)
+	}
+	busybox, zlib := paragraphs[0], paragraphs[1]
+	for field, want := range map[string]string{
+		"Filename":       "busybox_1.36.1.ipk",
+		"Status":         "install ok installed",
+		"Conffiles":      "\n/etc/busybox.conf 0123abcd",
+		"Installed-Size": "900",
+	} {
+		if got := busybox.Value(field); got != want {
+			t.Errorf("busybox %s = %q, want %q", field, got, want)
+		}
+	}
+	for field, want := range map[string]string{
+		"Filename":       "zlib_1.3.ipk",
+		"Status":         "install user installed",
+		"Auto-Installed": "yes",
+		"Installed-Size": "96",
+	} {
+		if got := zlib.Value(field); got != want {
+			t.Errorf("zlib %s = %q, want %q", field, got, want)
+		}
+	}
+}
+
+func TestWithAllDestinationsMergesStatus(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	usb := filepath.Join(dir, "usb")
+	writeStatus := func(destRoot, contents string) {
+		t.Helper()
+		path := filepath.Join(destRoot, "usr/lib/opkg/status")
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeStatus(root, "Package: busybox\nVersion: 1.35.0\nStatus: install ok installed\n")
+	writeStatus(usb, "Package: busybox\nVersion: 1.36.1\nStatus: install ok installed\n\n"+
+		"Package: curl\nVersion: 8.0\nStatus: install ok installed\n")
+	conf := filepath.Join(dir, "opkg.conf")
+	data := fmt.Sprintf("option cache_dir %s\ndest root %s\ndest usb %s\ndest ram %s\n",
+		filepath.Join(dir, "cache"), root, usb, filepath.Join(dir, "ram"))
+	if err := os.WriteFile(conf, []byte(data), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	plain, err := New(conf)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	if plain.Status().Installed("curl") {
+		t.Fatal("without WithAllDestinations only the root status should be loaded")
+	}
+
+	m, err := New(conf, WithAllDestinations(true))
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	if !m.Status().Installed("curl") {
+		t.Fatal("curl from the usb destination is missing")
+	}
+	if entry, _ := m.Status().Lookup("busybox"); entry.Version != "1.36.1" {
+		t.Fatalf("the later destination should win, got busybox %s", entry.Version)
+	}
+
+	writeStatus(usb, "Package: wget\nVersion: 1.21\nStatus: install ok installed\n")
+	if err := m.Reset(); err != nil {
+		t.Fatalf("Reset returned error: %v", err)
+	}
+	if !m.Status().Installed("wget") || m.Status().Installed("curl") {
+		t.Fatalf("Reset did not merge the destinations again: %+v", m.Status().Entries())
+	}
+}
+
+func TestSortByInstallDate(t *testing.T) {
+	dir := t.TempDir()
+	statusPath := filepath.Join(dir, "usr/lib/opkg/status")
+	infoDir := filepath.Join(dir, "usr/lib/opkg/info")
+	if err := os.MkdirAll(infoDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var status strings.Builder
+	for _, name := range []string{"busybox", "curl", "nolist", "zlib"} {
+		fmt.Fprintf(&status, "Package: %s\nVersion: 1.0\nStatus: install ok installed\n\n", name)
+	}
+	if err := os.WriteFile(statusPath, []byte(status.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for name, days := range map[string]int{"busybox": 2, "curl": 0, "zlib": 1} {
+		path := filepath.Join(infoDir, name+".list")
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		when := base.AddDate(0, 0, days)
+		if err := os.Chtimes(path, when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := newTestManager(t)
+	loaded, err := pkgdb.Load(statusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.status = loaded
+
+	if when, ok := m.InstallDate("zlib"); !ok || !when.Equal(base.AddDate(0, 0, 1)) {
+		t.Fatalf("InstallDate(zlib) = %v, %v", when, ok)
+	}
+	if _, ok := m.InstallDate("nolist"); ok {
+		t.Fatal("a package without a file list has no install date")
+	}
+
+	names := func(desc bool) []string {
+		t.Helper()
+		pkgs, err := m.ListPackagesJSON(ListOptions{InstalledOnly: true, SortByInstallDate: true, InstallDateDescending: desc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, pkg := range pkgs {
+			out = append(out, pkg.Name)
+		}
+		return out
+	}
+	if got, want := names(false), []string{"curl", "zlib", "busybox", "nolist"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("oldest first = %v, want %v", got, want)
+	}
+	if got, want := names(true), []string{"busybox", "zlib", "curl", "nolist"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("newest first = %v, want %v", got, want)
+	}
+	lines, err := m.ListPackages(ListOptions{InstalledOnly: true, SortByInstallDate: true})
+	if err != nil || len(lines) != 4 || !strings.HasPrefix(lines[0], "curl ") || !strings.HasPrefix(lines[3], "nolist ") {
+		t.Fatalf("ListPackages sorted by install date = %q, %v", lines, err)
+	}
+}
+
+func TestListUpgradableChangeType(t *testing.T) {
+	m := newIndexedManager(t, "Package: major\nVersion: 2.0\n\nPackage: minor\nVersion: 1.1\n\nPackage: patch\nVersion: 1.0.1\n")
+	m.status = statusFromText(t, "Package: major\nVersion: 1.0\nStatus: install ok installed\n\n"+
+		"Package: minor\nVersion: 1.0\nStatus: install ok installed\n\n"+
+		"Package: patch\nVersion: 1.0.0\nStatus: install ok installed\n")
+
+	candidates, err := m.ListUpgradable(nil)
+	if err != nil {
+		t.Fatalf("ListUpgradable returned error: %v", err)
+	}
+	want := map[string]version.VersionChange{"major": version.MajorChange, "minor": version.MinorChange, "patch": version.PatchChange}
+	if len(candidates) != len(want) {
+		t.Fatalf("got %d candidates: %+v", len(candidates), candidates)
+	}
+	for _, c := range candidates {
+		if c.ChangeType != want[c.Name] {
+			t.Errorf("%s %s -> %s has change %s, want %s", c.Name, c.Installed, c.Available, c.ChangeType, want[c.Name])
+		}
+	}
+}
+
+func TestFeedUpdatedAt(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{"/base/Packages": "Package: tool\nVersion: 1.0\n"})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+
+	if _, ok := m.FeedUpdatedAt("base"); ok {
+		t.Fatal("a feed never fetched has no update time")
+	}
+	before := time.Now().Add(-time.Second)
+	if err := m.Update(context.Background()); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	updated, ok := m.FeedUpdatedAt("base")
+	if !ok || updated.Before(before) {
+		t.Fatalf("FeedUpdatedAt = %v, %v", updated, ok)
+	}
+	if _, ok := m.FeedUpdatedAt("missing"); ok {
+		t.Fatal("unknown feed reported an update time")
+	}
+	if feeds := m.FeedsJSON(); feeds[0].UpdatedAt != updated.Format(time.RFC3339) {
+		t.Fatalf("FeedsJSON reports %q", feeds[0].UpdatedAt)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	m := newIndexedManager(t, "Package: curl\nVersion: 8.0\nSection: net\nMaintainer: Net Team\nDescription: URL transfer tool\n\n"+
+		"Package: libcurl4\nVersion: 8.0\nSection: libs\nMaintainer: Net Team\nDescription: URL transfer library\n\n"+
+		"Package: wget\nVersion: 1.21\nSection: net\nMaintainer: GNU\nDescription: Network downloader\n\n"+
+		"Package: zlib\nVersion: 1.3\nSection: libs\nMaintainer: Core Team\nDescription: Compression library\n")
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name string
+		opts SearchOptions
+		want []string
+	}{
+		{"everything", SearchOptions{}, []string{"curl", "libcurl4", "wget", "zlib"}},
+		{"name glob", SearchOptions{NamePattern: "*curl*"}, []string{"curl", "libcurl4"}},
+		{"description substring", SearchOptions{DescPattern: "LIBRARY"}, []string{"libcurl4", "zlib"}},
+		{"name and description", SearchOptions{NamePattern: "*curl*", DescPattern: "library"}, []string{"libcurl4"}},
+		{"section and maintainer", SearchOptions{Sections: []string{"net"}, Maintainer: "Net*"}, []string{"curl"}},
+		{"regex", SearchOptions{NamePattern: "^(curl|wget)$", DescPattern: "(?i)tool|downloader", Regex: true}, []string{"curl", "wget"}},
+	} {
+		pkgs, err := m.Search(ctx, tc.opts)
+		if err != nil {
+			t.Fatalf("%s: Search returned error: %v", tc.name, err)
+		}
+		var names []string
+		for _, pkg := range pkgs {
+			names = append(names, pkg.Name)
+		}
+		if !reflect.DeepEqual(names, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, names, tc.want)
+		}
+	}
+
+	if _, err := m.Search(ctx, SearchOptions{NamePattern: "(", Regex: true}); err == nil {
+		t.Error("expected an error for an invalid regular expression")
+	}
+	if _, err := m.Search(ctx, SearchOptions{NamePattern: "["}); err == nil {
+		t.Error("expected an error for an invalid glob")
+	}
+}
+
+func TestRecordInstallAndRemove(t *testing.T) {
+	m := newTestManager(t)
+	path := filepath.Join(t.TempDir(), "status")
+	if err := os.WriteFile(path, []byte("Package: busybox\nVersion: 1.35\nArchitecture: armv7\nStatus: install ok installed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	status, err := pkgdb.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.status = status
+
+	if err := m.RecordInstall("curl", "8.0", "base"); err != nil {
+		t.Fatalf("RecordInstall returned error: %v", err)
+	}
+	if err := m.RecordInstall("busybox", "1.36", ""); err != nil {
+		t.Fatalf("RecordInstall returned error: %v", err)
+	}
+	reloaded, err := pkgdb.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.Installed("curl") {
+		t.Fatal("curl not installed after reloading the status file")
+	}
+	curl, _ := reloaded.Lookup("curl")
+	if curl.Version != "8.0" || curl.Raw.Value("Feed") != "base" {
+		t.Errorf("unexpected curl entry: %+v", curl.Raw.Fields)
+	}
+	busybox, _ := reloaded.Lookup("busybox")
+	if busybox.Version != "1.36" || busybox.Architecture != "armv7" || busybox.Raw.Value("Feed") != "" {
+		t.Errorf("existing entry not updated in place: %+v", busybox.Raw.Fields)
+	}
+	// Installs record the architecture of the package.
+	if err := m.recordPackage(repo.Package{Name: "wget", Version: "1.21", Architecture: "armv7a", Feed: config.Feed{Name: "base"}}); err != nil {
+		t.Fatalf("recordPackage returned error: %v", err)
+	}
+	if wget, _ := m.Status().Lookup("wget"); wget.Architecture != "armv7a" {
+		t.Errorf("architecture not recorded: %+v", wget.Raw.Fields)
+	}
+
+	if err := m.RecordRemove("curl"); err != nil {
+		t.Fatalf("RecordRemove returned error: %v", err)
+	}
+	if reloaded, err = pkgdb.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Installed("curl") || !reloaded.Installed("busybox") {
+		t.Errorf("unexpected entries after RecordRemove: %+v", reloaded.Entries())
+	}
+	if err := m.RecordRemove("curl"); !errors.Is(err, pkgdb.ErrNotFound) {
+		t.Errorf("expected ErrNotFound removing a missing package, got %v", err)
+	}
+}
+
+func TestInstallRecordsStatus(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages":  "Package: first\nVersion: 1.0\nFilename: first.ipk\n",
+		"/base/first.ipk": "first",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+	path := filepath.Join(t.TempDir(), "status")
+	m.status = pkgdb.EmptyAt(path)
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	if _, err := m.Download(ctx, "first"); err != nil {
+		t.Fatalf("Download returned error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Download wrote the status database: %v", err)
+	}
+
+	if _, err := m.Install(ctx, "first"); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	reloaded, err := pkgdb.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := reloaded.Lookup("first")
+	if err != nil || !entry.IsFullyInstalled() || entry.Version != "1.0" || entry.Raw.Value("Feed") != "base" {
+		t.Fatalf("install not recorded: %+v (%v)", entry, err)
+	}
+}
+
+func TestInstallPreDepends(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: tool\nVersion: 1.0\nFilename: tool.ipk\nPre-Depends: libc (>= 2.0)\n\n" +
+			"Package: libc\nVersion: 2.38\nFilename: libc.ipk\nPre-Depends: base-files\n\n" +
+			"Package: base-files\nVersion: 1\nFilename: base-files.ipk\n\n" +
+			"Package: broken\nVersion: 1.0\nFilename: broken.ipk\nPre-Depends: missing | libc (>= 3.0)\n",
+		"/base/tool.ipk":       "tool",
+		"/base/libc.ipk":       "libc",
+		"/base/base-files.ipk": "base-files",
+		"/base/broken.ipk":     "broken",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base"})
+	m.status = pkgdb.EmptyAt(filepath.Join(t.TempDir(), "status"))
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	_, err := m.Install(ctx, "broken")
+	var preErr *PreDependsError
+	if !errors.As(err, &preErr) || preErr.Package != "broken" || preErr.Requirement != "missing | libc (>= 3.0)" {
+		t.Fatalf("expected PreDependsError, got %v", err)
+	}
+	for _, file := range []string{"broken.ipk", "libc.ipk"} {
+		if _, err := os.Stat(filepath.Join(m.cache, file)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s not to be downloaded, stat error: %v", file, err)
+		}
+	}
+	if len(m.Status().Entries()) != 0 {
+		t.Fatalf("expected nothing recorded, got %+v", m.Status().Entries())
+	}
+
+	plan, err := m.PlanInstall("tool")
+	if err != nil || plan.Package.Name != "tool" || strings.Join(plan.PreDepends, ",") != "base-files,libc" {
+		t.Fatalf("unexpected plan %+v: %v", plan, err)
+	}
+	if _, err := m.Install(ctx, "tool"); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	for _, name := range []string{"base-files", "libc", "tool"} {
+		if !m.Status().Installed(name) {
+			t.Fatalf("expected %s to be recorded as installed", name)
+		}
+	}
+	if plan, err := m.PlanInstall("tool"); err != nil || len(plan.PreDepends) != 0 {
+		t.Fatalf("expected installed pre-dependencies to be skipped, got %+v: %v", plan, err)
+	}
+}
+
+func TestSimulateUpToDate(t *testing.T) {
+	m := newIndexedManager(t, "Package: busybox\nVersion: 1.36\nFilename: busybox.ipk\nDepends: libc\n\n"+
+		"Package: libc\nVersion: 2.38\nFilename: libc.ipk\n")
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.36\nStatus: install ok installed\n\n"+
+		"Package: libc\nVersion: 2.38\nStatus: install ok installed\n")
+
+	res, err := m.Simulate(context.Background(), SimulateOptions{Install: []string{"busybox"}, Upgrade: true})
+	if err != nil {
+		t.Fatalf("Simulate returned error: %v", err)
+	}
+	if len(res.ToInstall) != 0 || len(res.ToRemove) != 0 || len(res.ToUpgrade) != 0 || len(res.Conflicts) != 0 || len(res.Missing) != 0 {
+		t.Fatalf("expected an empty simulation, got %+v", res)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	m := newIndexedManager(t, "Package: busybox\nVersion: 1.36\nFilename: busybox.ipk\nDepends: libc (>= 2.38)\n\n"+
+		"Package: libc\nVersion: 2.38\nFilename: libc.ipk\n\n"+
+		"Package: curl\nVersion: 8.0\nFilename: curl.ipk\nPre-Depends: libcurl, libssl\nDepends: libidn\n\n"+
+		"Package: libcurl\nVersion: 8.0\nFilename: libcurl.ipk\nConflicts: oldcurl\n\n"+
+		"Package: wget\nVersion: 1.21\nFilename: wget.ipk\nPre-Depends: zlib\n")
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.35\nStatus: install ok installed\n\n"+
+		"Package: libc\nVersion: 2.38\nStatus: install ok installed\n\n"+
+		"Package: openssl\nVersion: 3.0\nProvides: libssl\nStatus: install ok installed\n\n"+
+		"Package: oldcurl\nVersion: 7.0\nStatus: install ok installed\n")
+	before, err := m.Status().Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Install never fetches plain Depends, so libidn is neither installed
+	// nor missing; wget cannot be installed without zlib.
+	res, err := m.Simulate(context.Background(), SimulateOptions{Install: []string{"curl", "wget"}, Upgrade: true})
+	if err != nil {
+		t.Fatalf("Simulate returned error: %v", err)
+	}
+	want := SimulationResult{
+		ToInstall: []SimulatedPackage{
+			{Name: "curl", Version: "8.0", Feed: "base"},
+			{Name: "libcurl", Version: "8.0", Feed: "base"},
+		},
+		ToRemove:  []SimulatedPackage{{Name: "oldcurl", Version: "7.0", Installed: "7.0"}},
+		ToUpgrade: []SimulatedPackage{{Name: "busybox", Version: "1.36", Installed: "1.35", Feed: "base"}},
+		Conflicts: []ConflictSet{{Package: "libcurl", ConflictingWith: "oldcurl", ConflictType: "Conflicts"}},
+		Missing:   []string{"zlib"},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Fatalf("unexpected simulation:\n got %+v\nwant %+v", res, want)
+	}
+	if after, _ := m.Status().Bytes(); !bytes.Equal(before, after) {
+		t.Error("Simulate modified the status database")
+	}
+}
+
+func TestDownloadSource(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: busybox-udhcpc\nVersion: 1:1.36.1-r0\nSource: busybox\nFilename: busybox-udhcpc.ipk\n\n" +
+			"Package: evil\nVersion: 1.0\nSource: ../../evil\nFilename: evil.ipk\n",
+		"/sources/busybox_1.36.1-r0.tar.gz": "tarball",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	if _, err := m.DownloadSource(ctx, "busybox-udhcpc"); err == nil {
+		t.Fatal("expected an error without source_uri")
+	}
+
+	m.cfg.Options["source_uri"] = srv.URL + "/sources/"
+	dest, err := m.DownloadSource(ctx, "busybox-udhcpc")
+	if err != nil {
+		t.Fatalf("DownloadSource returned error: %v", err)
+	}
+	if want := filepath.Join(m.cache, "busybox_1.36.1-r0.tar.gz"); dest != want {
+		t.Fatalf("DownloadSource returned %s, want %s", dest, want)
+	}
+	if data, err := os.ReadFile(dest); err != nil || string(data) != "tarball" {
+		t.Fatalf("unexpected tarball contents %q: %v", data, err)
+	}
+	var notFound *PackageNotFoundError
+	if _, err := m.DownloadSource(ctx, "missing"); !errors.As(err, &notFound) {
+		t.Errorf("expected PackageNotFoundError, got %v", err)
+	}
+	if dest, err := m.DownloadSource(ctx, "evil"); err == nil {
+		t.Errorf("expected a source outside the cache to be rejected, got %s", dest)
+	}
+}
+
+func TestSetProxy(t *testing.T) {
+	var proxied []string
+	var mu sync.Mutex
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+		if r.URL.Host != "feed.invalid" || r.URL.Path != "/base/Packages" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "Package: busybox\nVersion: 1.36\n")
+	}))
+	t.Cleanup(proxy.Close)
+
+	m := newTestManager(t, config.Feed{Name: "base", URI: "http://feed.invalid/base", Type: "src"})
+	if err := m.SetProxy("::not a url"); err == nil {
+		t.Fatal("expected an error for an unparseable proxy URL")
+	}
+	if err := m.SetProxy("proxy.example.com"); err == nil {
+		t.Fatal("expected an error for a proxy URL without scheme")
+	}
+	if err := m.SetProxy(proxy.URL); err != nil {
+		t.Fatalf("SetProxy returned error: %v", err)
+	}
+	if err := m.Update(context.Background()); err != nil {
+		t.Fatalf("Update through proxy returned error: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Contains(proxied, "http://feed.invalid/base/Packages") {
+		t.Fatalf("index not fetched through the proxy: %v", proxied)
+	}
+	if err := m.SetProxy(""); err != nil {
+		t.Fatalf("clearing the proxy returned error: %v", err)
+	}
+}
+
+func TestSetTimeout(t *testing.T) {
+	srv := newStallingFeedServer(t)
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+	m.SetTimeout(100 * time.Millisecond)
+
+	start := time.Now()
+	if err := m.Update(context.Background()); err == nil {
+		t.Fatal("expected the update to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("update took %v despite the timeout", elapsed)
+	}
+}
+
+func TestCacheSize(t *testing.T) {
+	m := newTestManager(t)
+	for name, size := range map[string]int{
+		"busybox_1.36_armv7a.ipk": 3000,
+		"zlib_1.3_armv7a.ipk":     1000,
+		"base.Packages":           500,
+		"curl_8.0_armv7a.ipk.tmp": 20,
+		"sub/nested.ipk":          4,
+	} {
+		path := filepath.Join(m.cache, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	total, err := m.CacheSize()
+	if err != nil || total != 4524 {
+		t.Fatalf("CacheSize = %d, %v; want 4524", total, err)
+	}
+	byType, err := m.CacheSizeByType()
+	if err != nil {
+		t.Fatalf("CacheSizeByType returned error: %v", err)
+	}
+	if want := map[string]int64{".ipk": 4004, ".Packages": 500, ".tmp": 20}; !reflect.DeepEqual(byType, want) {
+		t.Fatalf("CacheSizeByType = %v, want %v", byType, want)
+	}
+	files, err := m.CacheFiles()
+	if err != nil {
+		t.Fatalf("CacheFiles returned error: %v", err)
+	}
+	if len(files) != 5 || files[0].Name != "base.Packages" || files[0].Size != 500 || files[0].ModTime.IsZero() {
+		t.Fatalf("unexpected cache files %+v", files)
+	}
+
+	m.cache = filepath.Join(m.cache, "missing")
+	if total, err := m.CacheSize(); err != nil || total != 0 {
+		t.Fatalf("CacheSize of a missing cache = %d, %v", total, err)
+	}
+}
+
+func TestStreamPackages(t *testing.T) {
+	m := &Manager{cfg: &config.Config{}, status: pkgdb.Empty(), indexes: largeIndexSet(1, 500), indexesLoaded: true}
+	want, err := m.ListPackages(ListOptions{Patterns: []string{"pkg-0-1*"}})
+	if err != nil {
+		t.Fatalf("ListPackages returned error: %v", err)
+	}
+	lines, errc := m.StreamPackages(context.Background(), ListOptions{Patterns: []string{"pkg-0-1*"}})
+	var got []string
+	for line := range lines {
+		got = append(got, line)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("StreamPackages returned error: %v", err)
+	}
+	if len(got) == 0 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("streamed %d lines, ListPackages returned %d", len(got), len(want))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lines, errc = m.StreamPackages(ctx, ListOptions{})
+	<-lines
+	cancel()
+	n := 1
+	for range lines {
+		n++
+	}
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if n == 500 {
+		t.Fatal("cancellation did not stop the stream")
+	}
+
+	m.indexesLoaded = false
+	m.cache = t.TempDir()
+	if _, errc := m.StreamPackages(context.Background(), ListOptions{}); <-errc == nil {
+		t.Fatal("expected an error without indexes")
+	}
+}
+
+func TestUpgradeInstallsNewestFeed(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/old/Packages":     "Package: tool\nVersion: 1.5\nFilename: tool_1.5.ipk\n",
+		"/old/tool_1.5.ipk": "old",
+		"/new/Packages":     "Package: tool\nVersion: 2.0\nFilename: tool_2.0.ipk\n",
+		"/new/tool_2.0.ipk": "new",
+	})
+	m := newTestManager(t,
+		config.Feed{Name: "old", URI: srv.URL + "/old", Type: "src"},
+		config.Feed{Name: "new", URI: srv.URL + "/new", Type: "src"})
+	m.status = statusFromText(t, "Package: tool\nVersion: 1.0\nStatus: install ok installed\n")
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	results, err := m.Upgrade(ctx, nil)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("expected one result, got %+v, %v", results, err)
+	}
+	if res := results[0]; res.Upgrade.Available != "2.0" || res.Destination != filepath.Join(m.cache, "tool_2.0.ipk") {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if data, err := os.ReadFile(results[0].Destination); err != nil || string(data) != "new" {
+		t.Fatalf("archive holds %q: %v", data, err)
+	}
+	if entry, _ := m.Status().Lookup("tool"); entry.Version != "2.0" || entry.Raw.Value("Feed") != "new" {
+		t.Fatalf("recorded %s from %s, want 2.0 from new", entry.Version, entry.Raw.Value("Feed"))
+	}
+}
+
+func TestLatestPackagePrefersEarlierFeed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/first/Packages":
+			// The first feed completes last.
+			time.Sleep(50 * time.Millisecond)
+			fmt.Fprint(w, "Package: tool\nVersion: 2.0\nFilename: tool.ipk\n")
+		case "/second/Packages":
+			fmt.Fprint(w, "Package: tool\nVersion: 2.0\nFilename: tool.ipk\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	m := newTestManager(t,
+		config.Feed{Name: "first", URI: srv.URL + "/first", Type: "src"},
+		config.Feed{Name: "second", URI: srv.URL + "/second", Type: "src"})
+	if err := m.Update(context.Background()); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	pkg, ok := m.latestPackage("tool")
+	if !ok || pkg.Feed.Name != "first" {
+		t.Fatalf("expected tool from first, got %+v (%v)", pkg.Feed, ok)
+	}
+}
+
+func TestUpgradeRollsBackOnFailure(t *testing.T) {
+	index := "Package: first\nVersion: 2.0\nFilename: first.ipk\n\n" +
+		"Package: second\nVersion: 2.0\nFilename: second.ipk\n\n" +
+		"Package: third\nVersion: 2.0\nFilename: third.ipk\n"
+	installed := "Package: first\nVersion: 1.0\nStatus: install ok installed\n\n" +
+		"Package: second\nVersion: 1.0\nStatus: install ok installed\n\n" +
+		"Package: third\nVersion: 1.0\nStatus: install ok installed\n"
+	// second.ipk is missing, so its download fails.
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages":  index,
+		"/base/first.ipk": "first",
+		"/base/third.ipk": "third",
+	})
+	ctx := context.Background()
+	newManager := func() *Manager {
+		m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+		m.status = statusFromText(t, installed)
+		if err := m.Update(ctx); err != nil {
+			t.Fatalf("Update returned error: %v", err)
+		}
+		return m
+	}
+
+	m := newManager()
+	results, err := m.Upgrade(ctx, nil)
+	if err == nil || results != nil {
+		t.Fatalf("expected a failed upgrade without results, got %+v, %v", results, err)
+	}
+	var netErr *NetworkError
+	if !errors.As(err, &netErr) || !strings.Contains(err.Error(), "upgrade second") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(m.cache, "first.ipk")); !os.IsNotExist(err) {
+		t.Fatalf("first.ipk was not rolled back: %v", err)
+	}
+	reloaded, err := pkgdb.Load(m.Status().Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := reloaded.Lookup("first"); entry.Version != "1.0" {
+		t.Fatalf("status not restored, first is at %s", entry.Version)
+	}
+
+	m = newManager()
+	results, err = m.UpgradeWith(ctx, nil, UpgradeOptions{ContinueOnError: true})
+	if err == nil || len(results) != 3 {
+		t.Fatalf("expected three results and an error, got %+v, %v", results, err)
+	}
+	if results[0].Err != nil || results[1].Err == nil || results[2].Err != nil {
+		t.Fatalf("unexpected per-package errors %+v", results)
+	}
+	for _, name := range []string{"first.ipk", "third.ipk"} {
+		if _, err := os.Stat(filepath.Join(m.cache, name)); err != nil {
+			t.Errorf("%s not kept: %v", name, err)
+		}
+	}
+	if entry, _ := m.Status().Lookup("third"); entry.Version != "2.0" {
+		t.Errorf("third not recorded as upgraded: %s", entry.Version)
+	}
+}
+
+func TestUpgradeCallbacks(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: first\nVersion: 2.0\nFilename: first.ipk\n\n" +
+			"Package: second\nVersion: 2.0\nFilename: second.ipk\n\n" +
+			"Package: third\nVersion: 2.0\nFilename: third.ipk\n",
+		"/base/first.ipk":  "first",
+		"/base/second.ipk": "second",
+		"/base/third.ipk":  "third",
+	})
+	ctx := context.Background()
+	newManager := func() *Manager {
+		m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+		m.status = statusFromText(t, "Package: first\nVersion: 1.0\nStatus: install ok installed\n\n"+
+			"Package: second\nVersion: 1.0\nStatus: install ok installed\n\n"+
+			"Package: third\nVersion: 1.0\nStatus: install ok installed\n")
+		if err := m.Update(ctx); err != nil {
+			t.Fatalf("Update returned error: %v", err)
+		}
+		return m
+	}
+
+	var progress, done []string
+	results, err := newManager().UpgradeWith(ctx, nil, UpgradeOptions{
+		OnProgress: func(c UpgradeCandidate, n, total int) bool {
+			progress = append(progress, fmt.Sprintf("%s %d/%d", c.Name, n, total))
+			return true
+		},
+		OnDone: func(res UpgradeResult) {
+			done = append(done, res.Upgrade.Name)
+		},
+	})
+	if err != nil || len(results) != 3 {
+		t.Fatalf("expected three results, got %+v, %v", results, err)
+	}
+	if got := strings.Join(progress, ","); got != "first 0/3,second 1/3,third 2/3" {
+		t.Fatalf("unexpected progress calls %q", got)
+	}
+	if got := strings.Join(done, ","); got != "first,second,third" {
+		t.Fatalf("unexpected done calls %q", got)
+	}
+
+	m := newManager()
+	done = nil
+	results, err = m.UpgradeWith(ctx, nil, UpgradeOptions{
+		OnProgress: func(c UpgradeCandidate, n, total int) bool { return n < 1 },
+		OnDone:     func(res UpgradeResult) { done = append(done, res.Upgrade.Name) },
+	})
+	if !errors.Is(err, ErrUpgradeAborted) || len(results) != 1 || results[0].Upgrade.Name != "first" {
+		t.Fatalf("expected an aborted upgrade with one result, got %+v, %v", results, err)
+	}
+	if len(done) != 1 {
+		t.Fatalf("expected one done call, got %v", done)
+	}
+	if entry, _ := m.Status().Lookup("first"); entry.Version != "2.0" {
+		t.Errorf("first not kept upgraded: %s", entry.Version)
+	}
+	if entry, _ := m.Status().Lookup("second"); entry.Version != "1.0" {
+		t.Errorf("second upgraded after abort: %s", entry.Version)
+	}
+}
+
+func TestSafeUpgradeKeepsBackNewDependencies(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: app\nVersion: 2.0\nFilename: app.ipk\nDepends: libc, libnew (>= 1.0)\n\n" +
+			"Package: tool\nVersion: 2.0\nFilename: tool.ipk\nDepends: libc | musl, sh\n\n" +
+			"Package: libnew\nVersion: 1.0\nFilename: libnew.ipk\n",
+		"/base/app.ipk":  "app",
+		"/base/tool.ipk": "tool",
+	})
+	m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+	m.status = statusFromText(t, "Package: app\nVersion: 1.0\nDepends: libc\nStatus: install ok installed\n\n"+
+		"Package: tool\nVersion: 1.0\nStatus: install ok installed\n\n"+
+		"Package: libc\nVersion: 2.38\nStatus: install ok installed\n\n"+
+		"Package: busybox\nVersion: 1.36\nProvides: sh\nStatus: install ok installed\n")
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	results, err := m.UpgradeWith(ctx, nil, UpgradeOptions{SafeUpgrade: true})
+	if err != nil || len(results) != 2 {
+		t.Fatalf("expected two results, got %+v, %v", results, err)
+	}
+	if results[0].Upgrade.Name != "app" || strings.Join(results[0].KeptBack, ",") != "libnew (>= 1.0)" || results[0].Destination != "" {
+		t.Fatalf("expected app to be kept back, got %+v", results[0])
+	}
+	if results[1].Upgrade.Name != "tool" || len(results[1].KeptBack) != 0 || results[1].Destination == "" {
+		t.Fatalf("expected tool to be upgraded, got %+v", results[1])
+	}
+	if entry, _ := m.Status().Lookup("app"); entry.Version != "1.0" {
+		t.Errorf("app was upgraded to %s", entry.Version)
+	}
+	if m.Status().Installed("libnew") {
+		t.Errorf("safe upgrade installed libnew")
+	}
+}
+
+func TestChangelog(t *testing.T) {
+	upstream := newFeedServer(t, map[string]string{
+		"/notes.txt":         "2.0: rewrite\n",
+		"/project/CHANGELOG": "1.1: fixes\n",
+	})
+	m := newIndexedManager(t, "Package: direct\nVersion: 2.0\nChangelog-URL: "+upstream.URL+"/notes.txt\nHomepage: "+upstream.URL+"/ignored\n\n"+
+		"Package: home\nVersion: 1.1\nHomepage: "+upstream.URL+"/project/\n\n"+
+		"Package: gone\nVersion: 1.0\nHomepage: "+upstream.URL+"/gone\n\n"+
+		"Package: bare\nVersion: 1.0\n")
+	ctx := context.Background()
+
+	if text, err := m.Changelog(ctx, "direct"); err != nil || text != "2.0: rewrite\n" {
+		t.Fatalf("Changelog(direct) = %q, %v", text, err)
+	}
+	if u, err := m.ChangelogURL("home"); err != nil || u != upstream.URL+"/project/CHANGELOG" {
+		t.Fatalf("ChangelogURL(home) = %q, %v", u, err)
+	}
+	if text, err := m.Changelog(ctx, "home"); err != nil || text != "1.1: fixes\n" {
+		t.Fatalf("Changelog(home) = %q, %v", text, err)
+	}
+	var netErr *NetworkError
+	if _, err := m.Changelog(ctx, "gone"); !errors.As(err, &netErr) {
+		t.Fatalf("expected NetworkError, got %v", err)
+	}
+	if _, err := m.Changelog(ctx, "bare"); err == nil || !strings.Contains(err.Error(), "neither") {
+		t.Fatalf("expected an error for a package without URLs, got %v", err)
+	}
+	var notFound *PackageNotFoundError
+	if _, err := m.Changelog(ctx, "missing"); !errors.As(err, &notFound) {
+		t.Fatalf("expected PackageNotFoundError, got %v", err)
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	oldFeed := config.Feed{Name: "old", URI: "http://example.invalid/old"}
+	newFeed := config.Feed{Name: "new", URI: "http://example.invalid/new"}
+	m := newTestManager(t, oldFeed, newFeed)
+	var indexes []repo.Index
+	for feed, text := range map[config.Feed]string{
+		oldFeed: "Package: app\nVersion: 1.0\nArchitecture: arm\n\nPackage: lib\nVersion: 1.0\nArchitecture: all\n",
+		newFeed: "Package: app\nVersion: 2.0\nArchitecture: arm\n\nPackage: tool\nVersion: 3.0\nArchitecture: all\n\n" +
+			"Package: lib\nVersion: 2.0\nArchitecture: arm\n",
+	} {
+		idx, err := repo.ParseIndex(feed, []byte(text))
+		if err != nil {
+			t.Fatalf("parse index: %v", err)
+		}
+		indexes = append(indexes, *idx)
+	}
+	m.setIndexes(indexes)
+	m.cfg.Architectures = []config.Architecture{{Name: "all", Priority: 1}, {Name: "arm", Priority: 10}}
+	m.status = statusFromText(t, "Package: app\nVersion: 1.0\nStatus: hold ok installed\n\n"+
+		"Package: tool\nVersion: 2.0\nStatus: install ok installed\n\n"+
+		"Package: local\nVersion: 0.1\nStatus: install ok installed\n")
+
+	policy, err := m.PolicyFor("app")
+	if err != nil {
+		t.Fatalf("PolicyFor returned error: %v", err)
+	}
+	if want := (Policy{Name: "app", Installed: "1.0", Candidate: "1.0", CandidateFeed: policy.CandidateFeed, Held: true, CandidatePriority: 10}); policy != want || policy.CandidateFeed.Name != "old" {
+		t.Fatalf("unexpected policy for held package %+v", policy)
+	}
+	if policy, err := m.PolicyFor("tool"); err != nil || policy.Held || policy.Installed != "2.0" || policy.Candidate != "3.0" || policy.CandidateFeed.Name != "new" || policy.CandidatePriority != 1 {
+		t.Fatalf("unexpected policy for tool %+v: %v", policy, err)
+	}
+	// Install ranks lib by architecture priority, so the older build wins.
+	if policy, err := m.PolicyFor("lib"); err != nil || policy.Installed != "" || policy.Candidate != "1.0" || policy.CandidateFeed.Name != "old" || policy.CandidatePriority != 1 {
+		t.Fatalf("unexpected policy for lib %+v: %v", policy, err)
+	}
+	if plan, err := m.PlanInstall("lib"); err != nil || plan.Package.Version != "1.0" {
+		t.Fatalf("policy disagrees with install plan %+v: %v", plan, err)
+	}
+	if policy, err := m.PolicyFor("local"); err != nil || policy.Installed != "0.1" || policy.Candidate != "" || policy.CandidateFeed.Name != "" {
+		t.Fatalf("unexpected policy for local %+v: %v", policy, err)
+	}
+	var notFound *PackageNotFoundError
+	if _, err := m.PolicyFor("missing"); !errors.As(err, &notFound) {
+		t.Fatalf("expected PackageNotFoundError, got %v", err)
+	}
+}
+
+func TestSafeUpgradeChecksCandidateVersion(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/old/Packages":    "Package: app\nVersion: 1.5\nFilename: app_1.5.ipk\n",
+		"/new/Packages":    "Package: app\nVersion: 2.0\nFilename: app_2.0.ipk\nDepends: libnew\n",
+		"/new/app_2.0.ipk": "app",
+		"/old/app_1.5.ipk": "app",
+	})
+	m := newTestManager(t,
+		config.Feed{Name: "old", URI: srv.URL + "/old", Type: "src"},
+		config.Feed{Name: "new", URI: srv.URL + "/new", Type: "src"})
+	m.status = statusFromText(t, "Package: app\nVersion: 1.0\nStatus: install ok installed\n")
+	ctx := context.Background()
+	if err := m.Update(ctx); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	results, err := m.UpgradeWith(ctx, nil, UpgradeOptions{SafeUpgrade: true})
+	if err != nil || len(results) != 1 || strings.Join(results[0].KeptBack, ",") != "libnew" {
+		t.Fatalf("expected app 2.0 to be kept back for libnew, got %+v, %v", results, err)
+	}
+}
+
+func TestRollbackRemovesPreDependsArchives(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: first\nVersion: 2.0\nFilename: first.ipk\nPre-Depends: libpre\n\n" +
+			"Package: second\nVersion: 2.0\nFilename: second.ipk\n\n" +
+			"Package: libpre\nVersion: 1.0\nFilename: libpre.ipk\n",
+		"/base/first.ipk":  "first",
+		"/base/libpre.ipk": "libpre",
+	})
+	ctx := context.Background()
+	newManager := func() *Manager {
+		m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+		m.status = statusFromText(t, "Package: first\nVersion: 1.0\nStatus: install ok installed\n\n"+
+			"Package: second\nVersion: 1.0\nStatus: install ok installed\n")
+		if err := m.Update(ctx); err != nil {
+			t.Fatalf("Update returned error: %v", err)
+		}
+		return m
+	}
+	assertRemoved := func(m *Manager) {
+		t.Helper()
+		for _, name := range []string{"first.ipk", "libpre.ipk"} {
+			if _, err := os.Stat(filepath.Join(m.cache, name)); !os.IsNotExist(err) {
+				t.Errorf("%s was not rolled back: %v", name, err)
+			}
+		}
+		if m.Status().Installed("libpre") {
+			t.Errorf("libpre is still recorded as installed")
+		}
+	}
+
+	// second.ipk is missing, so its download fails.
+	m := newManager()
+	if _, err := m.Upgrade(ctx, nil); err == nil {
+		t.Fatalf("expected the upgrade to fail")
+	}
+	assertRemoved(m)
+
+	m = newManager()
+	err := m.Transaction(ctx, func(tx *Transaction) error {
+		tx.Install("first")
+		tx.Install("second")
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected the transaction to fail")
+	}
+	assertRemoved(m)
+}
+
+func TestListOrphaned(t *testing.T) {
+	m := newIndexedManager(t, "Package: busybox\nVersion: 1.36\n")
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.35\nStatus: install ok installed\n\n"+
+		"Package: legacy-tool\nVersion: 0.9\nArchitecture: armv7a\nDescription: Dropped from the feed\nStatus: install ok installed\n\n"+
+		"Package: removed\nVersion: 1.0\nStatus: deinstall ok config-files\n")
+
+	orphaned, err := m.ListOrphaned()
+	if err != nil {
+		t.Fatalf("ListOrphaned returned error: %v", err)
+	}
+	if len(orphaned) != 1 {
+		t.Fatalf("expected one orphaned package, got %+v", orphaned)
+	}
+	pkg := orphaned[0]
+	if pkg.Name != "legacy-tool" || pkg.Version != "0.9" || pkg.Architecture != "armv7a" || pkg.Description != "Dropped from the feed" {
+		t.Fatalf("unexpected orphaned package %+v", pkg)
+	}
+}
+
+func TestFindPackagesHonoursDeadline(t *testing.T) {
+	m := &Manager{cfg: &config.Config{}, status: pkgdb.Empty(), indexes: largeIndexSet(4, 50000), indexesLoaded: true}
+
+	matches, err := m.FindPackages(context.Background(), "TOOL 4242 ")
+	if err != nil || len(matches) != 4 {
+		t.Fatalf("FindPackages = %d matches, %v; want one per feed", len(matches), err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := m.FindPackages(ctx, "no such package"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("cancelled search took %v", elapsed)
+	}
+}
+
+func TestReverseDependenciesDepthLimit(t *testing.T) {
+	// e depends on d, d on c, c on b and b on a; a depends back on e.
+	m := newIndexedManager(t, `Package: a
+Version: 1
+Depends: e
+
+Package: b
+Version: 1
+Depends: a
+
+Package: c
+Version: 1
+Depends: b
+
+Package: d
+Version: 1
+Depends: c
+
+Package: e
+Version: 1
+Depends: d
+`)
+	query := func(depth int) ReverseDependencyQuery {
+		return ReverseDependencyQuery{Field: "Depends", IncludeAll: true, Recursive: true, Depth: depth, Patterns: []string{"a"}}
+	}
+
+	got, err := m.ReverseDependencies(query(2))
+	if !errors.Is(err, ErrDepthLimitReached) {
+		t.Fatalf("depth 2: expected ErrDepthLimitReached, got %v", err)
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("depth 2: got %v, want %v", got, want)
+	}
+
+	// The cycle closes at depth 5, so a limit of 5 finds everything.
+	got, err = m.ReverseDependencies(query(5))
+	if err != nil {
+		t.Fatalf("depth 5: %v", err)
+	}
+	if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("depth 5: got %v, want %v", got, want)
+	}
+
+	got, err = m.ReverseDependencies(query(0))
+	if err != nil {
+		t.Fatalf("no limit: %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("no limit: got %v", got)
+	}
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	m := newTestManager(t)
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.36\nStatus: install ok installed\nAuto-Installed: yes\n\n"+
+		"Package: curl\nStatus: install ok installed\n\n"+
+		"Version: 1.0\nStatus: install ok installed\n\n"+
+		"Package: dropbear\nVersion: 2022.83\nStatus: install ok\n\n"+
+		"Package: zlib\nVersion: 1.3\nStatus: install ok broken\n\n"+
+		"Package: libc\nVersion: 2.39\nStatus: install ok installed\nAuto-Installed: no\n\n"+
+		"Package: busybox\nVersion: 1.35\nStatus: install ok installed\n")
+
+	got, err := m.CheckIntegrity()
+	if err != nil {
+		t.Fatalf("CheckIntegrity returned error: %v", err)
+	}
+	want := []IntegrityError{
+		{Package: "curl", Field: "Version", Message: "missing or empty"},
+		{Field: "Package", Message: "missing or empty"},
+		{Package: "dropbear", Field: "Status", Message: `invalid status "install ok"`},
+		{Package: "zlib", Field: "Status", Message: `invalid status "install ok broken"`},
+		{Package: "libc", Field: "Auto-Installed", Message: `invalid value "no", want yes`},
+		{Package: "busybox", Field: "Package", Message: "duplicate entry"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("CheckIntegrity returned %+v, want %+v", got, want)
+	}
+
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.36\nStatus: install ok installed\n")
+	if got, err := m.CheckIntegrity(); err != nil || len(got) != 0 {
+		t.Fatalf("clean database reported %+v, %v", got, err)
+	}
+}
+
+func TestListConflicting(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		index   string
+		status  string
+		install string
+		want    []ConflictSet
+	}{
+		{
+			name:    "new package conflicts with installed",
+			index:   "Package: dropbear\nVersion: 2022.83\nConflicts: openssh\n",
+			status:  "Package: openssh\nVersion: 9.0\nStatus: install ok installed\n",
+			install: "dropbear",
+			want:    []ConflictSet{{Package: "dropbear", ConflictingWith: "openssh", ConflictType: "Conflicts"}},
+		},
+		{
+			name:    "installed package conflicts with new",
+			index:   "Package: openssh\nVersion: 9.0\n",
+			status:  "Package: dropbear\nVersion: 2022.83\nStatus: install ok installed\nConflicts: openssh\n",
+			install: "openssh",
+			want:    []ConflictSet{{Package: "openssh", ConflictingWith: "dropbear", ConflictType: "Conflicts"}},
+		},
+		{
+			name:    "versioned conflict hits",
+			index:   "Package: curl\nVersion: 8.0\nConflicts: libssl (< 1.1)\n",
+			status:  "Package: libssl\nVersion: 1.0.2\nStatus: install ok installed\n",
+			install: "curl",
+			want:    []ConflictSet{{Package: "curl", ConflictingWith: "libssl", ConflictType: "Conflicts"}},
+		},
+		{
+			name:    "versioned conflict misses",
+			index:   "Package: curl\nVersion: 8.0\nConflicts: libssl (< 1.1)\n",
+			status:  "Package: libssl\nVersion: 3.0.8\nStatus: install ok installed\n",
+			install: "curl",
+		},
+		{
+			name:    "breaks",
+			index:   "Package: busybox\nVersion: 1.36\nBreaks: coreutils (<= 9.0)\n",
+			status:  "Package: coreutils\nVersion: 8.32\nStatus: install ok installed\n",
+			install: "busybox",
+			want:    []ConflictSet{{Package: "busybox", ConflictingWith: "coreutils", ConflictType: "Breaks"}},
+		},
+		{
+			name:    "removed package does not conflict",
+			index:   "Package: dropbear\nVersion: 2022.83\nConflicts: openssh\n",
+			status:  "Package: openssh\nVersion: 9.0\nStatus: deinstall ok config-files\n",
+			install: "dropbear",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newIndexedManager(t, tc.index)
+			m.status = statusFromText(t, tc.status)
+			got, err := m.ListConflicting([]string{tc.install})
+			if err != nil {
+				t.Fatalf("ListConflicting returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("ListConflicting = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	m := newIndexedManager(t, "Package: curl\nVersion: 8.0\n")
+	var notFound *PackageNotFoundError
+	if _, err := m.ListConflicting([]string{"missing"}); !errors.As(err, &notFound) {
+		t.Fatalf("expected PackageNotFoundError, got %v", err)
+	}
+}
+
+func TestRepairStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		status  string
+		want    string
+		repairs []StatusRepair
+	}{
+		{
+			name:    "missing status",
+			status:  "Package: curl\nVersion: 8.0\nArchitecture: armv7\n",
+			want:    "Package: curl\nVersion: 8.0\nArchitecture: armv7\nStatus: install ok installed\n",
+			repairs: []StatusRepair{{Package: "curl", Message: `set Status to "install ok installed"`}},
+		},
+		{
+			name:    "empty status",
+			status:  "Package: curl\nStatus:\nVersion: 8.0\n",
+			want:    "Package: curl\nStatus: install ok installed\nVersion: 8.0\n",
+			repairs: []StatusRepair{{Package: "curl", Message: `set Status to "install ok installed"`}},
+		},
+		{
+			name: "duplicate with newer entry first",
+			status: "Package: busybox\nVersion: 1.36\nStatus: install ok installed\n\n" +
+				"Package: curl\nVersion: 8.0\nStatus: install ok installed\n\n" +
+				"Package: busybox\nVersion: 1.35\nStatus: install ok installed\n",
+			want: "Package: busybox\nVersion: 1.36\nStatus: install ok installed\n\n" +
+				"Package: curl\nVersion: 8.0\nStatus: install ok installed\n",
+			repairs: []StatusRepair{{Package: "busybox", Message: "dropped duplicate entry for version 1.35, kept 1.36"}},
+		},
+		{
+			name: "duplicate with newer entry last",
+			status: "Package: busybox\nVersion: 1.35\nStatus: install ok installed\n\n" +
+				"Package: busybox\nVersion: 1:1.0\nStatus: install ok installed\n\n" +
+				"Package: busybox\nVersion: 1.36\nStatus: install ok installed\n",
+			want: "Package: busybox\nVersion: 1:1.0\nStatus: install ok installed\n",
+			repairs: []StatusRepair{
+				{Package: "busybox", Message: "dropped duplicate entry for version 1.35, kept 1:1.0"},
+				{Package: "busybox", Message: "dropped duplicate entry for version 1.36, kept 1:1.0"},
+			},
+		},
+		{
+			name: "duplicate with equal versions keeps the later entry",
+			status: "Package: busybox\nVersion: 1.36\nStatus: install ok installed\nFeed: old\n\n" +
+				"Package: busybox\nVersion: 1.36\nStatus: install ok installed\nFeed: new\n",
+			want:    "Package: busybox\nVersion: 1.36\nStatus: install ok installed\nFeed: new\n",
+			repairs: []StatusRepair{{Package: "busybox", Message: "dropped duplicate entry for version 1.36, kept 1.36"}},
+		},
+		{
+			name:    "duplicate missing status",
+			status:  "Package: busybox\nVersion: 1.35\n\nPackage: busybox\nVersion: 1.36\nStatus: install ok installed\n",
+			want:    "Package: busybox\nVersion: 1.36\nStatus: install ok installed\n",
+			repairs: []StatusRepair{{Package: "busybox", Message: `set Status to "install ok installed"`}, {Package: "busybox", Message: "dropped duplicate entry for version 1.35, kept 1.36"}},
+		},
+		{
+			name:    "malformed version is left unchanged",
+			status:  "Package: curl\nVersion: 8.0 beta\nStatus: install ok installed\n",
+			want:    "Package: curl\nVersion: 8.0 beta\nStatus: install ok installed\n",
+			repairs: []StatusRepair{{Package: "curl", Message: `malformed version "8.0 beta" left unchanged`}},
+		},
+		{
+			name:    "nameless entry is kept",
+			status:  "Version: 1.0\n\nPackage: curl\nVersion: 8.0\nStatus: install ok installed\n",
+			want:    "Version: 1.0\nStatus: install ok installed\n\nPackage: curl\nVersion: 8.0\nStatus: install ok installed\n",
+			repairs: []StatusRepair{{Message: `set Status to "install ok installed"`}},
+		},
+		{
+			name:   "clean database",
+			status: "Package: curl\nVersion: 8.0\nStatus:   install ok installed\n",
+			want:   "Package: curl\nVersion: 8.0\nStatus:   install ok installed\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newTestManager(t)
+			m.status = statusFromText(t, tc.status)
+			path := m.status.Path()
+
+			repairs, err := m.RepairStatusWith(RepairOptions{DryRun: true})
+			if err != nil {
+				t.Fatalf("dry run returned error: %v", err)
+			}
+			if !reflect.DeepEqual(repairs, tc.repairs) {
+				t.Fatalf("dry run reported %+v, want %+v", repairs, tc.repairs)
+			}
+			if data, _ := os.ReadFile(path); string(data) != tc.status {
+				t.Fatalf("dry run modified the database:\n%s", data)
+			}
+
+			repairs, err = m.RepairStatusWith(RepairOptions{})
+			if err != nil {
+				t.Fatalf("RepairStatusWith returned error: %v", err)
+			}
+			if !reflect.DeepEqual(repairs, tc.repairs) {
+				t.Fatalf("repair reported %+v, want %+v", repairs, tc.repairs)
+			}
+			if data, _ := os.ReadFile(path); string(data) != tc.want {
+				t.Fatalf("repaired database:\n%s\nwant:\n%s", data, tc.want)
+			}
+			if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("temporary file left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestRepairStatusUpdatesLoadedDatabase(t *testing.T) {
+	m := newTestManager(t)
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.35\n\nPackage: busybox\nVersion: 1.36\n")
+	if err := m.RepairStatus(); err != nil {
+		t.Fatalf("RepairStatus returned error: %v", err)
+	}
+	entry, err := m.Status().Lookup("busybox")
+	if err != nil || entry.Version != "1.36" || !entry.IsFullyInstalled() {
+		t.Fatalf("loaded entry after repair: %+v, %v", entry, err)
+	}
+	if problems, err := m.CheckIntegrity(); err != nil || len(problems) != 0 {
+		t.Fatalf("repaired database still has problems: %+v, %v", problems, err)
+	}
+}
+
+func TestListUpgradableJSONSizes(t *testing.T) {
+	m := newIndexedManager(t, "Package: busybox\nVersion: 1.36\nSize: 4096\nInstalled-Size: 300\n\n"+
+		"Package: curl\nVersion: 8.1\nSize: 2048\nInstalled-Size: 150\n\n"+
+		"Package: zlib\nVersion: 1.3.1\nInstalled-Size: 90\n")
+	m.status = statusFromText(t, "Package: busybox\nVersion: 1.35\nStatus: install ok installed\nInstalled-Size: 256\n\n"+
+		"Package: curl\nVersion: 8.0\nStatus: install ok installed\nInstalled-Size: 200\n\n"+
+		"Package: zlib\nVersion: 1.3\nStatus: install ok installed\n")
+
+	got, err := m.ListUpgradableJSON(nil)
+	if err != nil {
+		t.Fatalf("ListUpgradableJSON returned error: %v", err)
+	}
+	want := []jsonout.UpgradeCandidateJSON{
+		{Name: "busybox", Installed: "1.35", Available: "1.36", Change: "minor", Feed: "base", FeedURL: "http://example.invalid/base",
+			DownloadSize: 4096, InstalledSizeDelta: 44 * 1024},
+		{Name: "curl", Installed: "8.0", Available: "8.1", Change: "minor", Feed: "base", FeedURL: "http://example.invalid/base",
+			DownloadSize: 2048, InstalledSizeDelta: -50 * 1024},
+		// zlib's installed version declares no size, so there is no delta.
+		{Name: "zlib", Installed: "1.3", Available: "1.3.1", Change: "patch", Feed: "base", FeedURL: "http://example.invalid/base"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ListUpgradableJSON returned\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDependencyGraphJSON(t *testing.T) {
+	m := newIndexedManager(t, "Package: app\nVersion: 2.0\nDepends: libfoo, libbar | libbaz\nRecommends: extra\nConflicts: oldapp\n\n"+
+		"Package: libfoo\nVersion: 1.1\nDepends: libc\n\n"+
+		"Package: libbaz\nVersion: 0.9\n\n"+
+		"Package: libc\nVersion: 2.39\n\n"+
+		"Package: extra\nVersion: 1.0\nDepends: unrelated\n")
+	m.status = statusFromText(t, "Package: libc\nVersion: 2.39\nStatus: install ok installed\n")
+
+	graph, err := m.DependencyGraph([]string{"app"})
+	if err != nil {
+		t.Fatalf("DependencyGraph returned error: %v", err)
+	}
+	data, err := json.Marshal(graph)
+	if err != nil {
+		t.Fatalf("marshal graph: %v", err)
+	}
+	if !json.Valid(data) {
+		t.Fatalf("invalid JSON %s", data)
+	}
+	var doc struct {
+		Nodes []GraphNode `json:"nodes"`
+		Edges []GraphEdge `json:"edges"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("unmarshal graph: %v", err)
+	}
+	// extra's own dependencies are not followed, and oldapp is unknown.
+	if len(doc.Nodes) != 6 || len(doc.Edges) != 5 {
+		t.Fatalf("got %d nodes and %d edges: %s", len(doc.Nodes), len(doc.Edges), data)
+	}
+	if want := (GraphNode{ID: "libc", Version: "2.39", Installed: true}); doc.Nodes[3] != want {
+		t.Fatalf("node %+v, want %+v", doc.Nodes[3], want)
+	}
+	wantEdges := []GraphEdge{
+		{Source: "app", Target: "extra", Type: "Recommends"},
+		{Source: "app", Target: "libbaz", Type: "Depends"},
+		{Source: "app", Target: "libfoo", Type: "Depends"},
+		{Source: "app", Target: "oldapp", Type: "Conflicts"},
+		{Source: "libfoo", Target: "libc", Type: "Depends"},
+	}
+	if !reflect.DeepEqual(doc.Edges, wantEdges) {
+		t.Fatalf("edges %+v, want %+v", doc.Edges, wantEdges)
+	}
+
+	data, err = json.Marshal(&DependencyGraph{})
+	if err != nil || string(data) != `{"nodes":[],"edges":[]}` {
+		t.Fatalf("empty graph encoded as %s, %v", data, err)
+	}
+}
+
+func TestListPackagesProvidesFilter(t *testing.T) {
+	m := newIndexedManager(t, "Package: openssl\nVersion: 3.0\nProvides: libssl, libcrypto\nDescription: OpenSSL\n\n"+
+		"Package: libressl\nVersion: 3.8\nProvides: libssl (= 3.8)\nDescription: LibreSSL\n\n"+
+		"Package: libssl\nVersion: 1.1\nDescription: Legacy SSL library\n\n"+
+		"Package: curl\nVersion: 8.0\nDepends: libssl\nDescription: URL tool\n")
+
+	got, err := m.ListPackages(ListOptions{ProvidesFilter: "libssl"})
+	if err != nil {
+		t.Fatalf("ListPackages returned error: %v", err)
+	}
+	if want := []string{"libressl - LibreSSL", "openssl - OpenSSL"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ListPackages returned %q, want %q", got, want)
+	}
+	if got, _ := m.ListPackages(ListOptions{ProvidesFilter: "libcr*"}); len(got) != 1 || got[0] != "openssl - OpenSSL" {
+		t.Fatalf("glob filter returned %q", got)
+	}
+}
+
+func TestReverseProvides(t *testing.T) {
+	m := newIndexedManager(t, "Package: openssl\nVersion: 3.0\nProvides: libssl (= 3.0)\nFilename: openssl_3.0_armv7.ipk\n\n"+
+		"Package: libressl\nVersion: 3.8\nProvides: libssl, libtls\nFilename: libressl_3.8_armv7.ipk\n\n"+
+		"Package: libssl\nVersion: 1.1\nFilename: libssl_1.1_armv7.ipk\n")
+
+	providers, err := m.ReverseProvides("libssl")
+	if err != nil {
+		t.Fatalf("ReverseProvides returned error: %v", err)
+	}
+	if len(providers) != 2 || providers[0].Name != "libressl" || providers[1].Name != "openssl" {
+		t.Fatalf("unexpected providers %+v", providers)
+	}
+	if providers[0].Filename != "libressl_3.8_armv7.ipk" || providers[1].Filename != "openssl_3.0_armv7.ipk" {
+		t.Fatalf("providers lack their filenames: %q, %q", providers[0].Filename, providers[1].Filename)
+	}
+	if providers, err := m.ReverseProvides("libt*"); err != nil || len(providers) != 1 || providers[0].Name != "libressl" {
+		t.Fatalf("glob capability returned %+v, %v", providers, err)
+	}
+}
+
+func TestSimulateNoInstallRecommends(t *testing.T) {
+	m := newIndexedManager(t, "Package: curl\nVersion: 8.0\nFilename: curl.ipk\nPre-Depends: libcurl\nRecommends: ca-certificates, curl-doc\n\n"+
+		"Package: libcurl\nVersion: 8.0\nFilename: libcurl.ipk\n\n"+
+		"Package: ca-certificates\nVersion: 2024\nFilename: ca-certificates.ipk\nDepends: openssl-bin\n\n"+
+		"Package: openssl-bin\nVersion: 3.0\nFilename: openssl-bin.ipk\n")
+	names := func(pkgs []SimulatedPackage) []string {
+		var out []string
+		for _, pkg := range pkgs {
+			out = append(out, pkg.Name)
+		}
+		return out
+	}
+
+	// curl-doc is in no feed; an unavailable recommendation is not missing.
+	// The Depends of ca-certificates are not installed.
+	res, err := m.Simulate(context.Background(), SimulateOptions{Install: []string{"curl"}})
+	if err != nil {
+		t.Fatalf("Simulate returned error: %v", err)
+	}
+	if got, want := names(res.ToInstall), []string{"ca-certificates", "curl", "libcurl"}; !reflect.DeepEqual(got, want) || len(res.Missing) != 0 {
+		t.Fatalf("plan with recommends installs %v (missing %v), want %v", got, res.Missing, want)
+	}
+
+	WithNoInstallRecommends(true)(m)
+	res, err = m.Simulate(context.Background(), SimulateOptions{Install: []string{"curl"}})
+	if err != nil {
+		t.Fatalf("Simulate returned error: %v", err)
+	}
+	if got, want := names(res.ToInstall), []string{"curl", "libcurl"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("plan without recommends installs %v, want %v", got, want)
+	}
+}
+
+func TestInstallFetchesRecommends(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: curl\nVersion: 8.0\nFilename: curl.ipk\nRecommends: ca-certificates, curl-doc\n\n" +
+			"Package: ca-certificates\nVersion: 2024\nFilename: ca-certificates.ipk\n",
+		"/base/curl.ipk":            "curl",
+		"/base/ca-certificates.ipk": "certs",
+	})
+	ctx := context.Background()
+	newManager := func(noRecommends bool) *Manager {
+		m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+		m.status = pkgdb.EmptyAt(filepath.Join(t.TempDir(), "status"))
+		WithNoInstallRecommends(noRecommends)(m)
+		if err := m.Update(ctx); err != nil {
+			t.Fatalf("Update returned error: %v", err)
+		}
+		return m
+	}
+
+	// curl-doc is in no feed and is skipped.
+	m := newManager(false)
+	if _, err := m.Install(ctx, "curl"); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	if !m.Status().Installed("ca-certificates") {
+		t.Fatalf("recommended package was not installed: %+v", m.Status().Entries())
+	}
+
+	m = newManager(false)
+	results, err := m.InstallMultiple(ctx, []string{"curl"})
+	if err != nil {
+		t.Fatalf("InstallMultiple returned error: %v", err)
+	}
+	if len(results) != 2 || results[1].Name != "ca-certificates" || !m.Status().Installed("ca-certificates") {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	m = newManager(true)
+	if _, err := m.Install(ctx, "curl"); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	if m.Status().Installed("ca-certificates") {
+		t.Fatalf("recommended package installed despite WithNoInstallRecommends")
+	}
+	if _, err := os.Stat(filepath.Join(m.cache, "ca-certificates.ipk")); !os.IsNotExist(err) {
+		t.Fatalf("recommended package was downloaded: %v", err)
+	}
+}
+
+func TestInstallWarnsOnFailedRecommends(t *testing.T) {
+	srv := newFeedServer(t, map[string]string{
+		"/base/Packages": "Package: curl\nVersion: 8.0\nFilename: curl.ipk\nRecommends: ca-certificates\n\n" +
+			"Package: wget\nVersion: 1.21\nFilename: wget.ipk\nRecommends: wget-doc\n\n" +
+			"Package: wget-doc\nVersion: 1.21\nFilename: wget-doc.ipk\n\n" +
+			"Package: ca-certificates\nVersion: 2024\nFilename: ca-certificates.ipk\n",
+		"/base/curl.ipk":     "curl",
+		"/base/wget-doc.ipk": "docs",
+	})
+	ctx := context.Background()
+	newManager := func() *Manager {
+		m := newTestManager(t, config.Feed{Name: "base", URI: srv.URL + "/base", Type: "src"})
+		m.status = pkgdb.EmptyAt(filepath.Join(t.TempDir(), "status"))
+		if err := m.Update(ctx); err != nil {
+			t.Fatalf("Update returned error: %v", err)
+		}
+		return m
+	}
+
+	// ca-certificates.ipk is missing from the feed.
+	m := newManager()
+	if _, err := m.Install(ctx, "curl"); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	if !m.Status().Installed("curl") || m.Status().Installed("ca-certificates") {
+		t.Fatalf("unexpected entries %+v", m.Status().Entries())
+	}
+
+	m = newManager()
+	results, err := m.InstallMultiple(ctx, []string{"curl"})
+	if err != nil {
+		t.Fatalf("InstallMultiple returned error: %v", err)
+	}
+	if len(results) != 2 || results[1].Err != nil || results[1].Warning == nil {
+		t.Fatalf("expected a warning for ca-certificates: %+v", results)
+	}
+	if !m.Status().Installed("curl") || m.Status().Installed("ca-certificates") {
+		t.Fatalf("unexpected entries %+v", m.Status().Entries())
+	}
+
+	// wget.ipk is missing, so its downloaded recommend is not recorded.
+	m = newManager()
+	results, err = m.InstallMultipleWith(ctx, []string{"wget"}, InstallOptions{AllowPartial: true})
+	if err == nil {
+		t.Fatalf("expected wget to fail")
+	}
+	if len(results) != 2 || results[0].Err == nil || results[1].Warning == nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if m.Status().Installed("wget-doc") {
+		t.Fatalf("recommend of a failed package was recorded")
+	}
+}
+
+func TestNewFromReader(t *testing.T) {
+	dir := t.TempDir()
+	cache := filepath.Join(dir, "cache")
+	if err := os.MkdirAll(cache, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	feed := config.Feed{Name: "base", URI: "http://example.invalid/base"}
+	if err := os.WriteFile(repo.CachedIndexPath(cache, feed), []byte("Package: busybox\nVersion: 1.36\nDescription: tiny utilities\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conf := fmt.Sprintf("option status_file %s\noption cache_dir %s\nsrc base %s\n", filepath.Join(dir, "status"), cache, feed.URI)
+
+	m, err := NewFromReader(strings.NewReader(conf), WithNoNetwork())
+	if err != nil {
+		t.Fatalf("NewFromReader returned error: %v", err)
+	}
+	if err := m.LoadCached(); err != nil {
+		t.Fatalf("LoadCached returned error: %v", err)
+	}
+	got, err := m.ListPackages(ListOptions{})
+	if err != nil {
+		t.Fatalf("ListPackages returned error: %v", err)
+	}
+	if want := []string{"busybox - tiny utilities"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ListPackages returned %q, want %q", got, want)
+	}
+	if err := m.Reset(); err == nil {
+		t.Fatal("Reset succeeded without a configuration file")
+	}
+
+	if _, err := NewFromReader(strings.NewReader("option\n")); err == nil {
+		t.Fatal("NewFromReader accepted an invalid configuration")
+	}
+	if _, err := NewFromConfig(nil); err == nil {
+		t.Fatal("NewFromConfig accepted a nil configuration")
+	}
+}
+
+func TestRecursiveDependencies(t *testing.T) {
+	m := newIndexedManager(t, "Package: app\nVersion: 1\nDepends: libfoo (>= 1.0)\nRecommends: extra\n\n"+
+		"Package: libfoo\nVersion: 1.2\nPre-Depends: libbar | libbaz\n\n"+
+		"Package: libbar\nVersion: 1\nDepends: libc, app\n\n"+
+		"Package: libbaz\nVersion: 1\n\n"+
+		"Package: libc\nVersion: 2.39\n\n"+
+		"Package: extra\nVersion: 1\n")
+
+	tree, err := m.RecursiveDependencies("app", true)
+	if err != nil {
+		t.Fatalf("RecursiveDependencies returned error: %v", err)
+	}
+	want := map[string]map[string][]string{
+		"app":    {"Depends": {"libfoo"}, "Recommends": {"extra"}},
+		"libfoo": {"Pre-Depends": {"libbar", "libbaz"}},
+		"libbar": {"Depends": {"libc", "app"}},
+		"libbaz": {},
+		"libc":   {},
+	}
+	if !reflect.DeepEqual(tree, want) {
+		t.Fatalf("RecursiveDependencies returned %v, want %v", tree, want)
+	}
+
+	// Only installed packages are expanded without includeAll.
+	m.status = statusFromText(t, "Package: app\nVersion: 1\nStatus: install ok installed\nDepends: libfoo\n\n"+
+		"Package: libfoo\nVersion: 1.1\nStatus: install ok installed\nDepends: libc\n\n"+
+		"Package: libc\nVersion: 2.39\nStatus: install ok installed\n")
+	tree, err = m.RecursiveDependencies("app", false)
+	if err != nil {
+		t.Fatalf("RecursiveDependencies returned error: %v", err)
+	}
+	want = map[string]map[string][]string{
+		"app":    {"Depends": {"libfoo"}},
+		"libfoo": {"Depends": {"libc"}},
+		"libc":   {},
+	}
+	if !reflect.DeepEqual(tree, want) {
+		t.Fatalf("installed RecursiveDependencies returned %v, want %v", tree, want)
+	}
+	var notFound *PackageNotFoundError
+	if _, err := m.RecursiveDependencies("extra", false); !errors.As(err, &notFound) {
+		t.Fatalf("expected PackageNotFoundError for an uninstalled package, got %v", err)
+	}
+}
+
+func TestTransitiveDependencies(t *testing.T) {
+	m := newIndexedManager(t, "Package: app\nVersion: 1\nDepends: libfoo (>= 1.0), missing-lib | libalt\nRecommends: extra\nSuggests: docs\n\n"+
+		"Package: libfoo\nVersion: 1.2\nPre-Depends: libc (>= 2.30)\n\n"+
+		"Package: libalt\nVersion: 1\nDepends: app\n\n"+
+		"Package: libc\nVersion: 2.39\n\n"+
+		"Package: extra\nVersion: 1\nDepends: libextra\n\n"+
+		"Package: libextra\nVersion: 1\n\n"+
+		"Package: docs\nVersion: 1\n")
+	ctx := context.Background()
+
+	got, err := m.TransitiveDependencies(ctx, "app", TransitiveOptions{})
+	if err != nil {
+		t.Fatalf("TransitiveDependencies returned error: %v", err)
+	}
+	if want := []string{"libalt", "libc", "libfoo"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("TransitiveDependencies returned %v, want %v", got, want)
+	}
+	got, err = m.TransitiveDependencies(ctx, "app", TransitiveOptions{IncludeRecommends: true, IncludeSuggests: true})
+	if err != nil {
+		t.Fatalf("TransitiveDependencies returned error: %v", err)
+	}
+	if want := []string{"docs", "extra", "libalt", "libc", "libextra", "libfoo"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("TransitiveDependencies with optional relations returned %v, want %v", got, want)
+	}
+	var notFound *PackageNotFoundError
+	if _, err := m.TransitiveDependencies(ctx, "nonexistent", TransitiveOptions{}); !errors.As(err, &notFound) {
+		t.Fatalf("expected PackageNotFoundError, got %v", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := m.TransitiveDependencies(cancelled, "app", TransitiveOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package pkgmgr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/oe-mirrors/opkg_go/internal/config"
+	"github.com/oe-mirrors/opkg_go/internal/format"
+	"github.com/oe-mirrors/opkg_go/internal/logging"
+	"github.com/oe-mirrors/opkg_go/internal/repo"
+)
+
+// MirrorOptions controls the behaviour of Mirror.
+type MirrorOptions struct {
+	// Architectures restricts the mirror to packages compatible with one of
+	// the listed architectures. An empty list mirrors every package.
+	Architectures []string
+	// DryRun reports what would be mirrored without downloading or writing
+	// anything.
+	DryRun bool
+	// Workers is the number of concurrent downloads. Values below one use
+	// the manager's WithWorkers setting, or four when there is none.
+	Workers int
+	// Progress, when set, is called after each package has been processed.
+	// Calls are serialised.
+	Progress func(done, total int, pkg repo.Package, err error)
+}
+
+// Mirror downloads every package of the loaded feeds into
+// destDir/<feed>/<filename> and writes a Packages.gz index for the mirrored
+// packages into each feed directory. Download failures do not stop the
+// mirror; they are collected and returned together.
+func (m *Manager) Mirror(ctx context.Context, destDir string, opts MirrorOptions) error {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return err
+	}
+	workers := m.downloadWorkers(opts.Workers)
+
+	type job struct {
+		pkg  repo.Package
+		feed string
+		dest string
+	}
+	var jobs []job
+	feeds := map[string]bool{}
+	for _, idx := range m.indexSet().Indexes() {
+		for _, pkg := range idx.Packages {
+			if !mirrorArchMatches(pkg.Architecture, opts.Architectures) || pkg.IsVirtual() {
+				continue
+			}
+			rel := mirrorPath(pkg.Filename)
+			jobs = append(jobs, job{pkg: pkg, feed: idx.Feed.Name, dest: filepath.Join(destDir, idx.Feed.Name, filepath.FromSlash(rel))})
+			feeds[idx.Feed.Name] = true
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].dest < jobs[j].dest })
+	logging.Debugf("pkgmgr: mirroring %d packages from %d feeds into %s", len(jobs), len(feeds), destDir)
+
+	// byFeed collects the packages that were mirrored; only they are listed
+	// in the mirror indexes.
+	byFeed := map[string][]repo.Package{}
+
+	var (
+		mu   sync.Mutex
+		done int
+		errs []error
+		wg   sync.WaitGroup
+	)
+	queue := make(chan job)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				var err error
+				if !opts.DryRun {
+					err = m.downloader().DownloadToFile(ctx, j.pkg.FullURL(), j.dest)
+				}
+				mu.Lock()
+				done++
+				if err != nil {
+					errs = append(errs, fmt.Errorf("mirror %s: %w", j.pkg.Name, classifyDownloadError(j.pkg.Name, err)))
+				} else {
+					byFeed[j.feed] = append(byFeed[j.feed], j.pkg)
+				}
+				if opts.Progress != nil {
+					opts.Progress(done, len(jobs), j.pkg, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, j := range jobs {
+		select {
+		case queue <- j:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !opts.DryRun {
+		for feed, pkgs := range byFeed {
+			if err := writeMirrorIndex(filepath.Join(destDir, feed), pkgs); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func mirrorArchMatches(arch string, filter []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, target := range filter {
+		if (config.Architecture{Name: arch}).IsCompatibleWith(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorPath returns the feed relative path a package is stored under in a
+// mirror. Absolute URLs are reduced to their base name and parent directory
+// references are dropped so files always stay inside the feed directory.
+func mirrorPath(filename string) string {
+	if strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://") {
+		return path.Base(filename)
+	}
+	return strings.TrimPrefix(path.Clean("/"+filename), "/")
+}
+
+func writeMirrorIndex(dir string, pkgs []repo.Package) error {
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	var cf format.ControlFile
+	for _, pkg := range pkgs {
+		p := pkg.Raw.Clone()
+		for k := range p.Fields {
+			if strings.EqualFold(k, "Filename") {
+				p.Fields[k] = mirrorPath(pkg.Filename)
+			}
+		}
+		cf.Paragraphs = append(cf.Paragraphs, p)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := cf.WriteTo(zw); err != nil {
+		return fmt.Errorf("encode mirror index: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("compress mirror index: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("prepare mirror directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Packages.gz"), buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write mirror index: %w", err)
+	}
+	logging.Debugf("pkgmgr: wrote mirror index for %d packages in %s", len(pkgs), dir)
+	return nil
+}
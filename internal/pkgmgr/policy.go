@@ -0,0 +1,84 @@
+package pkgmgr
+
+import (
+	"github.com/oe-mirrors/opkg_go/internal/config"
+	"github.com/oe-mirrors/opkg_go/internal/pkgdb"
+	"github.com/oe-mirrors/opkg_go/internal/repo"
+)
+
+// Policy explains which version of a package an install or upgrade would
+// select, in the spirit of apt-cache policy.
+type Policy struct {
+	Name string
+	// Installed is the installed version, or empty when the package is not
+	// installed.
+	Installed string
+	// Candidate is the version an install or upgrade selects, or empty when
+	// there is none.
+	Candidate string
+	// CandidateFeed is the feed carrying Candidate. It is the zero Feed when
+	// the candidate of a held package is only known from the status
+	// database.
+	CandidateFeed config.Feed
+	// Held reports that the status database puts the package on hold, in
+	// which case the installed version stays the candidate.
+	Held bool
+	// CandidatePriority is the priority declared for the candidate's
+	// architecture, by which install ranks the feeds carrying a package, or
+	// zero when the architecture is not declared.
+	CandidatePriority int
+}
+
+// PolicyFor returns the version selection policy for name. The candidate of
+// a held package is its installed version. Otherwise it is the package
+// upgrade installs when the feeds carry a newer version than the installed
+// one, and the package install selects in every other case. It fails with a
+// PackageNotFoundError when neither the feeds nor the status database know
+// name.
+func (m *Manager) PolicyFor(name string) (Policy, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return Policy{}, err
+	}
+	policy := Policy{Name: name}
+	entry, err := m.Status().Lookup(name)
+	known := err == nil
+	if known && pkgdb.ParseEntryStatus(entry.Status).Status == "installed" {
+		policy.Installed = entry.Version
+	}
+	policy.Held = known && entry.IsHeld()
+
+	selected, found := m.findPackage(name)
+	if !found && !known {
+		return Policy{}, &PackageNotFoundError{Name: name}
+	}
+	var candidate repo.Package
+	switch {
+	case policy.Held && policy.Installed != "":
+		policy.Candidate = policy.Installed
+		for _, pkg := range m.indexSet().FindAll(name) {
+			if m.archAllowed(pkg.Architecture) && pkg.Version == policy.Installed {
+				candidate = pkg
+				break
+			}
+		}
+	case policy.Installed != "":
+		if pkg, ok := m.upgradePackage(entry); ok {
+			candidate = pkg
+		} else if found {
+			candidate = selected
+		}
+	case found:
+		candidate = selected
+	}
+	if candidate.Name == "" {
+		return policy, nil
+	}
+	policy.Candidate = candidate.Version
+	policy.CandidateFeed = candidate.Feed
+	for _, arch := range m.Architectures() {
+		if arch.Name == candidate.Architecture {
+			policy.CandidatePriority = arch.Priority
+		}
+	}
+	return policy, nil
+}
@@ -7,11 +7,16 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/oe-mirrors/opkg_go/internal/config"
 	"github.com/oe-mirrors/opkg_go/internal/format"
+	"github.com/oe-mirrors/opkg_go/internal/logging"
 	"github.com/oe-mirrors/opkg_go/internal/pkgdb"
 	"github.com/oe-mirrors/opkg_go/internal/repo"
 	"github.com/oe-mirrors/opkg_go/internal/version"
@@ -23,6 +28,41 @@ type ListOptions struct {
 	Patterns         []string
 	ShortDescription bool
 	IncludeSize      bool
+	// Sections keeps only packages whose Section field is one of these,
+	// when not empty.
+	Sections []string
+	// MaintainerPattern keeps only packages whose Maintainer field matches
+	// this glob, when not empty.
+	MaintainerPattern string
+	// ProvidesFilter keeps only packages whose Provides field names a
+	// capability matching this glob, when not empty.
+	ProvidesFilter string
+	// SortByInstallDate orders installed packages by InstallDate, oldest
+	// first or, with InstallDateDescending, newest first. Packages without
+	// an install date come last either way.
+	SortByInstallDate     bool
+	InstallDateDescending bool
+}
+
+// selects reports whether a package with paragraph p passes the Sections,
+// MaintainerPattern and ProvidesFilter filters.
+func (o ListOptions) selects(p format.Paragraph) bool {
+	if len(o.Sections) > 0 && !slices.Contains(o.Sections, p.Value("Section")) {
+		return false
+	}
+	if o.MaintainerPattern != "" {
+		ok, err := path.Match(o.MaintainerPattern, p.Value("Maintainer"))
+		if err != nil || !ok {
+			return false
+		}
+	}
+	if o.ProvidesFilter != "" && !slices.ContainsFunc(tokensFromRelations(p.Value("Provides")), func(token string) bool {
+		ok, err := path.Match(o.ProvidesFilter, token)
+		return err == nil && ok
+	}) {
+		return false
+	}
+	return true
 }
 
 // UpgradeCandidate represents an installed package that has a newer version
@@ -32,6 +72,19 @@ type UpgradeCandidate struct {
 	Installed   string
 	Available   string
 	Description string
+	// Feed is the feed providing the Available version.
+	Feed config.Feed
+	// ChangeType classifies the step from Installed to Available.
+	ChangeType version.VersionChange
+	// DownloadSize is the archive size of the Available version in bytes.
+	DownloadSize int64
+	// InstalledSizeDelta is the Installed-Size of the Available version
+	// minus that of the Installed one, in bytes. It is zero unless both
+	// declare one.
+	InstalledSizeDelta int64
+	// Package is the index entry of the Available version. Upgrades
+	// install exactly this package.
+	Package repo.Package
 }
 
 // UpgradeResult contains the outcome of an upgrade operation for a single
@@ -39,80 +92,334 @@ type UpgradeCandidate struct {
 type UpgradeResult struct {
 	Upgrade     UpgradeCandidate
 	Destination string
+	// Err is the failure of this package when UpgradeOptions.ContinueOnError
+	// is set.
+	Err error
+	// KeptBack lists the dependencies of the new version that are not
+	// installed when UpgradeOptions.SafeUpgrade skipped the package.
+	KeptBack []string
+}
+
+// UpgradeOptions controls the behaviour of UpgradeWith.
+type UpgradeOptions struct {
+	// ContinueOnError upgrades the remaining packages after a failure and
+	// reports it in the result of the failed package. Without it the first
+	// failure undoes the batch: the archives it downloaded are deleted and
+	// the status database is restored.
+	ContinueOnError bool
+	// SafeUpgrade keeps back packages whose new version depends on a
+	// package that is not installed, so that upgrading never installs new
+	// packages.
+	SafeUpgrade bool
+	// OnProgress, when set, is called before each package is downloaded
+	// with the number of candidates already handled and their total.
+	// Returning false stops the upgrade: UpgradeWith returns the results so
+	// far with ErrUpgradeAborted and keeps the packages already upgraded.
+	OnProgress func(candidate UpgradeCandidate, done, total int) bool
+	// OnDone, when set, is called with the result of each package once it
+	// has been upgraded, kept back or, with ContinueOnError, has failed.
+	OnDone func(result UpgradeResult)
 }
 
+// ErrUpgradeAborted is returned by UpgradeWith, together with the results
+// so far, when UpgradeOptions.OnProgress stops the upgrade.
+var ErrUpgradeAborted = errors.New("upgrade aborted")
+
+// errNotLoaded is returned by queries when no update has succeeded and the
+// cache directory holds no index either.
+var errNotLoaded = errors.New("package indexes not loaded; run 'opkg update' first")
+
+// ensureIndexesLoaded falls back to the indexes cached by a previous update
+// when none have been loaded yet.
 func (m *Manager) ensureIndexesLoaded() error {
-	if !m.indexesLoaded {
-		return errors.New("package indexes not loaded; run 'opkg update' first")
+	if m.IndexesLoaded() {
+		return nil
 	}
-	return nil
+	return m.LoadCached()
+}
+
+// findPackage selects the package to use for name when several feeds carry
+// it. Candidates whose architecture exactly matches a declared architecture
+// are preferred in priority order; when there is none the search broadens to
+// candidates compatible with a declared architecture before falling back to
+// the first feed. A manager scoped with WithArch only considers candidates
+// compatible with its architecture, preferring exact matches and then the
+// declared architectures compatible with it.
+func (m *Manager) findPackage(name string) (repo.Package, bool) {
+	var candidates []repo.Package
+	for _, pkg := range m.indexSet().FindAll(name) {
+		if m.archAllowed(pkg.Architecture) {
+			candidates = append(candidates, pkg)
+		}
+	}
+	if len(candidates) == 0 {
+		return repo.Package{}, false
+	}
+	if m.archOverride != "" {
+		// Prefer the override itself, then the declared architectures
+		// compatible with it in priority order.
+		ranked := append([]config.Architecture{{Name: m.archOverride}}, m.conf().CompatibleArchitectures(m.archOverride)...)
+		for _, arch := range ranked {
+			for _, pkg := range candidates {
+				if pkg.Architecture == arch.Name {
+					return pkg, true
+				}
+			}
+		}
+		return candidates[0], true
+	}
+	arches := m.Architectures()
+	sort.SliceStable(arches, func(i, j int) bool { return arches[i].Priority < arches[j].Priority })
+	for _, arch := range arches {
+		for _, pkg := range candidates {
+			if pkg.Architecture == arch.Name {
+				return pkg, true
+			}
+		}
+	}
+	for _, arch := range arches {
+		for _, pkg := range candidates {
+			if (config.Architecture{Name: pkg.Architecture}).IsCompatibleWith(arch.Name) {
+				return pkg, true
+			}
+		}
+	}
+	return candidates[0], true
 }
 
 // ListPackages returns the list of packages matching the provided filters.
+// It collects the lines of StreamPackages.
 func (m *Manager) ListPackages(opts ListOptions) ([]string, error) {
-	if opts.InstalledOnly {
-		return m.listInstalled(opts)
+	lines, errc := m.StreamPackages(context.Background(), opts)
+	var out []string
+	for line := range lines {
+		out = append(out, line)
 	}
-	if err := m.ensureIndexesLoaded(); err != nil {
+	if err := <-errc; err != nil {
 		return nil, err
 	}
-	pkgs := m.indexes.All()
-	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return out, nil
+}
 
-	var lines []string
-	for _, pkg := range pkgs {
-		if !matchesAny(pkg.Name, opts.Patterns) {
-			continue
+// StreamPackages sends the lines ListPackages would return one at a time,
+// so callers that only iterate them need not hold all of them. Both
+// channels are closed when the listing ends; a failure, including the
+// cancellation of ctx, is sent on the error channel first. Read the lines
+// until the channel closes, then receive from the error channel.
+func (m *Manager) StreamPackages(ctx context.Context, opts ListOptions) (<-chan string, <-chan error) {
+	lines := make(chan string, 64)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(lines)
+		send := func(line string) error {
+			select {
+			case lines <- line:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
-		desc := pkg.Description
-		if opts.ShortDescription {
-			desc = firstLine(desc)
+		var err error
+		if opts.InstalledOnly {
+			err = m.streamInstalled(opts, send)
 		} else {
-			desc = strings.ReplaceAll(desc, "\n", " ")
+			err = m.streamAvailable(opts, send)
 		}
-		if desc == "" {
-			desc = "(no description)"
+		if err != nil {
+			errc <- err
 		}
+	}()
+	return lines, errc
+}
+
+func (m *Manager) streamAvailable(opts ListOptions, send func(string) error) error {
+	pkgs, err := m.listAvailable(opts)
+	if err != nil {
+		return err
+	}
+	installed := m.Status()
+	for _, pkg := range pkgs {
+		desc := listDescription(pkg.Description, opts)
 		status := ""
-		if m.status.Installed(pkg.Name) {
+		if installed.Installed(pkg.Name) {
 			status = " [installed]"
 		}
+		line := fmt.Sprintf("%s - %s%s", pkg.Name, desc, status)
 		if opts.IncludeSize && pkg.Size != "" {
-			lines = append(lines, fmt.Sprintf("%s - %s%s (%s)", pkg.Name, desc, status, pkg.Size))
-			continue
+			line += fmt.Sprintf(" (%s)", pkg.Size)
+		}
+		if err := send(line); err != nil {
+			return err
 		}
-		lines = append(lines, fmt.Sprintf("%s - %s%s", pkg.Name, desc, status))
 	}
-	return lines, nil
+	return nil
+}
+
+// listDescription formats a description for a list line.
+func listDescription(desc string, opts ListOptions) string {
+	if opts.ShortDescription {
+		desc = firstLine(desc)
+	} else {
+		desc = strings.ReplaceAll(desc, "\n", " ")
+	}
+	if desc == "" {
+		desc = "(no description)"
+	}
+	return desc
 }
 
-func (m *Manager) listInstalled(opts ListOptions) ([]string, error) {
-	entries := m.status.Entries()
+// listAvailable returns the index packages matching the patterns and
+// sections of opts, sorted by name.
+func (m *Manager) listAvailable(opts ListOptions) ([]repo.Package, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	var pkgs []repo.Package
+	for _, pkg := range m.indexSet().All() {
+		if matchesAny(pkg.Name, opts.Patterns) && m.archAllowed(pkg.Architecture) && opts.selects(pkg.Raw) {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs, nil
+}
+
+func (m *Manager) streamInstalled(opts ListOptions, send func(string) error) error {
+	entries := m.Status().Entries()
 	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
-	var lines []string
+	m.sortInstalled(entries, opts)
 	for _, entry := range entries {
-		if !matchesAny(entry.Name, opts.Patterns) {
+		if !matchesAny(entry.Name, opts.Patterns) || !opts.selects(entry.Raw) {
 			continue
 		}
-		desc := entry.Raw.Value("Description")
-		if opts.ShortDescription {
-			desc = firstLine(desc)
-		} else {
-			desc = strings.ReplaceAll(desc, "\n", " ")
+		line := fmt.Sprintf("%s - %s", entry.Name, listDescription(entry.Raw.Value("Description"), opts))
+		if size := entry.Raw.Value("Installed-Size"); opts.IncludeSize && size != "" {
+			line += fmt.Sprintf(" (%s)", size)
 		}
-		if desc == "" {
-			desc = "(no description)"
+		if err := send(line); err != nil {
+			return err
 		}
-		if opts.IncludeSize {
-			size := entry.Raw.Value("Installed-Size")
-			if size != "" {
-				lines = append(lines, fmt.Sprintf("%s - %s (%s)", entry.Name, desc, size))
-				continue
-			}
+	}
+	return nil
+}
+
+// InstallDate approximates when a package was installed by the
+// modification time of its file list, <info dir>/<name>.list, next to the
+// status file. opkg records no install dates itself.
+func (m *Manager) InstallDate(name string) (time.Time, bool) {
+	statusPath := m.Status().Path()
+	if statusPath == "" {
+		return time.Time{}, false
+	}
+	info, err := os.Stat(filepath.Join(filepath.Dir(statusPath), "info", name+".list"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
+
+// sortInstalled reorders entries, sorted by name, by install date when opts
+// asks for it. The sort is stable, so equal dates keep name order.
+func (m *Manager) sortInstalled(entries []pkgdb.Entry, opts ListOptions) {
+	if !opts.SortByInstallDate {
+		return
+	}
+	type dated struct {
+		when time.Time
+		ok   bool
+	}
+	dates := make(map[string]dated, len(entries))
+	for _, entry := range entries {
+		when, ok := m.InstallDate(entry.Name)
+		dates[entry.Name] = dated{when, ok}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := dates[entries[i].Name], dates[entries[j].Name]
+		if a.ok != b.ok {
+			return a.ok
+		}
+		if opts.InstallDateDescending {
+			return a.when.After(b.when)
 		}
-		lines = append(lines, fmt.Sprintf("%s - %s", entry.Name, desc))
+		return a.when.Before(b.when)
+	})
+}
+
+// ListSections returns the sorted set of Section values of the indexed
+// packages, for completing the --section filter of list.
+func (m *Manager) ListSections() ([]string, error) {
+	return m.fieldValues("Section")
+}
+
+// ListBySection returns the available packages matching opts grouped by
+// section with repo.GroupBySection. InstalledOnly is ignored.
+func (m *Manager) ListBySection(opts ListOptions) (map[string][]repo.Package, error) {
+	pkgs, err := m.listAvailable(opts)
+	if err != nil {
+		return nil, err
 	}
-	return lines, nil
+	return repo.GroupBySection(pkgs), nil
+}
+
+// ListMaintainers returns the sorted set of Maintainer values of the
+// indexed packages.
+func (m *Manager) ListMaintainers() ([]string, error) {
+	return m.fieldValues("Maintainer")
+}
+
+// fieldValues returns the sorted distinct non-empty values of field across
+// the indexed packages.
+func (m *Manager) fieldValues(field string) ([]string, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var values []string
+	for _, pkg := range m.indexSet().All() {
+		value := pkg.Raw.Value(field)
+		if value != "" && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// latestPackage returns the highest version of name found in any feed for
+// an allowed architecture. Equal versions prefer the earlier feed.
+func (m *Manager) latestPackage(name string) (repo.Package, bool) {
+	var (
+		pkgs     []repo.Package
+		versions []string
+	)
+	for _, pkg := range m.indexSet().FindAll(name) {
+		if m.archAllowed(pkg.Architecture) {
+			pkgs = append(pkgs, pkg)
+			versions = append(versions, pkg.Version)
+		}
+	}
+	best, err := version.Latest(versions)
+	if err != nil {
+		return repo.Package{}, false
+	}
+	for _, pkg := range pkgs {
+		if pkg.Version == best {
+			return pkg, true
+		}
+	}
+	return repo.Package{}, false
+}
+
+// upgradePackage returns the package an upgrade installs for entry: the
+// latest version the feeds carry, when it is newer than the installed one.
+func (m *Manager) upgradePackage(entry pkgdb.Entry) (repo.Package, bool) {
+	pkg, ok := m.latestPackage(entry.Name)
+	if !ok || version.Compare(entry.Version, pkg.Version) >= 0 {
+		return repo.Package{}, false
+	}
+	return pkg, true
 }
 
 // ListUpgradable reports all installed packages that have newer versions
@@ -122,59 +429,295 @@ func (m *Manager) ListUpgradable(patterns []string) ([]UpgradeCandidate, error)
 		return nil, err
 	}
 	var candidates []UpgradeCandidate
-	for _, entry := range m.status.Entries() {
+	for _, entry := range m.Status().Entries() {
 		if !matchesAny(entry.Name, patterns) {
 			continue
 		}
-		pkg, ok := m.indexes.Find(entry.Name)
+		pkg, ok := m.upgradePackage(entry)
 		if !ok {
 			continue
 		}
-		if version.Compare(entry.Version, pkg.Version) >= 0 {
-			continue
-		}
+		download, delta := upgradeSizes(entry, pkg)
 		candidates = append(candidates, UpgradeCandidate{
-			Name:        entry.Name,
-			Installed:   entry.Version,
-			Available:   pkg.Version,
-			Description: firstLine(pkg.Description),
+			Name:               entry.Name,
+			Installed:          entry.Version,
+			Available:          pkg.Version,
+			Description:        firstLine(pkg.Description),
+			Feed:               pkg.Feed,
+			ChangeType:         version.Diff(entry.Version, pkg.Version),
+			DownloadSize:       download,
+			InstalledSizeDelta: delta,
+			Package:            pkg,
 		})
 	}
 	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
 	return candidates, nil
 }
 
-// Upgrade downloads newer versions for packages that have updates available.
+// upgradeSizes returns the download size of pkg and the change in installed
+// size from entry to pkg. Invalid sizes count as undeclared.
+func upgradeSizes(entry pkgdb.Entry, pkg repo.Package) (download, delta int64) {
+	download, err := pkg.DownloadSize()
+	if err != nil {
+		logging.Debugf("pkgmgr: %v", err)
+	}
+	if entry.Raw.Value("Installed-Size") == "" || pkg.Raw.Value("Installed-Size") == "" {
+		return download, 0
+	}
+	oldSize, err := repo.Package{Name: entry.Name, Raw: entry.Raw}.InstalledSize()
+	if err != nil {
+		logging.Debugf("pkgmgr: %v", err)
+		return download, 0
+	}
+	newSize, err := pkg.InstalledSize()
+	if err != nil {
+		logging.Debugf("pkgmgr: %v", err)
+		return download, 0
+	}
+	return download, newSize - oldSize
+}
+
+// ListOrphaned returns the installed packages that no loaded feed index
+// carries any more, sorted by name. They will never receive updates. Unlike
+// auto-installed packages nothing depends on, they are reported regardless
+// of why they were installed. The packages are built from the status
+// database and have no feed.
+func (m *Manager) ListOrphaned() ([]repo.Package, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	indexes := m.indexSet()
+	var orphaned []repo.Package
+	for _, entry := range m.Status().Entries() {
+		if !entry.IsFullyInstalled() {
+			continue
+		}
+		if _, ok := indexes.Find(entry.Name); ok {
+			continue
+		}
+		orphaned = append(orphaned, repo.Package{
+			Name:         entry.Name,
+			Version:      entry.Version,
+			Architecture: entry.Architecture,
+			Description:  entry.Raw.Value("Description"),
+			Raw:          entry.Raw,
+		})
+	}
+	return orphaned, nil
+}
+
+// Upgrade is UpgradeWith with default options.
 func (m *Manager) Upgrade(ctx context.Context, patterns []string) ([]UpgradeResult, error) {
+	return m.UpgradeWith(ctx, patterns, UpgradeOptions{})
+}
+
+// UpgradeWith installs the newer versions of the installed packages matching
+// patterns, or of every installed package when there are none. The returned
+// error joins the failures of all packages. Packages kept back by
+// SafeUpgrade are reported with their missing dependencies and are not
+// failures.
+func (m *Manager) UpgradeWith(ctx context.Context, patterns []string, opts UpgradeOptions) ([]UpgradeResult, error) {
 	candidates, err := m.ListUpgradable(patterns)
 	if err != nil {
 		return nil, err
 	}
-	var results []UpgradeResult
-	for _, candidate := range candidates {
-		dest, err := m.Install(ctx, candidate.Name)
+	status := m.Status()
+	var prev []byte
+	if status.Path() != "" {
+		if prev, err = status.Bytes(); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		results    []UpgradeResult
+		downloaded []string
+		errs       []error
+	)
+	report := func(res UpgradeResult) {
+		results = append(results, res)
+		if opts.OnDone != nil {
+			opts.OnDone(res)
+		}
+	}
+	for i, candidate := range candidates {
+		if opts.SafeUpgrade {
+			if missing := m.uninstalledDependencies(candidate.Package); len(missing) > 0 {
+				logging.Debugf("pkgmgr: keeping back %s, it needs %v", candidate.Name, missing)
+				report(UpgradeResult{Upgrade: candidate, KeptBack: missing})
+				continue
+			}
+		}
+		if opts.OnProgress != nil && !opts.OnProgress(candidate, i, len(candidates)) {
+			logging.Debugf("pkgmgr: upgrade aborted before %s", candidate.Name)
+			return results, errors.Join(append(errs, ErrUpgradeAborted)...)
+		}
+		plan, err := m.planPackage(candidate.Package)
+		var dest string
+		if err == nil {
+			logging.Debugf("pkgmgr: upgrading %s to %s from %s", candidate.Name, candidate.Available, candidate.Feed.Name)
+			// Only the archives the upgrade adds to the cache,
+			// pre-dependencies included, are removed on rollback.
+			var added []string
+			dest, added, err = m.install(ctx, plan)
+			downloaded = append(downloaded, added...)
+		}
 		if err != nil {
-			return results, err
+			err = fmt.Errorf("upgrade %s: %w", candidate.Name, err)
+			if !opts.ContinueOnError {
+				return nil, m.rollbackUpgrade(err, downloaded, status, prev)
+			}
+			report(UpgradeResult{Upgrade: candidate, Err: err})
+			errs = append(errs, err)
+			continue
+		}
+		report(UpgradeResult{Upgrade: candidate, Destination: dest})
+	}
+	return results, errors.Join(errs...)
+}
+
+// uninstalledDependencies returns the Pre-Depends and Depends clauses of pkg
+// that no installed package meets, by name or through Provides. Version
+// constraints are not checked, since the installed packages may be upgraded
+// as well.
+func (m *Manager) uninstalledDependencies(pkg repo.Package) []string {
+	status := m.Status()
+	provided := map[string]bool{}
+	for _, entry := range status.Entries() {
+		if !entry.IsFullyInstalled() {
+			continue
+		}
+		provided[entry.Name] = true
+		for _, token := range tokensFromRelations(entry.Raw.Value("Provides")) {
+			provided[token] = true
+		}
+	}
+	var missing []string
+	for _, field := range []string{"Pre-Depends", "Depends"} {
+		for _, group := range version.ParseRelations(pkg.Raw.Value(field)) {
+			met := false
+			for _, rel := range group {
+				if provided[rel.Name] {
+					met = true
+					break
+				}
+			}
+			if !met {
+				missing = append(missing, relationGroupString(group))
+			}
 		}
-		results = append(results, UpgradeResult{Upgrade: candidate, Destination: dest})
 	}
-	return results, nil
+	return missing
+}
+
+// rollbackUpgrade deletes the archives an upgrade downloaded and restores
+// the status database to prev, returning cause with any rollback failure
+// appended.
+func (m *Manager) rollbackUpgrade(cause error, downloaded []string, status *pkgdb.Status, prev []byte) error {
+	for _, path := range downloaded {
+		logging.Debugf("pkgmgr: rolling back download %s", path)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			cause = fmt.Errorf("%w (rollback incomplete: %v)", cause, err)
+		}
+	}
+	if prev != nil {
+		logging.Debugf("pkgmgr: restoring status database")
+		if err := status.Restore(prev); err != nil {
+			cause = fmt.Errorf("%w (rollback incomplete: %v)", cause, err)
+		}
+	}
+	return cause
 }
 
 // Download retrieves the package archive for the provided package name without
 // making any changes to the status database.
 func (m *Manager) Download(ctx context.Context, name string) (string, error) {
-	return m.Install(ctx, name)
+	_, dest, err := m.fetch(ctx, name)
+	return dest, err
+}
+
+// DownloadSource retrieves the source tarball of the package name was built
+// from into the cache directory and returns its path. The tarball is named
+// <source>_<version>.tar.gz, without the epoch, below the source_uri option.
+func (m *Manager) DownloadSource(ctx context.Context, name string) (string, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return "", err
+	}
+	pkg, ok := m.findPackage(name)
+	if !ok {
+		return "", &PackageNotFoundError{Name: name}
+	}
+	base := m.conf().SourceURI()
+	if base == "" {
+		return "", errors.New("source_uri option is not configured")
+	}
+	src, ver := pkg.Source()
+	if _, rest, ok := strings.Cut(ver, ":"); ok {
+		ver = rest
+	}
+	file := src + "_" + ver + ".tar.gz"
+	// The name comes from the feed; it must not reach outside the cache.
+	if strings.ContainsAny(file, `/\`) || strings.Contains(file, "..") {
+		return "", fmt.Errorf("package %s names an invalid source tarball %q", name, file)
+	}
+	dest := filepath.Join(m.cache, file)
+	if m.noNetwork {
+		if _, err := os.Stat(dest); err != nil {
+			return "", fmt.Errorf("source of %s is not in the cache and network access is disabled: %w", name, err)
+		}
+		return dest, nil
+	}
+	logging.Debugf("pkgmgr: downloading source %s of %s", file, name)
+	if err := m.downloader().DownloadToFile(ctx, base+"/"+file, dest); err != nil {
+		return "", classifyDownloadError(name, err)
+	}
+	return dest, nil
+}
+
+// maxChangelogSize bounds the changelogs Changelog downloads.
+const maxChangelogSize = 4 << 20
+
+// ChangelogURL returns where the changelog of name is published: its
+// Changelog-URL field or, failing that, CHANGELOG below its Homepage.
+func (m *Manager) ChangelogURL(name string) (string, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return "", err
+	}
+	p, ok := m.lookupParagraph(name)
+	if !ok {
+		return "", &PackageNotFoundError{Name: name}
+	}
+	if u := p.Value("Changelog-URL"); u != "" {
+		return u, nil
+	}
+	if home := p.Value("Homepage"); home != "" {
+		return strings.TrimSuffix(home, "/") + "/CHANGELOG", nil
+	}
+	return "", fmt.Errorf("package %s declares neither a Changelog-URL nor a Homepage", name)
+}
+
+// Changelog downloads the upstream changelog of name from ChangelogURL and
+// returns its text.
+func (m *Manager) Changelog(ctx context.Context, name string) (string, error) {
+	u, err := m.ChangelogURL(name)
+	if err != nil {
+		return "", err
+	}
+	logging.Debugf("pkgmgr: fetching changelog of %s from %s", name, u)
+	data, err := m.downloader().GetBytesLimited(ctx, u, maxChangelogSize)
+	if err != nil {
+		return "", fmt.Errorf("changelog of %s: %w", name, classifyDownloadError(name, err))
+	}
+	return string(data), nil
 }
 
 // Status returns the status paragraphs for all installed packages matching the
 // provided patterns. When no patterns are provided all entries are returned.
 func (m *Manager) StatusParagraphs(patterns []string) []pkgdb.Entry {
 	if len(patterns) == 0 {
-		return m.status.Entries()
+		return m.Status().Entries()
 	}
 	var entries []pkgdb.Entry
-	for _, entry := range m.status.Entries() {
+	for _, entry := range m.Status().Entries() {
 		if matchesAny(entry.Name, patterns) {
 			entries = append(entries, entry)
 		}
@@ -185,6 +728,53 @@ func (m *Manager) StatusParagraphs(patterns []string) []pkgdb.Entry {
 
 // Clean removes cached package archives from the cache directory.
 func (m *Manager) Clean() error {
+	return m.cleanCache(func(os.DirEntry) (bool, error) { return true, nil })
+}
+
+// CleanStale removes the files in the cache directory that were last
+// modified more than maxAge ago.
+func (m *Manager) CleanStale(maxAge time.Duration) error {
+	cutoff := time.Now().Add(-maxAge)
+	return m.cleanCache(func(entry os.DirEntry) (bool, error) {
+		info, err := entry.Info()
+		if err != nil {
+			return false, err
+		}
+		return info.ModTime().Before(cutoff), nil
+	})
+}
+
+// CleanOrphaned removes the package archives in the cache directory that
+// no longer belong to any package of the loaded indexes. It refuses to run
+// until every enabled feed has an index, as the archives of a feed that was
+// never loaded would otherwise all look orphaned.
+func (m *Manager) CleanOrphaned() error {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return err
+	}
+	loaded := map[string]bool{}
+	for _, idx := range m.indexSet().Indexes() {
+		loaded[idx.Feed.Name] = true
+	}
+	for _, feed := range m.conf().Feeds {
+		if !feed.Disabled && !loaded[feed.Name] {
+			return fmt.Errorf("feed %s has no index; run update before removing orphaned archives", feed.Name)
+		}
+	}
+	known := map[string]bool{}
+	for _, pkg := range m.indexSet().All() {
+		if pkg.Filename != "" {
+			known[filepath.Base(pkg.Filename)] = true
+		}
+	}
+	return m.cleanCache(func(entry os.DirEntry) (bool, error) {
+		return filepath.Ext(entry.Name()) == ".ipk" && !known[entry.Name()], nil
+	})
+}
+
+// cleanCache removes the regular files of the cache directory selected by
+// remove.
+func (m *Manager) cleanCache(remove func(os.DirEntry) (bool, error)) error {
 	entries, err := os.ReadDir(m.cache)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -196,7 +786,15 @@ func (m *Manager) Clean() error {
 		if entry.IsDir() {
 			continue
 		}
+		ok, err := remove(entry)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
 		path := filepath.Join(m.cache, entry.Name())
+		logging.Debugf("pkgmgr: removing %s from the cache", path)
 		if err := os.Remove(path); err != nil {
 			return err
 		}
@@ -204,14 +802,184 @@ func (m *Manager) Clean() error {
 	return nil
 }
 
+// CacheFile describes a file in the cache directory. Name is relative to the
+// cache directory.
+type CacheFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// CacheFiles returns the regular files below the cache directory in name
+// order. A missing cache directory holds no files.
+func (m *Manager) CacheFiles() ([]CacheFile, error) {
+	var files []CacheFile
+	err := filepath.WalkDir(m.cache, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if path == m.cache && errors.Is(err, os.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(m.cache, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, CacheFile{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan cache: %w", err)
+	}
+	return files, nil
+}
+
+// CacheSize returns the total size in bytes of the files in the cache
+// directory.
+func (m *Manager) CacheSize() (int64, error) {
+	files, err := m.CacheFiles()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	return total, nil
+}
+
+// CacheSizeByType returns the size of the cached files grouped by extension,
+// such as ".ipk" for archives, ".Packages" for indexes and ".tmp" for
+// interrupted downloads. Files without an extension are counted under "".
+func (m *Manager) CacheSizeByType() (map[string]int64, error) {
+	files, err := m.CacheFiles()
+	if err != nil {
+		return nil, err
+	}
+	sizes := map[string]int64{}
+	for _, f := range files {
+		sizes[filepath.Ext(f.Name)] += f.Size
+	}
+	return sizes, nil
+}
+
 // Architectures returns the architectures declared in the configuration file.
 func (m *Manager) Architectures() []config.Architecture {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	if m.cfg == nil {
 		return nil
 	}
 	return append([]config.Architecture(nil), m.cfg.Architectures...)
 }
 
+// IndexStats returns aggregate information about the loaded indexes.
+func (m *Manager) IndexStats() (repo.IndexStats, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return repo.IndexStats{}, err
+	}
+	return m.indexSet().Stats(), nil
+}
+
+// FeedUpdatedAt returns when the named feed was last fetched, as recorded
+// next to its cached index. It reports false for feeds never fetched into
+// the cache.
+func (m *Manager) FeedUpdatedAt(name string) (time.Time, bool) {
+	m.mu.RLock()
+	feed, ok := m.cfg.FeedByName(name)
+	m.mu.RUnlock()
+	if !ok {
+		return time.Time{}, false
+	}
+	updated, err := repo.CachedUpdatedAt(feed, m.cache)
+	if err != nil || updated.IsZero() {
+		return time.Time{}, false
+	}
+	return updated, true
+}
+
+// Feeds returns the feeds declared in the configuration file, including
+// disabled ones.
+func (m *Manager) Feeds() []config.Feed {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cfg == nil {
+		return nil
+	}
+	return append([]config.Feed(nil), m.cfg.Feeds...)
+}
+
+// Source returns the feed a package comes from. Packages present in the
+// loaded indexes report the feed selected for installation. Installed
+// packages missing from every index fall back to the Feed field recorded in
+// the status database, when there is one.
+func (m *Manager) Source(name string) (config.Feed, bool) {
+	if m.IndexesLoaded() {
+		if pkg, ok := m.findPackage(name); ok {
+			return pkg.Feed, true
+		}
+	}
+	entry, err := m.Status().Lookup(name)
+	if err != nil {
+		return config.Feed{}, false
+	}
+	feedName := entry.Raw.Value("Feed")
+	if feedName == "" {
+		return config.Feed{}, false
+	}
+	m.mu.RLock()
+	feed, ok := m.cfg.FeedByName(feedName)
+	m.mu.RUnlock()
+	if ok {
+		return feed, true
+	}
+	return config.Feed{Name: feedName}, true
+}
+
+// EnableFeed re-enables a feed previously disabled with DisableFeed.
+func (m *Manager) EnableFeed(name string) error {
+	return m.setFeedDisabled(name, false)
+}
+
+// DisableFeed disables a feed so that Update no longer fetches it. The change
+// is written back to the configuration file.
+func (m *Manager) DisableFeed(name string) error {
+	return m.setFeedDisabled(name, true)
+}
+
+// setFeedDisabled changes a copy of the configuration and swaps it in, as
+// the configuration returned by conf may still be in use elsewhere.
+func (m *Manager) setFeedDisabled(name string, disabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cfg := m.cfg.Clone()
+	if err := cfg.SetFeedDisabled(name, disabled); err != nil {
+		return err
+	}
+	m.cfg = cfg
+	return nil
+}
+
+// CompatibleArchitectures returns the declared architectures together with
+// the implicit "all" architecture, which opkg always accepts. The "all" entry
+// is added with priority 0 unless the configuration already declares it.
+func (m *Manager) CompatibleArchitectures() []config.Architecture {
+	arches := m.Architectures()
+	for _, arch := range arches {
+		if arch.Name == "all" {
+			return arches
+		}
+	}
+	return append([]config.Architecture{{Name: "all"}}, arches...)
+}
+
 func matchesAny(name string, patterns []string) bool {
 	if len(patterns) == 0 {
 		return true
@@ -232,18 +1000,26 @@ func firstLine(text string) string {
 }
 
 // ReverseDependencyQuery describes the type of relationship to query for
-// reverse lookups such as whatdepends or whatrecommends.
+// reverse lookups such as whatdepends or whatrecommends. Depth bounds a
+// recursive search: packages matching the patterns directly are at depth 1,
+// packages depending on those at depth 2 and so on. Zero means no limit.
 type ReverseDependencyQuery struct {
 	Field      string
 	IncludeAll bool
 	Recursive  bool
+	Depth      int
 	Patterns   []string
 }
 
+// ErrDepthLimitReached is returned by ReverseDependencies, together with the
+// matches found so far, when a recursive search stopped at the query's Depth
+// while more packages remained to be found.
+var ErrDepthLimitReached = errors.New("depth limit reached, results may be incomplete")
+
 // ReverseDependencies returns packages that declare a relationship with the
 // provided target patterns. Patterns follow shell glob semantics. When
 // recursive is enabled the search is extended to packages that depend on the
-// matches as well.
+// matches as well, up to q.Depth levels.
 func (m *Manager) ReverseDependencies(q ReverseDependencyQuery) ([]string, error) {
 	if err := m.ensureIndexesLoaded(); err != nil {
 		return nil, err
@@ -251,33 +1027,50 @@ func (m *Manager) ReverseDependencies(q ReverseDependencyQuery) ([]string, error
 	if len(q.Patterns) == 0 {
 		return nil, errors.New("at least one package name or glob is required")
 	}
+	if q.Depth < 0 {
+		return nil, fmt.Errorf("invalid depth %d", q.Depth)
+	}
 
-	universe := m.indexes.All()
+	universe := m.indexSet().All()
+	status := m.Status()
 	if q.IncludeAll {
-		universe = appendMissingInstalled(universe, m.status)
+		universe = appendMissingInstalled(universe, status)
 	} else {
-		universe = appendMissingInstalled(filterInstalled(universe, m.status), m.status)
+		universe = appendMissingInstalled(filterInstalled(universe, status), status)
 	}
 
-	queue := append([]string(nil), q.Patterns...)
+	type target struct {
+		name  string
+		depth int
+	}
+	var queue []target
+	for _, pattern := range q.Patterns {
+		queue = append(queue, target{name: pattern})
+	}
 	seenTargets := map[string]bool{}
 	matched := map[string]bool{}
+	// unexpanded holds the matches the depth limit kept off the queue.
+	var unexpanded []string
 
 	for len(queue) > 0 {
-		target := queue[0]
+		t := queue[0]
 		queue = queue[1:]
-		if seenTargets[target] {
+		if seenTargets[t.name] {
 			continue
 		}
-		seenTargets[target] = true
+		seenTargets[t.name] = true
 		for _, pkg := range universe {
 			if matched[pkg.Name] {
 				continue
 			}
-			if relationMatches(pkg.Raw.Value(q.Field), target) {
+			if relationMatches(pkg.Raw.Value(q.Field), t.name) {
 				matched[pkg.Name] = true
-				if q.Recursive {
-					queue = append(queue, pkg.Name)
+				switch {
+				case !q.Recursive:
+				case q.Depth > 0 && t.depth+1 >= q.Depth:
+					unexpanded = append(unexpanded, pkg.Name)
+				default:
+					queue = append(queue, target{name: pkg.Name, depth: t.depth + 1})
 				}
 			}
 		}
@@ -288,9 +1081,94 @@ func (m *Manager) ReverseDependencies(q ReverseDependencyQuery) ([]string, error
 		result = append(result, name)
 	}
 	sort.Strings(result)
+
+	// Only report truncation when expanding further would have found
+	// something; a search that ends exactly at the limit is complete.
+	for _, pkg := range universe {
+		if matched[pkg.Name] {
+			continue
+		}
+		for _, name := range unexpanded {
+			if relationMatches(pkg.Raw.Value(q.Field), name) {
+				logging.Debugf("pkgmgr: reverse dependencies: stopped at depth %d before %s", q.Depth, pkg.Name)
+				return result, ErrDepthLimitReached
+			}
+		}
+	}
 	return result, nil
 }
 
+// ReverseProvides returns the feed packages whose Provides field names
+// capability, sorted by name. Like whatprovides, capability may be a glob
+// and versions in Provides are ignored; unlike FindProviders, a package
+// named capability is not a match unless it provides it too. The packages
+// carry their full index metadata, including Filename for downloading.
+func (m *Manager) ReverseProvides(capability string) ([]repo.Package, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	if capability == "" {
+		return nil, errors.New("a capability is required")
+	}
+	var providers []repo.Package
+	for _, pkg := range m.indexSet().All() {
+		if relationMatches(pkg.Raw.Value("Provides"), capability) {
+			providers = append(providers, pkg)
+		}
+	}
+	sort.SliceStable(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+	return providers, nil
+}
+
+// FindProviders returns every package able to satisfy capability, which is
+// a relation such as "libssl1.1" or "libssl1.1 (= 1.1.1)". Packages match by
+// name or through their Provides field. A versioned requirement is only met by
+// a Provides entry that declares an exact version satisfying it.
+func (m *Manager) FindProviders(ctx context.Context, capability string) ([]repo.Package, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	want, err := version.ParseRelation(capability)
+	if err != nil {
+		return nil, fmt.Errorf("parse capability: %w", err)
+	}
+
+	var providers []repo.Package
+	for _, pkg := range appendMissingInstalled(m.indexSet().All(), m.Status()) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if want.SatisfiedBy(pkg.Name, pkg.Version) || providesCapability(pkg.Raw.Value("Provides"), want) {
+			providers = append(providers, pkg)
+		}
+	}
+	sort.SliceStable(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+	return providers, nil
+}
+
+// providesCapability reports whether a Provides field satisfies want.
+func providesCapability(field string, want version.Relation) bool {
+	if field == "" {
+		return false
+	}
+	for _, group := range version.ParseRelations(field) {
+		for _, provided := range group {
+			if provided.Name != want.Name {
+				continue
+			}
+			if len(want.Constraints) == 0 {
+				return true
+			}
+			for _, c := range provided.Constraints {
+				if c.Op == "=" && want.SatisfiedBy(provided.Name, c.Version) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func filterInstalled(pkgs []repo.Package, status *pkgdb.Status) []repo.Package {
 	var out []repo.Package
 	for _, pkg := range pkgs {
@@ -306,19 +1184,64 @@ func (m *Manager) Dependencies(name string) (map[string][]string, error) {
 	if err := m.ensureIndexesLoaded(); err != nil {
 		return nil, err
 	}
-	pkg, ok := m.indexes.Find(name)
+	pkg, ok := m.findPackage(name)
 	if !ok {
-		entry, err := m.status.Lookup(name)
+		entry, err := m.Status().Lookup(name)
 		if err != nil {
-			return nil, fmt.Errorf("package %s not found", name)
+			return nil, &PackageNotFoundError{Name: name}
 		}
 		return dependenciesFromParagraph(entry.Raw), nil
 	}
 	return dependenciesFromParagraph(pkg.Raw), nil
 }
 
+// RecursiveDependencies returns the relationships of name and of every
+// package it depends on through Depends and Pre-Depends, directly or
+// transitively, keyed by package name. Every alternative of a dependency is
+// followed. Unless includeAll is set, only installed packages are
+// considered, with the relationships recorded in the status database, and
+// name must be installed.
+func (m *Manager) RecursiveDependencies(name string, includeAll bool) (map[string]map[string][]string, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	status := m.Status()
+	lookup := func(name string) (format.Paragraph, bool) {
+		if includeAll {
+			return m.lookupParagraph(name)
+		}
+		entry, err := status.Lookup(name)
+		if err != nil || !entry.IsFullyInstalled() {
+			return format.Paragraph{}, false
+		}
+		return entry.Raw, true
+	}
+	if _, ok := lookup(name); !ok {
+		return nil, &PackageNotFoundError{Name: name}
+	}
+	tree := map[string]map[string][]string{}
+	queue := []string{name}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if _, seen := tree[current]; seen {
+			continue
+		}
+		p, ok := lookup(current)
+		if !ok {
+			logging.Debugf("pkgmgr: recursive dependencies: %s not available, not expanded", current)
+			continue
+		}
+		deps := dependenciesFromParagraph(p)
+		tree[current] = deps
+		queue = append(queue, deps["Pre-Depends"]...)
+		queue = append(queue, deps["Depends"]...)
+	}
+	return tree, nil
+}
+
 func dependenciesFromParagraph(p format.Paragraph) map[string][]string {
-	fields := []string{"Depends", "Pre-Depends", "Recommends", "Suggests", "Provides", "Conflicts", "Replaces"}
+	fields := []string{"Depends", "Pre-Depends", "Recommends", "Suggests", "Provides", "Conflicts", "Breaks", "Replaces"}
 	result := make(map[string][]string, len(fields))
 	for _, field := range fields {
 		if value := p.Value(field); value != "" {
@@ -330,21 +1253,9 @@ func dependenciesFromParagraph(p format.Paragraph) map[string][]string {
 
 func tokensFromRelations(field string) []string {
 	var result []string
-	for _, clause := range strings.Split(field, ",") {
-		clause = strings.TrimSpace(clause)
-		if clause == "" {
-			continue
-		}
-		for _, part := range strings.Split(clause, "|") {
-			part = strings.TrimSpace(part)
-			if part == "" {
-				continue
-			}
-			name := part
-			if idx := strings.IndexAny(part, " (<>="); idx >= 0 {
-				name = strings.TrimSpace(part[:idx])
-			}
-			result = append(result, name)
+	for _, group := range version.ParseRelations(field) {
+		for _, rel := range group {
+			result = append(result, rel.Name)
 		}
 	}
 	return result
@@ -382,53 +1293,367 @@ func appendMissingInstalled(pkgs []repo.Package, status *pkgdb.Status) []repo.Pa
 	return pkgs
 }
 
-// FindPackages performs a substring search across package names and
-// descriptions.
-func (m *Manager) FindPackages(pattern string) ([]repo.Package, error) {
+// FindPackages performs a case-insensitive substring search across package
+// names and descriptions. Each feed index is searched on its own goroutine
+// with Index.SearchName and Index.SearchDescription and the results are
+// merged. The search stops with ctx.Err() when ctx is cancelled; ctx is
+// checked before each index is searched by name and by description.
+func (m *Manager) FindPackages(ctx context.Context, pattern string) ([]repo.Package, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	indexes := m.indexSet().Indexes()
+	results := make([][]repo.Package, len(indexes))
+	var wg sync.WaitGroup
+	for i, idx := range indexes {
+		wg.Add(1)
+		go func(i int, idx repo.Index) {
+			defer wg.Done()
+			found := idx.SearchName(pattern)
+			if ctx.Err() != nil {
+				return
+			}
+			byName := make(map[string]bool, len(found))
+			for _, pkg := range found {
+				byName[pkg.Name] = true
+			}
+			for _, pkg := range idx.SearchDescription(pattern) {
+				if !byName[pkg.Name] {
+					found = append(found, pkg)
+				}
+			}
+			var matches []repo.Package
+			for _, pkg := range found {
+				if m.archAllowed(pkg.Architecture) {
+					matches = append(matches, pkg)
+				}
+			}
+			results[i] = matches
+		}(i, idx)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var matches []repo.Package
+	for _, found := range results {
+		matches = append(matches, found...)
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Name < matches[j].Name })
+	return matches, nil
+}
+
+// SearchOptions selects the packages returned by Search. Every non-empty
+// filter must match.
+type SearchOptions struct {
+	// NamePattern is a glob matched against the package name, or a regular
+	// expression when Regex is set.
+	NamePattern string
+	// DescPattern is a case-insensitive substring of the description, or a
+	// regular expression when Regex is set.
+	DescPattern string
+	Regex       bool
+	// Sections and Maintainer filter like ListOptions.Sections and
+	// ListOptions.MaintainerPattern.
+	Sections   []string
+	Maintainer string
+}
+
+// matcher compiles the name and description filters of opts.
+func (opts SearchOptions) matcher() (func(repo.Package) bool, error) {
+	if _, err := path.Match(opts.Maintainer, ""); err != nil {
+		return nil, fmt.Errorf("invalid maintainer pattern %q: %w", opts.Maintainer, err)
+	}
+	var matchName, matchDesc func(string) bool
+	if opts.Regex {
+		name, err := regexp.Compile(opts.NamePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name pattern: %w", err)
+		}
+		desc, err := regexp.Compile(opts.DescPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid description pattern: %w", err)
+		}
+		matchName, matchDesc = name.MatchString, desc.MatchString
+	} else {
+		if _, err := path.Match(opts.NamePattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern %q: %w", opts.NamePattern, err)
+		}
+		matchName = func(name string) bool {
+			ok, _ := path.Match(opts.NamePattern, name)
+			return ok
+		}
+		needle := strings.ToLower(opts.DescPattern)
+		matchDesc = func(desc string) bool {
+			return strings.Contains(strings.ToLower(desc), needle)
+		}
+	}
+	list := ListOptions{Sections: opts.Sections, MaintainerPattern: opts.Maintainer}
+	return func(pkg repo.Package) bool {
+		return (opts.NamePattern == "" || matchName(pkg.Name)) &&
+			(opts.DescPattern == "" || matchDesc(pkg.Description)) &&
+			list.selects(pkg.Raw)
+	}, nil
+}
+
+// Search returns the index packages passing every filter of opts, sorted by
+// name. Packages found in several feeds are reported once per feed.
+func (m *Manager) Search(ctx context.Context, opts SearchOptions) ([]repo.Package, error) {
+	match, err := opts.matcher()
+	if err != nil {
+		return nil, err
+	}
 	if err := m.ensureIndexesLoaded(); err != nil {
 		return nil, err
 	}
-	pattern = strings.ToLower(pattern)
 	var matches []repo.Package
-	for _, pkg := range m.indexes.All() {
-		if strings.Contains(strings.ToLower(pkg.Name), pattern) || strings.Contains(strings.ToLower(pkg.Description), pattern) {
-			matches = append(matches, pkg)
+	for _, idx := range m.indexSet().Indexes() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, pkg := range idx.Packages {
+			if m.archAllowed(pkg.Architecture) && match(pkg) {
+				matches = append(matches, pkg)
+			}
 		}
 	}
-	sort.Slice(matches, func(i, j int) bool { return matches[i].Name < matches[j].Name })
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Name < matches[j].Name })
 	return matches, nil
 }
 
 // InfoParagraphs returns metadata for packages matching the provided patterns.
+// Each package name is reported once, using the paragraph of the package
+// findPackage selects, and index packages are sorted by name. Installed
+// packages carry the Status, Auto-Installed, Conffiles and Installed-Size
+// fields of the status database. Lookups are cached until the indexes
+// change.
 func (m *Manager) InfoParagraphs(patterns []string) ([]format.Paragraph, error) {
 	if err := m.ensureIndexesLoaded(); err != nil {
 		return nil, err
 	}
+	cache := m.paragraphCache()
+	status := m.Status()
+	names := m.infoNames(cache, patterns)
+	paragraphs := make([]format.Paragraph, 0, len(names))
+	for _, name := range names {
+		p, ok := cache.lookup(m, name)
+		if !ok {
+			continue
+		}
+		if entry, err := status.Lookup(name); err == nil {
+			p = withStatusFields(p, entry.Raw)
+		}
+		paragraphs = append(paragraphs, p)
+	}
+	return append(paragraphs, m.unindexedEntries(cache, patterns)...), nil
+}
+
+// RawInfoParagraphs returns the paragraphs InfoParagraphs selects for
+// patterns as they appear in the cached indexes, so RawBytes reproduces the
+// text the feed served. Status fields are not merged in. Installed packages
+// missing from the index are returned as recorded in the status database.
+func (m *Manager) RawInfoParagraphs(patterns []string) ([]format.Paragraph, error) {
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return nil, err
+	}
+	cache := m.paragraphCache()
+	// Each cached index is parsed again, once, with its raw text kept.
+	feeds := map[string]map[string]format.Paragraph{}
 	var paragraphs []format.Paragraph
-	seen := map[string]bool{}
-	for _, pkg := range m.indexes.All() {
-		if !matchesAny(pkg.Name, patterns) {
+	for _, name := range m.infoNames(cache, patterns) {
+		pkg, ok := m.findPackage(name)
+		if !ok {
 			continue
 		}
-		paragraphs = append(paragraphs, pkg.Raw)
-		seen[pkg.Name] = true
+		byName, ok := feeds[pkg.Feed.Name]
+		if !ok {
+			raw, err := repo.CachedParagraphs(pkg.Feed, m.cache)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			byName = make(map[string]format.Paragraph, len(raw))
+			for _, p := range raw {
+				byName[p.Value("Package")] = p
+			}
+			feeds[pkg.Feed.Name] = byName
+		}
+		if p, ok := byName[name]; ok {
+			paragraphs = append(paragraphs, p)
+		} else {
+			paragraphs = append(paragraphs, pkg.Raw)
+		}
 	}
-	// Include installed packages that are missing from the index.
-	for _, entry := range m.status.Entries() {
-		if seen[entry.Name] {
+	return append(paragraphs, m.unindexedEntries(cache, patterns)...), nil
+}
+
+// infoNames returns the sorted, distinct package names InfoParagraphs
+// reports for patterns.
+func (m *Manager) infoNames(cache *paragraphCache, patterns []string) []string {
+	var names []string
+	if literalPatterns(patterns) {
+		names = append(names, patterns...)
+		sort.Strings(names)
+		return slices.Compact(names)
+	}
+	for _, name := range cache.sortedNames(m) {
+		if matchesAny(name, patterns) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// unindexedEntries returns the status paragraphs of installed packages that
+// match patterns but are missing from the index.
+func (m *Manager) unindexedEntries(cache *paragraphCache, patterns []string) []format.Paragraph {
+	var paragraphs []format.Paragraph
+	for _, entry := range m.Status().Entries() {
+		if _, ok := cache.lookup(m, entry.Name); ok {
 			continue
 		}
 		if matchesAny(entry.Name, patterns) {
 			paragraphs = append(paragraphs, entry.Raw)
 		}
 	}
-	return paragraphs, nil
+	return paragraphs
+}
+
+// statusOnlyFields are the fields only the status database records; they
+// replace whatever the index paragraph says.
+var statusOnlyFields = []string{"Status", "Auto-Installed", "Conffiles"}
+
+// withStatusFields returns a copy of the index paragraph p completed with
+// the installation details of the status paragraph st. Installed-Size is
+// only added when the index lacks it. p itself is not modified, so cached
+// paragraphs can be passed in.
+func withStatusFields(p, st format.Paragraph) format.Paragraph {
+	p = p.Clone()
+	for _, key := range statusOnlyFields {
+		if v := st.Value(key); v != "" {
+			p.MergeOverride(format.Paragraph{Fields: map[string]string{key: v}})
+		}
+	}
+	if size := st.Value("Installed-Size"); size != "" {
+		p.Merge(format.Paragraph{Fields: map[string]string{"Installed-Size": size}})
+	}
+	return p
+}
+
+// paragraphCache memoises the paragraph findPackage selects for each package
+// name. A cache belongs to one set of indexes; setIndexes and Reset drop it.
+type paragraphCache struct {
+	mu     sync.RWMutex
+	byName map[string]cachedParagraph
+	names  []string
+}
+
+type cachedParagraph struct {
+	paragraph format.Paragraph
+	ok        bool
+}
+
+// paragraphCache returns the cache for the current indexes, creating it on
+// first use.
+func (m *Manager) paragraphCache() *paragraphCache {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.paragraphs == nil {
+		m.paragraphs = &paragraphCache{byName: map[string]cachedParagraph{}}
+	}
+	return m.paragraphs
 }
 
-// GlobStatus returns paragraphs from the status database matching the
+// lookup returns the paragraph of the package findPackage selects for name.
+// Misses are cached as well.
+func (c *paragraphCache) lookup(m *Manager, name string) (format.Paragraph, bool) {
+	c.mu.RLock()
+	entry, hit := c.byName[name]
+	c.mu.RUnlock()
+	if hit {
+		return entry.paragraph, entry.ok
+	}
+	pkg, ok := m.findPackage(name)
+	entry = cachedParagraph{paragraph: pkg.Raw, ok: ok}
+	c.mu.Lock()
+	c.byName[name] = entry
+	c.mu.Unlock()
+	return entry.paragraph, entry.ok
+}
+
+// sortedNames returns the distinct package names across all indexes in
+// ascending order. The result must not be modified.
+func (c *paragraphCache) sortedNames(m *Manager) []string {
+	c.mu.RLock()
+	names := c.names
+	c.mu.RUnlock()
+	if names != nil {
+		return names
+	}
+	seen := map[string]bool{}
+	names = []string{}
+	for _, idx := range m.indexSet().Indexes() {
+		for name := range idx.Packages {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	c.mu.Lock()
+	c.names = names
+	c.mu.Unlock()
+	return names
+}
+
+// literalPatterns reports whether patterns is non-empty and contains no glob
+// meta characters, so that every pattern is an exact package name.
+func literalPatterns(patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	for _, pattern := range patterns {
+		if strings.ContainsAny(pattern, `*?[\`) {
+			return false
+		}
+	}
+	return true
+}
+
+// ListInstalledVersions maps the name of every installed package to its
+// installed version.
+func (m *Manager) ListInstalledVersions() (map[string]string, error) {
+	return m.ListInstalledVersionsWith(ListOptions{})
+}
+
+// ListInstalledVersionsWith is ListInstalledVersions restricted to the
+// packages matching the Patterns and filters of opts, as list-installed
+// selects them.
+func (m *Manager) ListInstalledVersionsWith(opts ListOptions) (map[string]string, error) {
+	status := m.Status()
+	versions := map[string]string{}
+	for _, entry := range status.Entries() {
+		if status.Installed(entry.Name) && matchesAny(entry.Name, opts.Patterns) && opts.selects(entry.Raw) {
+			versions[entry.Name] = entry.Version
+		}
+	}
+	return versions, nil
+}
+
+// GlobStatusEntries returns the entries of the status database matching the
 // provided patterns. If no patterns are supplied all entries are returned.
-func (m *Manager) GlobStatus(patterns []string) []format.Paragraph {
-	entries := m.StatusParagraphs(patterns)
+func (m *Manager) GlobStatusEntries(patterns []string) []pkgdb.Entry {
+	return m.StatusParagraphs(patterns)
+}
+
+// GlobStatusParagraphs is like GlobStatusEntries but returns the raw status
+// paragraphs, for callers that format every field.
+func (m *Manager) GlobStatusParagraphs(patterns []string) []format.Paragraph {
+	entries := m.GlobStatusEntries(patterns)
 	out := make([]format.Paragraph, 0, len(entries))
 	for _, entry := range entries {
 		out = append(out, entry.Raw)
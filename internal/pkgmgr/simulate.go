@@ -0,0 +1,152 @@
+package pkgmgr
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+
+	"github.com/oe-mirrors/opkg_go/internal/logging"
+	"github.com/oe-mirrors/opkg_go/internal/repo"
+)
+
+// SimulateOptions selects the operation Simulate plans.
+type SimulateOptions struct {
+	// Install names packages to install, as passed to Install.
+	Install []string
+	// Upgrade plans the upgrade of installed packages, restricted to the
+	// names matching Patterns when there are any.
+	Upgrade  bool
+	Patterns []string
+}
+
+// SimulatedPackage is one package of a SimulationResult. Version is the
+// version the operation would install, or the installed version for
+// removals. Installed is empty for packages that are not installed.
+type SimulatedPackage struct {
+	Name      string
+	Version   string
+	Installed string
+	Feed      string
+}
+
+// SimulationResult describes what an install or upgrade would change. It
+// follows the plans Install and Upgrade execute (see InstallPlan): ToInstall
+// and ToUpgrade hold the requested packages, their Pre-Depends and, for
+// installs, the recommended packages. Plain Depends are not installed and
+// are not listed. ToRemove lists the installed packages that conflict with
+// the new ones; they must be removed before the operation can succeed.
+// Missing lists the Pre-Depends requirements no feed can satisfy.
+type SimulationResult struct {
+	ToInstall []SimulatedPackage
+	ToRemove  []SimulatedPackage
+	ToUpgrade []SimulatedPackage
+	Conflicts []ConflictSet
+	Missing   []string
+}
+
+// Simulate plans the operation described by opts without downloading
+// anything or modifying the status database.
+func (m *Manager) Simulate(ctx context.Context, opts SimulateOptions) (SimulationResult, error) {
+	var res SimulationResult
+	if err := m.ensureIndexesLoaded(); err != nil {
+		return res, err
+	}
+	status := m.Status()
+	planned := map[string]bool{}
+	missing := map[string]bool{}
+	var targets []string
+	// add lists pkg as an install or an upgrade, unless it is already
+	// planned or installed in that version.
+	add := func(pkg repo.Package) {
+		if planned[pkg.Name] {
+			return
+		}
+		entry, err := status.Lookup(pkg.Name)
+		switch {
+		case err != nil || !entry.IsFullyInstalled():
+			res.ToInstall = append(res.ToInstall, SimulatedPackage{Name: pkg.Name, Version: pkg.Version, Feed: pkg.Feed.Name})
+		case entry.Version != pkg.Version:
+			res.ToUpgrade = append(res.ToUpgrade, SimulatedPackage{Name: pkg.Name, Version: pkg.Version, Installed: entry.Version, Feed: pkg.Feed.Name})
+		default:
+			logging.Debugf("pkgmgr: simulate: %s %s is already installed", pkg.Name, pkg.Version)
+			return
+		}
+		planned[pkg.Name] = true
+		targets = append(targets, pkg.Name)
+	}
+	// follow adds the packages of plan in the order install executes them.
+	follow := func(plan InstallPlan, err error) error {
+		var preErr *PreDependsError
+		if errors.As(err, &preErr) {
+			missing[preErr.Requirement] = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, name := range slices.Concat(plan.PreDepends, []string{plan.Package.Name}, plan.Recommends) {
+			if name == plan.Package.Name {
+				add(plan.Package)
+			} else if pkg, ok := m.findPackage(name); ok {
+				add(pkg)
+			}
+		}
+		return nil
+	}
+
+	if opts.Upgrade {
+		candidates, err := m.ListUpgradable(opts.Patterns)
+		if err != nil {
+			return res, err
+		}
+		for _, c := range candidates {
+			if err := follow(m.planPackage(c.Package)); err != nil {
+				return res, err
+			}
+		}
+	}
+	for _, name := range opts.Install {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		pkg, ok := m.findPackage(name)
+		if !ok {
+			return res, &PackageNotFoundError{Name: name}
+		}
+		if pkg.IsVirtual() {
+			return res, m.virtualPackageError(ctx, name)
+		}
+		if planned[pkg.Name] {
+			continue
+		}
+		if err := follow(m.PlanInstall(name)); err != nil {
+			return res, err
+		}
+	}
+	res.Missing = sortedKeys(missing)
+
+	if len(targets) > 0 {
+		conflicts, err := m.ListConflicting(targets)
+		if err != nil {
+			return res, err
+		}
+		res.Conflicts = conflicts
+		removed := map[string]bool{}
+		for _, c := range conflicts {
+			if removed[c.ConflictingWith] {
+				continue
+			}
+			removed[c.ConflictingWith] = true
+			entry, _ := status.Lookup(c.ConflictingWith)
+			res.ToRemove = append(res.ToRemove, SimulatedPackage{Name: entry.Name, Version: entry.Version, Installed: entry.Version})
+		}
+	}
+
+	for _, list := range [][]SimulatedPackage{res.ToInstall, res.ToRemove, res.ToUpgrade} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+	logging.Debugf("pkgmgr: simulate: %d to install, %d to upgrade, %d to remove",
+		len(res.ToInstall), len(res.ToUpgrade), len(res.ToRemove))
+	return res, nil
+}
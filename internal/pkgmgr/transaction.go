@@ -0,0 +1,99 @@
+package pkgmgr
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/oe-mirrors/opkg_go/internal/logging"
+)
+
+type txKind int
+
+const (
+	txInstall txKind = iota
+	txRemove
+)
+
+type txOp struct {
+	kind txKind
+	name string
+}
+
+// Transaction records operations that Manager.Transaction executes as a
+// single unit. Queuing an operation does not perform it.
+type Transaction struct {
+	ops []txOp
+}
+
+// Install queues the installation of the named package.
+func (tx *Transaction) Install(name string) {
+	tx.ops = append(tx.ops, txOp{kind: txInstall, name: name})
+}
+
+// Remove queues the removal of the named package from the status database.
+func (tx *Transaction) Remove(name string) {
+	tx.ops = append(tx.ops, txOp{kind: txRemove, name: name})
+}
+
+// Transaction calls fn to queue operations and then executes them in order.
+// Installs are recorded in the status database like Install does. When an
+// operation fails the completed ones are reversed: the archives they added
+// to the cache, pre-dependencies included, are deleted and the status
+// database is restored to its previous contents. Nothing is executed when fn
+// returns an error.
+func (m *Manager) Transaction(ctx context.Context, fn func(*Transaction) error) error {
+	tx := &Transaction{}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	var undo []func() error
+	rollback := func(cause error) error {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err := undo[i](); err != nil {
+				logging.Debugf("pkgmgr: rollback step failed: %v", err)
+				cause = fmt.Errorf("%w (rollback incomplete: %v)", cause, err)
+			}
+		}
+		return cause
+	}
+
+	for _, op := range tx.ops {
+		// Both operations write the status database; snapshot it so a
+		// later failure can put it back.
+		status := m.Status()
+		if status.Path() != "" {
+			prev, err := status.Bytes()
+			if err != nil {
+				return rollback(err)
+			}
+			undo = append(undo, func() error {
+				logging.Debugf("pkgmgr: restoring status database")
+				return status.Restore(prev)
+			})
+		}
+		switch op.kind {
+		case txInstall:
+			plan, err := m.PlanInstall(op.name)
+			if err != nil {
+				return rollback(fmt.Errorf("install %s: %w", op.name, err))
+			}
+			_, added, err := m.install(ctx, plan)
+			for _, path := range added {
+				undo = append(undo, func() error {
+					logging.Debugf("pkgmgr: rolling back download %s", path)
+					return os.Remove(path)
+				})
+			}
+			if err != nil {
+				return rollback(fmt.Errorf("install %s: %w", op.name, err))
+			}
+		case txRemove:
+			if err := m.RecordRemove(op.name); err != nil {
+				return rollback(err)
+			}
+		}
+	}
+	return nil
+}
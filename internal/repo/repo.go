@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -28,20 +32,189 @@ type Package struct {
 	Description  string
 	Filename     string
 	Size         string
+	Checksum     PackageChecksum
 	Feed         config.Feed
 	Raw          format.Paragraph
 }
 
+// PackageChecksum holds the hex encoded digests an index declares for a
+// package archive. Fields the index omits are empty.
+type PackageChecksum struct {
+	MD5    string
+	SHA256 string
+	SHA512 string
+}
+
+// parseChecksum reads the checksum fields of an index paragraph. opkg
+// writes MD5Sum and SHA256sum; the Debian spellings are accepted too.
+func parseChecksum(p format.Paragraph) PackageChecksum {
+	return PackageChecksum{
+		MD5:    p.Value("MD5Sum"),
+		SHA256: firstValue(p, "SHA256sum", "SHA256"),
+		SHA512: firstValue(p, "SHA512sum", "SHA512"),
+	}
+}
+
+func firstValue(p format.Paragraph, keys ...string) string {
+	for _, key := range keys {
+		if v := p.Value(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// FullURL returns the download URL of the package archive. Filename values
+// that are already absolute http(s) URLs are returned unchanged; otherwise the
+// filename is resolved relative to the feed URI.
+func (p Package) FullURL() string {
+	if strings.HasPrefix(p.Filename, "http://") || strings.HasPrefix(p.Filename, "https://") {
+		return p.Filename
+	}
+	return strings.TrimSuffix(p.Feed.URI, "/") + "/" + strings.TrimPrefix(p.Filename, "/")
+}
+
+// DownloadSize returns the size in bytes of the package archive declared by
+// the Size field, or zero when the index declares none.
+func (p Package) DownloadSize() (int64, error) {
+	return parseSize(p.Name, "Size", p.Size)
+}
+
+// InstalledSize returns the disk space in bytes the installed package
+// needs. The Installed-Size field counts kibibytes; zero is returned when
+// the index declares no size.
+func (p Package) InstalledSize() (int64, error) {
+	kib, err := parseSize(p.Name, "Installed-Size", p.Raw.Value("Installed-Size"))
+	return kib * 1024, err
+}
+
+func parseSize(name, field, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err == nil && n < 0 {
+		err = errors.New("negative size")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("package %s: invalid %s %q: %w", name, field, value, err)
+	}
+	return n, nil
+}
+
+// Source returns the name and version of the source package p was built
+// from. The Source field is either a name or a name followed by a version in
+// parentheses, as in "busybox (1.36.1-r0)". Without a version the binary
+// version is used, and without a Source field the binary name too.
+func (p Package) Source() (name, version string) {
+	name, version = p.Name, p.Version
+	field := strings.TrimSpace(p.Raw.Value("Source"))
+	if field == "" {
+		return name, version
+	}
+	name = field
+	if i := strings.IndexByte(field, '('); i >= 0 {
+		name = strings.TrimSpace(field[:i])
+		if v, _, ok := strings.Cut(field[i+1:], ")"); ok && strings.TrimSpace(v) != "" {
+			version = strings.TrimSpace(v)
+		}
+	}
+	return name, version
+}
+
+// IsVirtual reports whether the package has no archive of its own and only
+// exists to satisfy Provides declarations of other packages.
+func (p Package) IsVirtual() bool {
+	return p.Filename == ""
+}
+
 // Index contains the parsed metadata for a feed.
 type Index struct {
 	Feed     config.Feed
 	Packages map[string]Package
 	Updated  time.Time
+
+	// updatedAt is the fetch time recorded in the cache sidecar file.
+	updatedAt time.Time
+}
+
+// UpdatedAt returns when the index was last fetched from its feed, as
+// persisted in the cache sidecar file, or the zero time when it was never
+// persisted, for example because no cache directory is configured.
+func (idx Index) UpdatedAt() time.Time {
+	return idx.updatedAt
+}
+
+// indexMeta is the content of the sidecar file stored next to a cached
+// index.
+type indexMeta struct {
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SearchName returns the packages of the index whose name contains query,
+// ignoring case.
+func (idx Index) SearchName(query string) []Package {
+	query = strings.ToLower(query)
+	var out []Package
+	for _, pkg := range idx.Packages {
+		if strings.Contains(strings.ToLower(pkg.Name), query) {
+			out = append(out, pkg)
+		}
+	}
+	return out
+}
+
+// SearchDescription returns the packages of the index whose description
+// contains query, ignoring case. Results from several indexes can be combined
+// by the caller.
+func (idx Index) SearchDescription(query string) []Package {
+	query = strings.ToLower(query)
+	var out []Package
+	for _, pkg := range idx.Packages {
+		if strings.Contains(strings.ToLower(pkg.Description), query) {
+			out = append(out, pkg)
+		}
+	}
+	return out
+}
+
+// Statuses reported in FeedEvent.Status.
+const (
+	FeedFetching = "fetching"
+	FeedDone     = "done"
+	FeedError    = "error"
+)
+
+// FeedEvent reports the progress of a single feed during an update.
+type FeedEvent struct {
+	Feed   config.Feed
+	Status string
+	Err    error
+}
+
+// UpdateOptions tunes the behaviour of UpdateWith.
+type UpdateOptions struct {
+	// Events receives a FeedEvent before and after each feed is fetched
+	// when non-nil. The channel is not closed by UpdateWith and must have
+	// room for two events per feed or be drained concurrently.
+	Events chan<- FeedEvent
+	// Downloads bounds the number of feeds downloaded concurrently. Values
+	// below one download every feed at once.
+	Downloads int
+	// ParseWorkers bounds the number of feeds parsed concurrently once
+	// downloaded. Values below one use runtime.GOMAXPROCS(0).
+	ParseWorkers int
 }
 
 // Update fetches the Packages files for all feeds defined in the configuration
-// and stores them inside cacheDir. The function runs downloads concurrently.
+// and stores them inside cacheDir. The function runs downloads concurrently;
+// the indexes are returned in the order of the configured feeds.
 func Update(ctx context.Context, cfg *config.Config, cacheDir string, client *downloader.Client) ([]Index, error) {
+	return UpdateWith(ctx, cfg, cacheDir, client, UpdateOptions{})
+}
+
+// UpdateWith behaves like Update and additionally applies opts.
+func UpdateWith(ctx context.Context, cfg *config.Config, cacheDir string, client *downloader.Client, opts UpdateOptions) ([]Index, error) {
 	if cfg == nil {
 		return nil, errors.New("configuration required")
 	}
@@ -49,56 +222,227 @@ func Update(ctx context.Context, cfg *config.Config, cacheDir string, client *do
 		return nil, errors.New("downloader required")
 	}
 
-	logging.Debugf("repo: updating %d feeds", len(cfg.Feeds))
+	workers := opts.ParseWorkers
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	logging.Debugf("repo: updating %d feeds with %d parse workers", len(cfg.Feeds), workers)
 
-	var (
-		wg       sync.WaitGroup
-		mu       sync.Mutex
-		result   []Index
-		firstErr error
-	)
+	type fetched struct {
+		pos  int
+		feed config.Feed
+		data []byte
+		log  func(format string, args ...any)
+	}
+	type parsed struct {
+		pos   int
+		feed  config.Feed
+		index *Index
+		err   error
+		log   func(format string, args ...any)
+	}
+
+	emit := func(feed config.Feed, status string, err error) {
+		if opts.Events != nil {
+			opts.Events <- FeedEvent{Feed: feed, Status: status, Err: err}
+		}
+	}
 
-	for _, feed := range cfg.Feeds {
-		feed := feed
-		wg.Add(1)
+	// Downloads run one goroutine per feed; parsing is CPU bound and is
+	// handed to a fixed pool of workers through parseQueue.
+	parseQueue := make(chan fetched)
+	results := make(chan parsed)
+	var slots chan struct{}
+	if opts.Downloads > 0 {
+		slots = make(chan struct{}, opts.Downloads)
+	}
+	var downloads, parsers sync.WaitGroup
+	for pos, feed := range cfg.Feeds {
+		if feed.Disabled {
+			logging.WithFeed(feed.Name)("repo: skipping disabled feed")
+			continue
+		}
+		pos, feed := pos, feed
+		downloads.Add(1)
 		go func() {
-			defer wg.Done()
-			logging.Debugf("repo: fetching feed %s", feed.Name)
-			idx, err := fetchFeed(ctx, feed, cacheDir, client)
+			defer downloads.Done()
+			log := logging.WithFeed(feed.Name)
+			if slots != nil {
+				slots <- struct{}{}
+			}
+			log("repo: fetching feed")
+			emit(feed, FeedFetching, nil)
+			data, err := Fetch(ctx, feed, client)
+			if slots != nil {
+				<-slots
+			}
 			if err != nil {
-				mu.Lock()
-				if firstErr == nil {
-					firstErr = err
-					logging.Debugf("repo: feed %s failed: %v", feed.Name, err)
-				}
-				mu.Unlock()
+				results <- parsed{pos: pos, feed: feed, err: err, log: log}
 				return
 			}
-			logging.Debugf("repo: feed %s loaded with %d packages", feed.Name, len(idx.Packages))
-			mu.Lock()
-			result = append(result, *idx)
-			mu.Unlock()
+			parseQueue <- fetched{pos: pos, feed: feed, data: data, log: log}
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		parsers.Add(1)
+		go func() {
+			defer parsers.Done()
+			for job := range parseQueue {
+				idx, err := storeFeed(job.feed, job.data, cacheDir, job.log)
+				results <- parsed{pos: job.pos, feed: job.feed, index: idx, err: err, log: job.log}
+			}
 		}()
 	}
+	go func() {
+		downloads.Wait()
+		close(parseQueue)
+		parsers.Wait()
+		close(results)
+	}()
 
-	wg.Wait()
+	// Results arrive in completion order and are stored by feed position,
+	// so callers that prefer earlier feeds see the configured order.
+	var (
+		byFeed   = make([]*Index, len(cfg.Feeds))
+		firstErr error
+	)
+	for r := range results {
+		if r.err != nil {
+			emit(r.feed, FeedError, r.err)
+			if firstErr == nil {
+				firstErr = r.err
+				r.log("repo: feed failed: %v", r.err)
+			}
+			continue
+		}
+		r.log("repo: feed loaded with %d packages", len(r.index.Packages))
+		emit(r.feed, FeedDone, nil)
+		byFeed[r.pos] = r.index
+	}
 	if firstErr != nil {
 		return nil, firstErr
 	}
+	var result []Index
+	for _, idx := range byFeed {
+		if idx != nil {
+			result = append(result, *idx)
+		}
+	}
 	return result, nil
 }
 
-func fetchFeed(ctx context.Context, feed config.Feed, cacheDir string, client *downloader.Client) (*Index, error) {
+// storeFeed parses the downloaded index data of feed and writes it to the
+// cache directory when one is configured. log is the logger of the feed.
+func storeFeed(feed config.Feed, data []byte, cacheDir string, log func(format string, args ...any)) (*Index, error) {
+	index, err := ParseIndex(feed, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheDir != "" {
+		path := CachedIndexPath(cacheDir, feed)
+		if err := osWriteFile(path, data, 0o644); err != nil {
+			return nil, fmt.Errorf("cache feed %s: %w", feed.Name, err)
+		}
+		meta, err := json.Marshal(indexMeta{UpdatedAt: index.Updated.UTC()})
+		if err != nil {
+			return nil, err
+		}
+		if err := osWriteFile(CachedMetaPath(cacheDir, feed), meta, 0o644); err != nil {
+			return nil, fmt.Errorf("cache feed %s: %w", feed.Name, err)
+		}
+		index.updatedAt = index.Updated
+		log("repo: cached feed at %s", path)
+	}
+
+	return index, nil
+}
+
+// CachedIndexPath returns the path Update stores the index of feed under.
+func CachedIndexPath(cacheDir string, feed config.Feed) string {
+	return filepath.Join(cacheDir, fmt.Sprintf("%s.Packages", feed.Name))
+}
+
+// CachedMetaPath returns the path of the sidecar file recording when the
+// index cached at CachedIndexPath was fetched.
+func CachedMetaPath(cacheDir string, feed config.Feed) string {
+	return CachedIndexPath(cacheDir, feed) + ".meta"
+}
+
+// CachedUpdatedAt returns the fetch time recorded in the sidecar file of the
+// cached index of feed. Errors wrap os.ErrNotExist when there is none.
+func CachedUpdatedAt(feed config.Feed, cacheDir string) (time.Time, error) {
+	data, err := os.ReadFile(CachedMetaPath(cacheDir, feed))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("read metadata of feed %s: %w", feed.Name, err)
+	}
+	var meta indexMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return time.Time{}, fmt.Errorf("parse metadata of feed %s: %w", feed.Name, err)
+	}
+	return meta.UpdatedAt, nil
+}
+
+// LoadCachedIndex parses the index of feed stored in cacheDir by a previous
+// Update. The index's Updated time is the modification time of the cached
+// file, and UpdatedAt is restored from the sidecar file when there is one.
+// Errors wrap os.ErrNotExist when the feed was never cached.
+func LoadCachedIndex(feed config.Feed, cacheDir string) (*Index, error) {
+	path := CachedIndexPath(cacheDir, feed)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("load cached feed %s: %w", feed.Name, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load cached feed %s: %w", feed.Name, err)
+	}
+	index, err := ParseIndex(feed, data)
+	if err != nil {
+		return nil, err
+	}
+	index.Updated = info.ModTime()
+	log := logging.WithFeed(feed.Name)
+	if updatedAt, err := CachedUpdatedAt(feed, cacheDir); err == nil {
+		index.updatedAt = updatedAt
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log("repo: ignoring cache metadata: %v", err)
+	}
+	log("repo: loaded cached feed from %s", path)
+	return index, nil
+}
+
+// CachedParagraphs parses the cached index of feed keeping the verbatim text
+// of each paragraph, so Paragraph.RawBytes returns it as the feed served it.
+func CachedParagraphs(feed config.Feed, cacheDir string) ([]format.Paragraph, error) {
+	f, err := os.Open(CachedIndexPath(cacheDir, feed))
+	if err != nil {
+		return nil, fmt.Errorf("load cached feed %s: %w", feed.Name, err)
+	}
+	defer f.Close()
+	cf, err := format.ParseControlOpts(f, format.ParseControlOptions{MaxBytes: MaxIndexSize, KeepRaw: true})
+	if err != nil {
+		return nil, fmt.Errorf("parse feed %s: %w", feed.Name, err)
+	}
+	return cf.Paragraphs, nil
+}
+
+// MaxIndexSize bounds the size of a Packages index, compressed or not, so a
+// misbehaving feed cannot exhaust memory.
+const MaxIndexSize = 100 << 20
+
+// Fetch downloads the Packages index of a feed, preferring Packages.gz, and
+// returns the uncompressed contents. Indexes larger than MaxIndexSize are
+// rejected.
+func Fetch(ctx context.Context, feed config.Feed, client *downloader.Client) ([]byte, error) {
 	if feed.URI == "" {
 		return nil, fmt.Errorf("feed %s has empty URI", feed.Name)
 	}
-	base := strings.TrimSuffix(feed.URI, "/")
-	urls := []string{base + "/Packages.gz", base + "/Packages"}
 	var data []byte
 	var err error
-	for _, url := range urls {
+	for _, url := range indexURLs(feed) {
 		logging.Debugf("repo: attempting %s", url)
-		data, err = client.GetBytes(ctx, url)
+		data, err = client.GetBytesLimited(ctx, url, MaxIndexSize)
 		if err == nil {
 			break
 		}
@@ -114,18 +458,85 @@ func fetchFeed(ctx context.Context, feed config.Feed, cacheDir string, client *d
 			return nil, fmt.Errorf("decompress %s: %w", feed.Name, err)
 		}
 		defer zr.Close()
-		data, err = ioReadAll(zr)
+		data, err = ioReadAll(io.LimitReader(zr, MaxIndexSize+1))
 		if err != nil {
 			return nil, fmt.Errorf("read %s: %w", feed.Name, err)
 		}
+		if len(data) > MaxIndexSize {
+			return nil, fmt.Errorf("decompress %s: %w: more than %d bytes", feed.Name, format.ErrLimitExceeded, MaxIndexSize)
+		}
 	}
+	return data, nil
+}
 
-	cf, err := format.ParseControl(bytes.NewReader(data))
+// FetchPrefix downloads at most maxBytes of the index at url, as returned
+// by Probe, and returns the complete paragraphs of its uncompressed start.
+// complete reports that the whole index fit within maxBytes.
+func FetchPrefix(ctx context.Context, feed config.Feed, client *downloader.Client, url string, maxBytes int64) (data []byte, complete bool, err error) {
+	data, err = client.GetPrefix(ctx, url, maxBytes+1)
 	if err != nil {
-		return nil, fmt.Errorf("parse feed %s: %w", feed.Name, err)
+		return nil, false, fmt.Errorf("fetch feed %s: %w", feed.Name, err)
+	}
+	complete = int64(len(data)) <= maxBytes
+	if !complete {
+		data = data[:maxBytes]
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, false, fmt.Errorf("decompress %s: %w", feed.Name, err)
+		}
+		defer zr.Close()
+		out, err := ioReadAll(io.LimitReader(zr, maxBytes+1))
+		// A compressed stream cut short ends early; keep what it held.
+		if err != nil && (complete || !errors.Is(err, io.ErrUnexpectedEOF)) {
+			return nil, false, fmt.Errorf("decompress %s: %w", feed.Name, err)
+		}
+		if int64(len(out)) > maxBytes {
+			complete = false
+			out = out[:maxBytes]
+		}
+		data = out
+	}
+	if !complete {
+		// Drop the paragraph the limit cut short.
+		end := bytes.LastIndex(data, []byte("\n\n"))
+		data = data[:end+1]
+	}
+	return data, complete, nil
+}
+
+// Probe checks with HEAD requests that the index of feed can be fetched,
+// preferring Packages.gz like Fetch, and returns the URL that answered.
+func Probe(ctx context.Context, feed config.Feed, client *downloader.Client) (string, error) {
+	if feed.URI == "" {
+		return "", fmt.Errorf("feed %s has empty URI", feed.Name)
 	}
+	var err error
+	for _, url := range indexURLs(feed) {
+		logging.Debugf("repo: probing %s", url)
+		if _, err = client.Head(ctx, url); err == nil {
+			return url, nil
+		}
+	}
+	return "", fmt.Errorf("probe feed %s: %w", feed.Name, err)
+}
+
+// indexURLs returns the locations of the index of feed in the order Fetch
+// tries them.
+func indexURLs(feed config.Feed) []string {
+	base := strings.TrimSuffix(feed.URI, "/")
+	return []string{base + "/Packages.gz", base + "/Packages"}
+}
+
+// ParseIndex parses uncompressed Packages data belonging to feed.
+func ParseIndex(feed config.Feed, data []byte) (*Index, error) {
+	logging.WithFeed(feed.Name)("repo: parsing feed")
 
-	logging.Debugf("repo: parsing feed %s", feed.Name)
+	cf, err := format.ParseControlWithLimit(bytes.NewReader(data), MaxIndexSize)
+	if err != nil {
+		return nil, fmt.Errorf("parse feed %s: %w", feed.Name, err)
+	}
 
 	index := Index{
 		Feed:     feed,
@@ -145,19 +556,11 @@ func fetchFeed(ctx context.Context, feed config.Feed, cacheDir string, client *d
 			Description:  paragraph.Value("Description"),
 			Filename:     paragraph.Value("Filename"),
 			Size:         paragraph.Value("Size"),
+			Checksum:     parseChecksum(paragraph),
 			Feed:         feed,
 			Raw:          paragraph,
 		}
 	}
-
-	if cacheDir != "" {
-		path := filepath.Join(cacheDir, fmt.Sprintf("%s.Packages", feed.Name))
-		if err := osWriteFile(path, data, 0o644); err != nil {
-			return nil, fmt.Errorf("cache feed %s: %w", feed.Name, err)
-		}
-		logging.Debugf("repo: cached feed %s at %s", feed.Name, path)
-	}
-
 	return &index, nil
 }
 
@@ -182,6 +585,22 @@ func (s IndexSet) Find(name string) (Package, bool) {
 	return Package{}, false
 }
 
+// FindAll returns every package with the provided name, in feed order.
+func (s IndexSet) FindAll(name string) []Package {
+	var out []Package
+	for _, idx := range s.indexes {
+		if pkg, ok := idx.Packages[name]; ok {
+			out = append(out, pkg)
+		}
+	}
+	return out
+}
+
+// Indexes returns the indexes contained in the set.
+func (s IndexSet) Indexes() []Index {
+	return append([]Index(nil), s.indexes...)
+}
+
 // All returns a flattened slice of all packages.
 func (s IndexSet) All() []Package {
 	var out []Package
@@ -193,6 +612,71 @@ func (s IndexSet) All() []Package {
 	return out
 }
 
+// GroupBySection groups the packages of the set by Section. See
+// GroupBySection.
+func (s IndexSet) GroupBySection() map[string][]Package {
+	return GroupBySection(s.All())
+}
+
+// GroupBySection maps each Section value to the packages in that section,
+// sorted by name. A package whose Section lists several sections separated
+// by semicolons appears under each of them; packages without a section are
+// grouped under "unknown".
+func GroupBySection(pkgs []Package) map[string][]Package {
+	groups := map[string][]Package{}
+	for _, pkg := range pkgs {
+		found := false
+		for _, section := range strings.Split(pkg.Raw.Value("Section"), ";") {
+			if section = strings.TrimSpace(section); section != "" {
+				groups[section] = append(groups[section], pkg)
+				found = true
+			}
+		}
+		if !found {
+			groups["unknown"] = append(groups["unknown"], pkg)
+		}
+	}
+	for _, list := range groups {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+	return groups
+}
+
+// IndexStats summarises the contents of an IndexSet.
+type IndexStats struct {
+	FeedCount    int
+	PackageCount int
+	// UniquePackageCount counts distinct package names; a package carried
+	// by several feeds counts once.
+	UniquePackageCount int
+	// ByFeed maps each feed name to its number of packages.
+	ByFeed map[string]int
+}
+
+// AveragePackagesPerFeed returns PackageCount divided by FeedCount, or zero
+// when there are no feeds.
+func (s IndexStats) AveragePackagesPerFeed() float64 {
+	if s.FeedCount == 0 {
+		return 0
+	}
+	return float64(s.PackageCount) / float64(s.FeedCount)
+}
+
+// Stats returns aggregate information about the indexes in the set.
+func (s IndexSet) Stats() IndexStats {
+	stats := IndexStats{FeedCount: len(s.indexes), ByFeed: map[string]int{}}
+	names := map[string]struct{}{}
+	for _, idx := range s.indexes {
+		stats.PackageCount += len(idx.Packages)
+		stats.ByFeed[idx.Feed.Name] += len(idx.Packages)
+		for name := range idx.Packages {
+			names[name] = struct{}{}
+		}
+	}
+	stats.UniquePackageCount = len(names)
+	return stats
+}
+
 // Helpers extracted for testing.
 var (
 	ioReadAll   = func(r io.Reader) ([]byte, error) { return io.ReadAll(r) }
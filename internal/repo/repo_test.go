@@ -0,0 +1,317 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oe-mirrors/opkg_go/internal/config"
+	"github.com/oe-mirrors/opkg_go/internal/downloader"
+	"github.com/oe-mirrors/opkg_go/internal/format"
+	"github.com/oe-mirrors/opkg_go/internal/logging"
+)
+
+func TestPackageFullURL(t *testing.T) {
+	feed := config.Feed{Name: "base", URI: "http://example.invalid/feeds/base/"}
+
+	pkg := Package{Filename: "/busybox_1.36.1-r0_armv7a.ipk", Feed: feed}
+	if got, want := pkg.FullURL(), "http://example.invalid/feeds/base/busybox_1.36.1-r0_armv7a.ipk"; got != want {
+		t.Fatalf("FullURL()=%q want %q", got, want)
+	}
+
+	pkg.Filename = "https://cdn.example.invalid/ipk/busybox_1.36.1-r0_armv7a.ipk"
+	if got := pkg.FullURL(); got != pkg.Filename {
+		t.Fatalf("FullURL()=%q want absolute filename %q", got, pkg.Filename)
+	}
+}
+
+func TestPackageSource(t *testing.T) {
+	for _, tc := range []struct {
+		source, name, version string
+	}{
+		{"", "busybox-udhcpc", "1.36.1-r0"},
+		{"busybox", "busybox", "1.36.1-r0"},
+		{"busybox (1:1.36.1-r1)", "busybox", "1:1.36.1-r1"},
+		{"busybox ()", "busybox", "1.36.1-r0"},
+	} {
+		pkg := Package{Name: "busybox-udhcpc", Version: "1.36.1-r0", Raw: format.Paragraph{Fields: map[string]string{"Source": tc.source}}}
+		if name, version := pkg.Source(); name != tc.name || version != tc.version {
+			t.Errorf("Source field %q: got %s %s, want %s %s", tc.source, name, version, tc.name, tc.version)
+		}
+	}
+}
+
+func TestPackageSizes(t *testing.T) {
+	for _, tc := range []struct {
+		size, installed string
+		download, disk  int64
+		wantErr         bool
+	}{
+		{"12345", "40", 12345, 40 * 1024, false},
+		{"", "", 0, 0, false},
+		{" 512 ", "1", 512, 1024, false},
+		{"12k", "", 0, 0, true},
+		{"", "-3", 0, 0, true},
+	} {
+		pkg := Package{Name: "busybox", Size: tc.size, Raw: format.Paragraph{Fields: map[string]string{"Installed-Size": tc.installed}}}
+		download, err1 := pkg.DownloadSize()
+		disk, err2 := pkg.InstalledSize()
+		if gotErr := err1 != nil || err2 != nil; gotErr != tc.wantErr {
+			t.Errorf("Size %q, Installed-Size %q: errors %v, %v", tc.size, tc.installed, err1, err2)
+			continue
+		}
+		if !tc.wantErr && (download != tc.download || disk != tc.disk) {
+			t.Errorf("Size %q, Installed-Size %q: got %d and %d bytes, want %d and %d", tc.size, tc.installed, download, disk, tc.download, tc.disk)
+		}
+	}
+}
+
+// serveFeeds starts a server publishing n feeds of perFeed packages each and
+// returns a configuration referring to them.
+func serveFeeds(tb testing.TB, n, perFeed int) *config.Config {
+	tb.Helper()
+	var body strings.Builder
+	for p := 0; p < perFeed; p++ {
+		fmt.Fprintf(&body, "Package: pkg-%d\nVersion: 1.0-r%d\nArchitecture: armv7a\nDepends: libc6 (>= 2.35), zlib\nFilename: pkg-%d.ipk\nSize: 1024\nDescription: synthetic package %d\n\n", p, p, p, p)
+	}
+	data := []byte(body.String())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/feed") || !strings.HasSuffix(r.URL.Path, "/Packages") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	tb.Cleanup(srv.Close)
+	cfg := &config.Config{}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("feed%d", i)
+		cfg.Feeds = append(cfg.Feeds, config.Feed{Name: name, URI: srv.URL + "/" + name})
+	}
+	return cfg
+}
+
+func TestUpdateWithParseWorkers(t *testing.T) {
+	cfg := serveFeeds(t, 5, 50)
+	cfg.Feeds = append(cfg.Feeds, config.Feed{Name: "off", URI: "http://example.invalid", Disabled: true})
+	events := make(chan FeedEvent, 2*len(cfg.Feeds))
+	indexes, err := UpdateWith(context.Background(), cfg, t.TempDir(), downloader.New(5*time.Second), UpdateOptions{Events: events, ParseWorkers: 2})
+	if err != nil {
+		t.Fatalf("UpdateWith returned error: %v", err)
+	}
+	if len(indexes) != 5 {
+		t.Fatalf("expected 5 indexes, got %d", len(indexes))
+	}
+	for _, idx := range indexes {
+		if len(idx.Packages) != 50 {
+			t.Fatalf("feed %s has %d packages", idx.Feed.Name, len(idx.Packages))
+		}
+	}
+	close(events)
+	done := 0
+	for ev := range events {
+		if ev.Status == FeedDone {
+			done++
+		}
+	}
+	if done != 5 {
+		t.Fatalf("expected 5 done events, got %d", done)
+	}
+}
+
+func TestUpdateWithReportsFetchError(t *testing.T) {
+	cfg := serveFeeds(t, 2, 1)
+	cfg.Feeds[1].URI = strings.Replace(cfg.Feeds[1].URI, "feed1", "missing", 1)
+	if _, err := UpdateWith(context.Background(), cfg, "", downloader.New(5*time.Second), UpdateOptions{}); err == nil {
+		t.Fatalf("expected error for unreachable feed")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	cfg := serveFeeds(t, 1, 1)
+	client := downloader.New(5 * time.Second)
+	feed := cfg.Feeds[0]
+	url, err := Probe(context.Background(), feed, client)
+	if err != nil || url != feed.URI+"/Packages" {
+		t.Fatalf("Probe = %q, %v; want the uncompressed index", url, err)
+	}
+	feed.URI = strings.Replace(feed.URI, "feed0", "missing", 1)
+	var status *downloader.StatusError
+	if _, err := Probe(context.Background(), feed, client); !errors.As(err, &status) || status.Code != http.StatusNotFound {
+		t.Fatalf("expected a 404 for a missing feed, got %v", err)
+	}
+}
+
+func BenchmarkUpdateParseWorkers(b *testing.B) {
+	cfg := serveFeeds(b, 10, 5000)
+	client := downloader.New(30 * time.Second)
+	for _, workers := range []int{1, 0} {
+		name := "gomaxprocs"
+		if workers == 1 {
+			name = "single"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := UpdateWith(context.Background(), cfg, "", client, UpdateOptions{ParseWorkers: workers}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexSetStats(t *testing.T) {
+	base := config.Feed{Name: "base"}
+	extra := config.Feed{Name: "extra"}
+	set := NewIndexSet([]Index{
+		{Feed: base, Packages: map[string]Package{"busybox": {Name: "busybox"}, "zlib": {Name: "zlib"}, "curl": {Name: "curl"}}},
+		{Feed: extra, Packages: map[string]Package{"zlib": {Name: "zlib"}}},
+	})
+	stats := set.Stats()
+	if stats.FeedCount != 2 || stats.PackageCount != 4 || stats.UniquePackageCount != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.UniquePackageCount >= stats.PackageCount {
+		t.Fatalf("expected overlapping names to be counted once: %+v", stats)
+	}
+	if stats.ByFeed["base"] != 3 || stats.ByFeed["extra"] != 1 {
+		t.Fatalf("unexpected per-feed counts %v", stats.ByFeed)
+	}
+	if got := stats.AveragePackagesPerFeed(); got != 2 {
+		t.Fatalf("AveragePackagesPerFeed()=%v want 2", got)
+	}
+	if got := (IndexStats{}).AveragePackagesPerFeed(); got != 0 {
+		t.Fatalf("expected zero average for empty stats, got %v", got)
+	}
+}
+
+func TestIndexSetGroupBySection(t *testing.T) {
+	idx, err := ParseIndex(config.Feed{Name: "base"}, []byte("Package: wget\nVersion: 1.21\nSection: net\n\n"+
+		"Package: curl\nVersion: 8.0\nSection: net\n\n"+
+		"Package: ntpd\nVersion: 4.2\nSection: net; admin\n\n"+
+		"Package: mystery\nVersion: 1.0\n"))
+	if err != nil {
+		t.Fatalf("ParseIndex returned error: %v", err)
+	}
+	groups := NewIndexSet([]Index{*idx}).GroupBySection()
+	names := map[string][]string{}
+	for section, pkgs := range groups {
+		for _, pkg := range pkgs {
+			names[section] = append(names[section], pkg.Name)
+		}
+	}
+	want := map[string][]string{
+		"net":     {"curl", "ntpd", "wget"},
+		"admin":   {"ntpd"},
+		"unknown": {"mystery"},
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("GroupBySection grouped %v, want %v", names, want)
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	idx, err := ParseIndex(config.Feed{Name: "base"}, []byte("Package: busybox\nVersion: 1.36\nDescription: Tiny utilities\n\n"+
+		"Package: curl\nVersion: 8.0\nDescription: Command line tool for transferring data\n\n"+
+		"Package: libcurl\nVersion: 8.0\nDescription: Library for transferring data with URLs\n"))
+	if err != nil {
+		t.Fatalf("ParseIndex returned error: %v", err)
+	}
+	names := func(pkgs []Package) []string {
+		var out []string
+		for _, pkg := range pkgs {
+			out = append(out, pkg.Name)
+		}
+		sort.Strings(out)
+		return out
+	}
+	for _, tc := range []struct {
+		search func(string) []Package
+		query  string
+		want   []string
+	}{
+		{idx.SearchName, "CURL", []string{"curl", "libcurl"}},
+		{idx.SearchName, "utilities", nil},
+		{idx.SearchDescription, "Transferring", []string{"curl", "libcurl"}},
+		{idx.SearchDescription, "tiny", []string{"busybox"}},
+		{idx.SearchDescription, "busybox", nil},
+	} {
+		if got := names(tc.search(tc.query)); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("search for %q = %v, want %v", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestParseIndexChecksums(t *testing.T) {
+	const sha256 = "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0"
+	data := "Package: busybox\nVersion: 1.36.1-r0\nMD5Sum: d41d8cd98f00b204e9800998ecf8427e\nSHA256sum: " + sha256 + "\n\n" +
+		"Package: zlib\nVersion: 1.3-r0\nSHA256: " + sha256 + "\nSHA512: cafe\n\n" +
+		"Package: plain\nVersion: 1.0\n"
+	idx, err := ParseIndex(config.Feed{Name: "base"}, []byte(data))
+	if err != nil {
+		t.Fatalf("ParseIndex returned error: %v", err)
+	}
+
+	want := map[string]PackageChecksum{
+		"busybox": {MD5: "d41d8cd98f00b204e9800998ecf8427e", SHA256: sha256},
+		"zlib":    {SHA256: sha256, SHA512: "cafe"},
+		"plain":   {},
+	}
+	for name, sum := range want {
+		if got := idx.Packages[name].Checksum; got != sum {
+			t.Errorf("%s checksum = %+v, want %+v", name, got, sum)
+		}
+	}
+}
+
+func TestCachedIndexUpdatedAt(t *testing.T) {
+	cacheDir := t.TempDir()
+	feed := config.Feed{Name: "base"}
+	if err := os.WriteFile(CachedIndexPath(cacheDir, feed), []byte("Package: busybox\nVersion: 1.36.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := LoadCachedIndex(feed, cacheDir)
+	if err != nil {
+		t.Fatalf("LoadCachedIndex returned error: %v", err)
+	}
+	if !idx.UpdatedAt().IsZero() {
+		t.Fatalf("UpdatedAt without a sidecar = %v, want zero", idx.UpdatedAt())
+	}
+
+	want := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	if err := os.WriteFile(CachedMetaPath(cacheDir, feed), []byte(`{"updated_at":"2024-01-15T10:30:00Z"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err = LoadCachedIndex(feed, cacheDir)
+	if err != nil {
+		t.Fatalf("LoadCachedIndex returned error: %v", err)
+	}
+	if !idx.UpdatedAt().Equal(want) {
+		t.Fatalf("UpdatedAt = %v, want %v", idx.UpdatedAt(), want)
+	}
+
+	before := time.Now()
+	stored, err := storeFeed(feed, []byte("Package: zlib\nVersion: 1.3\n"), cacheDir, logging.WithFeed(feed.Name))
+	if err != nil {
+		t.Fatalf("storeFeed returned error: %v", err)
+	}
+	got, err := CachedUpdatedAt(feed, cacheDir)
+	if err != nil {
+		t.Fatalf("CachedUpdatedAt returned error: %v", err)
+	}
+	if got.Before(before.Add(-time.Second)) || !got.Equal(stored.UpdatedAt()) {
+		t.Fatalf("sidecar records %v, index reports %v", got, stored.UpdatedAt())
+	}
+	if uncached, _ := storeFeed(feed, []byte("Package: zlib\nVersion: 1.3\n"), "", logging.WithFeed(feed.Name)); !uncached.UpdatedAt().IsZero() {
+		t.Fatal("an index that was not cached has no persisted update time")
+	}
+}
@@ -0,0 +1,6 @@
+package version
+
+// Version is the version of the running opkg-go build. The command sets it
+// from its buildVersion variable, which is stamped at link time with
+// -ldflags "-X main.buildVersion=...".
+var Version = "dev"
@@ -1,7 +1,9 @@
 package version
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -31,9 +33,7 @@ func Compare(a, b string) int {
 // provided operator. Supported operators match opkg's syntax: "<", "<=", "=",
 // "==", ">", ">=", "<<" and ">>".
 func CompareOp(a, op, b string) (bool, error) {
-	switch op {
-	case "<", "<=", "=", "==", ">", ">=", "<<", ">>":
-	default:
+	if !validOp(op) {
 		return false, fmt.Errorf("unsupported operator %q", op)
 	}
 	cmp := Compare(a, b)
@@ -52,6 +52,88 @@ func CompareOp(a, op, b string) (bool, error) {
 	return false, nil
 }
 
+// Latest returns the greatest of the given versions. Equal versions keep the
+// first occurrence.
+func Latest(vs []string) (string, error) {
+	if len(vs) == 0 {
+		return "", errors.New("no versions given")
+	}
+	best := vs[0]
+	for _, v := range vs[1:] {
+		if Compare(v, best) > 0 {
+			best = v
+		}
+	}
+	return best, nil
+}
+
+// Oldest returns the smallest of the given versions. Equal versions keep the
+// first occurrence.
+func Oldest(vs []string) (string, error) {
+	if len(vs) == 0 {
+		return "", errors.New("no versions given")
+	}
+	best := vs[0]
+	for _, v := range vs[1:] {
+		if Compare(v, best) < 0 {
+			best = v
+		}
+	}
+	return best, nil
+}
+
+// Validate reports whether v is a well-formed version: an optional numeric
+// epoch followed by a colon, a non-empty upstream version and an optional
+// revision after the last hyphen. Upstream versions may contain
+// alphanumerics and ".+~-:"; revisions may contain alphanumerics and ".+~".
+func Validate(v string) error {
+	if v == "" {
+		return errors.New("empty version")
+	}
+	rest := v
+	if epoch, after, ok := strings.Cut(v, ":"); ok {
+		if _, err := strconv.ParseUint(epoch, 10, 31); err != nil {
+			return fmt.Errorf("invalid epoch in version %q", v)
+		}
+		rest = after
+	}
+	upstream, revision := rest, ""
+	if idx := strings.LastIndexByte(rest, '-'); idx >= 0 {
+		upstream, revision = rest[:idx], rest[idx+1:]
+		if revision == "" {
+			return fmt.Errorf("empty revision in version %q", v)
+		}
+	}
+	if upstream == "" {
+		return fmt.Errorf("empty upstream version in %q", v)
+	}
+	if i := strings.IndexFunc(upstream, func(r rune) bool { return !versionRune(r, ".+~-:") }); i >= 0 {
+		return fmt.Errorf("invalid character %q in version %q", upstream[i], v)
+	}
+	if i := strings.IndexFunc(revision, func(r rune) bool { return !versionRune(r, ".+~") }); i >= 0 {
+		return fmt.Errorf("invalid character %q in version %q", revision[i], v)
+	}
+	return nil
+}
+
+func versionRune(r rune, extra string) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(extra, r))
+}
+
+// Sort orders vs in place from the smallest to the greatest version. Equal
+// versions keep their relative order.
+func Sort(vs []string) {
+	sort.SliceStable(vs, func(i, j int) bool { return Compare(vs[i], vs[j]) < 0 })
+}
+
+func validOp(op string) bool {
+	switch op {
+	case "<", "<=", "=", "==", ">", ">=", "<<", ">>":
+		return true
+	}
+	return false
+}
+
 func splitEpoch(s string) (int, string) {
 	parts := strings.SplitN(s, ":", 2)
 	if len(parts) != 2 {
@@ -70,3 +70,54 @@ func TestCompareOp(t *testing.T) {
 		t.Fatalf("expected error for unsupported operator")
 	}
 }
+
+func TestLatestAndOldest(t *testing.T) {
+	vs := []string{"1.0", "1:0.9", "2.0~rc1", "2.0", "0.5-r3"}
+	if got, err := Latest(vs); err != nil || got != "1:0.9" {
+		t.Fatalf("Latest=%q, %v want 1:0.9", got, err)
+	}
+	if got, err := Oldest(vs); err != nil || got != "0.5-r3" {
+		t.Fatalf("Oldest=%q, %v want 0.5-r3", got, err)
+	}
+	if got, err := Latest([]string{"2.0~rc1", "2.0~beta", "1.9"}); err != nil || got != "2.0~rc1" {
+		t.Fatalf("Latest=%q, %v want 2.0~rc1", got, err)
+	}
+	if got, err := Oldest([]string{"2.0", "2.0~rc1", "2.0~~"}); err != nil || got != "2.0~~" {
+		t.Fatalf("Oldest=%q, %v want 2.0~~", got, err)
+	}
+	if _, err := Latest(nil); err == nil {
+		t.Fatalf("expected error for empty input to Latest")
+	}
+	if _, err := Oldest(nil); err == nil {
+		t.Fatalf("expected error for empty input to Oldest")
+	}
+}
+
+func TestSort(t *testing.T) {
+	vs := []string{"1:0.1", "2.0", "1.0-r1", "2.0~rc1", "1.0", "0:1.0-r0", "10.0"}
+	Sort(vs)
+	want := []string{"1.0", "0:1.0-r0", "1.0-r1", "2.0~rc1", "2.0", "10.0", "1:0.1"}
+	for i := range want {
+		if vs[i] != want[i] {
+			t.Fatalf("Sort=%v want %v", vs, want)
+		}
+	}
+	for i := 1; i < len(vs); i++ {
+		if Compare(vs[i-1], vs[i]) > 0 {
+			t.Fatalf("Sort order disagrees with Compare at %q, %q", vs[i-1], vs[i])
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, v := range []string{"1.0", "1:2.3-r0", "1.0+git0+abc123-r4.1", "2.0~rc1", "1.2-3-r0"} {
+		if err := Validate(v); err != nil {
+			t.Errorf("Validate(%q) returned %v", v, err)
+		}
+	}
+	for _, v := range []string{"", "x:1.0", "-r0", "1.0-", "1.0 beta", "1.0-r0_1", "1.0/2"} {
+		if err := Validate(v); err == nil {
+			t.Errorf("Validate(%q) accepted a malformed version", v)
+		}
+	}
+}
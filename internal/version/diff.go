@@ -0,0 +1,79 @@
+package version
+
+import "strings"
+
+// VersionChange classifies the difference between two versions.
+type VersionChange int
+
+const (
+	// NoChange means both versions compare equal.
+	NoChange VersionChange = iota
+	// EpochChange means the epochs differ.
+	EpochChange
+	// MajorChange means the first upstream component differs.
+	MajorChange
+	// MinorChange means the second upstream component differs.
+	MinorChange
+	// PatchChange means the third upstream component differs.
+	PatchChange
+	// RevisionChange means the versions differ only past the third upstream
+	// component or in the package revision.
+	RevisionChange
+	// PreReleaseChange means the upstream versions differ only in a tilde
+	// pre-release suffix, as in "1.2.3~rc1" and "1.2.3".
+	PreReleaseChange
+)
+
+// String returns a lower case name for the change, suitable for display.
+func (c VersionChange) String() string {
+	switch c {
+	case NoChange:
+		return "none"
+	case EpochChange:
+		return "epoch"
+	case MajorChange:
+		return "major"
+	case MinorChange:
+		return "minor"
+	case PatchChange:
+		return "patch"
+	case RevisionChange:
+		return "revision"
+	case PreReleaseChange:
+		return "pre-release"
+	}
+	return "unknown"
+}
+
+// Diff returns the most significant kind of change between from and to. The
+// first three dot separated components of the upstream version are treated as
+// major, minor and patch numbers.
+func Diff(from, to string) VersionChange {
+	if Compare(from, to) == 0 {
+		return NoChange
+	}
+	ef, rf := splitEpoch(from)
+	et, rt := splitEpoch(to)
+	if ef != et {
+		return EpochChange
+	}
+	uf, _ := splitRevision(rf)
+	ut, _ := splitRevision(rt)
+	pf, pt := strings.Split(uf, "."), strings.Split(ut, ".")
+	for i, change := range []VersionChange{MajorChange, MinorChange, PatchChange} {
+		if compareDigits(leadingDigits(component(pf, i)), leadingDigits(component(pt, i))) != 0 {
+			return change
+		}
+	}
+	if comparePart(uf, ut) != 0 && (strings.Contains(uf, "~") || strings.Contains(ut, "~")) {
+		return PreReleaseChange
+	}
+	return RevisionChange
+}
+
+func component(parts []string, i int) string {
+	if i < len(parts) {
+		return parts[i]
+	}
+	return ""
+}
@@ -0,0 +1,26 @@
+package version
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     VersionChange
+	}{
+		{"1.2.3", "1.2.3", NoChange},
+		{"1.2.3-r0", "1:1.2.3-r0", EpochChange},
+		{"1.2.3", "2.0.0", MajorChange},
+		{"1.2.3", "1.3.0", MinorChange},
+		{"1.2.3", "1.2.4", PatchChange},
+		{"1.2", "1.2.1", PatchChange},
+		{"1.2.3-r0", "1.2.3-r1", RevisionChange},
+		{"1.2.3.4", "1.2.3.5", RevisionChange},
+		{"1.2.3~rc1", "1.2.3", PreReleaseChange},
+		{"1.2.3~rc1-r0", "1.2.3~rc2-r0", PreReleaseChange},
+	}
+	for _, tc := range cases {
+		if got := Diff(tc.from, tc.to); got != tc.want {
+			t.Fatalf("Diff(%q,%q)=%s want %s", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package version
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constraint restricts the acceptable versions of a relation, for example the
+// ">= 1.2" part of "libfoo (>= 1.2)".
+type Constraint struct {
+	Op      string
+	Version string
+}
+
+// Relation is a single package reference from a dependency field together
+// with its optional version constraints.
+type Relation struct {
+	Name        string
+	Constraints []Constraint
+}
+
+// ParseConstraint parses a version constraint such as ">= 1.2" or "(<< 2.0)".
+// Whitespace between the operator and the version is optional.
+func ParseConstraint(s string) (Constraint, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(s, "("), ")"))
+	if s == "" {
+		return Constraint{}, fmt.Errorf("empty version constraint")
+	}
+	i := 0
+	for i < len(s) && strings.IndexByte("<>=", s[i]) >= 0 {
+		i++
+	}
+	op := s[:i]
+	ver := strings.TrimSpace(s[i:])
+	if op == "" {
+		return Constraint{}, fmt.Errorf("version constraint %q lacks an operator", s)
+	}
+	if !validOp(op) {
+		return Constraint{}, fmt.Errorf("unsupported operator %q", op)
+	}
+	if ver == "" || strings.ContainsAny(ver, " \t") {
+		return Constraint{}, fmt.Errorf("invalid version in constraint %q", s)
+	}
+	return Constraint{Op: op, Version: ver}, nil
+}
+
+// ParseRelation parses a single relation token, i.e. one element obtained after
+// splitting a dependency field on "," and "|".
+func ParseRelation(token string) (Relation, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return Relation{}, fmt.Errorf("empty relation")
+	}
+	name := token
+	rest := ""
+	if idx := strings.IndexAny(token, " \t(<>="); idx >= 0 {
+		name = token[:idx]
+		rest = strings.TrimSpace(token[idx:])
+	}
+	if name == "" {
+		return Relation{}, fmt.Errorf("relation %q lacks a package name", token)
+	}
+	rel := Relation{Name: name}
+	for rest != "" {
+		if rest[0] != '(' {
+			return Relation{}, fmt.Errorf("unexpected %q in relation %q", rest, token)
+		}
+		end := strings.IndexByte(rest, ')')
+		if end < 0 {
+			return Relation{}, fmt.Errorf("unterminated constraint in relation %q", token)
+		}
+		c, err := ParseConstraint(rest[:end+1])
+		if err != nil {
+			return Relation{}, fmt.Errorf("relation %q: %w", token, err)
+		}
+		rel.Constraints = append(rel.Constraints, c)
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+	return rel, nil
+}
+
+// ParseRelations parses a complete dependency field such as
+// "libfoo (>= 1.2), libbar (<< 2.0) | libbaz". The result contains one group
+// per comma separated clause; each group lists the alternatives of the clause.
+// Malformed tokens are kept with their bare package name so that callers still
+// see every referenced package.
+func ParseRelations(field string) [][]Relation {
+	var groups [][]Relation
+	for _, clause := range strings.Split(field, ",") {
+		var group []Relation
+		for _, part := range strings.Split(clause, "|") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			rel, err := ParseRelation(part)
+			if err != nil {
+				name := part
+				if idx := strings.IndexAny(part, " \t(<>="); idx >= 0 {
+					name = strings.TrimSpace(part[:idx])
+				}
+				if name == "" {
+					continue
+				}
+				rel = Relation{Name: name}
+			}
+			group = append(group, rel)
+		}
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// Satisfies reports whether the version v meets the constraint.
+func (c Constraint) Satisfies(v string) bool {
+	ok, err := CompareOp(v, c.Op, c.Version)
+	return err == nil && ok
+}
+
+// FilterSatisfying returns the versions in vs that meet the constraint,
+// preserving their order.
+func FilterSatisfying(vs []string, constraint Constraint) []string {
+	var out []string
+	for _, v := range vs {
+		if constraint.Satisfies(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// SatisfiedBy reports whether a package called name at version v fulfils the
+// relation. All constraints must hold.
+func (r Relation) SatisfiedBy(name, v string) bool {
+	if r.Name != name {
+		return false
+	}
+	for _, c := range r.Constraints {
+		if !c.Satisfies(v) {
+			return false
+		}
+	}
+	return true
+}
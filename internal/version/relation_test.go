@@ -0,0 +1,85 @@
+package version
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConstraint(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Constraint
+	}{
+		{">= 1.2", Constraint{Op: ">=", Version: "1.2"}},
+		{"(<< 2.0)", Constraint{Op: "<<", Version: "2.0"}},
+		{"(=1:1.0-r0)", Constraint{Op: "=", Version: "1:1.0-r0"}},
+	}
+	for _, tc := range cases {
+		got, err := ParseConstraint(tc.in)
+		if err != nil {
+			t.Fatalf("ParseConstraint(%q) unexpected error: %v", tc.in, err)
+		}
+		if got != tc.want {
+			t.Fatalf("ParseConstraint(%q)=%+v want %+v", tc.in, got, tc.want)
+		}
+	}
+	for _, bad := range []string{"", "1.0", "!= 1.0", ">="} {
+		if _, err := ParseConstraint(bad); err == nil {
+			t.Fatalf("ParseConstraint(%q) expected error", bad)
+		}
+	}
+}
+
+func TestParseRelation(t *testing.T) {
+	rel, err := ParseRelation("libfoo (>= 1.2)")
+	if err != nil {
+		t.Fatalf("ParseRelation returned error: %v", err)
+	}
+	want := Relation{Name: "libfoo", Constraints: []Constraint{{Op: ">=", Version: "1.2"}}}
+	if !reflect.DeepEqual(rel, want) {
+		t.Fatalf("ParseRelation=%+v want %+v", rel, want)
+	}
+	if _, err := ParseRelation("libfoo (>= 1.2"); err == nil {
+		t.Fatalf("expected error for unterminated constraint")
+	}
+}
+
+func TestParseRelations(t *testing.T) {
+	got := ParseRelations("libfoo (>= 1.2), libbar (<< 2.0) | libbaz, broken (>= ")
+	want := [][]Relation{
+		{{Name: "libfoo", Constraints: []Constraint{{Op: ">=", Version: "1.2"}}}},
+		{
+			{Name: "libbar", Constraints: []Constraint{{Op: "<<", Version: "2.0"}}},
+			{Name: "libbaz"},
+		},
+		{{Name: "broken"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseRelations=%+v want %+v", got, want)
+	}
+}
+
+func TestRelationSatisfiedBy(t *testing.T) {
+	rel := Relation{Name: "libssl", Constraints: []Constraint{{Op: "<", Version: "1.1"}}}
+	if !rel.SatisfiedBy("libssl", "1.0.2") {
+		t.Fatalf("expected libssl 1.0.2 to satisfy %+v", rel)
+	}
+	if rel.SatisfiedBy("libssl", "1.1.1") {
+		t.Fatalf("did not expect libssl 1.1.1 to satisfy %+v", rel)
+	}
+	if rel.SatisfiedBy("libcrypto", "1.0.2") {
+		t.Fatalf("did not expect a different name to satisfy %+v", rel)
+	}
+}
+
+func TestFilterSatisfying(t *testing.T) {
+	vs := []string{"1.0", "1.1~rc1", "1.1", "1:0.1", "0.9"}
+	got := FilterSatisfying(vs, Constraint{Op: ">=", Version: "1.1~rc1"})
+	want := []string{"1.1~rc1", "1.1", "1:0.1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("FilterSatisfying=%v want %v", got, want)
+	}
+	if got := FilterSatisfying(vs, Constraint{Op: "<<", Version: "0.1"}); got != nil {
+		t.Fatalf("expected no matches, got %v", got)
+	}
+}